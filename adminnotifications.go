@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strconv"
+)
+
+// Events admins can route notifications for.
+const (
+	EventUserCreated     = "user_created"
+	EventUserDeleted     = "user_deleted"  // Deleted by the user expiry daemon.
+	EventUserDisabled    = "user_disabled" // Disabled by the user expiry daemon.
+	EventPasswordReset   = "password_reset"
+	EventInviteExpired   = "invite_expired"
+	EventBackupFailed    = "backup_failed"
+	EventUpdateAvailable = "update_available"
+)
+
+var adminEvents = []string{EventUserCreated, EventUserDeleted, EventUserDisabled, EventPasswordReset, EventInviteExpired, EventBackupFailed, EventUpdateAvailable}
+
+// Destination types, each delivered through the matching daemon.
+const (
+	DestinationEmail    = "email"
+	DestinationDiscord  = "discord"
+	DestinationTelegram = "telegram"
+	DestinationMatrix   = "matrix"
+	DestinationSignal   = "signal"
+	DestinationSMS      = "sms"
+)
+
+// NotificationDestination is somewhere admin notifications are sent: an email address, Discord channel ID, Telegram chat ID, Matrix room ID, Signal number or phone number for SMS.
+type NotificationDestination struct {
+	Type   string `json:"type" example:"discord"`
+	Target string `json:"target" example:"123456789012345678"`
+}
+
+// NotificationRoute lists where notifications for an event are sent.
+type NotificationRoute struct {
+	Event        string `badgerhold:"key"`
+	Destinations []NotificationDestination
+}
+
+// destinationTypes returns the destination types usable with the enabled messaging methods.
+func destinationTypes() []string {
+	types := []string{}
+	if emailEnabled {
+		types = append(types, DestinationEmail)
+	}
+	if discordEnabled {
+		types = append(types, DestinationDiscord)
+	}
+	if telegramEnabled {
+		types = append(types, DestinationTelegram)
+	}
+	if matrixEnabled {
+		types = append(types, DestinationMatrix)
+	}
+	if signalEnabled {
+		types = append(types, DestinationSignal)
+	}
+	if smsEnabled {
+		types = append(types, DestinationSMS)
+	}
+	return types
+}
+
+// notifyAdmins sends the message built by construct to each destination routed for the event, in the background.
+// construct is only called if the event has any destinations.
+func (app *appContext) notifyAdmins(event string, construct func() (*Message, error)) {
+	route, ok := app.storage.GetNotificationRouteKey(event)
+	if !ok || len(route.Destinations) == 0 {
+		return
+	}
+	go func() {
+		msg, err := construct()
+		if err != nil {
+			app.err.Printf("Failed to construct \"%s\" admin notification: %v", event, err)
+			return
+		}
+		for _, dest := range route.Destinations {
+			err := app.sendToDestination(msg, dest)
+			app.recordMessageFailure(dest.Type, dest.Target, err)
+			if err != nil {
+				app.err.Printf("Failed to send \"%s\" admin notification to %s \"%s\": %v", event, dest.Type, dest.Target, err)
+			} else {
+				app.debug.Printf("Sent \"%s\" admin notification to %s \"%s\"", event, dest.Type, dest.Target)
+			}
+		}
+	}()
+}
+
+// sendToDestination sends a message to a single destination. Destinations for disabled methods are skipped.
+func (app *appContext) sendToDestination(msg *Message, dest NotificationDestination) error {
+	switch dest.Type {
+	case DestinationEmail:
+		if emailEnabled {
+			return app.email.send(msg, dest.Target)
+		}
+	case DestinationDiscord:
+		if discordEnabled {
+			return app.discord.Send(msg, dest.Target)
+		}
+	case DestinationTelegram:
+		if telegramEnabled {
+			chatID, err := strconv.ParseInt(dest.Target, 10, 64)
+			if err != nil {
+				return err
+			}
+			return app.telegram.Send(msg, chatID)
+		}
+	case DestinationMatrix:
+		if matrixEnabled {
+			return app.matrix.Send(msg, MatrixUser{RoomID: dest.Target})
+		}
+	case DestinationSignal:
+		if signalEnabled {
+			return app.signal.Send(msg, dest.Target)
+		}
+	case DestinationSMS:
+		if smsEnabled {
+			return app.sms.Send(msg, dest.Target)
+		}
+	}
+	return nil
+}
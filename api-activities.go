@@ -27,6 +27,18 @@ func stringToActivityType(v string) ActivityType {
 		return ActivityCreateInvite
 	case "deleteInvite":
 		return ActivityDeleteInvite
+	case "lockout":
+		return ActivityLockout
+	case "referralReward":
+		return ActivityReferralReward
+	case "redeemCode":
+		return ActivityRedeemCode
+	case "payment":
+		return ActivityPayment
+	case "usernameChange":
+		return ActivityUsernameChange
+	case "streamLimit":
+		return ActivityStreamLimit
 	}
 	return ActivityUnknown
 }
@@ -53,6 +65,18 @@ func activityTypeToString(v ActivityType) string {
 		return "createInvite"
 	case ActivityDeleteInvite:
 		return "deleteInvite"
+	case ActivityLockout:
+		return "lockout"
+	case ActivityReferralReward:
+		return "referralReward"
+	case ActivityRedeemCode:
+		return "redeemCode"
+	case ActivityPayment:
+		return "payment"
+	case ActivityUsernameChange:
+		return "usernameChange"
+	case ActivityStreamLimit:
+		return "streamLimit"
 	}
 	return "unknown"
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Most extension codes that can be generated in one request.
+const EXTENSION_CODE_MAX_COUNT = 100
+
+// @Summary Get all extension codes.
+// @Produce json
+// @Success 200 {object} extensionCodesDTO
+// @Router /extension-codes [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetExtensionCodes(gc *gin.Context) {
+	codes := app.storage.GetExtensionCodes()
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Created.After(codes[j].Created) })
+	resp := extensionCodesDTO{Codes: make([]extensionCodeDTO, len(codes))}
+	for i, c := range codes {
+		resp.Codes[i] = extensionCodeDTO{
+			Code:          c.Code,
+			Created:       c.Created.Unix(),
+			Label:         c.Label,
+			Days:          c.Days,
+			RemainingUses: c.RemainingUses,
+			NoLimit:       c.NoLimit,
+			Profile:       c.Profile,
+			Redeemed:      len(c.RedeemedBy),
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Generate one or more extension codes, which users can redeem on the user page to extend their expiry.
+// @Produce json
+// @Param generateExtensionCodesDTO body generateExtensionCodesDTO true "Extension code settings"
+// @Success 200 {object} extensionCodesDTO
+// @Failure 400 {object} stringResponse
+// @Router /extension-codes [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GenerateExtensionCodes(gc *gin.Context) {
+	var req generateExtensionCodesDTO
+	gc.BindJSON(&req)
+	if req.Days <= 0 || (!req.NoLimit && req.Uses <= 0) {
+		respond(400, "Invalid days or uses", gc)
+		return
+	}
+	if req.Profile != "" {
+		if _, ok := app.storage.GetProfileKey(req.Profile); !ok {
+			respond(400, "Profile not found", gc)
+			return
+		}
+	}
+	if req.Count <= 0 {
+		req.Count = 1
+	} else if req.Count > EXTENSION_CODE_MAX_COUNT {
+		req.Count = EXTENSION_CODE_MAX_COUNT
+	}
+	resp := extensionCodesDTO{Codes: make([]extensionCodeDTO, req.Count)}
+	now := time.Now()
+	for i := range resp.Codes {
+		code := ExtensionCode{
+			Created:       now,
+			Label:         req.Label,
+			Days:          req.Days,
+			RemainingUses: req.Uses,
+			NoLimit:       req.NoLimit,
+			Profile:       req.Profile,
+		}
+		key := GenerateInviteCode()
+		app.storage.SetExtensionCodeKey(key, code)
+		resp.Codes[i] = extensionCodeDTO{
+			Code:          key,
+			Created:       now.Unix(),
+			Label:         code.Label,
+			Days:          code.Days,
+			RemainingUses: code.RemainingUses,
+			NoLimit:       code.NoLimit,
+			Profile:       code.Profile,
+		}
+	}
+	app.info.Printf("Generated %d extension code(s) for %d day(s)", req.Count, req.Days)
+	gc.JSON(200, resp)
+}
+
+// @Summary Delete an extension code.
+// @Produce json
+// @Param code path string true "Extension code"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /extension-codes/{code} [delete]
+// @Security Bearer
+// @tags Users
+func (app *appContext) DeleteExtensionCode(gc *gin.Context) {
+	code := gc.Param("code")
+	if _, ok := app.storage.GetExtensionCodeKey(code); !ok {
+		respondBool(400, false, gc)
+		return
+	}
+	app.storage.DeleteExtensionCodeKey(code)
+	app.info.Printf("Deleted extension code \"%s\"", code)
+	respondBool(200, true, gc)
+}
+
+// disabledByDaemon returns whether the user's most recent disable was done automatically, on expiry or by a payment provider.
+func (app *appContext) disabledByDaemon(jfID string) bool {
+	activities := []Activity{}
+	err := app.storage.db.Find(&activities, badgerhold.Where("Type").Eq(ActivityDisabled).And("UserID").Eq(jfID).SortBy("Time").Reverse().Limit(1))
+	return err == nil && len(activities) != 0 && activities[0].SourceType == ActivityDaemon
+}
+
+// @Summary Redeem an extension code, extending the user's expiry (and re-enabling them if they were disabled on expiry).
+// @Produce json
+// @Param redeemCodeDTO body redeemCodeDTO true "Extension code"
+// @Success 200 {object} redeemCodeRespDTO
+// @Failure 400 {object} stringResponse
+// @Failure 429 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/redeem [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) RedeemExtensionCode(gc *gin.Context) {
+	var req redeemCodeDTO
+	gc.BindJSON(&req)
+	id := gc.GetString("jfId")
+	if !app.checkRateLimit(gc, true, "") {
+		return
+	}
+	code, expiry, status, errKey := app.redeemExtensionCode(gc, id, req.Code)
+	if errKey == "errorInvalidExtensionCode" {
+		app.logIpInfo(gc, true, fmt.Sprintf("Extension code redemption failed for \"%s\": invalid code", id))
+		app.recordFailedAttempt(gc, true, "")
+	}
+	if errKey != "" {
+		respond(status, errKey, gc)
+		return
+	}
+	app.recordSuccessfulAttempt(gc, "")
+	gc.JSON(200, redeemCodeRespDTO{Days: code.Days, Expiry: expiry.Expiry.Unix()})
+}
+
+// @Summary Redeem an extension code on an account disabled on expiry, which can't log in to the user page. Re-enables the account.
+// @Produce json
+// @Param redeemCodeLoginDTO body redeemCodeLoginDTO true "Username, password and extension code"
+// @Success 200 {object} redeemCodeRespDTO
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} stringResponse
+// @Failure 429 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/redeem/login [post]
+// @tags User Page
+func (app *appContext) RedeemExtensionCodeLogin(gc *gin.Context) {
+	var req redeemCodeLoginDTO
+	gc.BindJSON(&req)
+	if req.Username == "" || req.Password == "" || req.Code == "" {
+		respond(400, "errorLoginBlank", gc)
+		return
+	}
+	if !app.checkRateLimit(gc, true, req.Username) {
+		return
+	}
+	deny := func(reason string) {
+		app.logIpInfo(gc, true, fmt.Sprintf("Extension code redemption failed for \"%s\": %s", req.Username, reason))
+		app.recordFailedAttempt(gc, true, req.Username)
+		respond(401, "Unauthorized", gc)
+	}
+	user, status, err := app.jf.UserByName(req.Username, false)
+	if status != 200 || err != nil {
+		deny("user not found")
+		return
+	}
+	// Anyone else can log in and use /my/redeem.
+	if !user.Policy.IsDisabled || !app.disabledByDaemon(user.ID) {
+		deny("not disabled on expiry")
+		return
+	}
+	// Checked first, so a guessed password alone doesn't get the account briefly re-enabled.
+	if code, ok := app.storage.GetExtensionCodeKey(req.Code); !ok || (!code.NoLimit && code.RemainingUses <= 0) {
+		app.logIpInfo(gc, true, fmt.Sprintf("Extension code redemption failed for \"%s\": invalid code", req.Username))
+		app.recordFailedAttempt(gc, true, req.Username)
+		respond(400, "errorInvalidExtensionCode", gc)
+		return
+	}
+	if !app.checkDisabledUserPassword(user, req.Password) {
+		deny("invalid password")
+		return
+	}
+	code, expiry, status, errKey := app.redeemExtensionCode(gc, user.ID, req.Code)
+	if errKey == "errorInvalidExtensionCode" {
+		app.recordFailedAttempt(gc, true, req.Username)
+	}
+	if errKey != "" {
+		respond(status, errKey, gc)
+		return
+	}
+	app.recordSuccessfulAttempt(gc, req.Username)
+	gc.JSON(200, redeemCodeRespDTO{Days: code.Days, Expiry: expiry.Expiry.Unix()})
+}
+
+// disabledLoginLock stops the account of one disabled user being re-enabled for two password checks at once.
+var disabledLoginLock sync.Mutex
+
+// checkDisabledUserPassword checks the password of a disabled user.
+// Jellyfin refuses to authenticate disabled users whatever the password, so they're re-enabled just for the check.
+func (app *appContext) checkDisabledUserPassword(user mediabrowser.User, password string) bool {
+	disabledLoginLock.Lock()
+	defer disabledLoginLock.Unlock()
+	policy := user.Policy
+	policy.IsDisabled = false
+	if status, err := app.jf.SetPolicy(user.ID, policy); !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to enable \"%s\" to check their password (%d): %v", user.Name, status, err)
+		return false
+	}
+	_, status, err := app.authJf.Authenticate(user.Name, password)
+	policy.IsDisabled = true
+	if status, err := app.jf.SetPolicy(user.ID, policy); !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to disable \"%s\" again after checking their password (%d): %v", user.Name, status, err)
+	}
+	return status == 200 && err == nil
+}
+
+// extensionCodeLock serializes redemptions, so a code can't be used more times than it allows.
+var extensionCodeLock sync.Mutex
+
+// claimExtensionCode checks the code can be used by the user, then extends their expiry and uses up the code.
+// Returns whether the user should be re-enabled, as they were disabled on expiry.
+func (app *appContext) claimExtensionCode(id, codeStr string) (code ExtensionCode, expiry UserExpiry, user mediabrowser.User, reEnable bool, status int, errKey string) {
+	extensionCodeLock.Lock()
+	defer extensionCodeLock.Unlock()
+	code, ok := app.storage.GetExtensionCodeKey(codeStr)
+	if !ok || (!code.NoLimit && code.RemainingUses <= 0) {
+		return code, expiry, user, false, 400, "errorInvalidExtensionCode"
+	}
+	for _, redeemer := range code.RedeemedBy {
+		if redeemer == id {
+			return code, expiry, user, false, 400, "errorCodeAlreadyRedeemed"
+		}
+	}
+	if code.Profile != "" {
+		if profile, ok := app.storage.GetUserProfileKey(id); !ok || profile.Profile != code.Profile {
+			return code, expiry, user, false, 400, "errorCodeNotForYou"
+		}
+	}
+	user, status, err := app.jf.UserByID(id, false)
+	if status != 200 || err != nil {
+		app.err.Printf("Failed to get user \"%s\" (%d): %v", id, status, err)
+		return code, expiry, user, false, 500, "errorUnknown"
+	}
+	base := time.Now()
+	if existing, ok := app.storage.GetUserExpiryKey(id); ok {
+		if existing.Expiry.After(base) {
+			base = existing.Expiry
+		}
+	} else if user.Policy.IsDisabled && app.disabledByDaemon(id) {
+		reEnable = true
+	} else {
+		// Without an expiry, the user already has unlimited access.
+		return code, expiry, user, false, 400, "errorNoExpiry"
+	}
+
+	expiry = UserExpiry{Expiry: base.AddDate(0, 0, code.Days)}
+	app.storage.SetUserExpiryKey(id, expiry)
+	code.RedeemedBy = append(code.RedeemedBy, id)
+	if !code.NoLimit {
+		code.RemainingUses--
+	}
+	if !code.NoLimit && code.RemainingUses <= 0 {
+		app.storage.DeleteExtensionCodeKey(codeStr)
+	} else {
+		app.storage.SetExtensionCodeKey(codeStr, code)
+	}
+	return code, expiry, user, reEnable, 200, ""
+}
+
+// redeemExtensionCode extends the user's expiry with the given code, re-enabling them if they were disabled on expiry, and notifies them.
+// On failure, returns an HTTP status and the key of a notification string saying why. gc is only used for activity logging, and can be nil.
+func (app *appContext) redeemExtensionCode(gc *gin.Context, id, codeStr string) (code ExtensionCode, expiry UserExpiry, status int, errKey string) {
+	code, expiry, user, reEnable, status, errKey := app.claimExtensionCode(id, codeStr)
+	if errKey != "" {
+		return code, expiry, status, errKey
+	}
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityRedeemCode,
+		UserID:     id,
+		SourceType: ActivityUser,
+		Source:     id,
+		InviteCode: codeStr,
+		Value:      fmt.Sprint(code.Days),
+		Time:       time.Now(),
+	}, gc, true)
+	app.info.Printf("\"%s\" redeemed extension code \"%s\" for %d day(s)", user.Name, codeStr, code.Days)
+
+	if reEnable {
+		user.Policy.IsDisabled = false
+		status, err := app.jf.SetPolicy(id, user.Policy)
+		if !(status == 200 || status == 204) || err != nil {
+			app.err.Printf("Failed to re-enable \"%s\" (%d): %v", user.Name, status, err)
+		} else {
+			app.storage.SetActivityKey(shortuuid.New(), Activity{
+				Type:       ActivityEnabled,
+				UserID:     id,
+				SourceType: ActivityUser,
+				Source:     id,
+				Time:       time.Now(),
+			}, gc, true)
+			app.jf.CacheExpiry = time.Now()
+		}
+	}
+
+	if messagesEnabled {
+		msg, err := app.emailFor(id).constructExpiryExtended(user.Name, code.Days, expiry.Expiry, app)
+		if err != nil {
+			app.err.Printf("Failed to construct expiry extension message for \"%s\": %v", user.Name, err)
+		} else if err := app.sendByID(msg, id); err != nil {
+			app.err.Printf("Failed to send expiry extension message to \"%s\": %v", user.Name, err)
+		}
+	}
+	return code, expiry, 200, ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+func TestConcurrentExtensionCodeRedemption(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	app.storage.SetExtensionCodeKey("code", ExtensionCode{Days: 7, RemainingUses: 1})
+	const n = 8
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("redeemer%02d", i)
+		jf.addUser(mediabrowser.User{ID: id, Name: id})
+		app.storage.SetUserExpiryKey(id, UserExpiry{Expiry: time.Now().Add(time.Hour)})
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	redeemed := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, _, _, errKey := app.redeemExtensionCode(nil, id, "code"); errKey == "" {
+				lock.Lock()
+				redeemed++
+				lock.Unlock()
+			}
+		}(fmt.Sprintf("redeemer%02d", i))
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Errorf("single-use code redeemed %d times", redeemed)
+	}
+	if _, ok := app.storage.GetExtensionCodeKey("code"); ok {
+		t.Error("used up code not deleted")
+	}
+}
+
+func TestRedeemExtensionCodeWhileDisabled(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	jf.addUser(mediabrowser.User{ID: "expireduser", Name: "expired", Policy: mediabrowser.Policy{IsDisabled: true}})
+	jf.addUser(mediabrowser.User{ID: "banneduser", Name: "banned", Policy: mediabrowser.Policy{IsDisabled: true}})
+	jf.passwords["expired"] = "password"
+	jf.passwords["banned"] = "password"
+	app.storage.SetActivityKey("expired", Activity{Type: ActivityDisabled, UserID: "expireduser", SourceType: ActivityDaemon, Time: time.Now()}, nil, false)
+	app.storage.SetActivityKey("banned", Activity{Type: ActivityDisabled, UserID: "banneduser", SourceType: ActivityAdmin, Time: time.Now()}, nil, false)
+	app.storage.SetExtensionCodeKey("code", ExtensionCode{Days: 7, NoLimit: true})
+
+	for _, tc := range []struct {
+		name, username, password, code string
+		status                         int
+	}{
+		{"disabled by an admin", "banned", "password", "code", 401},
+		{"wrong password", "expired", "wrong", "code", 401},
+		{"invalid code", "expired", "password", "wrong", 400},
+		{"redeemed", "expired", "password", "code", 200},
+	} {
+		gc, w := testContext("POST", "/my/redeem/login", redeemCodeLoginDTO{Username: tc.username, Password: tc.password, Code: tc.code})
+		app.RedeemExtensionCodeLogin(gc)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+		if tc.status == 200 {
+			break
+		}
+		// Failed checks leave the account disabled.
+		if user, _, _ := app.jf.UserByID("expireduser", false); !user.Policy.IsDisabled {
+			t.Fatalf("%s: user left enabled", tc.name)
+		}
+	}
+	if user, _, _ := app.jf.UserByID("expireduser", false); user.Policy.IsDisabled {
+		t.Error("user not re-enabled")
+	}
+	if _, ok := app.storage.GetUserExpiryKey("expireduser"); !ok {
+		t.Error("expiry not set")
+	}
+	// Now they're enabled, they have to log in normally.
+	gc, w := testContext("POST", "/my/redeem/login", redeemCodeLoginDTO{Username: "expired", Password: "password", Code: "code"})
+	app.RedeemExtensionCodeLogin(gc)
+	if w.Code != 401 || !strings.Contains(w.Body.String(), "Unauthorized") {
+		t.Errorf("expected 401 for an enabled user, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -63,6 +63,13 @@ func (app *appContext) checkInvites() {
 				app.storage.SetEmailsKey(data.ReferrerJellyfinID, user)
 			}
 		}
+		if !data.IsReferral {
+			// The message is built in another goroutine, after the loop's moved on.
+			data := data
+			app.notifyAdmins(EventInviteExpired, func() (*Message, error) {
+				return app.email.constructExpiry(data.Code, data, app, false)
+			})
+		}
 		notify := data.Notify
 		if emailEnabled && app.config.Section("notifications").Key("enabled").MustBool(false) && len(notify) != 0 {
 			app.debug.Printf("%s: Expiry notification", data.Code)
@@ -74,13 +81,18 @@ func (app *appContext) checkInvites() {
 				wait.Add(1)
 				go func(addr string) {
 					defer wait.Done()
-					msg, err := app.email.constructExpiry(data.Code, data, app, false)
+					// Check whether notify "address" is an email address of Jellyfin ID
+					isEmail := strings.Contains(addr, "@")
+					emailer := app.email
+					if !isEmail {
+						emailer = app.emailFor(addr)
+					}
+					msg, err := emailer.constructExpiry(data.Code, data, app, false)
 					if err != nil {
 						app.err.Printf("%s: Failed to construct expiry notification: %v", data.Code, err)
 					} else {
-						// Check whether notify "address" is an email address of Jellyfin ID
-						if strings.Contains(addr, "@") {
-							err = app.email.send(msg, addr)
+						if isEmail {
+							err = app.sendEmail(msg, addr)
 						} else {
 							err = app.sendByID(msg, addr)
 						}
@@ -115,6 +127,11 @@ func (app *appContext) checkInvite(code string, used bool, username string) bool
 	expiry := inv.ValidTill
 	if currentTime.After(expiry) {
 		app.debug.Printf("Housekeeping: Deleting old invite %s", code)
+		if !inv.IsReferral {
+			app.notifyAdmins(EventInviteExpired, func() (*Message, error) {
+				return app.email.constructExpiry(code, inv, app, false)
+			})
+		}
 		notify := inv.Notify
 		if emailEnabled && app.config.Section("notifications").Key("enabled").MustBool(false) && len(notify) != 0 {
 			app.debug.Printf("%s: Expiry notification", code)
@@ -126,13 +143,18 @@ func (app *appContext) checkInvite(code string, used bool, username string) bool
 				wait.Add(1)
 				go func(addr string) {
 					defer wait.Done()
-					msg, err := app.email.constructExpiry(code, inv, app, false)
+					// Check whether notify "address" is an email address of Jellyfin ID
+					isEmail := strings.Contains(addr, "@")
+					emailer := app.email
+					if !isEmail {
+						emailer = app.emailFor(addr)
+					}
+					msg, err := emailer.constructExpiry(code, inv, app, false)
 					if err != nil {
 						app.err.Printf("%s: Failed to construct expiry notification: %v", code, err)
 					} else {
-						// Check whether notify "address" is an email address of Jellyfin ID
-						if strings.Contains(addr, "@") {
-							err = app.email.send(msg, addr)
+						if isEmail {
+							err = app.sendEmail(msg, addr)
 						} else {
 							err = app.sendByID(msg, addr)
 						}
@@ -256,7 +278,7 @@ func (app *appContext) GenerateInvite(gc *gin.Context) {
 				if discord != "" {
 					err = app.discord.SendDM(msg, discord)
 				} else {
-					err = app.email.send(msg, req.SendTo)
+					err = app.sendEmail(msg, req.SendTo)
 				}
 				if err != nil {
 					invite.SendTo = fmt.Sprintf("Failed to send to %s", req.SendTo)
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -152,7 +154,6 @@ func (app *appContext) SetCustomMessageState(gc *gin.Context) {
 // @Security Bearer
 // @tags Configuration
 func (app *appContext) GetCustomMessageTemplate(gc *gin.Context) {
-	lang := app.storage.lang.chosenEmailLang
 	id := gc.Param("id")
 	var content string
 	var err error
@@ -160,8 +161,6 @@ func (app *appContext) GetCustomMessageTemplate(gc *gin.Context) {
 	var variables []string
 	var conditionals []string
 	var values map[string]interface{}
-	username := app.storage.lang.Email[lang].Strings.get("username")
-	emailAddress := app.storage.lang.Email[lang].Strings.get("emailAddress")
 	customMessage, ok := app.storage.GetCustomContentKey(id)
 	if !ok && id != "Announcement" {
 		app.err.Printf("Failed to get custom message with ID \"%s\"", id)
@@ -191,78 +190,51 @@ func (app *appContext) GetCustomMessageTemplate(gc *gin.Context) {
 		if noContent {
 			msg, err = app.email.constructCreated("", "", "", Invite{}, app, true)
 		}
-		values = app.email.createdValues("xxxxxx", username, emailAddress, Invite{}, app, false)
 	case "InviteExpiry":
 		if noContent {
 			msg, err = app.email.constructExpiry("", Invite{}, app, true)
 		}
-		values = app.email.expiryValues("xxxxxx", Invite{}, app, false)
 	case "PasswordReset":
 		if noContent {
 			msg, err = app.email.constructReset(PasswordReset{}, app, true)
 		}
-		values = app.email.resetValues(PasswordReset{Pin: "12-34-56", Username: username}, app, false)
 	case "UserDeleted":
 		if noContent {
 			msg, err = app.email.constructDeleted("", app, true)
 		}
-		values = app.email.deletedValues(app.storage.lang.Email[lang].Strings.get("reason"), app, false)
 	case "UserDisabled":
 		if noContent {
 			msg, err = app.email.constructDisabled("", app, true)
 		}
-		values = app.email.deletedValues(app.storage.lang.Email[lang].Strings.get("reason"), app, false)
 	case "UserEnabled":
 		if noContent {
 			msg, err = app.email.constructEnabled("", app, true)
 		}
-		values = app.email.deletedValues(app.storage.lang.Email[lang].Strings.get("reason"), app, false)
 	case "InviteEmail":
 		if noContent {
 			msg, err = app.email.constructInvite("", Invite{}, app, true)
 		}
-		values = app.email.inviteValues("xxxxxx", Invite{}, app, false)
 	case "WelcomeEmail":
 		if noContent {
 			msg, err = app.email.constructWelcome("", time.Time{}, app, true)
 		}
-		values = app.email.welcomeValues(username, time.Now(), app, false, true)
 	case "EmailConfirmation":
 		if noContent {
 			msg, err = app.email.constructConfirmation("", "", "", app, true)
 		}
-		values = app.email.confirmationValues("xxxxxx", username, "xxxxxx", app, false)
 	case "UserExpired":
 		if noContent {
 			msg, err = app.email.constructUserExpired(app, true)
 		}
-		values = app.email.userExpiredValues(app, false)
-	case "UserLogin", "UserPage":
-		values = map[string]interface{}{}
 	}
+	values = app.sampleMessageValues(app.email, id)
 	if err != nil {
 		respondBool(500, false, gc)
 		return
 	}
 	if noContent && id != "Announcement" && id != "UserPage" && id != "UserLogin" {
 		content = msg.Text
-		variables = make([]string, strings.Count(content, "{"))
-		i := 0
-		found := false
-		buf := ""
-		for _, c := range content {
-			if !found && c != '{' && c != '}' {
-				continue
-			}
-			found = true
-			buf += string(c)
-			if c == '}' {
-				found = false
-				variables[i] = buf
-				buf = ""
-				i++
-			}
-		}
+		variables = templateVariables(content)
 		customMessage.Variables = variables
 	}
 	if variables == nil {
@@ -285,6 +257,233 @@ func (app *appContext) GetCustomMessageTemplate(gc *gin.Context) {
 	gc.JSON(200, customEmailDTO{Content: content, Variables: variables, Conditionals: conditionals, Values: values, HTML: mail.HTML, Plaintext: mail.Text})
 }
 
+// templateVariables returns the {variables} used in the given content.
+func templateVariables(content string) (variables []string) {
+	found := false
+	buf := ""
+	for _, c := range content {
+		if c == '{' {
+			found = true
+		}
+		// Also skips a '}' with no '{' before it.
+		if !found {
+			continue
+		}
+		buf += string(c)
+		if c == '}' {
+			found = false
+			variables = append(variables, buf)
+			buf = ""
+		}
+	}
+	return
+}
+
+// sampleMessageValues returns example values for the variables of the given message, for previews.
+func (app *appContext) sampleMessageValues(emailer *Emailer, id string) map[string]interface{} {
+	username := emailer.lang.Strings.get("username")
+	emailAddress := emailer.lang.Strings.get("emailAddress")
+	switch id {
+	case "UserCreated":
+		return emailer.createdValues("xxxxxx", username, emailAddress, Invite{}, app, false)
+	case "InviteExpiry":
+		return emailer.expiryValues("xxxxxx", Invite{}, app, false)
+	case "PasswordReset":
+		return emailer.resetValues(PasswordReset{Pin: "12-34-56", Username: username}, app, false)
+	case "UserDeleted", "UserDisabled", "UserEnabled":
+		return emailer.deletedValues(emailer.lang.Strings.get("reason"), app, false)
+	case "InviteEmail":
+		return emailer.inviteValues("xxxxxx", Invite{}, app, false)
+	case "WelcomeEmail":
+		return emailer.welcomeValues(username, time.Now(), app, false, true)
+	case "EmailConfirmation":
+		return emailer.confirmationValues("xxxxxx", username, "xxxxxx", app, false)
+	case "UserExpired":
+		return emailer.userExpiredValues(app, false)
+	case "UserPage":
+		return map[string]interface{}{"username": username}
+	}
+	return map[string]interface{}{}
+}
+
+// defaultMessageTemplate returns the section and key fragment of the message's built-in template, and its subject.
+// ok is false for messages without one, like announcements and the user page.
+func (app *appContext) defaultMessageTemplate(emailer *Emailer, id string) (section, keyFragment, subject string, ok bool) {
+	lang := emailer.lang
+	switch id {
+	case "UserCreated":
+		return "notifications", "created_", lang.UserCreated.get("title"), true
+	case "InviteExpiry":
+		return "notifications", "expiry_", lang.InviteExpiry.get("title"), true
+	case "PasswordReset":
+		return "password_resets", "email_", app.config.Section("password_resets").Key("subject").MustString(lang.PasswordReset.get("title")), true
+	case "UserDeleted":
+		return "deletion", "email_", app.config.Section("deletion").Key("subject").MustString(lang.UserDeleted.get("title")), true
+	case "UserDisabled":
+		return "disable_enable", "disabled_", app.config.Section("disable_enable").Key("subject_disabled").MustString(lang.UserDisabled.get("title")), true
+	case "UserEnabled":
+		return "disable_enable", "enabled_", app.config.Section("disable_enable").Key("subject_enabled").MustString(lang.UserEnabled.get("title")), true
+	case "InviteEmail":
+		return "invite_emails", "email_", app.config.Section("invite_emails").Key("subject").MustString(lang.InviteEmail.get("title")), true
+	case "WelcomeEmail":
+		return "welcome_email", "email_", app.config.Section("welcome_email").Key("subject").MustString(lang.WelcomeEmail.get("title")), true
+	case "EmailConfirmation":
+		return "email_confirmation", "email_", app.config.Section("email_confirmation").Key("subject").MustString(lang.EmailConfirmation.get("title")), true
+	case "UserExpired":
+		return "user_expiry", "email_", app.config.Section("user_expiry").Key("subject").MustString(lang.UserExpired.get("title")), true
+	}
+	return "", "", "", false
+}
+
+// renderCustomMessage builds the given message with sample values, overridden by any in req.
+// req.Content is used if given, otherwise the stored custom content if enabled, otherwise the built-in template.
+func (app *appContext) renderCustomMessage(id string, req customMessagePreviewReqDTO) (*Message, error) {
+	emailer := app.email.withLang(app, req.Lang)
+	values := app.sampleMessageValues(emailer, id)
+	for k, v := range req.Values {
+		values[k] = v
+	}
+	section, keyFragment, subject, hasDefault := app.defaultMessageTemplate(emailer, id)
+	if req.Subject != "" {
+		subject = req.Subject
+	}
+	content := req.Content
+	if content == "" {
+		if customMessage, ok := app.storage.GetCustomContentKey(id); ok && customMessage.Enabled {
+			content = customMessage.Content
+		}
+	}
+	var msg *Message
+	var err error
+	if content != "" {
+		var conditionals []string
+		if id == "WelcomeEmail" {
+			conditionals = []string{"{yourAccountWillExpire}"}
+		}
+		msg, err = emailer.constructTemplate(subject, templateEmail(content, templateVariables(content), conditionals, values), app)
+	} else if hasDefault {
+		msg = &Message{Subject: subject}
+		msg.HTML, msg.Text, msg.Markdown, err = emailer.construct(app, section, keyFragment, values)
+	} else {
+		return nil, fmt.Errorf("no content given for \"%s\"", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Markdown is only built when a bot is enabled, otherwise the text is what's sent to other contact methods.
+	if msg.Markdown == "" {
+		msg.Markdown = msg.Text
+	}
+	return msg, nil
+}
+
+// @Summary Renders a message with sample values, or those given, for previewing. Uses the given content, otherwise the custom content if enabled, otherwise the default template.
+// @Produce json
+// @Param customMessagePreviewReqDTO body customMessagePreviewReqDTO true "Content and variable values to override."
+// @Success 200 {object} customMessagePreviewDTO
+// @Failure 400 {object} stringResponse
+// @Param id path string true "ID of email"
+// @Router /config/emails/{id}/preview [post]
+// @Security Bearer
+// @tags Configuration
+func (app *appContext) PreviewCustomMessage(gc *gin.Context) {
+	var req customMessagePreviewReqDTO
+	gc.BindJSON(&req)
+	id := gc.Param("id")
+	msg, err := app.renderCustomMessage(id, req)
+	if err != nil {
+		app.err.Printf("Failed to render preview of \"%s\": %v", id, err)
+		respond(400, err.Error(), gc)
+		return
+	}
+	gc.JSON(200, customMessagePreviewDTO{Subject: msg.Subject, HTML: msg.HTML, Text: msg.Text, Markdown: msg.Markdown})
+}
+
+// @Summary Renders a message like /config/emails/{id}/preview and sends it to a user or email address. If a contact method isn't given, the user's preferred ones are used.
+// @Produce json
+// @Param customMessageTestReqDTO body customMessageTestReqDTO true "Recipient, and content and variable values to override."
+// @Success 200 {object} customMessageTestDTO
+// @Failure 400 {object} stringResponse
+// @Param id path string true "ID of email"
+// @Router /config/emails/{id}/test [post]
+// @Security Bearer
+// @tags Configuration
+func (app *appContext) TestCustomMessage(gc *gin.Context) {
+	var req customMessageTestReqDTO
+	gc.BindJSON(&req)
+	id := gc.Param("id")
+	if (req.User == "") == (req.Address == "") {
+		respond(400, "Give one of user or address", gc)
+		return
+	}
+	if req.Lang == "" && req.User != "" {
+		req.Lang = app.userLang(req.User)
+	}
+	msg, err := app.renderCustomMessage(id, req.customMessagePreviewReqDTO)
+	if err != nil {
+		app.err.Printf("Failed to render test of \"%s\": %v", id, err)
+		respond(400, err.Error(), gc)
+		return
+	}
+	resp := customMessageTestDTO{
+		customMessagePreviewDTO: customMessagePreviewDTO{Subject: msg.Subject, HTML: msg.HTML, Text: msg.Text, Markdown: msg.Markdown},
+	}
+	if req.Address != "" {
+		if !emailEnabled {
+			respond(400, "Email is disabled", gc)
+			return
+		}
+		err = app.email.send(msg, req.Address)
+	} else if req.Method != "" {
+		cm, ok := app.contactMethod(req.Method)
+		if !ok || !cm.Enabled() {
+			respond(400, "Unknown or disabled contact method", gc)
+			return
+		}
+		if _, ok := cm.Linked(req.User); !ok {
+			err = fmt.Errorf("user has no linked %s account", cm.Name())
+		} else {
+			err = cm.Send(msg, req.User)
+		}
+	} else {
+		err = app.sendTestByID(msg, req.User)
+	}
+	resp.Sent = err == nil
+	if err != nil {
+		resp.Error = err.Error()
+		app.err.Printf("Failed to send test of \"%s\": %v", id, err)
+	} else {
+		app.info.Printf("Sent test of \"%s\"", id)
+	}
+	gc.JSON(200, resp)
+}
+
+// sendTestByID sends through each contact method the user has chosen to be contacted through, like sendByID,
+// but returns an error if there are none, and doesn't record failures for the admin digest.
+func (app *appContext) sendTestByID(msg *Message, jfID string) error {
+	sent := false
+	var errs []string
+	for _, cm := range app.contactMethods {
+		if !cm.Enabled() {
+			continue
+		}
+		if link, ok := cm.Linked(jfID); ok && link.Contact {
+			if err := cm.Send(msg, jfID); err != nil {
+				errs = append(errs, cm.Name()+": "+err.Error())
+			} else {
+				sent = true
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	if !sent {
+		return fmt.Errorf("user has no contact methods enabled")
+	}
+	return nil
+}
+
 // @Summary Returns a new Telegram verification PIN, and the bot username.
 // @Produce json
 // @Success 200 {object} telegramPinDTO
@@ -329,7 +528,7 @@ func (app *appContext) TelegramAddUser(gc *gin.Context) {
 		tgUser.Lang = lang
 	}
 	app.storage.SetTelegramKey(req.ID, tgUser)
-	linkExistingOmbiDiscordTelegram(app)
+	linkExistingRequestManagerDiscordTelegram(app)
 	respondBool(200, true, gc)
 }
 
@@ -353,53 +552,17 @@ func (app *appContext) SetContactMethods(gc *gin.Context) {
 }
 
 func (app *appContext) setContactMethods(req SetContactMethodsDTO, gc *gin.Context) {
-	if tgUser, ok := app.storage.GetTelegramKey(req.ID); ok {
-		change := tgUser.Contact != req.Telegram
-		tgUser.Contact = req.Telegram
-		app.storage.SetTelegramKey(req.ID, tgUser)
-		if change {
-			msg := ""
-			if !req.Telegram {
-				msg = " not"
-			}
-			app.debug.Printf("Telegram: User \"%s\" will%s be notified through Telegram.", tgUser.Username, msg)
+	for _, cm := range app.contactMethods {
+		contact := req.Contact[cm.Name()]
+		link, ok := cm.Linked(req.ID)
+		if !ok || !cm.SetContact(req.ID, contact) || link.Contact == contact {
+			continue
 		}
-	}
-	if dcUser, ok := app.storage.GetDiscordKey(req.ID); ok {
-		change := dcUser.Contact != req.Discord
-		dcUser.Contact = req.Discord
-		app.storage.SetDiscordKey(req.ID, dcUser)
-		if change {
-			msg := ""
-			if !req.Discord {
-				msg = " not"
-			}
-			app.debug.Printf("Discord: User \"%s\" will%s be notified through Discord.", dcUser.Username, msg)
-		}
-	}
-	if mxUser, ok := app.storage.GetMatrixKey(req.ID); ok {
-		change := mxUser.Contact != req.Matrix
-		mxUser.Contact = req.Matrix
-		app.storage.SetMatrixKey(req.ID, mxUser)
-		if change {
-			msg := ""
-			if !req.Matrix {
-				msg = " not"
-			}
-			app.debug.Printf("Matrix: User \"%s\" will%s be notified through Matrix.", mxUser.UserID, msg)
-		}
-	}
-	if email, ok := app.storage.GetEmailsKey(req.ID); ok {
-		change := email.Contact != req.Email
-		email.Contact = req.Email
-		app.storage.SetEmailsKey(req.ID, email)
-		if change {
-			msg := ""
-			if !req.Email {
-				msg = " not"
-			}
-			app.debug.Printf("\"%s\" will%s be notified via Email.", email.Addr, msg)
+		msg := ""
+		if !contact {
+			msg = " not"
 		}
+		app.debug.Printf("\"%s\" will%s be notified through %s.", link.Display, msg, cm.Name())
 	}
 	respondBool(200, true, gc)
 }
@@ -441,6 +604,31 @@ func (app *appContext) TelegramVerifiedInvite(gc *gin.Context) {
 	respondBool(200, ok, gc)
 }
 
+// @Summary Returns true/false on whether or not a Signal PIN was verified. Requires invite code.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Failure 401 {object} boolResponse
+// @Param pin path string true "PIN code to check"
+// @Param invCode path string true "invite Code"
+// @Router /invite/{invCode}/signal/verified/{pin} [get]
+// @tags Other
+func (app *appContext) SignalVerifiedInvite(gc *gin.Context) {
+	code := gc.Param("invCode")
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		respondBool(401, false, gc)
+		return
+	}
+	pin := gc.Param("pin")
+	token, ok := app.signal.TokenVerified(pin)
+	if ok && app.config.Section("signal").Key("require_unique").MustBool(false) && app.signal.UserExists(token.Number) {
+		app.signal.DeleteVerifiedToken(pin)
+		respondBool(400, false, gc)
+		return
+	}
+	respondBool(200, ok, gc)
+}
+
 // @Summary Returns true/false on whether or not a discord PIN was verified. Requires invite code.
 // @Produce json
 // @Success 200 {object} boolResponse
@@ -565,6 +753,78 @@ func (app *appContext) MatrixCheckPIN(gc *gin.Context) {
 	respondBool(200, true, gc)
 }
 
+// @Summary Text a new verification PIN to a phone number. Requires invite code.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Param invCode path string true "invite Code"
+// @Failure 429 {object} stringResponse
+// @Param SMSSendPINDTO body SMSSendPINDTO true "Phone number."
+// @Router /invite/{invCode}/sms/number [post]
+// @tags Other
+func (app *appContext) SMSSendPIN(gc *gin.Context) {
+	code := gc.Param("invCode")
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		respondBool(401, false, gc)
+		return
+	}
+	var req SMSSendPINDTO
+	gc.BindJSON(&req)
+	app.respondSendSMSPIN(req, "invite:"+code, false, gc)
+}
+
+// respondSendSMSPIN validates the number and texts it a PIN, for both the invite form and user page.
+// Sent PINs are counted against the client's IP, the given requester (invite or user) and a global limit, as each one costs money.
+func (app *appContext) respondSendSMSPIN(req SMSSendPINDTO, requester string, userpage bool, gc *gin.Context) {
+	number, ok := normalizeSMSNumber(req.Number)
+	if !ok {
+		respond(400, "errorInvalidNumber", gc)
+		return
+	}
+	if cm, _ := app.contactMethod("sms"); app.requireUnique(cm) && cm.Exists(number) {
+		respond(400, "errorAccountLinked", gc)
+		return
+	}
+	keys := []string{"sms:" + BAN_IP_PREFIX + gc.ClientIP(), "sms:" + requester}
+	_, locked := app.sms.limiter.Locked(keys...)
+	if _, globalLocked := app.sms.globalLimiter.Locked(SMS_GLOBAL_LIMIT_KEY); locked || globalLocked {
+		app.logIpInfo(gc, userpage, fmt.Sprintf("SMS: Not sending PIN for \"%s\", too many sent", requester))
+		respond(429, "errorTooManyPINs", gc)
+		return
+	}
+	if !app.sms.SendPIN(number, req.Lang) {
+		respondBool(500, false, gc)
+		return
+	}
+	app.recordAttempt(gc, app.sms.limiter, userpage, keys...)
+	if len(app.sms.globalLimiter.Fail(SMS_GLOBAL_LIMIT_KEY)) != 0 {
+		app.err.Println("SMS: Hourly PIN limit reached, no more will be sent for now")
+	}
+	respondBool(200, true, gc)
+}
+
+// @Summary Check whether an SMS PIN is valid, and mark the token as verified if so. Requires invite code.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 401 {object} boolResponse
+// @Param pin path string true "PIN code to check"
+// @Param invCode path string true "invite Code"
+// @Param number path string true "Phone number"
+// @Router /invite/{invCode}/sms/verified/{number}/{pin} [get]
+// @tags Other
+func (app *appContext) SMSCheckPIN(gc *gin.Context) {
+	code := gc.Param("invCode")
+	if _, ok := app.storage.GetInvitesKey(code); !ok {
+		app.debug.Println("SMS: Invite code was invalid")
+		respondBool(401, false, gc)
+		return
+	}
+	number, _ := normalizeSMSNumber(gc.Param("number"))
+	respondBool(200, app.sms.CheckPIN(gc.Param("pin"), number), gc)
+}
+
 // @Summary Generates a Matrix access token from a username and password.
 // @Produce json
 // @Success 200 {object} boolResponse
@@ -694,7 +954,7 @@ func (app *appContext) DiscordConnect(gc *gin.Context) {
 		Time:       time.Now(),
 	}, gc, false)
 
-	linkExistingOmbiDiscordTelegram(app)
+	linkExistingRequestManagerDiscordTelegram(app)
 	respondBool(200, true, gc)
 }
 
@@ -708,22 +968,8 @@ func (app *appContext) DiscordConnect(gc *gin.Context) {
 func (app *appContext) UnlinkDiscord(gc *gin.Context) {
 	var req forUserDTO
 	gc.BindJSON(&req)
-	/* user, status, err := app.jf.UserByID(req.ID, false)
-	if req.ID == "" || status != 200 || err != nil {
-		respond(400, "User not found", gc)
-		return
-	} */
-	app.storage.DeleteDiscordKey(req.ID)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     req.ID,
-		SourceType: ActivityAdmin,
-		Source:     gc.GetString("jfId"),
-		Value:      "discord",
-		Time:       time.Now(),
-	}, gc, false)
-
+	cm, _ := app.contactMethod("discord")
+	app.unlinkContact(gc, cm, req.ID, ActivityAdmin)
 	respondBool(200, true, gc)
 }
 
@@ -737,22 +983,8 @@ func (app *appContext) UnlinkDiscord(gc *gin.Context) {
 func (app *appContext) UnlinkTelegram(gc *gin.Context) {
 	var req forUserDTO
 	gc.BindJSON(&req)
-	/* user, status, err := app.jf.UserByID(req.ID, false)
-	if req.ID == "" || status != 200 || err != nil {
-		respond(400, "User not found", gc)
-		return
-	} */
-	app.storage.DeleteTelegramKey(req.ID)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     req.ID,
-		SourceType: ActivityAdmin,
-		Source:     gc.GetString("jfId"),
-		Value:      "telegram",
-		Time:       time.Now(),
-	}, gc, false)
-
+	cm, _ := app.contactMethod("telegram")
+	app.unlinkContact(gc, cm, req.ID, ActivityAdmin)
 	respondBool(200, true, gc)
 }
 
@@ -766,21 +998,44 @@ func (app *appContext) UnlinkTelegram(gc *gin.Context) {
 func (app *appContext) UnlinkMatrix(gc *gin.Context) {
 	var req forUserDTO
 	gc.BindJSON(&req)
-	/* user, status, err := app.jf.UserByID(req.ID, false)
-	if req.ID == "" || status != 200 || err != nil {
-		respond(400, "User not found", gc)
-		return
-	} */
-	app.storage.DeleteMatrixKey(req.ID)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     req.ID,
-		SourceType: ActivityAdmin,
-		Source:     gc.GetString("jfId"),
-		Value:      "matrix",
-		Time:       time.Now(),
-	}, gc, false)
+	cm, _ := app.contactMethod("matrix")
+	app.unlinkContact(gc, cm, req.ID, ActivityAdmin)
+	respondBool(200, true, gc)
+}
 
+// @Summary unlink the account on the given contact method from a Jellyfin user.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Param method path string true "Contact method, e.g. discord."
+// @Param forUserDTO body forUserDTO true "User's Jellyfin ID."
+// @Router /users/contact/{method} [delete]
+// @Security Bearer
+// @Tags Users
+func (app *appContext) UnlinkContact(gc *gin.Context) {
+	var req forUserDTO
+	gc.BindJSON(&req)
+	cm, ok := app.contactMethod(gc.Param("method"))
+	if !ok || req.ID == "" {
+		respondBool(400, false, gc)
+		return
+	}
+	app.unlinkContact(gc, cm, req.ID, ActivityAdmin)
 	respondBool(200, true, gc)
 }
+
+// @Summary Get the health of each email transport (the main email method and its fallbacks), in the order they're tried.
+// @Produce json
+// @Success 200 {object} EmailTransportsDTO
+// @Router /email/transports [get]
+// @Security Bearer
+// @tags Other
+func (app *appContext) GetEmailTransports(gc *gin.Context) {
+	resp := EmailTransportsDTO{Transports: []EmailTransportDTO{}}
+	if app.email != nil {
+		if failover, ok := app.email.sender.(*FailoverClient); ok {
+			resp.Transports = failover.Status()
+		}
+	}
+	gc.JSON(200, resp)
+}
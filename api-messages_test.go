@@ -0,0 +1,19 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	for content, expected := range map[string][]string{
+		"Hi {username}, your account expires {date}.": {"{username}", "{date}"},
+		"Hi {username}, see you :}":                   {"{username}"},
+		"} {username} {unclosed":                      {"{username}"},
+		"Nothing here.":                               nil,
+	} {
+		if variables := templateVariables(content); !reflect.DeepEqual(variables, expected) {
+			t.Errorf("%q: expected %v, got %v", content, expected, variables)
+		}
+	}
+}
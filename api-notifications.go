@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get the admin notification routes: where notifications for each event are sent.
+// @Produce json
+// @Success 200 {object} notificationRoutesDTO
+// @Router /notifications/routes [get]
+// @Security Bearer
+// @tags Other
+func (app *appContext) GetNotificationRoutes(gc *gin.Context) {
+	resp := notificationRoutesDTO{
+		Events: adminEvents,
+		Types:  destinationTypes(),
+		Routes: map[string][]NotificationDestination{},
+	}
+	for _, event := range adminEvents {
+		resp.Routes[event] = []NotificationDestination{}
+	}
+	for _, route := range app.storage.GetNotificationRoutes() {
+		if _, ok := resp.Routes[route.Event]; ok && route.Destinations != nil {
+			resp.Routes[route.Event] = route.Destinations
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Set where notifications for an event are sent, replacing any existing destinations.
+// @Produce json
+// @Param event path string true "Event to route, e.g. user_created."
+// @Param setNotificationRouteDTO body setNotificationRouteDTO true "Destinations for the event."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Router /notifications/routes/{event} [post]
+// @Security Bearer
+// @tags Other
+func (app *appContext) SetNotificationRoute(gc *gin.Context) {
+	event := gc.Param("event")
+	var req setNotificationRouteDTO
+	gc.BindJSON(&req)
+	valid := false
+	for _, e := range adminEvents {
+		if e == event {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		respond(400, "Invalid event", gc)
+		return
+	}
+	for i, dest := range req.Destinations {
+		dest.Target = strings.TrimSpace(dest.Target)
+		req.Destinations[i] = dest
+		if dest.Target == "" {
+			respond(400, "Empty target", gc)
+			return
+		}
+		switch dest.Type {
+		case DestinationEmail:
+			if !strings.Contains(dest.Target, "@") {
+				respond(400, "Invalid email address", gc)
+				return
+			}
+		case DestinationTelegram:
+			if _, err := strconv.ParseInt(dest.Target, 10, 64); err != nil {
+				respond(400, "Invalid Telegram chat ID", gc)
+				return
+			}
+		case DestinationSMS:
+			number, ok := normalizeSMSNumber(dest.Target)
+			if !ok {
+				respond(400, "Invalid phone number", gc)
+				return
+			}
+			req.Destinations[i].Target = number
+		case DestinationDiscord, DestinationMatrix, DestinationSignal:
+		default:
+			respond(400, "Invalid destination type", gc)
+			return
+		}
+	}
+	if len(req.Destinations) == 0 {
+		app.storage.DeleteNotificationRouteKey(event)
+	} else {
+		app.storage.SetNotificationRouteKey(event, NotificationRoute{Destinations: req.Destinations})
+	}
+	app.info.Printf("Set %d destination(s) for \"%s\" admin notifications", len(req.Destinations), event)
+	respondBool(200, true, gc)
+}
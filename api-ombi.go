@@ -1,62 +1,9 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/gin-gonic/gin"
-	"github.com/hrfee/mediabrowser"
 )
 
-func (app *appContext) getOmbiUser(jfID string) (map[string]interface{}, int, error) {
-	ombiUsers, code, err := app.ombi.GetUsers()
-	if err != nil || code != 200 {
-		return nil, code, err
-	}
-	jfUser, code, err := app.jf.UserByID(jfID, false)
-	if err != nil || code != 200 {
-		return nil, code, err
-	}
-	username := jfUser.Name
-	email := ""
-	if e, ok := app.storage.GetEmailsKey(jfID); ok {
-		email = e.Addr
-	}
-	for _, ombiUser := range ombiUsers {
-		ombiAddr := ""
-		if a, ok := ombiUser["emailAddress"]; ok && a != nil {
-			ombiAddr = a.(string)
-		}
-		if ombiUser["userName"].(string) == username || (ombiAddr == email && email != "") {
-			return ombiUser, code, err
-		}
-	}
-	return nil, 400, fmt.Errorf("couldn't find user")
-}
-
-// Returns a user with the given name who has been imported from Jellyfin/Emby by Ombi
-func (app *appContext) getOmbiImportedUser(name string) (map[string]interface{}, int, error) {
-	// Ombi User Types: 3/4 = Emby, 5 = Jellyfin
-	ombiUsers, code, err := app.ombi.GetUsers()
-	if err != nil || code != 200 {
-		return nil, code, err
-	}
-	for _, ombiUser := range ombiUsers {
-		if ombiUser["userName"].(string) == name {
-			uType, ok := ombiUser["userType"].(int)
-			if !ok { // Don't know if Ombi somehow allows duplicate usernames
-				continue
-			}
-			if serverType == mediabrowser.JellyfinServer && uType != 5 { // Jellyfin
-				continue
-			} else if uType != 3 && uType != 4 { // Emby
-				continue
-			}
-			return ombiUser, code, err
-		}
-	}
-	return nil, 400, fmt.Errorf("couldn't find user")
-}
-
 // @Summary Get a list of Ombi users.
 // @Produce json
 // @Success 200 {object} ombiUsersDTO
@@ -66,17 +13,22 @@ func (app *appContext) getOmbiImportedUser(name string) (map[string]interface{},
 // @tags Ombi
 func (app *appContext) OmbiUsers(gc *gin.Context) {
 	app.debug.Println("Ombi users requested")
-	users, status, err := app.ombi.GetUsers()
+	rm, ok := app.requestManager("ombi")
+	if !ok {
+		respond(500, "Ombi not enabled", gc)
+		return
+	}
+	users, status, err := rm.GetUsers()
 	if err != nil || status != 200 {
 		app.err.Printf("Failed to get users from Ombi (%d): %v", status, err)
 		respond(500, "Couldn't get users", gc)
 		return
 	}
 	userlist := make([]ombiUser, len(users))
-	for i, data := range users {
+	for i, user := range users {
 		userlist[i] = ombiUser{
-			Name: data["userName"].(string),
-			ID:   data["id"].(string),
+			Name: user.Name,
+			ID:   user.ID,
 		}
 	}
 	gc.JSON(200, ombiUsersDTO{Users: userlist})
@@ -101,13 +53,21 @@ func (app *appContext) SetOmbiProfile(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
-	template, code, err := app.ombi.TemplateByID(req.ID)
+	rm, ok := app.requestManager("ombi")
+	if !ok {
+		respond(500, "Ombi not enabled", gc)
+		return
+	}
+	template, code, err := rm.TemplateByID(req.ID)
 	if err != nil || code != 200 || len(template) == 0 {
 		app.err.Printf("Couldn't get user from Ombi (%d): %v", code, err)
 		respond(500, "Couldn't get user", gc)
 		return
 	}
-	profile.Ombi = template
+	if profile.RequestManagers == nil {
+		profile.RequestManagers = map[string]map[string]interface{}{}
+	}
+	profile.RequestManagers[rm.Name()] = template
 	app.storage.SetProfileKey(profileName, profile)
 	respondBool(204, true, gc)
 }
@@ -128,22 +88,7 @@ func (app *appContext) DeleteOmbiProfile(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
-	profile.Ombi = nil
+	delete(profile.RequestManagers, "ombi")
 	app.storage.SetProfileKey(profileName, profile)
 	respondBool(204, true, gc)
 }
-
-func (app *appContext) applyOmbiProfile(user map[string]interface{}, profile map[string]interface{}) (status int, err error) {
-	for k, v := range profile {
-		switch v.(type) {
-		case map[string]interface{}, []interface{}:
-			user[k] = v
-		default:
-			if v != user[k] {
-				user[k] = v
-			}
-		}
-	}
-	status, err = app.ombi.ModifyUser(user)
-	return
-}
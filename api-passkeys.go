@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// @Summary Returns the passkeys registered by the user.
+// @Produce json
+// @Success 200 {object} passkeysDTO
+// @Router /my/passkeys [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) GetMyPasskeys(gc *gin.Context) {
+	passkeys, _ := app.storage.GetPasskeysKey(gc.GetString("jfId"))
+	resp := passkeysDTO{Passkeys: make([]passkeyDTO, len(passkeys.Credentials))}
+	for i, c := range passkeys.Credentials {
+		resp.Passkeys[i] = passkeyDTO{
+			ID:    base64.RawURLEncoding.EncodeToString(c.Credential.ID),
+			Name:  c.Name,
+			Added: c.Added.Unix(),
+		}
+		if !c.LastUsed.IsZero() {
+			resp.Passkeys[i].LastUsed = c.LastUsed.Unix()
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Begin registering a passkey. The returned options should be passed to navigator.credentials.create(), and the result sent to /my/passkeys/finish/{session}.
+// @Produce json
+// @Param newPasskeyDTO body newPasskeyDTO true "Passkey name"
+// @Success 200 {object} passkeyBeginDTO
+// @Failure 500 {object} stringResponse
+// @Router /my/passkeys/begin [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) BeginMyPasskeyRegistration(gc *gin.Context) {
+	var req newPasskeyDTO
+	gc.BindJSON(&req)
+	jfID := gc.GetString("jfId")
+	user, err := app.getPasskeyUser(jfID)
+	if err != nil {
+		app.err.Printf("Failed to begin passkey registration: %v", err)
+		respond(500, "Couldn't get user", gc)
+		return
+	}
+	exclusions := make([]protocol.CredentialDescriptor, len(user.passkeys.Credentials))
+	for i, c := range user.passkeys.Credentials {
+		exclusions[i] = c.Credential.Descriptor()
+	}
+	options, session, err := app.webauthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		app.err.Printf("Failed to begin passkey registration: %v", err)
+		respond(500, "Couldn't begin registration", gc)
+		return
+	}
+	if req.Name == "" {
+		req.Name = fmt.Sprintf("Passkey %d", len(user.passkeys.Credentials)+1)
+	}
+	id, ok := app.passkeySessions.Add(passkeySession{data: *session, jfID: jfID, name: req.Name})
+	if !ok {
+		app.err.Println("Failed to begin passkey registration: too many in progress")
+		respond(429, "errorTooManyAttempts", gc)
+		return
+	}
+	gc.JSON(200, passkeyBeginDTO{Session: id, Options: options})
+}
+
+// @Summary Finish registering a passkey, with the response from navigator.credentials.create() as the body.
+// @Produce json
+// @Param session path string true "Session ID from /my/passkeys/begin"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/passkeys/finish/{session} [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) FinishMyPasskeyRegistration(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	session, ok := app.passkeySessions.Pop(gc.Param("session"))
+	if !ok || session.jfID != jfID {
+		respond(400, "Invalid session", gc)
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(gc.Request.Body)
+	if err != nil {
+		app.debug.Printf("Failed to parse passkey registration: %v", err)
+		respond(400, "Invalid response", gc)
+		return
+	}
+	user, err := app.getPasskeyUser(jfID)
+	if err != nil {
+		app.err.Printf("Failed to finish passkey registration: %v", err)
+		respond(500, "Couldn't get user", gc)
+		return
+	}
+	credential, err := app.webauthn.CreateCredential(user, session.data, parsed)
+	if err != nil {
+		app.info.Printf("Passkey registration failed for \"%s\": %v", user.name, err)
+		respond(400, "Invalid response", gc)
+		return
+	}
+	user.passkeys.Credentials = append(user.passkeys.Credentials, PasskeyCredential{
+		Name:       session.name,
+		Added:      time.Now(),
+		Credential: *credential,
+	})
+	app.storage.SetPasskeysKey(jfID, user.passkeys)
+	app.info.Printf("Passkey \"%s\" registered for \"%s\"", session.name, user.name)
+	respondBool(200, true, gc)
+}
+
+// @Summary Delete one of the user's passkeys.
+// @Produce json
+// @Param id path string true "Passkey ID"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Router /my/passkeys/{id} [delete]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) DeleteMyPasskey(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	id, err := base64.RawURLEncoding.DecodeString(gc.Param("id"))
+	if err != nil {
+		respond(400, "Invalid ID", gc)
+		return
+	}
+	passkeys, ok := app.storage.GetPasskeysKey(jfID)
+	if !ok {
+		respond(400, "Passkey not found", gc)
+		return
+	}
+	for i, c := range passkeys.Credentials {
+		if string(c.Credential.ID) == string(id) {
+			passkeys.Credentials = append(passkeys.Credentials[:i], passkeys.Credentials[i+1:]...)
+			if len(passkeys.Credentials) == 0 {
+				app.storage.DeletePasskeysKey(jfID)
+			} else {
+				app.storage.SetPasskeysKey(jfID, passkeys)
+			}
+			respondBool(200, true, gc)
+			return
+		}
+	}
+	respond(400, "Passkey not found", gc)
+}
+
+// @Summary Begin logging in with a passkey. The returned options should be passed to navigator.credentials.get(), and the result sent to /token/passkey/finish/{session}.
+// @Produce json
+// @Success 200 {object} passkeyBeginDTO
+// @Failure 429 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /token/passkey/begin [post]
+// @tags Auth
+func (app *appContext) BeginPasskeyLogin(gc *gin.Context) {
+	app.beginPasskeyLogin(gc, false)
+}
+
+// @Summary Begin logging in to the user page with a passkey. The returned options should be passed to navigator.credentials.get(), and the result sent to /my/token/passkey/finish/{session}.
+// @Produce json
+// @Success 200 {object} passkeyBeginDTO
+// @Failure 429 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/token/passkey/begin [post]
+// @tags Auth
+func (app *appContext) BeginUserPasskeyLogin(gc *gin.Context) {
+	app.beginPasskeyLogin(gc, true)
+}
+
+func (app *appContext) beginPasskeyLogin(gc *gin.Context, userpage bool) {
+	if !app.checkRateLimit(gc, userpage, "") {
+		return
+	}
+	options, session, err := app.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		app.err.Printf("Failed to begin passkey login: %v", err)
+		respond(500, "Couldn't begin login", gc)
+		return
+	}
+	id, ok := app.passkeySessions.Add(passkeySession{data: *session})
+	if !ok {
+		app.logIpInfo(gc, userpage, "Passkey login denied: too many logins in progress")
+		respond(429, "errorTooManyAttempts", gc)
+		return
+	}
+	gc.JSON(200, passkeyBeginDTO{Session: id, Options: options})
+}
+
+// validatePasskeyLogin checks the assertion in the request body, and returns the Jellyfin ID of the user it belongs to.
+// Caller should return if ok is false.
+func (app *appContext) validatePasskeyLogin(gc *gin.Context, userpage bool) (jfID string, ok bool) {
+	if !app.checkRateLimit(gc, userpage, "") {
+		return
+	}
+	session, ok := app.passkeySessions.Pop(gc.Param("session"))
+	if !ok || session.jfID != "" {
+		respond(400, "Invalid session", gc)
+		ok = false
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(gc.Request.Body)
+	if err != nil {
+		app.logIpDebug(gc, userpage, fmt.Sprintf("Auth denied: Couldn't parse passkey assertion: %v", err))
+		respond(400, "Invalid response", gc)
+		ok = false
+		return
+	}
+	var user passkeyUser
+	credential, err := app.webauthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		u, err := app.getPasskeyUser(string(userHandle))
+		user = u
+		return u, err
+	}, session.data, parsed)
+	if err != nil {
+		app.logIpInfo(gc, userpage, fmt.Sprintf("Auth denied: Invalid passkey: %v", err))
+		app.recordFailedAttempt(gc, userpage, "")
+		respond(401, "Unauthorized", gc)
+		ok = false
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		app.logIpInfo(gc, userpage, fmt.Sprintf("Auth denied: Passkey for \"%s\" may have been cloned", user.name))
+		respond(401, "Unauthorized", gc)
+		ok = false
+		return
+	}
+	for i, c := range user.passkeys.Credentials {
+		if string(c.Credential.ID) == string(credential.ID) {
+			user.passkeys.Credentials[i].Credential.Authenticator.SignCount = credential.Authenticator.SignCount
+			user.passkeys.Credentials[i].LastUsed = time.Now()
+		}
+	}
+	jfID = user.passkeys.JellyfinID
+	app.storage.SetPasskeysKey(jfID, user.passkeys)
+	jfUser, status, err := app.jf.UserByID(jfID, false)
+	if status != 200 || err != nil {
+		app.err.Printf("Auth failed: Couldn't get user \"%s\" (%d): %v", jfID, status, err)
+		respond(500, "Jellyfin error", gc)
+		ok = false
+		return
+	}
+	if jfUser.Policy.IsDisabled {
+		app.logIpInfo(gc, userpage, "Auth denied: Jellyfin account disabled")
+		respond(403, "yourAccountWasDisabled", gc)
+		ok = false
+		return
+	}
+	ok = true
+	return
+}
+
+// @Summary Grabs an API token using a passkey assertion from navigator.credentials.get() as the body.
+// @Produce json
+// @Param session path string true "Session ID from /token/passkey/begin"
+// @description If the admin has TOTP enabled, a challenge is returned instead of a token, as with /token/login.
+// @Success 200 {object} getTokenDTO
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} stringResponse
+// @Router /token/passkey/finish/{session} [post]
+// @tags Auth
+func (app *appContext) FinishPasskeyLogin(gc *gin.Context) {
+	app.logIpInfo(gc, false, "Token requested (passkey)")
+	jfID, ok := app.validatePasskeyLogin(gc, false)
+	if !ok {
+		return
+	}
+	user, status, err := app.jf.UserByID(jfID, false)
+	if status != 200 || err != nil || !app.isAccountsAdmin(user) {
+		app.debug.Printf("Auth denied: Users \"%s\" isn't admin", user.Name)
+		respond(401, "Unauthorized", gc)
+		return
+	}
+	// A passkey can be registered from the user page with just a password, so it mustn't skip TOTP.
+	app.respondAdminTokenOrChallenge(shortuuid.New(), jfID, user.Name, gc)
+}
+
+// @Summary Grabs an user-access token using a passkey assertion from navigator.credentials.get() as the body.
+// @Produce json
+// @Param session path string true "Session ID from /my/token/passkey/begin"
+// @Success 200 {object} getTokenDTO
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} stringResponse
+// @Router /my/token/passkey/finish/{session} [post]
+// @tags Auth
+func (app *appContext) FinishUserPasskeyLogin(gc *gin.Context) {
+	app.logIpInfo(gc, true, "UserToken requested (passkey)")
+	jfID, ok := app.validatePasskeyLogin(gc, true)
+	if !ok {
+		return
+	}
+	app.debug.Printf("Token generated for non-admin user \"%s\"", jfID)
+	app.respondUserToken(jfID, gc)
+}
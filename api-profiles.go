@@ -22,12 +22,21 @@ func (app *appContext) GetProfiles(gc *gin.Context) {
 	referralsEnabled := app.config.Section("user_page").Key("referrals").MustBool(false)
 	baseInv := Invite{}
 	for _, p := range app.storage.GetProfiles() {
+		_, ombi := p.RequestManagers["ombi"]
 		pdto := profileDTO{
 			Admin:            p.Admin,
 			LibraryAccess:    p.LibraryAccess,
 			FromUser:         p.FromUser,
-			Ombi:             p.Ombi != nil,
+			Ombi:             ombi,
 			ReferralsEnabled: false,
+			ReferralQuota: referralQuotaDTO{
+				MonthlyQuota:   p.ReferralMonthlyQuota,
+				MaxOutstanding: p.ReferralMaxOutstanding,
+			},
+			Limits: profileLimitsDTO{
+				MaxStreams: p.MaxStreams,
+				MaxDevices: p.MaxDevices,
+			},
 		}
 		if referralsEnabled {
 			err := app.storage.db.Get(p.ReferralTemplateKey, &baseInv)
@@ -131,6 +140,7 @@ func (app *appContext) DeleteProfile(gc *gin.Context) {
 // @Param profile path string true "name of profile to enable referrals for."
 // @Param invite path string true "invite code to create referral template from."
 // @Param useExpiry path string true "with-expiry or none."
+// @Param referralQuotaDTO body referralQuotaDTO false "Referral quotas for users of the profile."
 // @Success 200 {object} boolResponse
 // @Failure 400 {object} stringResponse
 // @Failure 500 {object} stringResponse
@@ -141,6 +151,8 @@ func (app *appContext) EnableReferralForProfile(gc *gin.Context) {
 	profileName := gc.Param("profile")
 	invCode := gc.Param("invite")
 	useExpiry := gc.Param("useExpiry") == "with-expiry"
+	var quota referralQuotaDTO
+	gc.ShouldBindJSON(&quota)
 	inv, ok := app.storage.GetInvitesKey(invCode)
 	if !ok {
 		respond(400, "Invalid invite code", gc)
@@ -171,6 +183,8 @@ func (app *appContext) EnableReferralForProfile(gc *gin.Context) {
 	app.storage.SetInvitesKey(inv.Code, inv)
 
 	profile.ReferralTemplateKey = inv.Code
+	profile.ReferralMonthlyQuota = quota.MonthlyQuota
+	profile.ReferralMaxOutstanding = quota.MaxOutstanding
 
 	app.storage.SetProfileKey(profile.Name, profile)
 
@@ -200,3 +214,32 @@ func (app *appContext) DisableReferralForProfile(gc *gin.Context) {
 
 	respondBool(200, true, gc)
 }
+
+// @Summary Set the max concurrent streams and signed in devices for users of a profile. 0 for no limit.
+// @Produce json
+// @Param profile path string true "name of profile to set limits for."
+// @Param profileLimitsDTO body profileLimitsDTO true "Limits for users of the profile."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /profiles/limits/{profile} [post]
+// @Security Bearer
+// @tags Profiles & Settings
+func (app *appContext) SetProfileLimits(gc *gin.Context) {
+	profileName := gc.Param("profile")
+	var req profileLimitsDTO
+	gc.BindJSON(&req)
+	if req.MaxStreams < 0 || req.MaxDevices < 0 {
+		respondBool(400, false, gc)
+		return
+	}
+	profile, ok := app.storage.GetProfileKey(profileName)
+	if !ok {
+		respondBool(400, false, gc)
+		return
+	}
+	profile.MaxStreams = req.MaxStreams
+	profile.MaxDevices = req.MaxDevices
+	app.storage.SetProfileKey(profileName, profile)
+	app.info.Printf("Set limits for profile \"%s\": %d streams, %d devices", profileName, req.MaxStreams, req.MaxDevices)
+	respondBool(200, true, gc)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+)
+
+// Deepest referral tree that can be requested in one go.
+const REFERRAL_TREE_MAX_DEPTH = 10
+
+// @Summary Get the referral tree of a user: who referred them, and who they've referred, down to the given depth.
+// @Produce json
+// @Param id path string true "Jellyfin ID of user"
+// @Param depth query int false "Levels of referrals to include, default 1, max 10. Counts always cover the whole tree."
+// @Success 200 {object} referralTreeDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /users/{id}/referrals [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetUserReferrals(gc *gin.Context) {
+	id := gc.Param("id")
+	depth := 1
+	if d := gc.Query("depth"); d != "" {
+		var err error
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 0 {
+			respond(400, "Invalid depth", gc)
+			return
+		}
+	}
+	if depth > REFERRAL_TREE_MAX_DEPTH {
+		depth = REFERRAL_TREE_MAX_DEPTH
+	}
+	jfUsers, status, err := app.jf.GetUsers(false)
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+		respond(500, "Couldn't get users", gc)
+		return
+	}
+	users := make(map[string]mediabrowser.User, len(jfUsers))
+	for _, user := range jfUsers {
+		users[user.ID] = user
+	}
+	referral, referred := app.storage.GetReferralKey(id)
+	if _, ok := users[id]; !ok && !referred {
+		respond(400, "User not found", gc)
+		return
+	}
+	resp := referralTreeDTO{User: app.referralNode(id, referral, users, depth)}
+	if referred {
+		referrer, _ := app.storage.GetReferralKey(referral.ReferrerJellyfinID)
+		node := app.referralNode(referral.ReferrerJellyfinID, referrer, users, 0)
+		resp.Referrer = &node
+	}
+	gc.JSON(200, resp)
+}
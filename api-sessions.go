@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+func (app *appContext) sessionsToDTO(sessions []Session, currentID string) sessionsDTO {
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastRefresh.After(sessions[j].LastRefresh) })
+	resp := sessionsDTO{Sessions: make([]sessionDTO, len(sessions))}
+	for i, s := range sessions {
+		resp.Sessions[i] = sessionDTO{
+			ID:          s.ID,
+			JellyfinID:  s.JellyfinID,
+			Admin:       s.Admin,
+			UserAgent:   s.UserAgent,
+			IP:          s.IP,
+			Created:     s.Created.Unix(),
+			LastRefresh: s.LastRefresh.Unix(),
+			Current:     s.ID == currentID,
+		}
+		if s.JellyfinID == "" {
+			resp.Sessions[i].Username = app.config.Section("ui").Key("username").String()
+		} else if user, status, err := app.jf.UserByID(s.JellyfinID, false); status == 200 && err == nil {
+			resp.Sessions[i].Username = user.Name
+		}
+	}
+	return resp
+}
+
+// @Summary Get all active admin & user page sessions.
+// @Produce json
+// @Success 200 {object} sessionsDTO
+// @Router /sessions [get]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) GetSessions(gc *gin.Context) {
+	gc.JSON(200, app.sessionsToDTO(app.storage.GetSessions(nil), gc.GetString("sessionId")))
+}
+
+// @Summary Revoke a session, logging it out immediately.
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /sessions/{id} [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) DeleteSession(gc *gin.Context) {
+	id := gc.Param("id")
+	if _, ok := app.storage.GetSessionsKey(id); !ok {
+		respondBool(400, false, gc)
+		return
+	}
+	app.storage.DeleteSessionsKey(id)
+	app.info.Printf("Session \"%s\" revoked by admin", id)
+	respondBool(200, true, gc)
+}
+
+// @Summary Get the user's active sessions.
+// @Produce json
+// @Success 200 {object} sessionsDTO
+// @Router /my/sessions [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) GetMySessions(gc *gin.Context) {
+	sessions := app.storage.GetSessions(badgerhold.Where("JellyfinID").Eq(gc.GetString("jfId")))
+	gc.JSON(200, app.sessionsToDTO(sessions, gc.GetString("sessionId")))
+}
+
+// @Summary Revoke one of the user's sessions.
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /my/sessions/{id} [delete]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) DeleteMySession(gc *gin.Context) {
+	id := gc.Param("id")
+	session, ok := app.storage.GetSessionsKey(id)
+	if !ok || session.JellyfinID != gc.GetString("jfId") {
+		respondBool(400, false, gc)
+		return
+	}
+	app.storage.DeleteSessionsKey(id)
+	respondBool(200, true, gc)
+}
+
+// @Summary Get the devices logged in to the user's Jellyfin account.
+// @Produce json
+// @Success 200 {object} myDevicesDTO
+// @Failure 500 {object} boolResponse
+// @Router /my/devices [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) GetMyDevices(gc *gin.Context) {
+	id := gc.GetString("jfId")
+	devices, err := app.getJellyfinDevices(id)
+	if err != nil {
+		app.err.Printf("%s: Failed to get Jellyfin devices: %v", id, err)
+		respondBool(500, false, gc)
+		return
+	}
+	sessions, err := app.getJellyfinSessions()
+	if err != nil {
+		app.debug.Printf("%s: Failed to get Jellyfin sessions, IPs won't be shown: %v", id, err)
+	}
+	active := map[string]jfSession{}
+	for _, s := range sessions {
+		if s.UserID == id {
+			active[s.DeviceID] = s
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DateLastActivity.After(devices[j].DateLastActivity.Time.Time) })
+	resp := myDevicesDTO{Devices: make([]myDeviceDTO, len(devices))}
+	for i, d := range devices {
+		resp.Devices[i] = myDeviceDTO{
+			ID:            d.ID,
+			Name:          d.Name,
+			Client:        d.AppName,
+			ClientVersion: d.AppVersion,
+			LastSeen:      d.DateLastActivity.Unix(),
+		}
+		if s, ok := active[d.ID]; ok {
+			resp.Devices[i].Active = true
+			resp.Devices[i].IP = s.RemoteEndPoint
+		}
+		if d.DateLastActivity.IsZero() {
+			resp.Devices[i].LastSeen = 0
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Sign out one of the devices logged in to the user's Jellyfin account.
+// @Produce json
+// @Param id path string true "Device ID"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Failure 500 {object} boolResponse
+// @Router /my/devices/{id} [delete]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) DeleteMyDevice(gc *gin.Context) {
+	id := gc.GetString("jfId")
+	deviceID := gc.Param("id")
+	devices, err := app.getJellyfinDevices(id)
+	if err != nil {
+		app.err.Printf("%s: Failed to get Jellyfin devices: %v", id, err)
+		respondBool(500, false, gc)
+		return
+	}
+	found := false
+	for _, d := range devices {
+		if d.ID == deviceID {
+			found = true
+			break
+		}
+	}
+	// Only allow signing out the user's own devices.
+	if !found {
+		respondBool(400, false, gc)
+		return
+	}
+	if err := app.deleteJellyfinDevice(deviceID); err != nil {
+		app.err.Printf("%s: Failed to sign out device \"%s\": %v", id, deviceID, err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.info.Printf("%s: Signed out Jellyfin device \"%s\"", id, deviceID)
+	respondBool(200, true, gc)
+}
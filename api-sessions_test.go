@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newDeviceTestApp(t *testing.T) (*appContext, *fakeJellyfin) {
+	app, jf := newTestApp(t, "")
+	jf.devices = []jfDevice{
+		{ID: "mine", Name: "Phone", LastUserID: "user"},
+		{ID: "theirs", Name: "TV", LastUserID: "other"},
+	}
+	return app, jf
+}
+
+func TestGetMyDevicesOnlyOwn(t *testing.T) {
+	app, _ := newDeviceTestApp(t)
+	gc, w := testContext("GET", "/my/devices", nil)
+	gc.Set("jfId", "user")
+	app.GetMyDevices(gc)
+	if w.Code != 200 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var resp myDevicesDTO
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Devices) != 1 || resp.Devices[0].ID != "mine" {
+		t.Errorf("expected only the user's device, got %+v", resp.Devices)
+	}
+}
+
+func TestDeleteMyDeviceOnlyOwn(t *testing.T) {
+	app, jf := newDeviceTestApp(t)
+	for _, tc := range []struct {
+		device string
+		status int
+	}{
+		{"theirs", 400},
+		{"mine", 200},
+	} {
+		gc, w := testContext("DELETE", "/my/devices/"+tc.device, nil)
+		gc.Set("jfId", "user")
+		gc.AddParam("id", tc.device)
+		app.DeleteMyDevice(gc)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.device, tc.status, w.Code)
+		}
+	}
+	if len(jf.deleted) != 1 || jf.deleted[0] != "mine" {
+		t.Errorf("expected only \"mine\" to be deleted, got %v", jf.deleted)
+	}
+}
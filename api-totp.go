@@ -0,0 +1,189 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isSuperAdmin returns whether the requesting admin can manage other admins, i.e. they're an administrator on Jellyfin, or Jellyfin login is disabled.
+func (app *appContext) isSuperAdmin(gc *gin.Context) bool {
+	if !app.jellyfinLogin {
+		return true
+	}
+	user, status, err := app.jf.UserByID(gc.GetString("jfId"), false)
+	if status != 200 || err != nil {
+		app.err.Printf("Failed to get user \"%s\" from Jellyfin (%d): %v", gc.GetString("jfId"), status, err)
+		return false
+	}
+	return user.Policy.IsAdministrator
+}
+
+// @Summary Get whether the requesting admin has TOTP enabled.
+// @Produce json
+// @Success 200 {object} totpStatusDTO
+// @Router /2fa [get]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) GetMyTOTP(gc *gin.Context) {
+	totp, ok := app.storage.GetAdminTOTPKey(adminTOTPKey(gc.GetString("jfId")))
+	gc.JSON(200, totpStatusDTO{
+		Enabled:           ok && totp.Enabled,
+		RecoveryCodesLeft: len(totp.RecoveryCodes),
+	})
+}
+
+// @Summary Begin TOTP enrolment for the requesting admin. Enrolment must be confirmed with a valid code before TOTP is required on login.
+// @Produce json
+// @Success 200 {object} totpEnrolDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /2fa/enrol [post]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) EnrolTOTP(gc *gin.Context) {
+	jfID := gc.GetString("jfId")
+	key := adminTOTPKey(jfID)
+	if totp, ok := app.storage.GetAdminTOTPKey(key); ok && totp.Enabled {
+		respond(400, "TOTP already enabled", gc)
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		app.err.Printf("Failed to generate TOTP secret: %v", err)
+		respond(500, "Couldn't generate secret", gc)
+		return
+	}
+	account := app.config.Section("ui").Key("username").String()
+	if jfID != "" {
+		if user, status, err := app.jf.UserByID(jfID, false); status == 200 && err == nil {
+			account = user.Name
+		}
+	}
+	app.storage.SetAdminTOTPKey(key, AdminTOTP{Secret: secret})
+	app.info.Printf("TOTP enrolment started for admin \"%s\"", account)
+	gc.JSON(200, totpEnrolDTO{Secret: secret, URL: totpURL(secret, account)})
+}
+
+// @Summary Confirm TOTP enrolment with a valid code. Returns a set of recovery codes, which are only shown once.
+// @Produce json
+// @Param totpCodeDTO body totpCodeDTO true "TOTP code"
+// @Success 200 {object} totpRecoveryCodesDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /2fa/confirm [post]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) ConfirmTOTP(gc *gin.Context) {
+	var req totpCodeDTO
+	gc.BindJSON(&req)
+	key := adminTOTPKey(gc.GetString("jfId"))
+	totp, ok := app.storage.GetAdminTOTPKey(key)
+	if !ok || totp.Enabled {
+		respond(400, "No enrolment in progress", gc)
+		return
+	}
+	counter, ok := validateTOTP(totp.Secret, req.Code, time.Now(), 0)
+	if !ok {
+		respond(400, "errorInvalidCode", gc)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.err.Printf("Failed to generate recovery codes: %v", err)
+		respond(500, "Couldn't generate recovery codes", gc)
+		return
+	}
+	totp.Enabled = true
+	totp.LastCounter = counter
+	totp.RecoveryCodes = hashes
+	totp.Enrolled = time.Now()
+	app.storage.SetAdminTOTPKey(key, totp)
+	app.info.Printf("TOTP enabled for admin \"%s\"", key)
+	gc.JSON(200, totpRecoveryCodesDTO{Codes: codes})
+}
+
+// @Summary Generate a new set of recovery codes for the requesting admin, invalidating the old ones.
+// @Produce json
+// @Param totpCodeDTO body totpCodeDTO true "TOTP code"
+// @Success 200 {object} totpRecoveryCodesDTO
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /2fa/recovery [post]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) RegenerateTOTPRecoveryCodes(gc *gin.Context) {
+	var req totpCodeDTO
+	gc.BindJSON(&req)
+	key := adminTOTPKey(gc.GetString("jfId"))
+	totp, ok := app.storage.GetAdminTOTPKey(key)
+	if !ok || !totp.Enabled {
+		respond(400, "TOTP not enabled", gc)
+		return
+	}
+	counter, ok := validateTOTP(totp.Secret, req.Code, time.Now(), totp.LastCounter)
+	if !ok {
+		respond(400, "errorInvalidCode", gc)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.err.Printf("Failed to generate recovery codes: %v", err)
+		respond(500, "Couldn't generate recovery codes", gc)
+		return
+	}
+	totp.LastCounter = counter
+	totp.RecoveryCodes = hashes
+	app.storage.SetAdminTOTPKey(key, totp)
+	gc.JSON(200, totpRecoveryCodesDTO{Codes: codes})
+}
+
+// @Summary Disable TOTP for the requesting admin. Requires a valid TOTP or recovery code.
+// @Produce json
+// @Param totpCodeDTO body totpCodeDTO true "TOTP or recovery code"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Router /2fa [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) DisableMyTOTP(gc *gin.Context) {
+	var req totpCodeDTO
+	gc.BindJSON(&req)
+	key := adminTOTPKey(gc.GetString("jfId"))
+	totp, ok := app.storage.GetAdminTOTPKey(key)
+	if !ok {
+		respond(400, "TOTP not enabled", gc)
+		return
+	}
+	if totp.Enabled && !totp.check(req.Code) {
+		respond(400, "errorInvalidCode", gc)
+		return
+	}
+	app.storage.DeleteAdminTOTPKey(key)
+	app.info.Printf("TOTP disabled for admin \"%s\"", key)
+	respondBool(200, true, gc)
+}
+
+// @Summary Reset TOTP for another admin, e.g. if they've lost their device and recovery codes. Only available to Jellyfin administrators.
+// @Produce json
+// @Param id path string true "Jellyfin ID of admin"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 403 {object} stringResponse
+// @Router /users/{id}/2fa [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) ResetTOTP(gc *gin.Context) {
+	if !app.isSuperAdmin(gc) {
+		respond(403, "Only Jellyfin administrators can reset 2FA", gc)
+		return
+	}
+	key := adminTOTPKey(gc.Param("id"))
+	if _, ok := app.storage.GetAdminTOTPKey(key); !ok {
+		respond(400, "TOTP not enabled", gc)
+		return
+	}
+	app.storage.DeleteAdminTOTPKey(key)
+	app.info.Printf("TOTP for admin \"%s\" reset by \"%s\"", key, adminTOTPKey(gc.GetString("jfId")))
+	respondBool(200, true, gc)
+}
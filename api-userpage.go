@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -48,36 +49,17 @@ func (app *appContext) MyDetails(gc *gin.Context) {
 		resp.Expiry = exp.Expiry.Unix()
 	}
 
-	if emailEnabled {
-		resp.Email = &MyDetailsContactMethodsDTO{}
-		if email, ok := app.storage.GetEmailsKey(user.ID); ok && email.Addr != "" {
-			resp.Email.Value = email.Addr
-			resp.Email.Enabled = email.Contact
+	resp.ContactMethods = map[string]*MyDetailsContactMethodsDTO{}
+	for _, cm := range app.contactMethods {
+		if !cm.Enabled() {
+			continue
 		}
-	}
-
-	if discordEnabled {
-		resp.Discord = &MyDetailsContactMethodsDTO{}
-		if discord, ok := app.storage.GetDiscordKey(user.ID); ok {
-			resp.Discord.Value = RenderDiscordUsername(discord)
-			resp.Discord.Enabled = discord.Contact
-		}
-	}
-
-	if telegramEnabled {
-		resp.Telegram = &MyDetailsContactMethodsDTO{}
-		if telegram, ok := app.storage.GetTelegramKey(user.ID); ok {
-			resp.Telegram.Value = telegram.Username
-			resp.Telegram.Enabled = telegram.Contact
-		}
-	}
-
-	if matrixEnabled {
-		resp.Matrix = &MyDetailsContactMethodsDTO{}
-		if matrix, ok := app.storage.GetMatrixKey(user.ID); ok {
-			resp.Matrix.Value = matrix.UserID
-			resp.Matrix.Enabled = matrix.Contact
+		details := &MyDetailsContactMethodsDTO{}
+		if link, ok := cm.Linked(user.ID); ok {
+			details.Value = link.Display
+			details.Enabled = link.Contact
 		}
+		resp.ContactMethods[cm.Name()] = details
 	}
 
 	if app.config.Section("user_page").Key("referrals").MustBool(false) {
@@ -99,9 +81,42 @@ func (app *appContext) MyDetails(gc *gin.Context) {
 		}
 	}
 
+	if usernameRequest, ok := app.storage.GetUsernameRequestKey(resp.Id); ok {
+		resp.PendingUsername = usernameRequest.NewName
+	}
+
+	if userLang, ok := app.storage.GetUserLanguageKey(resp.Id); ok {
+		resp.Lang = userLang.Lang
+	}
+
 	gc.JSON(200, resp)
 }
 
+// @Summary Sets the language messages are sent to you in. If blank, the language set on your contact methods or the server default is used.
+// @Produce json
+// @Param MyLangDTO body MyLangDTO true "Language code."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Router /my/language [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) SetMyLang(gc *gin.Context) {
+	var req MyLangDTO
+	gc.BindJSON(&req)
+	id := gc.GetString("jfId")
+	if req.Lang == "" {
+		app.storage.DeleteUserLanguageKey(id)
+		respondBool(200, true, gc)
+		return
+	}
+	if _, ok := app.storage.lang.Email[req.Lang]; !ok {
+		respond(400, "errorUnknown", gc)
+		return
+	}
+	app.storage.SetUserLanguageKey(id, UserLanguage{Lang: req.Lang})
+	respondBool(200, true, gc)
+}
+
 // @Summary Sets whether to notify yourself through telegram/discord/matrix/email or not.
 // @Produce json
 // @Param SetContactMethodsDTO body SetContactMethodsDTO true "User's Jellyfin ID and whether or not to notify then through Telegram."
@@ -130,14 +145,12 @@ func (app *appContext) SetMyContactMethods(gc *gin.Context) {
 // @Security Bearer
 // @tags User Page
 func (app *appContext) LogoutUser(gc *gin.Context) {
-	cookie, err := gc.Cookie("user-refresh")
-	if err != nil {
+	if err := app.revokeSessionFromCookie(gc, "user-refresh"); err != nil {
 		app.debug.Printf("Couldn't get cookies: %s", err)
 		respond(500, "Couldn't fetch cookies", gc)
 		return
 	}
-	app.invalidTokens = append(app.invalidTokens, cookie)
-	gc.SetCookie("refresh", "invalid", -1, "/my", gc.Request.URL.Hostname(), true, true)
+	gc.SetCookie("user-refresh", "invalid", -1, "/my", gc.Request.URL.Hostname(), true, true)
 	respondBool(200, true, gc)
 }
 
@@ -218,16 +231,7 @@ func (app *appContext) confirmMyAction(gc *gin.Context, key string) {
 			Time:       time.Now(),
 		}, gc, true)
 
-		if app.config.Section("ombi").Key("enabled").MustBool(false) {
-			ombiUser, code, err := app.getOmbiUser(id)
-			if code == 200 && err == nil {
-				ombiUser["emailAddress"] = claims["email"].(string)
-				code, err = app.ombi.ModifyUser(ombiUser)
-				if code != 200 || err != nil {
-					app.err.Printf("%s: Failed to change ombi email address (%d): %v", ombiUser["userName"].(string), code, err)
-				}
-			}
-		}
+		app.setRequestManagerEmails(id, claims["email"].(string))
 
 		app.info.Println("Email list modified")
 		gc.Redirect(http.StatusSeeOther, "/my/account")
@@ -281,10 +285,10 @@ func (app *appContext) ModifyMyEmail(gc *gin.Context) {
 		}
 		app.debug.Printf("%s: Email confirmation required", id)
 		respond(401, "confirmEmail", gc)
-		msg, err := app.email.constructConfirmation("", name, key, app, false)
+		msg, err := app.emailFor(id).constructConfirmation("", name, key, app, false)
 		if err != nil {
 			app.err.Printf("%s: Failed to construct confirmation email: %v", name, err)
-		} else if err := app.email.send(msg, req.Email); err != nil {
+		} else if err := app.sendEmail(msg, req.Email); err != nil {
 			app.err.Printf("%s: Failed to send user confirmation email: %v", name, err)
 		} else {
 			app.info.Printf("%s: Sent user confirmation email to \"%s\"", name, req.Email)
@@ -337,6 +341,13 @@ func (app *appContext) GetMyPIN(gc *gin.Context) {
 	case "telegram":
 		resp.PIN = app.telegram.NewAssignedAuthToken(gc.GetString("jfId"))
 		break
+	case "signal":
+		if !signalEnabled {
+			respond(400, "invalid service", gc)
+			return
+		}
+		resp.PIN = app.signal.NewAssignedAuthToken(gc.GetString("jfId"))
+		break
 	default:
 		respond(400, "invalid service", gc)
 		return
@@ -344,6 +355,19 @@ func (app *appContext) GetMyPIN(gc *gin.Context) {
 	gc.JSON(200, resp)
 }
 
+// @Summary Returns true/false on whether or not your Signal PIN was verified, and assigns the Signal number to you.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Param pin path string true "PIN code to check"
+// @Router /my/signal/verified/{pin} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) MySignalVerifiedInvite(gc *gin.Context) {
+	cm, _ := app.contactMethod("signal")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), "")
+}
+
 // @Summary Returns true/false on whether or not your discord PIN was verified, and assigns the discord user to you.
 // @Produce json
 // @Success 200 {object} boolResponse
@@ -353,34 +377,8 @@ func (app *appContext) GetMyPIN(gc *gin.Context) {
 // @Security Bearer
 // @tags User Page
 func (app *appContext) MyDiscordVerifiedInvite(gc *gin.Context) {
-	pin := gc.Param("pin")
-	dcUser, ok := app.discord.AssignedUserVerified(pin, gc.GetString("jfId"))
-	app.discord.DeleteVerifiedUser(pin)
-	if !ok {
-		respondBool(200, false, gc)
-		return
-	}
-	if app.config.Section("discord").Key("require_unique").MustBool(false) && app.discord.UserExists(dcUser.ID) {
-		respondBool(400, false, gc)
-		return
-	}
-	existingUser, ok := app.storage.GetDiscordKey(gc.GetString("jfId"))
-	if ok {
-		dcUser.Lang = existingUser.Lang
-		dcUser.Contact = existingUser.Contact
-	}
-	app.storage.SetDiscordKey(gc.GetString("jfId"), dcUser)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactLinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "discord",
-		Time:       time.Now(),
-	}, gc, true)
-
-	respondBool(200, true, gc)
+	cm, _ := app.contactMethod("discord")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), "")
 }
 
 // @Summary Returns true/false on whether or not your telegram PIN was verified, and assigns the telegram user to you.
@@ -392,43 +390,8 @@ func (app *appContext) MyDiscordVerifiedInvite(gc *gin.Context) {
 // @Security Bearer
 // @tags User Page
 func (app *appContext) MyTelegramVerifiedInvite(gc *gin.Context) {
-	pin := gc.Param("pin")
-	token, ok := app.telegram.AssignedTokenVerified(pin, gc.GetString("jfId"))
-	app.telegram.DeleteVerifiedToken(pin)
-	if !ok {
-		respondBool(200, false, gc)
-		return
-	}
-	if app.config.Section("telegram").Key("require_unique").MustBool(false) && app.telegram.UserExists(token.Username) {
-		respondBool(400, false, gc)
-		return
-	}
-	tgUser := TelegramUser{
-		ChatID:   token.ChatID,
-		Username: token.Username,
-		Contact:  true,
-	}
-	if lang, ok := app.telegram.languages[tgUser.ChatID]; ok {
-		tgUser.Lang = lang
-	}
-
-	existingUser, ok := app.storage.GetTelegramKey(gc.GetString("jfId"))
-	if ok {
-		tgUser.Lang = existingUser.Lang
-		tgUser.Contact = existingUser.Contact
-	}
-	app.storage.SetTelegramKey(gc.GetString("jfId"), tgUser)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactLinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "telegram",
-		Time:       time.Now(),
-	}, gc, true)
-
-	respondBool(200, true, gc)
+	cm, _ := app.contactMethod("telegram")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), "")
 }
 
 // @Summary Generate and send a new PIN to your given matrix user.
@@ -448,13 +411,9 @@ func (app *appContext) MatrixSendMyPIN(gc *gin.Context) {
 		respond(400, "errorNoUserID", gc)
 		return
 	}
-	if app.config.Section("matrix").Key("require_unique").MustBool(false) {
-		for _, u := range app.storage.GetMatrix() {
-			if req.UserID == u.UserID {
-				respondBool(400, false, gc)
-				return
-			}
-		}
+	if cm, _ := app.contactMethod("matrix"); app.requireUnique(cm) && cm.Exists(req.UserID) {
+		respondBool(400, false, gc)
+		return
 	}
 
 	ok := app.matrix.SendStart(req.UserID)
@@ -476,43 +435,106 @@ func (app *appContext) MatrixSendMyPIN(gc *gin.Context) {
 // @Security Bearer
 // @tags User Page
 func (app *appContext) MatrixCheckMyPIN(gc *gin.Context) {
-	userID := gc.Param("userID")
-	pin := gc.Param("pin")
-	user, ok := app.matrix.tokens[pin]
-	if !ok {
-		app.debug.Println("Matrix: PIN not found")
-		respondBool(200, false, gc)
+	cm, _ := app.contactMethod("matrix")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), gc.Param("userID"))
+}
+
+// @Summary Text a new verification PIN to your given phone number.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} boolResponse
+// @Failure 429 {object} stringResponse
+// @Param SMSSendPINDTO body SMSSendPINDTO true "Phone number."
+// @Router /my/sms/number [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) SMSSendMyPIN(gc *gin.Context) {
+	var req SMSSendPINDTO
+	gc.BindJSON(&req)
+	app.respondSendSMSPIN(req, BAN_USER_PREFIX+gc.GetString("jfId"), true, gc)
+}
+
+// @Summary Check whether your SMS PIN is valid, and link the number to your account if so.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Param pin path string true "PIN code to check"
+// @Param number path string true "Phone number"
+// @Router /my/sms/verified/{number}/{pin} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) SMSCheckMyPIN(gc *gin.Context) {
+	cm, _ := app.contactMethod("sms")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), gc.Param("number"))
+}
+
+// @Summary Push a new verification PIN to the given ntfy topic, Gotify application or Apprise URL.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 429 {object} stringResponse
+// @Failure 500 {object} boolResponse
+// @Param PushSendPINDTO body PushSendPINDTO true "Service and topic/token/URL."
+// @Router /my/push/target [post]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) PushSendMyPIN(gc *gin.Context) {
+	var req PushSendPINDTO
+	gc.BindJSON(&req)
+	target := PushTarget{
+		Service: req.Service,
+		Target:  strings.TrimSpace(req.Target),
+		Token:   strings.TrimSpace(req.Token),
+		Lang:    req.Lang,
+	}
+	if !app.push.validTarget(target) {
+		respond(400, "errorInvalidPushTarget", gc)
 		return
 	}
-	if user.User.UserID != userID {
-		app.debug.Println("Matrix: User ID of PIN didn't match")
-		respondBool(200, false, gc)
+	if target.Service != PushNtfy {
+		target.Token = ""
+	}
+	if cm, _ := app.contactMethod("push"); app.requireUnique(cm) && cm.Exists(pushAddress(target.Service, target.Target)) {
+		respond(400, "errorAccountLinked", gc)
 		return
 	}
-
-	mxUser := *user.User
-	mxUser.Contact = true
-	existingUser, ok := app.storage.GetMatrixKey(gc.GetString("jfId"))
-	if ok {
-		mxUser.Lang = existingUser.Lang
-		mxUser.Contact = existingUser.Contact
+	// PINs are pushed to any target given, so they're limited like SMS PINs to stop this being used to spam someone.
+	keys := []string{"push:" + BAN_IP_PREFIX + gc.ClientIP(), "push:" + BAN_USER_PREFIX + gc.GetString("jfId")}
+	_, locked := app.push.limiter.Locked(keys...)
+	if _, globalLocked := app.push.globalLimiter.Locked(PUSH_GLOBAL_LIMIT_KEY); locked || globalLocked {
+		app.logIpInfo(gc, true, fmt.Sprintf("Push: Not sending PIN for \"%s\", too many sent", gc.GetString("jfId")))
+		respond(429, "errorTooManyPINs", gc)
+		return
+	}
+	if err := app.push.SendPIN(target, gc.GetString("jfId"), req.Lang); err == ErrPINResentTooSoon {
+		respond(429, "errorTooManyPINs", gc)
+		return
+	} else if err != nil {
+		app.err.Printf("Push: Failed to send PIN through %s: %v", target.Service, err)
+		respondBool(500, false, gc)
+		return
+	}
+	app.recordAttempt(gc, app.push.limiter, true, keys...)
+	if len(app.push.globalLimiter.Fail(PUSH_GLOBAL_LIMIT_KEY)) != 0 {
+		app.err.Println("Push: Hourly PIN limit reached, no more will be sent for now")
 	}
-
-	app.storage.SetMatrixKey(gc.GetString("jfId"), mxUser)
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactLinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "matrix",
-		Time:       time.Now(),
-	}, gc, true)
-
-	delete(app.matrix.tokens, pin)
 	respondBool(200, true, gc)
 }
 
+// @Summary Check whether your push PIN is valid, and link the topic/token/URL it was sent to if so.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Param pin path string true "PIN code to check"
+// @Router /my/push/verified/{pin} [get]
+// @Security Bearer
+// @tags User Page
+func (app *appContext) PushCheckMyPIN(gc *gin.Context) {
+	cm, _ := app.contactMethod("push")
+	app.respondLinkMyContact(gc, cm, gc.Param("pin"), "")
+}
+
 // @Summary unlink the Discord account from your Jellyfin user. Always succeeds.
 // @Produce json
 // @Success 200 {object} boolResponse
@@ -520,17 +542,8 @@ func (app *appContext) MatrixCheckMyPIN(gc *gin.Context) {
 // @Security Bearer
 // @Tags User Page
 func (app *appContext) UnlinkMyDiscord(gc *gin.Context) {
-	app.storage.DeleteDiscordKey(gc.GetString("jfId"))
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "discord",
-		Time:       time.Now(),
-	}, gc, true)
-
+	cm, _ := app.contactMethod("discord")
+	app.unlinkContact(gc, cm, gc.GetString("jfId"), ActivityUser)
 	respondBool(200, true, gc)
 }
 
@@ -541,17 +554,8 @@ func (app *appContext) UnlinkMyDiscord(gc *gin.Context) {
 // @Security Bearer
 // @Tags User Page
 func (app *appContext) UnlinkMyTelegram(gc *gin.Context) {
-	app.storage.DeleteTelegramKey(gc.GetString("jfId"))
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "telegram",
-		Time:       time.Now(),
-	}, gc, true)
-
+	cm, _ := app.contactMethod("telegram")
+	app.unlinkContact(gc, cm, gc.GetString("jfId"), ActivityUser)
 	respondBool(200, true, gc)
 }
 
@@ -562,20 +566,43 @@ func (app *appContext) UnlinkMyTelegram(gc *gin.Context) {
 // @Security Bearer
 // @Tags User Page
 func (app *appContext) UnlinkMyMatrix(gc *gin.Context) {
-	app.storage.DeleteMatrixKey(gc.GetString("jfId"))
-
-	app.storage.SetActivityKey(shortuuid.New(), Activity{
-		Type:       ActivityContactUnlinked,
-		UserID:     gc.GetString("jfId"),
-		SourceType: ActivityUser,
-		Source:     gc.GetString("jfId"),
-		Value:      "matrix",
-		Time:       time.Now(),
-	}, gc, true)
+	cm, _ := app.contactMethod("matrix")
+	app.unlinkContact(gc, cm, gc.GetString("jfId"), ActivityUser)
+	respondBool(200, true, gc)
+}
 
+// @Summary unlink the account on the given contact method from your Jellyfin user.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Param method path string true "Contact method, e.g. discord."
+// @Router /my/contact/{method} [delete]
+// @Security Bearer
+// @Tags User Page
+func (app *appContext) UnlinkMyContact(gc *gin.Context) {
+	cm, ok := app.contactMethod(gc.Param("method"))
+	if !ok || !cm.Enabled() {
+		respondBool(400, false, gc)
+		return
+	}
+	app.unlinkContact(gc, cm, gc.GetString("jfId"), ActivityUser)
 	respondBool(200, true, gc)
 }
 
+// respondLinkMyContact links the account verified with the given PIN to the requesting user.
+// Responds false with 200 if the PIN wasn't verified, or 400 if the account is already linked to someone else.
+func (app *appContext) respondLinkMyContact(gc *gin.Context, cm ContactMethod, pin, account string) {
+	err := app.linkContactByPIN(gc, cm, pin, gc.GetString("jfId"), account, ActivityUser)
+	switch err {
+	case nil:
+		respondBool(200, true, gc)
+	case ErrContactExists:
+		respondBool(400, false, gc)
+	default:
+		respondBool(200, false, gc)
+	}
+}
+
 // @Summary Generate & send a password reset link if the given username/email/contact method exists. Doesn't give you any info about it's success.
 // @Produce json
 // @Param address path string true "address/contact method associated w/ your account."
@@ -600,6 +627,14 @@ func (app *appContext) ResetMyPassword(gc *gin.Context) {
 		respondBool(400, false, gc)
 		return
 	}
+	// Resets have their own budget per IP, so they don't lock users out of logging in, nor failed logins stop a user resetting their password.
+	resetKey := BAN_RESET_PREFIX + BAN_IP_PREFIX + gc.ClientIP()
+	if !app.checkLimit(gc, app.loginLimiter, true, resetKey) {
+		cancel.Stop()
+		return
+	}
+	// Every request counts, as the response doesn't reveal whether the address was valid.
+	app.recordAttempt(gc, app.loginLimiter, true, resetKey)
 	var pwr InternalPWR
 	var err error
 
@@ -626,8 +661,11 @@ func (app *appContext) ResetMyPassword(gc *gin.Context) {
 		app.internalPWRs = map[string]InternalPWR{}
 	}
 	app.internalPWRs[pwr.PIN] = pwr
+	app.notifyAdmins(EventPasswordReset, func() (*Message, error) {
+		return app.email.constructAdminNotification(EventPasswordReset, tmpl{"username": pwr.Username}, app)
+	})
 	// FIXME: Send to all contact methods
-	msg, err := app.email.constructReset(
+	msg, err := app.emailFor(jfUser.ID).constructReset(
 		PasswordReset{
 			Pin:      pwr.PIN,
 			Username: pwr.Username,
@@ -703,30 +741,64 @@ func (app *appContext) ChangeMyPassword(gc *gin.Context) {
 		Time:       time.Now(),
 	}, gc, true)
 
-	if app.config.Section("ombi").Key("enabled").MustBool(false) {
-		func() {
-			ombiUser, status, err := app.getOmbiUser(gc.GetString("jfId"))
-			if status != 200 || err != nil {
-				app.err.Printf("Failed to get user \"%s\" from ombi (%d): %v", user.Name, status, err)
-				return
-			}
-			ombiUser["password"] = req.New
-			status, err = app.ombi.ModifyUser(ombiUser)
-			if status != 200 || err != nil {
-				app.err.Printf("Failed to set password for ombi user \"%s\" (%d): %v", ombiUser["userName"], status, err)
-				return
-			}
-			app.debug.Printf("Reset password for ombi user \"%s\"", ombiUser["userName"])
-		}()
+	app.setRequestManagerPasswords(gc.GetString("jfId"), req.New)
+	// Log out everywhere, in case the old password was compromised.
+	app.revokeUserSessions(gc.GetString("jfId"), false)
+	gc.SetCookie("user-refresh", "invalid", -1, "/my", gc.Request.URL.Hostname(), true, true)
+	respondBool(204, true, gc)
+}
+
+// @Summary Change your username, or request to if changes must be approved by an admin.
+// @Produce json
+// @Param ChangeMyUsernameDTO body ChangeMyUsernameDTO true "New username."
+// @Success 200 {object} ChangeMyUsernameRespDTO
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /my/username [post]
+// @Security Bearer
+// @Tags User Page
+func (app *appContext) ChangeMyUsername(gc *gin.Context) {
+	var req ChangeMyUsernameDTO
+	gc.BindJSON(&req)
+	id := gc.GetString("jfId")
+	user, status, err := app.jf.UserByID(id, false)
+	if status != 200 || err != nil {
+		app.err.Printf("Failed to change username: couldn't find user (%d): %+v", status, err)
+		respond(500, "errorUnknown", gc)
+		return
 	}
-	cookie, err := gc.Cookie("user-refresh")
-	if err == nil {
-		app.invalidTokens = append(app.invalidTokens, cookie)
-		gc.SetCookie("refresh", "invalid", -1, "/my", gc.Request.URL.Hostname(), true, true)
-	} else {
-		app.debug.Printf("Couldn't get cookies: %s", err)
+	if req.Username == user.Name {
+		respond(400, "errorInvalidUsername", gc)
+		return
 	}
-	respondBool(204, true, gc)
+	if errKey := app.validateUsername(id, req.Username); errKey != "" {
+		app.debug.Printf("%s: Username change to \"%s\" failed: %s", user.Name, req.Username, errKey)
+		code := 400
+		if errKey == "errorUserExists" {
+			code = 401
+		} else if errKey == "errorUnknown" {
+			code = 500
+		}
+		respond(code, errKey, gc)
+		return
+	}
+	if app.config.Section("user_page").Key("username_change_approval").MustBool(false) {
+		app.storage.SetUsernameRequestKey(id, UsernameRequest{
+			OldName: user.Name,
+			NewName: req.Username,
+			Time:    time.Now(),
+		})
+		app.info.Printf("%s: Requested username change to \"%s\"", user.Name, req.Username)
+		gc.JSON(200, ChangeMyUsernameRespDTO{Pending: true})
+		return
+	}
+	if err := app.renameUser(gc, user, req.Username, ActivityUser, id, true); err != nil {
+		app.err.Printf("%s: Failed to change username: %v", user.Name, err)
+		respond(500, "errorUnknown", gc)
+		return
+	}
+	gc.JSON(200, ChangeMyUsernameRespDTO{Pending: false})
 }
 
 // @Summary Get or generate a new referral code.
@@ -764,6 +836,15 @@ func (app *appContext) GetMyReferral(gc *gin.Context) {
 		}
 		inv.IsReferral = true
 		inv.ReferrerJellyfinID = gc.GetString("jfId")
+		profile := Profile{}
+		if err := app.storage.db.FindOne(&profile, badgerhold.Where("ReferralTemplateKey").Eq(user.ReferralTemplateKey)); err == nil {
+			inv.ReferrerProfile = profile.Name
+		}
+		if !app.applyReferralQuota(&inv) {
+			app.debug.Printf("Not creating referral, quota reached.")
+			gc.JSON(200, GetMyReferralRespDTO{QuotaReached: true})
+			return
+		}
 		app.storage.SetInvitesKey(inv.Code, inv)
 	} else if time.Now().After(inv.ValidTill) {
 		// 3. We found an invite for us, but it's expired.
@@ -783,6 +864,18 @@ func (app *appContext) GetMyReferral(gc *gin.Context) {
 		inv.Code = GenerateInviteCode()
 		inv.Created = time.Now()
 		inv.ValidTill = inv.Created.Add(REFERRAL_EXPIRY_DAYS * 24 * time.Hour)
+		if !app.applyReferralQuota(&inv) {
+			app.debug.Printf("Not renewing referral, quota reached.")
+			gc.JSON(200, GetMyReferralRespDTO{QuotaReached: true})
+			return
+		}
+		app.storage.SetInvitesKey(inv.Code, inv)
+	} else if uses, noLimit := inv.RemainingUses, inv.NoLimit; !app.applyReferralQuota(&inv) {
+		// 4. We found a valid invite, but the quota's been used up since it was made.
+		app.storage.DeleteInvitesKey(inv.Code)
+		gc.JSON(200, GetMyReferralRespDTO{QuotaReached: true})
+		return
+	} else if uses != inv.RemainingUses || noLimit != inv.NoLimit {
 		app.storage.SetInvitesKey(inv.Code, inv)
 	}
 	gc.JSON(200, GetMyReferralRespDTO{
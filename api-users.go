@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/hrfee/jfa-go/common"
 	"github.com/hrfee/mediabrowser"
 	"github.com/lithammer/shortuuid/v3"
 	"github.com/timshannon/badgerhold/v4"
@@ -64,6 +65,7 @@ func (app *appContext) NewUserAdmin(gc *gin.Context) {
 		} else {
 			app.debug.Printf("Couldn't find profile \"%s\", using default", req.Profile)
 		}
+		app.storage.SetUserProfileKey(id, UserProfile{Profile: profile.Name})
 
 		status, err = app.jf.SetPolicy(id, profile.Policy)
 		if !(status == 200 || status == 204 || err == nil) {
@@ -81,26 +83,27 @@ func (app *appContext) NewUserAdmin(gc *gin.Context) {
 	if emailEnabled {
 		app.storage.SetEmailsKey(id, EmailAddress{Addr: req.Email, Contact: true})
 	}
-	if app.config.Section("ombi").Key("enabled").MustBool(false) {
-		if profile.Ombi == nil {
-			profile.Ombi = map[string]interface{}{}
+	for _, rm := range app.requestManagers {
+		template := profile.RequestManagers[rm.Name()]
+		if template == nil {
+			template = map[string]interface{}{}
 		}
-		errors, code, err := app.ombi.NewUser(req.Username, req.Password, req.Email, profile.Ombi)
+		errors, code, err := rm.NewUser(req.Username, req.Password, req.Email, template)
 		if err != nil || code != 200 {
-			app.err.Printf("Failed to create Ombi user (%d): %v", code, err)
-			app.debug.Printf("Errors reported by Ombi: %s", strings.Join(errors, ", "))
+			app.err.Printf("Failed to create %s user (%d): %v", rm.Name(), code, err)
+			app.debug.Printf("Errors reported by %s: %s", rm.Name(), strings.Join(errors, ", "))
 		} else {
-			app.info.Println("Created Ombi user")
+			app.info.Printf("Created %s user", rm.Name())
 		}
 	}
 	if emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "" {
 		app.debug.Printf("%s: Sending welcome email to %s", req.Username, req.Email)
-		msg, err := app.email.constructWelcome(req.Username, time.Time{}, app, false)
+		msg, err := app.emailFor(id).constructWelcome(req.Username, time.Time{}, app, false)
 		if err != nil {
 			app.err.Printf("%s: Failed to construct welcome email: %v", req.Username, err)
 			respondUser(500, true, false, err.Error(), gc)
 			return
-		} else if err := app.email.send(msg, req.Email); err != nil {
+		} else if err := app.sendEmail(msg, req.Email); err != nil {
 			app.err.Printf("%s: Failed to send welcome email: %v", req.Username, err)
 			respondUser(500, true, false, err.Error(), gc)
 			return
@@ -125,114 +128,44 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 		success = false
 		return
 	}
-	var discordUser DiscordUser
-	discordVerified := false
-	if discordEnabled {
-		if req.DiscordPIN == "" {
-			if app.config.Section("discord").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Discord verification not completed", req.Code)
-					respond(401, "errorDiscordVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			discordUser, discordVerified = app.discord.UserVerified(req.DiscordPIN)
-			if !discordVerified {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Discord PIN was invalid", req.Code)
-					respond(401, "errorInvalidPIN", gc)
-				}
-				success = false
-				return
-			}
-			if app.config.Section("discord").Key("require_unique").MustBool(false) && app.discord.UserExists(discordUser.ID) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Discord user already linked", req.Code)
-					respond(400, "errorAccountLinked", gc)
-				}
-				success = false
-				return
-			}
-			err := app.discord.ApplyRole(discordUser.ID)
-			if err != nil {
-				f = func(gc *gin.Context) {
-					app.err.Printf("%s: New user failed: Failed to set member role: %v", req.Code, err)
-					respond(401, "error", gc)
-				}
-				success = false
-				return
-			}
+	// Functions to link each contact method verified on the form, once the user exists.
+	contactLinks := map[string]func(jfID string, contact bool){}
+	for _, cm := range app.contactMethods {
+		verifier, ok := cm.(InviteLinker)
+		if !ok || !cm.Enabled() {
+			continue
 		}
-	}
-	var matrixUser MatrixUser
-	matrixVerified := false
-	if matrixEnabled {
-		if req.MatrixPIN == "" {
-			if app.config.Section("matrix").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Matrix verification not completed", req.Code)
-					respond(401, "errorMatrixVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			user, ok := app.matrix.tokens[req.MatrixPIN]
-			if !ok || !user.Verified {
-				matrixVerified = false
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Matrix PIN was invalid", req.Code)
-					respond(401, "errorInvalidPIN", gc)
-				}
-				success = false
-				return
-			}
-			if app.config.Section("matrix").Key("require_unique").MustBool(false) && app.matrix.UserExists(user.User.UserID) {
+		pin := req.ContactPINs[cm.Name()]
+		if pin == "" {
+			if app.config.Section(cm.Name()).Key("required").MustBool(false) {
 				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Matrix user already linked", req.Code)
-					respond(400, "errorAccountLinked", gc)
+					app.debug.Printf("%s: New user failed: %s verification not completed", req.Code, cm.Name())
+					respond(401, verifier.RequiredErrorKey(), gc)
 				}
 				success = false
 				return
 			}
-			matrixVerified = user.Verified
-			matrixUser = *user.User
-
+			continue
 		}
-	}
-	var tgToken TelegramVerifiedToken
-	telegramVerified := false
-	if telegramEnabled {
-		if req.TelegramPIN == "" {
-			if app.config.Section("telegram").Key("required").MustBool(false) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Telegram verification not completed", req.Code)
-					respond(401, "errorTelegramVerification", gc)
-				}
-				success = false
-				return
-			}
-		} else {
-			tgToken, telegramVerified = app.telegram.TokenVerified(req.TelegramPIN)
-			if !telegramVerified {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Telegram PIN was invalid", req.Code)
+		link, err := verifier.VerifyInvitePIN(pin)
+		if err != nil {
+			f = func(gc *gin.Context) {
+				switch err {
+				case ErrInvalidPIN:
+					app.debug.Printf("%s: New user failed: %s PIN was invalid", req.Code, cm.Name())
 					respond(401, "errorInvalidPIN", gc)
-				}
-				success = false
-				return
-			}
-			if app.config.Section("telegram").Key("require_unique").MustBool(false) && app.telegram.UserExists(tgToken.Username) {
-				f = func(gc *gin.Context) {
-					app.debug.Printf("%s: New user failed: Telegram user already linked", req.Code)
+				case ErrContactExists:
+					app.debug.Printf("%s: New user failed: %s account already linked", req.Code, cm.Name())
 					respond(400, "errorAccountLinked", gc)
+				default:
+					app.err.Printf("%s: New user failed: %s: %v", req.Code, cm.Name(), err)
+					respond(401, "error", gc)
 				}
-				success = false
-				return
 			}
+			success = false
+			return
 		}
+		contactLinks[cm.Name()] = link
 	}
 	if emailEnabled && app.config.Section("email_confirmation").Key("enabled").MustBool(false) && !confirmed {
 		claims := jwt.MapClaims{
@@ -265,10 +198,10 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 		f = func(gc *gin.Context) {
 			app.debug.Printf("%s: Email confirmation required", req.Code)
 			respond(401, "confirmEmail", gc)
-			msg, err := app.email.constructConfirmation(req.Code, req.Username, key, app, false)
+			msg, err := app.email.withLang(app, app.messageLang(gc)).constructConfirmation(req.Code, req.Username, key, app, false)
 			if err != nil {
 				app.err.Printf("%s: Failed to construct confirmation email: %v", req.Code, err)
-			} else if err := app.email.send(msg, req.Email); err != nil {
+			} else if err := app.sendEmail(msg, req.Email); err != nil {
 				app.err.Printf("%s: Failed to send user confirmation email: %v", req.Code, err)
 			} else {
 				app.info.Printf("%s: Sent user confirmation email to \"%s\"", req.Code, req.Email)
@@ -289,17 +222,25 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 	}
 	invite, _ := app.storage.GetInvitesKey(req.Code)
 	app.checkInvite(req.Code, true, req.Username)
+	app.notifyAdmins(EventUserCreated, func() (*Message, error) {
+		return app.email.constructCreated(req.Code, req.Username, req.Email, invite, app, false)
+	})
 	if emailEnabled && app.config.Section("notifications").Key("enabled").MustBool(false) {
 		for address, settings := range invite.Notify {
 			if settings["notify-creation"] {
-				go func() {
-					msg, err := app.email.constructCreated(req.Code, req.Username, req.Email, invite, app, false)
+				go func(address string) {
+					// Check whether notify "address" is an email address of Jellyfin ID
+					isEmail := strings.Contains(address, "@")
+					emailer := app.email
+					if !isEmail {
+						emailer = app.emailFor(address)
+					}
+					msg, err := emailer.constructCreated(req.Code, req.Username, req.Email, invite, app, false)
 					if err != nil {
 						app.err.Printf("%s: Failed to construct user creation notification: %v", req.Code, err)
 					} else {
-						// Check whether notify "address" is an email address of Jellyfin ID
-						if strings.Contains(address, "@") {
-							err = app.email.send(msg, address)
+						if isEmail {
+							err = app.sendEmail(msg, address)
 						} else {
 							err = app.sendByID(msg, address)
 						}
@@ -309,7 +250,7 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 							app.info.Printf("Sent user creation notification to %s", address)
 						}
 					}
-				}()
+				}(address)
 			}
 		}
 	}
@@ -333,9 +274,14 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 		Time:       time.Now(),
 	}, gc, true)
 
+	if invite.ReferrerJellyfinID != "" {
+		app.recordReferral(gc, invite.ReferrerJellyfinID, id, invite.Code)
+	}
+
 	emailStore := EmailAddress{
 		Addr:    req.Email,
 		Contact: (req.Email != ""),
+		Lang:    app.messageLang(gc),
 	}
 
 	if invite.UserLabel != "" {
@@ -350,6 +296,7 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 		if !ok {
 			profile = app.storage.GetDefaultProfile()
 		}
+		app.storage.SetUserProfileKey(id, UserProfile{Profile: profile.Name})
 		app.debug.Printf("Applying policy from profile \"%s\"", invite.Profile)
 		status, err = app.jf.SetPolicy(id, profile.Policy)
 		if !((status == 200 || status == 204) && err == nil) {
@@ -378,6 +325,8 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 				refInv.ValidTill = refInv.Created.Add(expiryDelta)
 				refInv.IsReferral = true
 				refInv.ReferrerJellyfinID = id
+				refInv.ReferrerProfile = profile.Name
+				app.applyReferralQuota(&refInv)
 				app.storage.SetInvitesKey(refInv.Code, refInv)
 			}
 		}
@@ -391,94 +340,69 @@ func (app *appContext) newUser(req newUserDTO, confirmed bool, gc *gin.Context)
 		expiry = time.Now().AddDate(0, invite.UserMonths, invite.UserDays).Add(time.Duration((60*invite.UserHours)+invite.UserMinutes) * time.Minute)
 		app.storage.SetUserExpiryKey(id, UserExpiry{Expiry: expiry})
 	}
-	if discordVerified {
-		discordUser.Contact = req.DiscordContact
-		if app.storage.deprecatedDiscord == nil {
-			app.storage.deprecatedDiscord = discordStore{}
-		}
-		// Note we don't log an activity here, since it's part of creating a user.
-		app.storage.SetDiscordKey(user.ID, discordUser)
-		delete(app.discord.verifiedTokens, req.DiscordPIN)
-	}
-	if telegramVerified {
-		tgUser := TelegramUser{
-			ChatID:   tgToken.ChatID,
-			Username: tgToken.Username,
-			Contact:  req.TelegramContact,
-		}
-		if lang, ok := app.telegram.languages[tgToken.ChatID]; ok {
-			tgUser.Lang = lang
-		}
-		if app.storage.deprecatedTelegram == nil {
-			app.storage.deprecatedTelegram = telegramStore{}
-		}
-		app.telegram.DeleteVerifiedToken(req.TelegramPIN)
-		app.storage.SetTelegramKey(user.ID, tgUser)
-	}
-	if invite.Profile != "" && app.config.Section("ombi").Key("enabled").MustBool(false) {
-		if profile.Ombi != nil && len(profile.Ombi) != 0 {
-			template := profile.Ombi
-			errors, code, err := app.ombi.NewUser(req.Username, req.Password, req.Email, template)
-			accountExists := false
-			var ombiUser map[string]interface{}
-			if err != nil || code != 200 {
-				// Check if on the off chance, Ombi's user importer has already added the account.
-				ombiUser, status, err = app.getOmbiImportedUser(req.Username)
-				if status == 200 && err == nil {
-					app.info.Println("Found existing Ombi user, applying changes")
-					accountExists = true
-					template["password"] = req.Password
-					status, err = app.applyOmbiProfile(ombiUser, template)
-					if status != 200 || err != nil {
-						app.err.Printf("Failed to modify existing Ombi user (%d): %v\n", status, err)
-					}
-				} else {
-					app.info.Printf("Failed to create Ombi user (%d): %s", code, err)
-					app.debug.Printf("Errors reported by Ombi: %s", strings.Join(errors, ", "))
-				}
-			} else {
-				ombiUser, status, err = app.getOmbiUser(id)
+	for name, link := range contactLinks {
+		link(user.ID, req.Contact[name])
+	}
+	for _, rm := range app.requestManagers {
+		if invite.Profile == "" {
+			break
+		}
+		template := profile.RequestManagers[rm.Name()]
+		if len(template) == 0 {
+			app.debug.Printf("Skipping %s: Profile \"%s\" was empty", rm.Name(), invite.Profile)
+			continue
+		}
+		errors, code, err := rm.NewUser(req.Username, req.Password, req.Email, template)
+		accountExists := false
+		var rmUser common.RequestManagerUser
+		if err != nil || code != 200 {
+			// Check if on the off chance, the request manager's user importer has already added the account.
+			rmUser, status, err = app.getRequestManagerImportedUser(rm, req.Username)
+			if status == 200 && err == nil {
+				app.info.Printf("Found existing %s user, applying changes", rm.Name())
+				accountExists = true
+				rmUser.Password = req.Password
+				status, err = rm.ApplyTemplate(rmUser, template)
 				if status != 200 || err != nil {
-					app.err.Printf("Failed to get Ombi user (%d): %v", status, err)
-				} else {
-					app.info.Println("Created Ombi user")
-					accountExists = true
-				}
-			}
-			if accountExists {
-				if discordVerified || telegramVerified {
-					dID := ""
-					tUser := ""
-					if discordVerified {
-						dID = discordUser.ID
-					}
-					if telegramVerified {
-						u, _ := app.storage.GetTelegramKey(user.ID)
-						tUser = u.Username
-					}
-					resp, status, err := app.ombi.SetNotificationPrefs(ombiUser, dID, tUser)
-					if !(status == 200 || status == 204) || err != nil {
-						app.err.Printf("Failed to link Telegram/Discord to Ombi (%d): %v", status, err)
-						app.debug.Printf("Response: %v", resp)
-					}
+					app.err.Printf("Failed to modify existing %s user (%d): %v\n", rm.Name(), status, err)
 				}
+			} else {
+				app.info.Printf("Failed to create %s user (%d): %s", rm.Name(), code, err)
+				app.debug.Printf("Errors reported by %s: %s", rm.Name(), strings.Join(errors, ", "))
 			}
 		} else {
-			app.debug.Printf("Skipping Ombi: Profile \"%s\" was empty", invite.Profile)
+			rmUser, status, err = app.getRequestManagerUser(rm, id)
+			if status != 200 || err != nil {
+				app.err.Printf("Failed to get %s user (%d): %v", rm.Name(), status, err)
+			} else {
+				app.info.Printf("Created %s user", rm.Name())
+				accountExists = true
+			}
 		}
-	}
-	if matrixVerified {
-		matrixUser.Contact = req.MatrixContact
-		delete(app.matrix.tokens, req.MatrixPIN)
-		if app.storage.deprecatedMatrix == nil {
-			app.storage.deprecatedMatrix = matrixStore{}
+		if accountExists {
+			// Request managers only take Discord & Telegram accounts verified on the form.
+			dID, tUser := "", ""
+			if _, ok := contactLinks["discord"]; ok {
+				dcUser, _ := app.storage.GetDiscordKey(user.ID)
+				dID = dcUser.ID
+			}
+			if _, ok := contactLinks["telegram"]; ok {
+				tgUser, _ := app.storage.GetTelegramKey(user.ID)
+				tUser = tgUser.Username
+			}
+			if dID != "" || tUser != "" {
+				resp, status, err := rm.SetNotificationPrefs(rmUser, dID, tUser)
+				if !(status == 200 || status == 204) || err != nil {
+					app.err.Printf("Failed to link Telegram/Discord to %s (%d): %v", rm.Name(), status, err)
+					app.debug.Printf("Response: %v", resp)
+				}
+			}
 		}
-		app.storage.SetMatrixKey(user.ID, matrixUser)
 	}
-	if (emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "") || telegramVerified || discordVerified || matrixVerified {
+	if (emailEnabled && app.config.Section("welcome_email").Key("enabled").MustBool(false) && req.Email != "") || len(contactLinks) != 0 {
 		name := app.getAddressOrName(user.ID)
 		app.debug.Printf("%s: Sending welcome message to %s", req.Username, name)
-		msg, err := app.email.constructWelcome(req.Username, expiry, app, false)
+		msg, err := app.emailFor(user.ID).constructWelcome(req.Username, expiry, app, false)
 		if err != nil {
 			app.err.Printf("%s: Failed to construct welcome message: %v", req.Username, err)
 		} else if err := app.sendByID(msg, user.ID); err != nil {
@@ -503,6 +427,9 @@ func (app *appContext) NewUser(gc *gin.Context) {
 	var req newUserDTO
 	gc.BindJSON(&req)
 	app.debug.Printf("%s: New user attempt", req.Code)
+	if !app.checkRateLimit(gc, true, "") {
+		return
+	}
 	if app.config.Section("captcha").Key("enabled").MustBool(false) && !app.verifyCaptcha(req.Code, req.CaptchaID, req.CaptchaText, false) {
 		app.info.Printf("%s: New user failed: Captcha Incorrect", req.Code)
 		respond(400, "errorCaptcha", gc)
@@ -510,9 +437,15 @@ func (app *appContext) NewUser(gc *gin.Context) {
 	}
 	if !app.checkInvite(req.Code, false, "") {
 		app.info.Printf("%s New user failed: invalid code", req.Code)
+		app.recordFailedAttempt(gc, true, "")
 		respond(401, "errorInvalidCode", gc)
 		return
 	}
+	if inv, ok := app.storage.GetInvitesKey(req.Code); ok && inv.IsReferral && !app.applyReferralQuota(&inv) {
+		app.info.Printf("%s New user failed: referrer's quota reached", req.Code)
+		respond(401, "errorReferralQuota", gc)
+		return
+	}
 	validation := app.validator.validate(req.Password)
 	valid := true
 	for _, val := range validation {
@@ -565,28 +498,36 @@ func (app *appContext) NewUser(gc *gin.Context) {
 func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 	var req enableDisableUserDTO
 	gc.BindJSON(&req)
+	errors := app.enableDisableUsers(gc, req, ActivityAdmin)
+	if len(errors["GetUser"]) != 0 || len(errors["SetPolicy"]) != 0 {
+		gc.JSON(500, errors)
+		return
+	}
+	respondBool(200, true, gc)
+}
+
+// enableDisableUsers enables or disables the given users, notifying them if requested, and returns any errors by user.
+// sourceType is recorded in the activity log, with the admin's ID as the source if ActivityAdmin.
+func (app *appContext) enableDisableUsers(gc *gin.Context, req enableDisableUserDTO, sourceType ActivitySource) errorListDTO {
 	errors := errorListDTO{
 		"GetUser":   map[string]string{},
 		"SetPolicy": map[string]string{},
 	}
 	sendMail := messagesEnabled
-	var msg *Message
-	var err error
-	if sendMail {
+	msgFor := app.messageForUsers(func(emailer *Emailer) (*Message, error) {
 		if req.Enabled {
-			msg, err = app.email.constructEnabled(req.Reason, app, false)
-		} else {
-			msg, err = app.email.constructDisabled(req.Reason, app, false)
-		}
-		if err != nil {
-			app.err.Printf("Failed to construct account enabled/disabled emails: %v", err)
-			sendMail = false
+			return emailer.constructEnabled(req.Reason, app, false)
 		}
-	}
+		return emailer.constructDisabled(req.Reason, app, false)
+	})
 	activityType := ActivityDisabled
 	if req.Enabled {
 		activityType = ActivityEnabled
 	}
+	source := ""
+	if sourceType == ActivityAdmin {
+		source = gc.GetString("jfId")
+	}
 	for _, userID := range req.Users {
 		user, status, err := app.jf.UserByID(userID, false)
 		if status != 200 || err != nil {
@@ -606,12 +547,17 @@ func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 		app.storage.SetActivityKey(shortuuid.New(), Activity{
 			Type:       activityType,
 			UserID:     userID,
-			SourceType: ActivityAdmin,
-			Source:     gc.GetString("jfId"),
+			SourceType: sourceType,
+			Source:     source,
 			Time:       time.Now(),
 		}, gc, false)
 
 		if sendMail && req.Notify {
+			msg, err := msgFor(userID)
+			if err != nil {
+				app.err.Printf("Failed to construct account enabled/disabled emails: %v", err)
+				continue
+			}
 			if err := app.sendByID(msg, userID); err != nil {
 				app.err.Printf("Failed to send account enabled/disabled email: %v", err)
 				continue
@@ -619,11 +565,7 @@ func (app *appContext) EnableDisableUsers(gc *gin.Context) {
 		}
 	}
 	app.jf.CacheExpiry = time.Now()
-	if len(errors["GetUser"]) != 0 || len(errors["SetPolicy"]) != 0 {
-		gc.JSON(500, errors)
-		return
-	}
-	respondBool(200, true, gc)
+	return errors
 }
 
 // @Summary Delete a list of users, optionally notifying them why.
@@ -639,27 +581,18 @@ func (app *appContext) DeleteUsers(gc *gin.Context) {
 	var req deleteUserDTO
 	gc.BindJSON(&req)
 	errors := map[string]string{}
-	ombiEnabled := app.config.Section("ombi").Key("enabled").MustBool(false)
 	sendMail := messagesEnabled
-	var msg *Message
-	var err error
-	if sendMail {
-		msg, err = app.email.constructDeleted(req.Reason, app, false)
-		if err != nil {
-			app.err.Printf("Failed to construct account deletion emails: %v", err)
-			sendMail = false
-		}
-	}
+	msgFor := app.messageForUsers(func(emailer *Emailer) (*Message, error) {
+		return emailer.constructDeleted(req.Reason, app, false)
+	})
 	for _, userID := range req.Users {
-		if ombiEnabled {
-			ombiUser, code, err := app.getOmbiUser(userID)
-			if code == 200 && err == nil {
-				if id, ok := ombiUser["id"]; ok {
-					status, err := app.ombi.DeleteUser(id.(string))
-					if err != nil || status != 200 {
-						app.err.Printf("Failed to delete ombi user (%d): %v", status, err)
-						errors[userID] = fmt.Sprintf("Ombi: %d %v, ", status, err)
-					}
+		for _, rm := range app.requestManagers {
+			rmUser, code, err := app.getRequestManagerUser(rm, userID)
+			if code == 200 && err == nil && rmUser.ID != "" {
+				status, err := rm.DeleteUser(rmUser.ID)
+				if err != nil || status != 200 {
+					app.err.Printf("Failed to delete %s user (%d): %v", rm.Name(), status, err)
+					errors[userID] += fmt.Sprintf("%s: %d %v, ", rm.Name(), status, err)
 				}
 			}
 		}
@@ -690,7 +623,9 @@ func (app *appContext) DeleteUsers(gc *gin.Context) {
 		}, gc, false)
 
 		if sendMail && req.Notify {
-			if err := app.sendByID(msg, userID); err != nil {
+			if msg, err := msgFor(userID); err != nil {
+				app.err.Printf("Failed to construct account deletion emails: %v", err)
+			} else if err := app.sendByID(msg, userID); err != nil {
 				app.err.Printf("Failed to send account deletion email: %v", err)
 			}
 		}
@@ -809,14 +744,19 @@ func (app *appContext) EnableReferralForUsers(gc *gin.Context) {
 		inv.IsReferral = true
 		inv.ReferrerJellyfinID = u
 		inv.UseReferralExpiry = useExpiry
+		if mode == "profile" {
+			inv.ReferrerProfile = source
+			app.applyReferralQuota(&inv)
+		}
 		app.storage.SetInvitesKey(inv.Code, inv)
 	}
 }
 
-// @Summary Disable referrals for the given user(s).
+// @Summary Disable referrals for the given user(s), optionally disabling (and revoking the referrals of) the users they referred.
 // @Produce json
 // @Param EnableDisableReferralDTO body EnableDisableReferralDTO true "List of users"
 // @Success 200 {object} boolResponse
+// @Failure 500 {object} errorListDTO "List of errors"
 // @Router /users/referral [delete]
 // @Security Bearer
 // @tags Users
@@ -824,15 +764,42 @@ func (app *appContext) DisableReferralForUsers(gc *gin.Context) {
 	var req EnableDisableReferralDTO
 	gc.BindJSON(&req)
 	for _, u := range req.Users {
-		// 1. Delete directly bound template
-		app.storage.db.DeleteMatching(Invite{}, badgerhold.Where("ReferrerJellyfinID").Eq(u))
-		// 2. Check for and delete profile-attached template
-		user, ok := app.storage.GetEmailsKey(u)
-		if !ok {
-			continue
+		app.revokeReferrals(u)
+	}
+	if !req.DisableReferred {
+		respondBool(200, true, gc)
+		return
+	}
+	users, status, err := app.jf.GetUsers(false)
+	if !(status == 200 || status == 204) || err != nil {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+		respondBool(500, false, gc)
+		return
+	}
+	exists := make(map[string]bool, len(users))
+	for _, user := range users {
+		exists[user.ID] = true
+	}
+	referred := []string{}
+	for _, u := range req.Users {
+		for _, id := range app.referredUsers(u, req.Recursive) {
+			app.revokeReferrals(id)
+			// Referral records outlive deleted users, who can't be disabled.
+			if exists[id] {
+				referred = append(referred, id)
+			}
 		}
-		user.ReferralTemplateKey = ""
-		app.storage.SetEmailsKey(u, user)
+	}
+	app.info.Printf("Revoked referrals for %d user(s), disabling %d referred user(s)", len(req.Users), len(referred))
+	errors := app.enableDisableUsers(gc, enableDisableUserDTO{
+		Users:   referred,
+		Enabled: false,
+		Notify:  req.Notify,
+		Reason:  req.Reason,
+	}, ActivityAdmin)
+	if len(errors["GetUser"]) != 0 || len(errors["SetPolicy"]) != 0 {
+		gc.JSON(500, errors)
+		return
 	}
 	respondBool(200, true, gc)
 }
@@ -862,27 +829,41 @@ func (app *appContext) Announce(gc *gin.Context) {
 				app.err.Printf("Failed to get user with ID \"%s\" (%d): %v", userID, status, err)
 				continue
 			}
-			msg, err := app.email.constructTemplate(req.Subject, req.Message, app, user.Name)
+			msg, err := app.emailFor(userID).constructTemplate(req.Subject, req.Message, app, user.Name)
 			if err != nil {
 				app.err.Printf("Failed to construct announcement message: %v", err)
 				respondBool(500, false, gc)
 				return
-			} else if err := app.sendByID(msg, userID); err != nil {
+			}
+			msg.Bulk = true
+			msg.Kind = "Announcement"
+			if err := app.sendByID(msg, userID); err != nil {
 				app.err.Printf("Failed to send announcement message: %v", err)
 				respondBool(500, false, gc)
 				return
 			}
 		}
 	} else {
-		msg, err := app.email.constructTemplate(req.Subject, req.Message, app)
-		if err != nil {
-			app.err.Printf("Failed to construct announcement messages: %v", err)
-			respondBool(500, false, gc)
-			return
-		} else if err := app.sendByID(msg, req.Users...); err != nil {
-			app.err.Printf("Failed to send announcement messages: %v", err)
-			respondBool(500, false, gc)
-			return
+		// Construct once per language group.
+		groups := map[string][]string{}
+		for _, userID := range req.Users {
+			lang := app.userLang(userID)
+			groups[lang] = append(groups[lang], userID)
+		}
+		for lang, users := range groups {
+			msg, err := app.email.withLang(app, lang).constructTemplate(req.Subject, req.Message, app)
+			if err != nil {
+				app.err.Printf("Failed to construct announcement messages: %v", err)
+				respondBool(500, false, gc)
+				return
+			}
+			msg.Bulk = true
+			msg.Kind = "Announcement"
+			if err := app.sendByID(msg, users...); err != nil {
+				app.err.Printf("Failed to send announcement messages: %v", err)
+				respondBool(500, false, gc)
+				return
+			}
 		}
 	}
 	app.info.Println("Sent announcement messages")
@@ -996,7 +977,7 @@ func (app *appContext) AdminPasswordReset(gc *gin.Context) {
 			}
 		}
 		if sendAddress != "" {
-			msg, err := app.email.constructReset(
+			msg, err := app.emailFor(id).constructReset(
 				PasswordReset{
 					Pin:      pwr.PIN,
 					Username: pwr.Username,
@@ -1022,6 +1003,94 @@ func (app *appContext) AdminPasswordReset(gc *gin.Context) {
 	respondBool(204, true, gc)
 }
 
+// @Summary Rename the given user(s), e.g. to enforce a naming policy. Also approves any pending username requests.
+// @Produce json
+// @Param renameUsersDTO body renameUsersDTO true "Map of user IDs to new usernames."
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} errorListDTO "List of errors"
+// @Router /users/rename [post]
+// @Security Bearer
+// @tags Users
+func (app *appContext) RenameUsers(gc *gin.Context) {
+	var req renameUsersDTO
+	gc.BindJSON(&req)
+	if len(req.Users) == 0 {
+		respond(400, "No users provided", gc)
+		return
+	}
+	errors := errorListDTO{
+		"GetUser":  map[string]string{},
+		"Validate": map[string]string{},
+		"Rename":   map[string]string{},
+	}
+	for id, name := range req.Users {
+		user, status, err := app.jf.UserByID(id, false)
+		if status != 200 || err != nil {
+			errors["GetUser"][id] = fmt.Sprintf("%d %v", status, err)
+			app.err.Printf("Failed to get user \"%s\" (%d): %v", id, status, err)
+			continue
+		}
+		if name == user.Name {
+			app.storage.DeleteUsernameRequestKey(id)
+			continue
+		}
+		if errKey := app.validateUsername(id, name); errKey != "" {
+			errors["Validate"][id] = errKey
+			app.err.Printf("Failed to rename \"%s\" to \"%s\": %s", user.Name, name, errKey)
+			continue
+		}
+		if err := app.renameUser(gc, user, name, ActivityAdmin, gc.GetString("jfId"), req.Notify); err != nil {
+			errors["Rename"][id] = err.Error()
+			app.err.Printf("Failed to rename \"%s\" to \"%s\": %v", user.Name, name, err)
+		}
+	}
+	if len(errors["GetUser"]) != 0 || len(errors["Validate"]) != 0 || len(errors["Rename"]) != 0 {
+		gc.JSON(500, errors)
+		return
+	}
+	respondBool(200, true, gc)
+}
+
+// @Summary Get username changes requested on the user page, awaiting approval.
+// @Produce json
+// @Success 200 {object} usernameRequestsDTO
+// @Router /users/username-requests [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetUsernameRequests(gc *gin.Context) {
+	requests := app.storage.GetUsernameRequests()
+	resp := usernameRequestsDTO{Requests: make([]usernameRequestDTO, len(requests))}
+	for i, r := range requests {
+		resp.Requests[i] = usernameRequestDTO{
+			ID:      r.JellyfinID,
+			OldName: r.OldName,
+			NewName: r.NewName,
+			Time:    r.Time.Unix(),
+		}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Deny a pending username change. To approve one, rename the user with /users/rename.
+// @Produce json
+// @Param id path string true "Jellyfin ID of the user"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /users/username-requests/{id} [delete]
+// @Security Bearer
+// @tags Users
+func (app *appContext) DenyUsernameRequest(gc *gin.Context) {
+	id := gc.Param("id")
+	if _, ok := app.storage.GetUsernameRequestKey(id); !ok {
+		respondBool(400, false, gc)
+		return
+	}
+	app.storage.DeleteUsernameRequestKey(id)
+	app.info.Printf("Denied username request for \"%s\"", id)
+	respondBool(200, true, gc)
+}
+
 // @Summary Get a list of Jellyfin users.
 // @Produce json
 // @Success 200 {object} getUsersDTO
@@ -1042,6 +1111,14 @@ func (app *appContext) GetUsers(gc *gin.Context) {
 	adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
 	allowAll := app.config.Section("ui").Key("allow_all").MustBool(false)
 	referralsEnabled := app.config.Section("user_page").Key("referrals").MustBool(false)
+	disabled := make(map[string]bool, len(users))
+	for _, jfUser := range users {
+		disabled[jfUser.ID] = jfUser.Policy.IsDisabled
+	}
+	pendingUsernames := map[string]string{}
+	for _, usernameRequest := range app.storage.GetUsernameRequests() {
+		pendingUsernames[usernameRequest.JellyfinID] = usernameRequest.NewName
+	}
 	i := 0
 	for _, jfUser := range users {
 		user := respUser{
@@ -1050,13 +1127,12 @@ func (app *appContext) GetUsers(gc *gin.Context) {
 			Admin:            jfUser.Policy.IsAdministrator,
 			Disabled:         jfUser.Policy.IsDisabled,
 			ReferralsEnabled: false,
+			PendingUsername:  pendingUsernames[jfUser.ID],
 		}
 		if !jfUser.LastActivityDate.IsZero() {
 			user.LastActive = jfUser.LastActivityDate.Unix()
 		}
 		if email, ok := app.storage.GetEmailsKey(jfUser.ID); ok {
-			user.Email = email.Addr
-			user.NotifyThroughEmail = email.Contact
 			user.Label = email.Label
 			user.AccountsAdmin = (app.jellyfinLogin) && (email.Admin || (adminOnly && jfUser.Policy.IsAdministrator) || allowAll)
 		}
@@ -1064,19 +1140,11 @@ func (app *appContext) GetUsers(gc *gin.Context) {
 		if ok {
 			user.Expiry = expiry.Expiry.Unix()
 		}
-		if tgUser, ok := app.storage.GetTelegramKey(jfUser.ID); ok {
-			user.Telegram = tgUser.Username
-			user.NotifyThroughTelegram = tgUser.Contact
-		}
-		if mxUser, ok := app.storage.GetMatrixKey(jfUser.ID); ok {
-			user.Matrix = mxUser.UserID
-			user.NotifyThroughMatrix = mxUser.Contact
-		}
-		if dcUser, ok := app.storage.GetDiscordKey(jfUser.ID); ok {
-			user.Discord = RenderDiscordUsername(dcUser)
-			// user.Discord = dcUser.Username + "#" + dcUser.Discriminator
-			user.DiscordID = dcUser.ID
-			user.NotifyThroughDiscord = dcUser.Contact
+		user.ContactMethods = make(map[string]ContactLink, len(app.contactMethods))
+		for _, cm := range app.contactMethods {
+			// Blank if not linked, as the accounts tab expects every field.
+			link, _ := cm.Linked(jfUser.ID)
+			user.ContactMethods[cm.Name()] = link
 		}
 		// FIXME: Send referral data
 		referrerInv := Invite{}
@@ -1089,6 +1157,10 @@ func (app *appContext) GetUsers(gc *gin.Context) {
 			} else if email, ok := app.storage.GetEmailsKey(jfUser.ID); ok && email.ReferralTemplateKey != "" {
 				user.ReferralsEnabled = true
 			}
+			if referral, ok := app.storage.GetReferralKey(jfUser.ID); ok {
+				isDisabled, exists := disabled[referral.ReferrerJellyfinID]
+				user.ReferrerInactive = !exists || isDisabled
+			}
 		}
 		resp.UserList[i] = user
 		i++
@@ -1180,7 +1252,6 @@ func (app *appContext) ModifyEmails(gc *gin.Context) {
 		respond(500, "Couldn't get users", gc)
 		return
 	}
-	ombiEnabled := app.config.Section("ombi").Key("enabled").MustBool(false)
 	for _, jfUser := range users {
 		id := jfUser.ID
 		if address, ok := req[id]; ok {
@@ -1210,16 +1281,7 @@ func (app *appContext) ModifyEmails(gc *gin.Context) {
 				Time:       time.Now(),
 			}, gc, false)
 
-			if ombiEnabled {
-				ombiUser, code, err := app.getOmbiUser(id)
-				if code == 200 && err == nil {
-					ombiUser["emailAddress"] = address
-					code, err = app.ombi.ModifyUser(ombiUser)
-					if code != 200 || err != nil {
-						app.err.Printf("%s: Failed to change ombi email address (%d): %v", ombiUser["userName"].(string), code, err)
-					}
-				}
-			}
+			app.setRequestManagerEmails(id, address)
 		}
 	}
 	app.info.Println("Email list modified")
@@ -1242,7 +1304,7 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 	var policy mediabrowser.Policy
 	var configuration mediabrowser.Configuration
 	var displayprefs map[string]interface{}
-	var ombi map[string]interface{}
+	rmTemplates := map[string]map[string]interface{}{}
 	if req.From == "profile" {
 		// Check profile exists & isn't empty
 		profile, ok := app.storage.GetProfileKey(req.Profile)
@@ -1261,9 +1323,9 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 			displayprefs = profile.Displayprefs
 		}
 		policy = profile.Policy
-		if app.config.Section("ombi").Key("enabled").MustBool(false) {
-			if profile.Ombi != nil && len(profile.Ombi) != 0 {
-				ombi = profile.Ombi
+		for _, rm := range app.requestManagers {
+			if template := profile.RequestManagers[rm.Name()]; len(template) != 0 {
+				rmTemplates[rm.Name()] = template
 			}
 		}
 
@@ -1292,7 +1354,9 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 	errors := errorListDTO{
 		"policy":     map[string]string{},
 		"homescreen": map[string]string{},
-		"ombi":       map[string]string{},
+	}
+	for _, rm := range app.requestManagers {
+		errors[rm.Name()] = map[string]string{}
 	}
 	/* Jellyfin doesn't seem to like too many of these requests sent in succession
 	and can crash and mess up its database. Issue #160 says this occurs when more
@@ -1303,6 +1367,9 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 		app.debug.Println("Adding delay between requests for large batch")
 	}
 	for _, id := range req.ApplyTo {
+		if req.From == "profile" {
+			app.storage.SetUserProfileKey(id, UserProfile{Profile: req.Profile})
+		}
 		status, err := app.jf.SetPolicy(id, policy)
 		if !(status == 200 || status == 204) || err != nil {
 			errors["policy"][id] = fmt.Sprintf("%d: %s", status, err)
@@ -1325,24 +1392,23 @@ func (app *appContext) ApplySettings(gc *gin.Context) {
 				errors["homescreen"][id] = errorString
 			}
 		}
-		if ombi != nil {
+		for _, rm := range app.requestManagers {
+			template, ok := rmTemplates[rm.Name()]
+			if !ok {
+				continue
+			}
 			errorString := ""
-			user, status, err := app.getOmbiUser(id)
+			user, status, err := app.getRequestManagerUser(rm, id)
 			if status != 200 || err != nil {
-				errorString += fmt.Sprintf("Ombi GetUser %d: %v ", status, err)
+				errorString += fmt.Sprintf("%s GetUser %d: %v ", rm.Name(), status, err)
 			} else {
-				// newUser := ombi
-				// newUser["id"] = user["id"]
-				// newUser["userName"] = user["userName"]
-				// newUser["alias"] = user["alias"]
-				// newUser["emailAddress"] = user["emailAddress"]
-				status, err = app.applyOmbiProfile(user, ombi)
+				status, err = rm.ApplyTemplate(user, template)
 				if status != 200 || err != nil {
 					errorString += fmt.Sprintf("Apply %d: %v ", status, err)
 				}
 			}
 			if errorString != "" {
-				errors["ombi"][id] = errorString
+				errors[rm.Name()][id] = errorString
 			}
 		}
 		if shouldDelay {
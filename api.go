@@ -199,28 +199,8 @@ func (app *appContext) ResetSetPassword(gc *gin.Context) {
 		respondBool(500, false, gc)
 		return
 	}
-	if app.config.Section("ombi").Key("enabled").MustBool(false) {
-		// Silently fail for changing ombi passwords
-		if (status != 200 && status != 204) || err != nil {
-			app.err.Printf("Failed to get user \"%s\" from jellyfin/emby (%d): %v", username, status, err)
-			respondBool(200, true, gc)
-			return
-		}
-		ombiUser, status, err := app.getOmbiUser(user.ID)
-		if status != 200 || err != nil {
-			app.err.Printf("Failed to get user \"%s\" from ombi (%d): %v", username, status, err)
-			respondBool(200, true, gc)
-			return
-		}
-		ombiUser["password"] = req.Password
-		status, err = app.ombi.ModifyUser(ombiUser)
-		if status != 200 || err != nil {
-			app.err.Printf("Failed to set password for ombi user \"%s\" (%d): %v", ombiUser["userName"], status, err)
-			respondBool(200, true, gc)
-			return
-		}
-		app.debug.Printf("Reset password for ombi user \"%s\"", ombiUser["userName"])
-	}
+	// Silently fail for changing request manager passwords
+	app.setRequestManagerPasswords(user.ID, req.Password)
 	respondBool(200, true, gc)
 }
 
@@ -450,13 +430,11 @@ func (app *appContext) ApplyUpdate(gc *gin.Context) {
 // @Security Bearer
 // @tags Other
 func (app *appContext) Logout(gc *gin.Context) {
-	cookie, err := gc.Cookie("refresh")
-	if err != nil {
+	if err := app.revokeSessionFromCookie(gc, "refresh"); err != nil {
 		app.debug.Printf("Couldn't get cookies: %s", err)
 		respond(500, "Couldn't fetch cookies", gc)
 		return
 	}
-	app.invalidTokens = append(app.invalidTokens, cookie)
 	gc.SetCookie("refresh", "invalid", -1, "/", gc.Request.URL.Hostname(), true, true)
 	respondBool(200, true, gc)
 }
@@ -42,7 +42,8 @@ func (app *appContext) webAuth() gin.HandlerFunc {
 }
 
 // CreateToken returns a web token as well as a refresh token, which can be used to obtain new tokens.
-func CreateToken(userId, jfId string, admin bool) (string, string, error) {
+// Both are tied to the given session, and are rejected once it is deleted.
+func CreateToken(userId, jfId string, admin bool, sessionID string) (string, string, error) {
 	var token, refresh string
 	claims := jwt.MapClaims{
 		"valid": true,
@@ -51,6 +52,7 @@ func CreateToken(userId, jfId string, admin bool) (string, string, error) {
 		"jfid":  jfId,
 		"admin": admin,
 		"type":  "bearer",
+		"sid":   sessionID,
 	}
 	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	token, err := tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
@@ -103,6 +105,11 @@ func (app *appContext) decodeValidateAuthHeader(gc *gin.Context) (claims jwt.Map
 		ok = false
 		return
 	}
+	if _, ok = app.getSessionFromClaims(claims); !ok {
+		app.debug.Printf("Auth denied: Session revoked or expired")
+		respond(401, "Unauthorized", gc)
+		return
+	}
 	ok = true
 	return
 }
@@ -136,6 +143,7 @@ func (app *appContext) authenticate(gc *gin.Context) {
 	}
 	gc.Set("jfId", jfID)
 	gc.Set("userId", userID)
+	gc.Set("sessionId", claims["sid"].(string))
 	gc.Set("userMode", false)
 	app.debug.Println("Auth succeeded")
 	gc.Next()
@@ -149,7 +157,9 @@ func checkToken(token *jwt.Token) (interface{}, error) {
 }
 
 type getTokenDTO struct {
-	Token string `json:"token" example:"kjsdklsfdkljfsjsdfklsdfkldsfjdfskjsdfjklsdf"` // API token for use with everything else.
+	Token         string `json:"token" example:"kjsdklsfdkljfsjsdfklsdfkldsfjdfskjsdfjklsdf"` // API token for use with everything else.
+	TOTPRequired  bool   `json:"totp_required,omitempty"`                                     // If true, no token is given, and a TOTP code must be submitted to /token/totp with the challenge.
+	TOTPChallenge string `json:"totp_challenge,omitempty"`                                    // Short-lived token identifying the login attempt for /token/totp.
 }
 
 func (app *appContext) decodeValidateLoginHeader(gc *gin.Context, userpage bool) (username, password string, ok bool) {
@@ -174,6 +184,7 @@ func (app *appContext) validateJellyfinCredentials(username, password string, gc
 	if status != 200 || err != nil {
 		if status == 401 || status == 400 {
 			app.logIpInfo(gc, userpage, "Auth denied: Invalid username/password (Jellyfin)")
+			app.recordFailedAttempt(gc, userpage, username)
 			respond(401, "Unauthorized", gc)
 			return
 		}
@@ -190,6 +201,19 @@ func (app *appContext) validateJellyfinCredentials(username, password string, gc
 	return
 }
 
+// isAccountsAdmin returns whether the given Jellyfin user is allowed to access the admin page.
+func (app *appContext) isAccountsAdmin(user mediabrowser.User) bool {
+	if app.config.Section("ui").Key("allow_all").MustBool(false) {
+		return true
+	}
+	accountsAdmin := false
+	adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
+	if emailStore, ok := app.storage.GetEmailsKey(user.ID); ok {
+		accountsAdmin = emailStore.Admin
+	}
+	return accountsAdmin || (adminOnly && user.Policy.IsAdministrator)
+}
+
 // @Summary Grabs an API token using username & password.
 // @description If viewing docs locally, click the lock icon next to this, login with your normal jfa-go credentials. Click 'try it out', then 'execute' and an API Key will be returned, copy it (not including quotes). On any of the other routes, click the lock icon and set the API key as "Bearer `your api key`".
 // @Produce json
@@ -204,6 +228,9 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 	if !ok {
 		return
 	}
+	if !app.checkRateLimit(gc, false, username) {
+		return
+	}
 	var userID, jfID string
 	match := false
 	for _, user := range app.adminUsers {
@@ -216,6 +243,7 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 	}
 	if !app.jellyfinLogin && !match {
 		app.logIpInfo(gc, false, "Auth denied: Invalid username/password")
+		app.recordFailedAttempt(gc, false, username)
 		respond(401, "Unauthorized", gc)
 		return
 	}
@@ -225,28 +253,49 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 			return
 		}
 		jfID = user.ID
-		if !app.config.Section("ui").Key("allow_all").MustBool(false) {
-			accountsAdmin := false
-			adminOnly := app.config.Section("ui").Key("admin_only").MustBool(true)
-			if emailStore, ok := app.storage.GetEmailsKey(jfID); ok {
-				accountsAdmin = emailStore.Admin
-			}
-			accountsAdmin = accountsAdmin || (adminOnly && user.Policy.IsAdministrator)
-			if !accountsAdmin {
-				app.debug.Printf("Auth denied: Users \"%s\" isn't admin", username)
-				respond(401, "Unauthorized", gc)
-				return
-			}
+		if !app.isAccountsAdmin(user) {
+			app.debug.Printf("Auth denied: Users \"%s\" isn't admin", username)
+			respond(401, "Unauthorized", gc)
+			return
 		}
 		// New users are only added when using jellyfinLogin.
 		userID = shortuuid.New()
-		newUser := User{
-			UserID: userID,
+	}
+	app.recordSuccessfulAttempt(gc, username)
+	app.respondAdminTokenOrChallenge(userID, jfID, username, gc)
+}
+
+// respondAdminTokenOrChallenge responds with a TOTP challenge if the admin has TOTP enabled, and a new token otherwise.
+func (app *appContext) respondAdminTokenOrChallenge(userID, jfID, username string, gc *gin.Context) {
+	if totp, ok := app.storage.GetAdminTOTPKey(adminTOTPKey(jfID)); ok && totp.Enabled {
+		challenge, err := createTOTPChallenge(userID, jfID)
+		if err != nil {
+			app.err.Printf("getToken failed: Couldn't generate TOTP challenge (%s)", err)
+			respond(500, "Couldn't generate token", gc)
+			return
 		}
-		app.debug.Printf("Token generated for user \"%s\"", username)
-		app.adminUsers = append(app.adminUsers, newUser)
+		app.debug.Printf("TOTP code required for user \"%s\"", username)
+		gc.JSON(200, getTokenDTO{TOTPRequired: true, TOTPChallenge: challenge})
+		return
 	}
-	token, refresh, err := CreateToken(userID, jfID, true)
+	app.debug.Printf("Token generated for user \"%s\"", username)
+	app.respondAdminToken(userID, jfID, gc)
+}
+
+// respondAdminToken adds the admin to the list of known admins, and responds with a new token & refresh cookie.
+func (app *appContext) respondAdminToken(userID, jfID string, gc *gin.Context) {
+	match := false
+	for _, user := range app.adminUsers {
+		if user.UserID == userID {
+			match = true
+			break
+		}
+	}
+	if !match {
+		app.adminUsers = append(app.adminUsers, User{UserID: userID})
+	}
+	session := app.newSession(gc, userID, jfID, true)
+	token, refresh, err := CreateToken(userID, jfID, true, session.ID)
 	if err != nil {
 		app.err.Printf("getToken failed: Couldn't generate token (%s)", err)
 		respond(500, "Couldn't generate token", gc)
@@ -254,10 +303,69 @@ func (app *appContext) getTokenLogin(gc *gin.Context) {
 	}
 	host := gc.Request.URL.Hostname()
 	gc.SetCookie("refresh", refresh, REFRESH_TOKEN_VALIDITY_SEC, "/", host, true, true)
-	gc.JSON(200, getTokenDTO{token})
+	gc.JSON(200, getTokenDTO{Token: token})
 }
 
-func (app *appContext) decodeValidateRefreshCookie(gc *gin.Context, cookieName string) (claims jwt.MapClaims, ok bool) {
+// createTOTPChallenge returns a short-lived token to be exchanged, along with a valid TOTP code, for a normal token.
+func createTOTPChallenge(userID, jfID string) (string, error) {
+	claims := jwt.MapClaims{
+		"valid": true,
+		"id":    userID,
+		"exp":   time.Now().Add(time.Second * TOTP_CHALLENGE_VALID).Unix(),
+		"jfid":  jfID,
+		"type":  "totp",
+	}
+	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tk.SignedString([]byte(os.Getenv("JFA_SECRET")))
+}
+
+// @Summary Grabs an API token using a TOTP challenge from /token/login, and a TOTP or recovery code.
+// @Produce json
+// @Param totpLoginDTO body totpLoginDTO true "Challenge and code"
+// @Success 200 {object} getTokenDTO
+// @Failure 400 {object} stringResponse
+// @Failure 401 {object} stringResponse
+// @Router /token/totp [post]
+// @tags Auth
+func (app *appContext) getTokenTOTP(gc *gin.Context) {
+	app.logIpInfo(gc, false, "Token requested (TOTP)")
+	if !app.checkRateLimit(gc, false, "") {
+		return
+	}
+	var req totpLoginDTO
+	gc.BindJSON(&req)
+	token, err := jwt.Parse(req.Challenge, checkToken)
+	if err != nil {
+		app.debug.Printf("Auth denied: %s", err)
+		respond(400, "Invalid challenge", gc)
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !(ok && token.Valid && claims["type"].(string) == "totp") {
+		app.debug.Println("Auth denied: Invalid TOTP challenge")
+		respond(400, "Invalid challenge", gc)
+		return
+	}
+	userID := claims["id"].(string)
+	jfID := claims["jfid"].(string)
+	key := adminTOTPKey(jfID)
+	totp, ok := app.storage.GetAdminTOTPKey(key)
+	if !ok || !totp.Enabled {
+		respond(400, "Invalid challenge", gc)
+		return
+	}
+	if !totp.check(req.Code) {
+		app.logIpInfo(gc, false, "Auth denied: Invalid TOTP code")
+		app.recordFailedAttempt(gc, false, "")
+		respond(401, "errorInvalidCode", gc)
+		return
+	}
+	app.storage.SetAdminTOTPKey(key, totp)
+	app.respondAdminToken(userID, jfID, gc)
+}
+
+// decodeValidateRefreshCookie validates the refresh token in the given cookie and its session, updating the session's last refresh time.
+func (app *appContext) decodeValidateRefreshCookie(gc *gin.Context, cookieName string) (claims jwt.MapClaims, session Session, ok bool) {
 	ok = false
 	cookie, err := gc.Cookie(cookieName)
 	if err != nil || cookie == "" {
@@ -265,13 +373,6 @@ func (app *appContext) decodeValidateRefreshCookie(gc *gin.Context, cookieName s
 		respond(400, "Couldn't get token", gc)
 		return
 	}
-	for _, token := range app.invalidTokens {
-		if cookie == token {
-			app.debug.Println("getTokenRefresh: Invalid token")
-			respond(401, "Invalid token", gc)
-			return
-		}
-	}
 	token, err := jwt.Parse(cookie, checkToken)
 	if err != nil {
 		app.debug.Println("getTokenRefresh: Invalid token")
@@ -293,6 +394,13 @@ func (app *appContext) decodeValidateRefreshCookie(gc *gin.Context, cookieName s
 		ok = false
 		return
 	}
+	if session, ok = app.getSessionFromClaims(claims); !ok {
+		app.debug.Println("getTokenRefresh: Session revoked or expired")
+		respond(401, "Invalid token", gc)
+		return
+	}
+	app.touchSession(gc, &session)
+	app.storage.SetSessionsKey(session.ID, session)
 	ok = true
 	return
 }
@@ -305,13 +413,13 @@ func (app *appContext) decodeValidateRefreshCookie(gc *gin.Context, cookieName s
 // @tags Auth
 func (app *appContext) getTokenRefresh(gc *gin.Context) {
 	app.logIpInfo(gc, false, "Token requested (refresh token)")
-	claims, ok := app.decodeValidateRefreshCookie(gc, "refresh")
+	claims, session, ok := app.decodeValidateRefreshCookie(gc, "refresh")
 	if !ok {
 		return
 	}
 	userID := claims["id"].(string)
 	jfID := claims["jfid"].(string)
-	jwt, refresh, err := CreateToken(userID, jfID, true)
+	jwt, refresh, err := CreateToken(userID, jfID, true, session.ID)
 	if err != nil {
 		app.err.Printf("getTokenRefresh failed: Couldn't generate token (%s)", err)
 		respond(500, "Couldn't generate token", gc)
@@ -319,5 +427,5 @@ func (app *appContext) getTokenRefresh(gc *gin.Context) {
 	}
 	host := gc.Request.URL.Hostname()
 	gc.SetCookie("refresh", refresh, REFRESH_TOKEN_VALIDITY_SEC, "/", host, true, true)
-	gc.JSON(200, getTokenDTO{jwt})
+	gc.JSON(200, getTokenDTO{Token: jwt})
 }
@@ -138,7 +138,7 @@ func (app *appContext) loadPendingBackup() {
 	if LOADBAK == "" {
 		return
 	}
-	oldPath := filepath.Join(app.dataPath, "db-"+string(time.Now().Unix())+"-pre-"+filepath.Base(LOADBAK))
+	oldPath := filepath.Join(app.dataPath, "db-"+fmt.Sprint(time.Now().Unix())+"-pre-"+filepath.Base(LOADBAK))
 	app.info.Printf("Moving existing database to \"%s\"\n", oldPath)
 	err := os.Rename(app.storage.db_path, oldPath)
 	if err != nil {
@@ -173,7 +173,11 @@ func newBackupDaemon(app *appContext) *housekeepingDaemon {
 	daemon.jobs = []func(app *appContext){
 		func(app *appContext) {
 			app.debug.Println("Backups: Creating backup")
-			app.makeBackup()
+			if backup := app.makeBackup(); backup.Name == "" {
+				app.notifyAdmins(EventBackupFailed, func() (*Message, error) {
+					return app.email.constructAdminNotification(EventBackupFailed, tmpl{}, app)
+				})
+			}
 		},
 	}
 	return &daemon
@@ -21,3 +21,35 @@ func NewTimeoutHandler(name, addr string, noFail bool) TimeoutHandler {
 		}
 	}
 }
+
+// RequestManagerUser is a user account on a request manager (e.g. Ombi).
+type RequestManagerUser struct {
+	ID    string
+	Name  string
+	Email string
+	// Password is only used when modifying a user, and is left unchanged if blank.
+	Password string
+	// Source is the media server the account was imported from ("jellyfin"/"emby"), or blank if it's a local account.
+	Source string
+	// Raw is the backend's own representation of the user, which is sent back when modifying.
+	Raw map[string]interface{}
+}
+
+// RequestManager is implemented by request manager clients (e.g. Ombi), so jfa-go can create, modify & delete accounts on them alongside Jellyfin ones.
+// Templates are opaque to jfa-go, and only need to be understood by the backend that produced them.
+type RequestManager interface {
+	// Name returns the name of the backend, used as the key for storing templates.
+	Name() string
+	GetUsers() ([]RequestManagerUser, int, error)
+	UserByID(id string) (RequestManagerUser, int, error)
+	// NewUser creates a new user from the given template, returning a list of errors reported by the backend.
+	NewUser(username, password, email string, template map[string]interface{}) ([]string, int, error)
+	ModifyUser(user RequestManagerUser) (int, error)
+	DeleteUser(id string) (int, error)
+	// TemplateByID returns a template based on the settings of the user with the given ID.
+	TemplateByID(id string) (map[string]interface{}, int, error)
+	// ApplyTemplate applies the settings in the given template to an existing user.
+	ApplyTemplate(user RequestManagerUser, template map[string]interface{}) (int, error)
+	// SetNotificationPrefs links the given Discord ID and Telegram username to the user, if supported.
+	SetNotificationPrefs(user RequestManagerUser, discordID, telegramUser string) (string, int, error)
+}
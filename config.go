@@ -17,6 +17,9 @@ var messagesEnabled = false
 var telegramEnabled = false
 var discordEnabled = false
 var matrixEnabled = false
+var signalEnabled = false
+var smsEnabled = false
+var pushEnabled = false
 
 func (app *appContext) GetPath(sect, key string) (fs.FS, string) {
 	val := app.config.Section(sect).Key(key).MustString("")
@@ -112,6 +115,10 @@ func (app *appContext) loadConfig() error {
 
 	app.MustSetValue("telegram", "show_on_reg", "true")
 
+	app.MustSetValue("signal", "show_on_reg", "true")
+
+	app.MustSetValue("sms", "show_on_reg", "true")
+
 	app.MustSetValue("backups", "every_n_minutes", "1440")
 	app.MustSetValue("backups", "path", filepath.Join(app.dataPath, "backups"))
 	app.MustSetValue("backups", "keep_n_backups", "20")
@@ -147,17 +154,23 @@ func (app *appContext) loadConfig() error {
 	telegramEnabled = app.config.Section("telegram").Key("enabled").MustBool(false)
 	discordEnabled = app.config.Section("discord").Key("enabled").MustBool(false)
 	matrixEnabled = app.config.Section("matrix").Key("enabled").MustBool(false)
+	signalEnabled = app.config.Section("signal").Key("enabled").MustBool(false)
+	smsEnabled = app.config.Section("sms").Key("enabled").MustBool(false)
+	pushEnabled = app.config.Section("push").Key("enabled").MustBool(false)
 	if !messagesEnabled {
 		emailEnabled = false
 		telegramEnabled = false
 		discordEnabled = false
 		matrixEnabled = false
+		signalEnabled = false
+		smsEnabled = false
+		pushEnabled = false
 	} else if app.config.Section("email").Key("method").MustString("") == "" {
 		emailEnabled = false
 	} else {
 		emailEnabled = true
 	}
-	if !emailEnabled && !telegramEnabled && !discordEnabled && !matrixEnabled {
+	if !emailEnabled && !telegramEnabled && !discordEnabled && !matrixEnabled && !signalEnabled && !smsEnabled && !pushEnabled {
 		messagesEnabled = false
 	}
 
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+var (
+	ErrInvalidPIN    = errors.New("invalid PIN")
+	ErrContactExists = errors.New("account already linked to another user")
+)
+
+// ContactLink is the account a user has linked on a ContactMethod.
+type ContactLink struct {
+	ID      string // ID of the account on the platform, e.g. a Discord user ID or email address.
+	Display string // Name of the account shown to users and admins, e.g. "@username" for Telegram.
+	Contact bool   // Whether the user wants to be contacted through it.
+	Lang    string // Language chosen on the platform, if any.
+	// The user only unsubscribed from bulk messages (e.g. announcements), so others are still sent even though Contact is false.
+	BulkOptOut bool
+}
+
+// ContactMethod is a way of messaging users, with one account linkable per Jellyfin user.
+// Methods are listed in app.contactMethods, so adding one to that list is enough for it to be used by sendByID,
+// shown on the accounts tab and user page, unlinkable, and cleaned up by the housekeeping daemon.
+type ContactMethod interface {
+	// Name is also the method's config section, and the value of ActivityContactLinked/Unlinked activities.
+	Name() string
+	Enabled() bool
+	// Linked returns the account linked to the given user, if any.
+	Linked(jfID string) (ContactLink, bool)
+	// LinkedUsers returns the Jellyfin IDs of all users with a linked account.
+	LinkedUsers() []string
+	// SetContact sets whether to contact the user through this method, returning false if they have no linked account.
+	SetContact(jfID string, contact bool) bool
+	Unlink(jfID string)
+	// Exists returns whether an account is linked to any user, for the method's require_unique setting.
+	// account is how the method identifies accounts when linking, e.g. an address or username.
+	Exists(account string) bool
+	// FindUser returns the Jellyfin ID of a user with the given account linked, where account is as a user would enter it,
+	// e.g. an email address or Telegram username. Used to find users by any of their accounts, e.g. for password resets.
+	FindUser(account string) (jfID string, ok bool)
+	// Send sends a message to the user's linked account, regardless of whether they want to be contacted through it.
+	Send(msg *Message, jfID string) error
+}
+
+// PINLinker is implemented by contact methods linked by verifying a PIN exchanged with the user's account.
+type PINLinker interface {
+	// LinkPIN links the account verified with the given PIN to the user. account is the account the user gave, where the method needs it.
+	// Returns ErrInvalidPIN if the PIN wasn't verified, or ErrContactExists if require_unique is set and the account is already linked.
+	LinkPIN(pin, jfID, account string) error
+}
+
+// InviteLinker is implemented by contact methods which can be verified on the invite form, before the user's account exists.
+type InviteLinker interface {
+	// VerifyInvitePIN checks the PIN given on the invite form, returning a function which links the verified account once the user's created.
+	// Returns ErrInvalidPIN if the PIN wasn't verified, or ErrContactExists if require_unique is set and the account is already linked.
+	VerifyInvitePIN(pin string) (link func(jfID string, contact bool), err error)
+	// RequiredErrorKey is the form lang key shown if the method is required but wasn't verified.
+	RequiredErrorKey() string
+}
+
+// loadContactMethods sets up the list of contact methods, in the order they're tried.
+func (app *appContext) loadContactMethods() {
+	app.contactMethods = []ContactMethod{
+		discordContactMethod{app},
+		telegramContactMethod{app},
+		emailContactMethod{app},
+		matrixContactMethod{app},
+		signalContactMethod{app},
+		smsContactMethod{app},
+		pushContactMethod{app},
+	}
+}
+
+// contactMethod returns the contact method with the given name.
+func (app *appContext) contactMethod(name string) (ContactMethod, bool) {
+	for _, cm := range app.contactMethods {
+		if cm.Name() == name {
+			return cm, true
+		}
+	}
+	return nil, false
+}
+
+// requireUnique returns whether the same account can't be linked to more than one user.
+func (app *appContext) requireUnique(cm ContactMethod) bool {
+	return app.config.Section(cm.Name()).Key("require_unique").MustBool(false)
+}
+
+// linkContactByPIN links an account verified with a PIN to a user, and records it in the activity log.
+func (app *appContext) linkContactByPIN(gc *gin.Context, cm ContactMethod, pin, jfID, account string, sourceType ActivitySource) error {
+	linker, ok := cm.(PINLinker)
+	if !ok || !cm.Enabled() {
+		return ErrInvalidPIN
+	}
+	if err := linker.LinkPIN(pin, jfID, account); err != nil {
+		return err
+	}
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityContactLinked,
+		UserID:     jfID,
+		SourceType: sourceType,
+		Source:     gc.GetString("jfId"),
+		Value:      cm.Name(),
+		Time:       time.Now(),
+	}, gc, sourceType == ActivityUser)
+	return nil
+}
+
+// unlinkContact unlinks the user's account on the given method, and records it in the activity log.
+func (app *appContext) unlinkContact(gc *gin.Context, cm ContactMethod, jfID string, sourceType ActivitySource) {
+	cm.Unlink(jfID)
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityContactUnlinked,
+		UserID:     jfID,
+		SourceType: sourceType,
+		Source:     gc.GetString("jfId"),
+		Value:      cm.Name(),
+		Time:       time.Now(),
+	}, gc, sourceType == ActivityUser)
+}
+
+// clearContactLinks unlinks accounts from users which no longer exist.
+// meant to be called with other such housekeeping functions, so assumes
+// the user cache is fresh.
+func (app *appContext) clearContactLinks(cm ContactMethod) {
+	app.debug.Printf("Housekeeping: removing unused %s links", cm.Name())
+	for _, jfID := range cm.LinkedUsers() {
+		_, _, err := app.jf.UserByID(jfID, false)
+		// Make sure the user doesn't exist, and no other error has occured
+		switch err.(type) {
+		case mediabrowser.ErrUserNotFound:
+			cm.Unlink(jfID)
+		default:
+			continue
+		}
+	}
+}
+
+type emailContactMethod struct {
+	app *appContext
+}
+
+func (cm emailContactMethod) Name() string  { return "email" }
+func (cm emailContactMethod) Enabled() bool { return emailEnabled }
+
+func (cm emailContactMethod) Linked(jfID string) (ContactLink, bool) {
+	email, ok := cm.app.storage.GetEmailsKey(jfID)
+	if !ok || email.Addr == "" {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: email.Addr, Display: email.Addr, Contact: email.Contact, Lang: email.Lang, BulkOptOut: email.Unsubscribed}, true
+}
+
+func (cm emailContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, email := range cm.app.storage.GetEmails() {
+		ids = append(ids, email.JellyfinID)
+	}
+	return ids
+}
+
+func (cm emailContactMethod) SetContact(jfID string, contact bool) bool {
+	email, ok := cm.app.storage.GetEmailsKey(jfID)
+	if !ok {
+		return false
+	}
+	email.Contact = contact
+	// An explicit choice overrides an earlier unsubscribe.
+	email.Unsubscribed = false
+	cm.app.storage.SetEmailsKey(jfID, email)
+	return true
+}
+
+// Unlink removes the address. The rest of the record (label, admin status, referrals) is kept if set.
+func (cm emailContactMethod) Unlink(jfID string) {
+	email, ok := cm.app.storage.GetEmailsKey(jfID)
+	if !ok {
+		return
+	}
+	if email.Label == "" && !email.Admin && email.ReferralTemplateKey == "" {
+		cm.app.storage.DeleteEmailsKey(jfID)
+		return
+	}
+	email.Addr = ""
+	email.Contact = false
+	cm.app.storage.SetEmailsKey(jfID, email)
+}
+
+func (cm emailContactMethod) Exists(account string) bool { return cm.app.EmailAddressExists(account) }
+
+func (cm emailContactMethod) FindUser(account string) (string, bool) {
+	emails := []EmailAddress{}
+	if err := cm.app.storage.db.Find(&emails, badgerhold.Where("Addr").Eq(account).Limit(1)); err != nil || len(emails) == 0 {
+		return "", false
+	}
+	return emails[0].JellyfinID, true
+}
+
+func (cm emailContactMethod) Send(msg *Message, jfID string) error {
+	email, ok := cm.app.storage.GetEmailsKey(jfID)
+	if !ok || email.Addr == "" {
+		return nil
+	}
+	if msg.Bulk {
+		msg = cm.app.withUnsubscribeHeaders(msg, jfID)
+	}
+	return cm.app.email.send(msg, email.Addr)
+}
+
+type discordContactMethod struct {
+	app *appContext
+}
+
+func (cm discordContactMethod) Name() string  { return "discord" }
+func (cm discordContactMethod) Enabled() bool { return discordEnabled }
+
+func (cm discordContactMethod) Linked(jfID string) (ContactLink, bool) {
+	dcUser, ok := cm.app.storage.GetDiscordKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: dcUser.ID, Display: RenderDiscordUsername(dcUser), Contact: dcUser.Contact, Lang: dcUser.Lang}, true
+}
+
+func (cm discordContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, dcUser := range cm.app.storage.GetDiscord() {
+		ids = append(ids, dcUser.JellyfinID)
+	}
+	return ids
+}
+
+func (cm discordContactMethod) SetContact(jfID string, contact bool) bool {
+	dcUser, ok := cm.app.storage.GetDiscordKey(jfID)
+	if !ok {
+		return false
+	}
+	dcUser.Contact = contact
+	cm.app.storage.SetDiscordKey(jfID, dcUser)
+	return true
+}
+
+func (cm discordContactMethod) Unlink(jfID string)         { cm.app.storage.DeleteDiscordKey(jfID) }
+func (cm discordContactMethod) Exists(account string) bool { return cm.app.discord.UserExists(account) }
+
+// FindUser matches the rendered username, so it can't use an index.
+func (cm discordContactMethod) FindUser(account string) (string, bool) {
+	for _, dcUser := range cm.app.storage.GetDiscord() {
+		if RenderDiscordUsername(dcUser) == strings.ToLower(account) {
+			return dcUser.JellyfinID, true
+		}
+	}
+	return "", false
+}
+
+func (cm discordContactMethod) Send(msg *Message, jfID string) error {
+	dcUser, ok := cm.app.storage.GetDiscordKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.discord.Send(msg, dcUser.ChannelID)
+}
+
+// LinkPIN links the Discord user who sent the bot the PIN. account is unused.
+func (cm discordContactMethod) LinkPIN(pin, jfID, account string) error {
+	dcUser, ok := cm.app.discord.AssignedUserVerified(pin, jfID)
+	cm.app.discord.DeleteVerifiedUser(pin)
+	if !ok {
+		return ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(dcUser.ID) {
+		return ErrContactExists
+	}
+	if existingUser, ok := cm.app.storage.GetDiscordKey(jfID); ok {
+		dcUser.Lang = existingUser.Lang
+		dcUser.Contact = existingUser.Contact
+	}
+	cm.app.storage.SetDiscordKey(jfID, dcUser)
+	return nil
+}
+
+// VerifyInvitePIN also gives the Discord user the member role, if set.
+func (cm discordContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	dcUser, ok := cm.app.discord.UserVerified(pin)
+	if !ok {
+		return nil, ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(dcUser.ID) {
+		return nil, ErrContactExists
+	}
+	if err := cm.app.discord.ApplyRole(dcUser.ID); err != nil {
+		return nil, fmt.Errorf("failed to set member role: %v", err)
+	}
+	return func(jfID string, contact bool) {
+		dcUser.Contact = contact
+		if cm.app.storage.deprecatedDiscord == nil {
+			cm.app.storage.deprecatedDiscord = discordStore{}
+		}
+		// Note we don't log an activity here, since it's part of creating a user.
+		cm.app.storage.SetDiscordKey(jfID, dcUser)
+		delete(cm.app.discord.verifiedTokens, pin)
+	}, nil
+}
+
+func (cm discordContactMethod) RequiredErrorKey() string { return "errorDiscordVerification" }
+
+type telegramContactMethod struct {
+	app *appContext
+}
+
+func (cm telegramContactMethod) Name() string  { return "telegram" }
+func (cm telegramContactMethod) Enabled() bool { return telegramEnabled }
+
+func (cm telegramContactMethod) Linked(jfID string) (ContactLink, bool) {
+	tgUser, ok := cm.app.storage.GetTelegramKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: strconv.FormatInt(tgUser.ChatID, 10), Display: "@" + tgUser.Username, Contact: tgUser.Contact, Lang: tgUser.Lang}, true
+}
+
+func (cm telegramContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, tgUser := range cm.app.storage.GetTelegram() {
+		ids = append(ids, tgUser.JellyfinID)
+	}
+	return ids
+}
+
+func (cm telegramContactMethod) SetContact(jfID string, contact bool) bool {
+	tgUser, ok := cm.app.storage.GetTelegramKey(jfID)
+	if !ok {
+		return false
+	}
+	tgUser.Contact = contact
+	cm.app.storage.SetTelegramKey(jfID, tgUser)
+	return true
+}
+
+func (cm telegramContactMethod) Unlink(jfID string) { cm.app.storage.DeleteTelegramKey(jfID) }
+
+func (cm telegramContactMethod) Exists(account string) bool {
+	return cm.app.telegram.UserExists(account)
+}
+
+// FindUser accepts the username with or without the "@".
+func (cm telegramContactMethod) FindUser(account string) (string, bool) {
+	tgUsers := []TelegramUser{}
+	if err := cm.app.storage.db.Find(&tgUsers, badgerhold.Where("Username").Eq(strings.TrimPrefix(account, "@")).Limit(1)); err != nil || len(tgUsers) == 0 {
+		return "", false
+	}
+	return tgUsers[0].JellyfinID, true
+}
+
+func (cm telegramContactMethod) Send(msg *Message, jfID string) error {
+	tgUser, ok := cm.app.storage.GetTelegramKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.telegram.Send(msg, tgUser.ChatID)
+}
+
+// LinkPIN links the Telegram user who sent the bot the PIN. account is unused.
+func (cm telegramContactMethod) LinkPIN(pin, jfID, account string) error {
+	token, ok := cm.app.telegram.AssignedTokenVerified(pin, jfID)
+	cm.app.telegram.DeleteVerifiedToken(pin)
+	if !ok {
+		return ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(token.Username) {
+		return ErrContactExists
+	}
+	tgUser := TelegramUser{
+		ChatID:   token.ChatID,
+		Username: token.Username,
+		Contact:  true,
+	}
+	if lang, ok := cm.app.telegram.languages[tgUser.ChatID]; ok {
+		tgUser.Lang = lang
+	}
+	if existingUser, ok := cm.app.storage.GetTelegramKey(jfID); ok {
+		tgUser.Lang = existingUser.Lang
+		tgUser.Contact = existingUser.Contact
+	}
+	cm.app.storage.SetTelegramKey(jfID, tgUser)
+	return nil
+}
+
+func (cm telegramContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	token, ok := cm.app.telegram.TokenVerified(pin)
+	if !ok {
+		return nil, ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(token.Username) {
+		return nil, ErrContactExists
+	}
+	return func(jfID string, contact bool) {
+		tgUser := TelegramUser{
+			ChatID:   token.ChatID,
+			Username: token.Username,
+			Contact:  contact,
+		}
+		if lang, ok := cm.app.telegram.languages[token.ChatID]; ok {
+			tgUser.Lang = lang
+		}
+		if cm.app.storage.deprecatedTelegram == nil {
+			cm.app.storage.deprecatedTelegram = telegramStore{}
+		}
+		cm.app.telegram.DeleteVerifiedToken(pin)
+		cm.app.storage.SetTelegramKey(jfID, tgUser)
+	}, nil
+}
+
+func (cm telegramContactMethod) RequiredErrorKey() string { return "errorTelegramVerification" }
+
+type matrixContactMethod struct {
+	app *appContext
+}
+
+func (cm matrixContactMethod) Name() string  { return "matrix" }
+func (cm matrixContactMethod) Enabled() bool { return matrixEnabled }
+
+func (cm matrixContactMethod) Linked(jfID string) (ContactLink, bool) {
+	mxUser, ok := cm.app.storage.GetMatrixKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: mxUser.UserID, Display: mxUser.UserID, Contact: mxUser.Contact, Lang: mxUser.Lang}, true
+}
+
+func (cm matrixContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, mxUser := range cm.app.storage.GetMatrix() {
+		ids = append(ids, mxUser.JellyfinID)
+	}
+	return ids
+}
+
+func (cm matrixContactMethod) SetContact(jfID string, contact bool) bool {
+	mxUser, ok := cm.app.storage.GetMatrixKey(jfID)
+	if !ok {
+		return false
+	}
+	mxUser.Contact = contact
+	cm.app.storage.SetMatrixKey(jfID, mxUser)
+	return true
+}
+
+func (cm matrixContactMethod) Unlink(jfID string)         { cm.app.storage.DeleteMatrixKey(jfID) }
+func (cm matrixContactMethod) Exists(account string) bool { return cm.app.matrix.UserExists(account) }
+
+func (cm matrixContactMethod) FindUser(account string) (string, bool) {
+	mxUsers := []MatrixUser{}
+	if err := cm.app.storage.db.Find(&mxUsers, badgerhold.Where("UserID").Eq(account).Limit(1)); err != nil || len(mxUsers) == 0 {
+		return "", false
+	}
+	return mxUsers[0].JellyfinID, true
+}
+
+func (cm matrixContactMethod) Send(msg *Message, jfID string) error {
+	mxUser, ok := cm.app.storage.GetMatrixKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.matrix.Send(msg, mxUser)
+}
+
+// LinkPIN links the Matrix user the PIN was sent to, which must match the given account (a Matrix user ID).
+func (cm matrixContactMethod) LinkPIN(pin, jfID, account string) error {
+	user, ok := cm.app.matrix.tokens[pin]
+	if !ok {
+		cm.app.debug.Println("Matrix: PIN not found")
+		return ErrInvalidPIN
+	}
+	if user.User.UserID != account {
+		cm.app.debug.Println("Matrix: User ID of PIN didn't match")
+		return ErrInvalidPIN
+	}
+	mxUser := *user.User
+	mxUser.Contact = true
+	if existingUser, ok := cm.app.storage.GetMatrixKey(jfID); ok {
+		mxUser.Lang = existingUser.Lang
+		mxUser.Contact = existingUser.Contact
+	}
+	cm.app.storage.SetMatrixKey(jfID, mxUser)
+	delete(cm.app.matrix.tokens, pin)
+	return nil
+}
+
+func (cm matrixContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	user, ok := cm.app.matrix.tokens[pin]
+	if !ok || !user.Verified {
+		return nil, ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(user.User.UserID) {
+		return nil, ErrContactExists
+	}
+	return func(jfID string, contact bool) {
+		mxUser := *user.User
+		mxUser.Contact = contact
+		delete(cm.app.matrix.tokens, pin)
+		if cm.app.storage.deprecatedMatrix == nil {
+			cm.app.storage.deprecatedMatrix = matrixStore{}
+		}
+		cm.app.storage.SetMatrixKey(jfID, mxUser)
+	}, nil
+}
+
+func (cm matrixContactMethod) RequiredErrorKey() string { return "errorMatrixVerification" }
+
+type signalContactMethod struct {
+	app *appContext
+}
+
+func (cm signalContactMethod) Name() string  { return "signal" }
+func (cm signalContactMethod) Enabled() bool { return signalEnabled }
+
+func (cm signalContactMethod) Linked(jfID string) (ContactLink, bool) {
+	sgUser, ok := cm.app.storage.GetSignalKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: sgUser.Number, Display: sgUser.Number, Contact: sgUser.Contact, Lang: sgUser.Lang}, true
+}
+
+func (cm signalContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, sgUser := range cm.app.storage.GetSignal() {
+		ids = append(ids, sgUser.JellyfinID)
+	}
+	return ids
+}
+
+func (cm signalContactMethod) SetContact(jfID string, contact bool) bool {
+	sgUser, ok := cm.app.storage.GetSignalKey(jfID)
+	if !ok {
+		return false
+	}
+	sgUser.Contact = contact
+	cm.app.storage.SetSignalKey(jfID, sgUser)
+	return true
+}
+
+func (cm signalContactMethod) Unlink(jfID string) { cm.app.storage.DeleteSignalKey(jfID) }
+
+func (cm signalContactMethod) Exists(account string) bool {
+	return cm.app.signal.UserExists(account)
+}
+
+func (cm signalContactMethod) FindUser(account string) (string, bool) {
+	sgUsers := []SignalUser{}
+	if err := cm.app.storage.db.Find(&sgUsers, badgerhold.Where("Number").Eq(account).Limit(1)); err != nil || len(sgUsers) == 0 {
+		return "", false
+	}
+	return sgUsers[0].JellyfinID, true
+}
+
+func (cm signalContactMethod) Send(msg *Message, jfID string) error {
+	sgUser, ok := cm.app.storage.GetSignalKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.signal.Send(msg, sgUser.Number)
+}
+
+// LinkPIN links the Signal number which sent the PIN. account is unused.
+func (cm signalContactMethod) LinkPIN(pin, jfID, account string) error {
+	token, ok := cm.app.signal.AssignedTokenVerified(pin, jfID)
+	cm.app.signal.DeleteVerifiedToken(pin)
+	if !ok {
+		return ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(token.Number) {
+		return ErrContactExists
+	}
+	sgUser := SignalUser{
+		Number:  token.Number,
+		Name:    token.Name,
+		Contact: true,
+	}
+	if lang, ok := cm.app.signal.languages[sgUser.Number]; ok {
+		sgUser.Lang = lang
+	}
+	if existingUser, ok := cm.app.storage.GetSignalKey(jfID); ok {
+		sgUser.Lang = existingUser.Lang
+		sgUser.Contact = existingUser.Contact
+	}
+	cm.app.storage.SetSignalKey(jfID, sgUser)
+	return nil
+}
+
+func (cm signalContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	token, ok := cm.app.signal.TokenVerified(pin)
+	if !ok {
+		return nil, ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.Exists(token.Number) {
+		return nil, ErrContactExists
+	}
+	return func(jfID string, contact bool) {
+		sgUser := SignalUser{
+			Number:  token.Number,
+			Name:    token.Name,
+			Contact: contact,
+		}
+		if lang, ok := cm.app.signal.languages[token.Number]; ok {
+			sgUser.Lang = lang
+		}
+		cm.app.signal.DeleteVerifiedToken(pin)
+		cm.app.storage.SetSignalKey(jfID, sgUser)
+	}, nil
+}
+
+func (cm signalContactMethod) RequiredErrorKey() string { return "errorSignalVerification" }
+
+type smsContactMethod struct {
+	app *appContext
+}
+
+func (cm smsContactMethod) Name() string  { return "sms" }
+func (cm smsContactMethod) Enabled() bool { return smsEnabled }
+
+func (cm smsContactMethod) Linked(jfID string) (ContactLink, bool) {
+	number, ok := cm.app.storage.GetSMSKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: number.Number, Display: number.Number, Contact: number.Contact, Lang: number.Lang}, true
+}
+
+func (cm smsContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, number := range cm.app.storage.GetSMS() {
+		ids = append(ids, number.JellyfinID)
+	}
+	return ids
+}
+
+func (cm smsContactMethod) SetContact(jfID string, contact bool) bool {
+	number, ok := cm.app.storage.GetSMSKey(jfID)
+	if !ok {
+		return false
+	}
+	number.Contact = contact
+	cm.app.storage.SetSMSKey(jfID, number)
+	return true
+}
+
+func (cm smsContactMethod) Unlink(jfID string) { cm.app.storage.DeleteSMSKey(jfID) }
+
+func (cm smsContactMethod) Exists(account string) bool {
+	number, _ := normalizeSMSNumber(account)
+	return cm.app.sms.UserExists(number)
+}
+
+func (cm smsContactMethod) FindUser(account string) (string, bool) {
+	number, valid := normalizeSMSNumber(account)
+	if !valid {
+		return "", false
+	}
+	numbers := []SMSNumber{}
+	if err := cm.app.storage.db.Find(&numbers, badgerhold.Where("Number").Eq(number).Limit(1)); err != nil || len(numbers) == 0 {
+		return "", false
+	}
+	return numbers[0].JellyfinID, true
+}
+
+func (cm smsContactMethod) Send(msg *Message, jfID string) error {
+	number, ok := cm.app.storage.GetSMSKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.sms.Send(msg, number.Number)
+}
+
+// LinkPIN links the number the PIN was texted to. account is the number the user gave.
+func (cm smsContactMethod) LinkPIN(pin, jfID, account string) error {
+	account, _ = normalizeSMSNumber(account)
+	if !cm.app.sms.CheckPIN(pin, account) {
+		cm.app.debug.Println("SMS: PIN not found, or number didn't match")
+		return ErrInvalidPIN
+	}
+	cm.app.sms.DeleteToken(pin)
+	if cm.app.requireUnique(cm) && cm.Exists(account) {
+		return ErrContactExists
+	}
+	number := SMSNumber{
+		Number:  account,
+		Contact: true,
+	}
+	if existing, ok := cm.app.storage.GetSMSKey(jfID); ok {
+		number.Lang = existing.Lang
+		number.Contact = existing.Contact
+	}
+	cm.app.storage.SetSMSKey(jfID, number)
+	return nil
+}
+
+func (cm smsContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	number, ok := cm.app.sms.TokenVerified(pin)
+	if !ok {
+		return nil, ErrInvalidPIN
+	}
+	if cm.app.requireUnique(cm) && cm.app.sms.UserExists(number) {
+		return nil, ErrContactExists
+	}
+	return func(jfID string, contact bool) {
+		cm.app.sms.DeleteToken(pin)
+		cm.app.storage.SetSMSKey(jfID, SMSNumber{
+			Number:  number,
+			Contact: contact,
+		})
+	}, nil
+}
+
+func (cm smsContactMethod) RequiredErrorKey() string { return "errorSMSVerification" }
+
+type pushContactMethod struct {
+	app *appContext
+}
+
+func (cm pushContactMethod) Name() string  { return "push" }
+func (cm pushContactMethod) Enabled() bool { return pushEnabled }
+
+func (cm pushContactMethod) Linked(jfID string) (ContactLink, bool) {
+	target, ok := cm.app.storage.GetPushKey(jfID)
+	if !ok {
+		return ContactLink{}, false
+	}
+	return ContactLink{ID: target.Address, Display: target.Display(), Contact: target.Contact, Lang: target.Lang}, true
+}
+
+func (cm pushContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for _, target := range cm.app.storage.GetPush() {
+		ids = append(ids, target.JellyfinID)
+	}
+	return ids
+}
+
+func (cm pushContactMethod) SetContact(jfID string, contact bool) bool {
+	target, ok := cm.app.storage.GetPushKey(jfID)
+	if !ok {
+		return false
+	}
+	target.Contact = contact
+	cm.app.storage.SetPushKey(jfID, target)
+	return true
+}
+
+func (cm pushContactMethod) Unlink(jfID string) { cm.app.storage.DeletePushKey(jfID) }
+
+func (cm pushContactMethod) Exists(account string) bool {
+	return cm.app.push.UserExists(account)
+}
+
+// FindUser matches the target's address ("<service>:<target>"), as Display hides tokens.
+func (cm pushContactMethod) FindUser(account string) (string, bool) {
+	targets := []PushTarget{}
+	if err := cm.app.storage.db.Find(&targets, badgerhold.Where("Address").Eq(account).Limit(1)); err != nil || len(targets) == 0 {
+		return "", false
+	}
+	return targets[0].JellyfinID, true
+}
+
+func (cm pushContactMethod) Send(msg *Message, jfID string) error {
+	target, ok := cm.app.storage.GetPushKey(jfID)
+	if !ok {
+		return nil
+	}
+	return cm.app.push.Send(msg, target)
+}
+
+// LinkPIN links the target the PIN was pushed to. The target is stored with the PIN, so account is unused.
+func (cm pushContactMethod) LinkPIN(pin, jfID, account string) error {
+	target, ok := cm.app.push.AssignedTokenVerified(pin, jfID)
+	if !ok {
+		cm.app.debug.Println("Push: PIN not found, or was for another user")
+		return ErrInvalidPIN
+	}
+	cm.app.push.DeleteToken(pin)
+	if cm.app.requireUnique(cm) && cm.Exists(pushAddress(target.Service, target.Target)) {
+		return ErrContactExists
+	}
+	target.Contact = true
+	if existing, ok := cm.app.storage.GetPushKey(jfID); ok {
+		target.Lang = existing.Lang
+		target.Contact = existing.Contact
+	}
+	cm.app.storage.SetPushKey(jfID, target)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+// fakeContactMethod is an in-memory ContactMethod, linked with PINs from its pins map.
+type fakeContactMethod struct {
+	links map[string]ContactLink // Jellyfin IDs to linked accounts.
+	pins  map[string]string      // Verified PINs to the account they verify.
+}
+
+func newFakeContactMethod() *fakeContactMethod {
+	return &fakeContactMethod{links: map[string]ContactLink{}, pins: map[string]string{}}
+}
+
+func (cm *fakeContactMethod) Name() string  { return "fake" }
+func (cm *fakeContactMethod) Enabled() bool { return true }
+
+func (cm *fakeContactMethod) Linked(jfID string) (ContactLink, bool) {
+	link, ok := cm.links[jfID]
+	return link, ok
+}
+
+func (cm *fakeContactMethod) LinkedUsers() []string {
+	ids := []string{}
+	for id := range cm.links {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (cm *fakeContactMethod) SetContact(jfID string, contact bool) bool {
+	link, ok := cm.links[jfID]
+	if !ok {
+		return false
+	}
+	link.Contact = contact
+	cm.links[jfID] = link
+	return true
+}
+
+func (cm *fakeContactMethod) Unlink(jfID string) { delete(cm.links, jfID) }
+
+func (cm *fakeContactMethod) Exists(account string) bool {
+	for _, link := range cm.links {
+		if link.ID == account {
+			return true
+		}
+	}
+	return false
+}
+
+func (cm *fakeContactMethod) FindUser(account string) (string, bool) {
+	for jfID, link := range cm.links {
+		if link.Display == account {
+			return jfID, true
+		}
+	}
+	return "", false
+}
+
+func (cm *fakeContactMethod) Send(msg *Message, jfID string) error { return nil }
+
+func (cm *fakeContactMethod) LinkPIN(pin, jfID, account string) error {
+	link, err := cm.VerifyInvitePIN(pin)
+	if err != nil {
+		return err
+	}
+	link(jfID, true)
+	return nil
+}
+
+func (cm *fakeContactMethod) VerifyInvitePIN(pin string) (func(jfID string, contact bool), error) {
+	account, ok := cm.pins[pin]
+	if !ok {
+		return nil, ErrInvalidPIN
+	}
+	if cm.Exists(account) {
+		return nil, ErrContactExists
+	}
+	return func(jfID string, contact bool) {
+		delete(cm.pins, pin)
+		cm.links[jfID] = ContactLink{ID: account, Display: "@" + account, Contact: contact}
+	}, nil
+}
+
+func (cm *fakeContactMethod) RequiredErrorKey() string { return "errorFakeVerification" }
+
+func newContactTestApp(t *testing.T, config string) (*appContext, *fakeJellyfin, *fakeContactMethod) {
+	app, jf := newTestApp(t, config)
+	cm := newFakeContactMethod()
+	app.contactMethods = append(app.contactMethods, cm)
+	app.email = &Emailer{}
+	app.storage.SetInvitesKey("invite", Invite{Code: "invite"})
+	// mediabrowser expects at least one user to exist.
+	jf.addUser(mediabrowser.User{ID: "adminuser1", Name: "admin"})
+	return app, jf, cm
+}
+
+func TestInviteContactVerification(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, body, errKey string
+	}{
+		{"not given", "", `{}`, ""},
+		{"required", "[fake]\nrequired = true\n", `{}`, "errorFakeVerification"},
+		{"invalid PIN", "", `{"fake_pin": "wrong"}`, "errorInvalidPIN"},
+		{"already linked", "", `{"fake_pin": "taken"}`, "errorAccountLinked"},
+		{"linked", "[fake]\nrequired = true\n", `{"fake_pin": "good", "fake_contact": true}`, ""},
+	} {
+		app, _, cm := newContactTestApp(t, tc.config)
+		cm.pins["good"] = "newaccount"
+		cm.pins["taken"] = "existing"
+		cm.links["someoneelse"] = ContactLink{ID: "existing"}
+		var req newUserDTO
+		if err := json.Unmarshal([]byte(`{"username": "user", "password": "pass", "code": "invite", "fake_pin": ""}`), &req); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.body), &req); err != nil {
+			t.Fatal(err)
+		}
+		gc, w := testContext("POST", "/newUser", nil)
+		f, success := app.newUser(req, false, gc)
+		if tc.errKey != "" {
+			if success {
+				t.Errorf("%s: user created", tc.name)
+				continue
+			}
+			f(gc)
+			if body := w.Body.String(); !strings.Contains(body, tc.errKey) {
+				t.Errorf("%s: expected %s, got %d %s", tc.name, tc.errKey, w.Code, body)
+			}
+			continue
+		}
+		if !success {
+			f(gc)
+			t.Errorf("%s: failed: %d %s", tc.name, w.Code, w.Body.String())
+			continue
+		}
+		user, _, _ := app.jf.UserByName("user", false)
+		link, linked := cm.Linked(user.ID)
+		if tc.body == `{}` {
+			if linked {
+				t.Errorf("%s: linked without a PIN", tc.name)
+			}
+			continue
+		}
+		if !linked || link.ID != "newaccount" || !link.Contact {
+			t.Errorf("%s: expected account linked with contact on, got %+v", tc.name, link)
+		}
+	}
+}
+
+func TestContactMethodDTOs(t *testing.T) {
+	app, jf, cm := newContactTestApp(t, "")
+	jf.addUser(mediabrowser.User{ID: "someuser1", Name: "someone"})
+	cm.links["someuser1"] = ContactLink{ID: "account", Display: "@account", Contact: true}
+
+	gc, w := testContext("POST", "/users/contact", []byte(`{"id": "someuser1", "fake": false}`))
+	var req SetContactMethodsDTO
+	gc.BindJSON(&req)
+	app.setContactMethods(req, gc)
+	if w.Code != 200 || cm.links["someuser1"].Contact {
+		t.Errorf("contact not turned off: %d %+v", w.Code, cm.links["someuser1"])
+	}
+
+	gc, w = testContext("GET", "/users", nil)
+	app.GetUsers(gc)
+	var users struct {
+		Users []map[string]interface{} `json:"users"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &users)
+	var user map[string]interface{}
+	for _, u := range users.Users {
+		if u["id"] == "someuser1" {
+			user = u
+		}
+	}
+	if user == nil {
+		t.Fatalf("user missing: %s", w.Body.String())
+	}
+	if user["fake"] != "@account" || user["fake_id"] != "account" || user["notify_fake"] != false {
+		t.Errorf("fake method missing from user: %v", user)
+	}
+	// Unlinked methods are still included, blank.
+	if v, ok := user["discord"]; !ok || v != "" {
+		t.Errorf("expected blank discord field, got %v", user)
+	}
+
+	data, err := json.Marshal(MyDetailsDTO{Id: "someuser1", ContactMethods: map[string]*MyDetailsContactMethodsDTO{"fake": {Value: "@account"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details map[string]interface{}
+	json.Unmarshal(data, &details)
+	if fake, ok := details["fake"].(map[string]interface{}); !ok || fake["value"] != "@account" || details["id"] != "someuser1" {
+		t.Errorf("unexpected details %s", data)
+	}
+}
+
+func TestGetAddressOrName(t *testing.T) {
+	app, _, cm := newContactTestApp(t, "")
+	telegramEnabled = true
+	defer func() { telegramEnabled = false }()
+	app.storage.SetTelegramKey("tguser", TelegramUser{Username: "tg", Contact: true})
+	// The email address is shown even if the user doesn't want to be emailed.
+	app.storage.SetEmailsKey("emailuser", EmailAddress{Addr: "user@example.com"})
+	cm.links["fakeuser"] = ContactLink{Display: "@fake", Contact: true}
+	for id, expected := range map[string]string{"tguser": "@tg", "emailuser": "user@example.com", "fakeuser": "@fake", "nobody": ""} {
+		if name := app.getAddressOrName(id); name != expected {
+			t.Errorf("%s: expected %q, got %q", id, expected, name)
+		}
+	}
+}
+
+func TestReverseUserSearch(t *testing.T) {
+	app, jf, cm := newContactTestApp(t, "")
+	for _, id := range []string{"tguser123", "emailuser", "fakeuser1"} {
+		jf.addUser(mediabrowser.User{ID: id, Name: id})
+	}
+	app.storage.SetTelegramKey("tguser123", TelegramUser{Username: "tg"})
+	app.storage.SetEmailsKey("emailuser", EmailAddress{Addr: "user@example.com"})
+	cm.links["fakeuser1"] = ContactLink{ID: "fake", Display: "@fake"}
+	for _, tc := range []struct {
+		address              string
+		email, contactMethod bool
+		expected             string
+	}{
+		{"@tg", false, true, "tguser123"},
+		{"tg", false, true, "tguser123"},
+		{"@fake", false, true, "fakeuser1"},
+		{"user@example.com", true, false, "emailuser"},
+		// Each kind of account has to be allowed.
+		{"user@example.com", false, true, ""},
+		{"@tg", true, false, ""},
+		{"emailuser", false, false, ""},
+	} {
+		user, ok := app.ReverseUserSearch(tc.address, false, tc.email, tc.contactMethod)
+		if (tc.expected == "" && ok) || (tc.expected != "" && (!ok || user.ID != tc.expected)) {
+			t.Errorf("%q: expected %q, got %q (%t)", tc.address, tc.expected, user.ID, ok)
+		}
+	}
+	if user, ok := app.ReverseUserSearch("emailuser", true, false, false); !ok || user.ID != "emailuser" {
+		t.Errorf("username not matched: %+v", user)
+	}
+}
@@ -4,76 +4,9 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
-	"github.com/hrfee/mediabrowser"
 	"github.com/timshannon/badgerhold/v4"
 )
 
-// clearEmails removes stored emails for users which no longer exist.
-// meant to be called with other such housekeeping functions, so assumes
-// the user cache is fresh.
-func (app *appContext) clearEmails() {
-	app.debug.Println("Housekeeping: removing unused email addresses")
-	emails := app.storage.GetEmails()
-	for _, email := range emails {
-		_, _, err := app.jf.UserByID(email.JellyfinID, false)
-		// Make sure the user doesn't exist, and no other error has occured
-		switch err.(type) {
-		case mediabrowser.ErrUserNotFound:
-			app.storage.DeleteEmailsKey(email.JellyfinID)
-		default:
-			continue
-		}
-	}
-}
-
-// clearDiscord does the same as clearEmails, but for Discord Users.
-func (app *appContext) clearDiscord() {
-	app.debug.Println("Housekeeping: removing unused Discord IDs")
-	discordUsers := app.storage.GetDiscord()
-	for _, discordUser := range discordUsers {
-		_, _, err := app.jf.UserByID(discordUser.JellyfinID, false)
-		// Make sure the user doesn't exist, and no other error has occured
-		switch err.(type) {
-		case mediabrowser.ErrUserNotFound:
-			app.storage.DeleteDiscordKey(discordUser.JellyfinID)
-		default:
-			continue
-		}
-	}
-}
-
-// clearMatrix does the same as clearEmails, but for Matrix Users.
-func (app *appContext) clearMatrix() {
-	app.debug.Println("Housekeeping: removing unused Matrix IDs")
-	matrixUsers := app.storage.GetMatrix()
-	for _, matrixUser := range matrixUsers {
-		_, _, err := app.jf.UserByID(matrixUser.JellyfinID, false)
-		// Make sure the user doesn't exist, and no other error has occured
-		switch err.(type) {
-		case mediabrowser.ErrUserNotFound:
-			app.storage.DeleteMatrixKey(matrixUser.JellyfinID)
-		default:
-			continue
-		}
-	}
-}
-
-// clearTelegram does the same as clearEmails, but for Telegram Users.
-func (app *appContext) clearTelegram() {
-	app.debug.Println("Housekeeping: removing unused Telegram IDs")
-	telegramUsers := app.storage.GetTelegram()
-	for _, telegramUser := range telegramUsers {
-		_, _, err := app.jf.UserByID(telegramUser.JellyfinID, false)
-		// Make sure the user doesn't exist, and no other error has occured
-		switch err.(type) {
-		case mediabrowser.ErrUserNotFound:
-			app.storage.DeleteTelegramKey(telegramUser.JellyfinID)
-		default:
-			continue
-		}
-	}
-}
-
 func (app *appContext) clearPWRCaptchas() {
 	app.debug.Println("Housekeeping: Clearing old PWR Captchas")
 	captchas := map[string]Captcha{}
@@ -141,32 +74,38 @@ func newInviteDaemon(interval time.Duration, app *appContext) *housekeepingDaemo
 			app.checkInvites()
 		},
 		func(app *appContext) { app.clearActivities() },
+		func(app *appContext) { app.clearSessions() },
+		func(app *appContext) { app.clearMessageFailures() },
 	}
 
-	clearEmail := app.config.Section("email").Key("require_unique").MustBool(false)
-	clearDiscord := app.config.Section("discord").Key("require_unique").MustBool(false)
-	clearTelegram := app.config.Section("telegram").Key("require_unique").MustBool(false)
-	clearMatrix := app.config.Section("matrix").Key("require_unique").MustBool(false)
 	clearPWR := app.config.Section("captcha").Key("enabled").MustBool(false) && !app.config.Section("captcha").Key("recaptcha").MustBool(false)
 
-	if clearEmail || clearDiscord || clearTelegram || clearMatrix {
+	// Links are only cleared where require_unique is set, so accounts of deleted users can be linked again.
+	var clearLinks []func(app *appContext)
+	for _, cm := range app.contactMethods {
+		if app.requireUnique(cm) {
+			cm := cm
+			clearLinks = append(clearLinks, func(app *appContext) { app.clearContactLinks(cm) })
+		}
+	}
+	if len(clearLinks) != 0 {
 		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.jf.CacheExpiry = time.Now() })
+		daemon.jobs = append(daemon.jobs, clearLinks...)
 	}
-
-	if clearEmail {
-		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearEmails() })
+	if clearPWR {
+		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearPWRCaptchas() })
 	}
-	if clearDiscord {
-		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearDiscord() })
+	if app.payments != nil {
+		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearPaymentEvents() })
 	}
-	if clearTelegram {
-		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearTelegram() })
+	if app.streamLimiter != nil {
+		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.streamLimiter.check() })
 	}
-	if clearMatrix {
-		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearMatrix() })
+	if messagesEnabled && app.config.Section("admin_digest").Key("enabled").MustBool(false) {
+		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.checkAdminDigest() })
 	}
-	if clearPWR {
-		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.clearPWRCaptchas() })
+	if app.watchTime != nil {
+		daemon.jobs = append(daemon.jobs, func(app *appContext) { app.watchTime.collect() }, func(app *appContext) { app.clearWatchTime() })
 	}
 
 	return &daemon
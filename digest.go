@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Failed message deliveries are kept for this long, for the admin digest.
+const MESSAGE_FAILURE_RETENTION_DAYS = 30
+
+// AdminDigestStatus stores when the admin digest was last sent, so restarts don't send it again.
+type AdminDigestStatus struct {
+	Sent time.Time
+}
+
+// adminDigest is a summary of what happened since the last digest, and what's coming up.
+type adminDigest struct {
+	Since           time.Time
+	Created         []string // Usernames of new users.
+	Deleted         int
+	Disabled        int
+	ExpiringUsers   []adminDigestItem
+	ExpiringInvites []adminDigestItem
+	FailedMessages  int
+	BackupsEnabled  bool
+	LastBackup      time.Time // Zero if there are no backups.
+	BackupOverdue   bool
+}
+
+type adminDigestItem struct {
+	Name string
+	Time time.Time
+}
+
+// adminDigestPeriod returns how often the digest is sent.
+func (app *appContext) adminDigestPeriod() time.Duration {
+	if app.config.Section("admin_digest").Key("frequency").MustString("daily") == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// checkAdminDigest sends the digest if it's due, at or after admin_digest/hour. Run by the housekeeping daemon.
+func (app *appContext) checkAdminDigest() {
+	section := app.config.Section("admin_digest")
+	now := time.Now()
+	if now.Hour() < section.Key("hour").MustInt(9) {
+		return
+	}
+	status := AdminDigestStatus{}
+	app.storage.db.Get("admin_digest", &status)
+	period := app.adminDigestPeriod()
+	// Compared by day, so the digest doesn't drift later each time.
+	if !status.Sent.IsZero() && watchTimeDay(now).Sub(watchTimeDay(status.Sent)) < period {
+		return
+	}
+	since := status.Sent
+	if since.IsZero() {
+		since = now.Add(-period)
+	}
+	// Marked as sent first, so a failed delivery isn't retried every minute.
+	app.storage.db.Upsert("admin_digest", AdminDigestStatus{Sent: now})
+
+	app.debug.Println("Admin digest: Sending")
+	msg, err := app.email.constructAdminDigest(app.buildAdminDigest(since, now), app)
+	if err != nil {
+		app.err.Printf("Admin digest: Failed to construct: %v", err)
+		return
+	}
+	app.sendToRecipients(msg, section.Key("recipients").String(), "admin digest")
+}
+
+// buildAdminDigest collects activity since the given time from the Activity store, and upcoming expiries.
+func (app *appContext) buildAdminDigest(since, now time.Time) adminDigest {
+	digest := adminDigest{Since: since}
+
+	activities := []Activity{}
+	app.storage.db.Find(&activities, badgerhold.Where("Time").Ge(since).SortBy("Time"))
+	for _, act := range activities {
+		switch act.Type {
+		case ActivityCreation:
+			digest.Created = append(digest.Created, act.Value)
+		case ActivityDeletion:
+			digest.Deleted++
+		case ActivityDisabled:
+			digest.Disabled++
+		}
+	}
+
+	expiringDays := app.config.Section("admin_digest").Key("expiring_days").MustInt(7)
+	until := now.AddDate(0, 0, expiringDays)
+	names := map[string]string{}
+	users, status, err := app.jf.GetUsers(false)
+	if err != nil || status != 200 {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+	}
+	for _, user := range users {
+		names[user.ID] = user.Name
+	}
+	for _, expiry := range app.storage.GetUserExpiries() {
+		name, ok := names[expiry.JellyfinID]
+		if !ok || expiry.Expiry.Before(now) || expiry.Expiry.After(until) {
+			continue
+		}
+		digest.ExpiringUsers = append(digest.ExpiringUsers, adminDigestItem{Name: name, Time: expiry.Expiry})
+	}
+	for _, inv := range app.storage.GetInvites() {
+		if inv.IsReferral || inv.ValidTill.Before(now) || inv.ValidTill.After(until) {
+			continue
+		}
+		name := inv.Code
+		if inv.Label != "" {
+			name = inv.Label + " (" + inv.Code + ")"
+		}
+		digest.ExpiringInvites = append(digest.ExpiringInvites, adminDigestItem{Name: name, Time: inv.ValidTill})
+	}
+	sort.Slice(digest.ExpiringUsers, func(i, j int) bool { return digest.ExpiringUsers[i].Time.Before(digest.ExpiringUsers[j].Time) })
+	sort.Slice(digest.ExpiringInvites, func(i, j int) bool { return digest.ExpiringInvites[i].Time.Before(digest.ExpiringInvites[j].Time) })
+
+	failed, _ := app.storage.db.Count(&MessageFailure{}, badgerhold.Where("Time").Ge(since))
+	digest.FailedMessages = int(failed)
+
+	digest.BackupsEnabled = app.config.Section("backups").Key("enabled").MustBool(false)
+	if digest.BackupsEnabled {
+		if backups := app.getBackups(); backups != nil {
+			for _, date := range backups.dates {
+				if date.After(digest.LastBackup) {
+					digest.LastBackup = date
+				}
+			}
+		}
+		interval := time.Duration(app.config.Section("backups").Key("every_n_minutes").MustInt(1440)) * time.Minute
+		digest.BackupOverdue = digest.LastBackup.IsZero() || now.Sub(digest.LastBackup) > 2*interval
+	}
+	return digest
+}
+
+// clearMessageFailures deletes failed deliveries older than MESSAGE_FAILURE_RETENTION_DAYS.
+func (app *appContext) clearMessageFailures() {
+	err := app.storage.db.DeleteMatching(&MessageFailure{}, badgerhold.Where("Time").Lt(time.Now().AddDate(0, 0, -MESSAGE_FAILURE_RETENTION_DAYS)))
+	if err != nil {
+		app.err.Printf("Failed to clear old message failures: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	dg "github.com/bwmarrin/discordgo"
+	"github.com/lithammer/shortuuid/v3"
 	"github.com/timshannon/badgerhold/v4"
 )
 
@@ -23,6 +24,7 @@ type DiscordDaemon struct {
 	roleID                                                     string
 	app                                                        *appContext
 	commandHandlers                                            map[string]func(s *dg.Session, i *dg.InteractionCreate, lang string)
+	actionHandlers                                             map[string]func(s *dg.Session, i *dg.InteractionCreate, lang string) // Map of MessageAction*s to handlers for their buttons.
 	commandIDs                                                 []string
 	commandDescriptions                                        []*dg.ApplicationCommand
 }
@@ -46,12 +48,15 @@ func newDiscordDaemon(app *appContext) (*DiscordDaemon, error) {
 		app:             app,
 		roleID:          app.config.Section("discord").Key("apply_role").String(),
 		commandHandlers: map[string]func(s *dg.Session, i *dg.InteractionCreate, lang string){},
+		actionHandlers:  map[string]func(s *dg.Session, i *dg.InteractionCreate, lang string){},
 		commandIDs:      []string{},
 	}
 	dd.commandHandlers[app.config.Section("discord").Key("start_command").MustString("start")] = dd.cmdStart
 	dd.commandHandlers["lang"] = dd.cmdLang
 	dd.commandHandlers["pin"] = dd.cmdPIN
 	dd.commandHandlers["inv"] = dd.cmdInvite
+	dd.actionHandlers[MessageActionResetPassword] = dd.actionResetPassword
+	dd.actionHandlers[MessageActionExtend] = dd.actionExtend
 	for _, user := range app.storage.GetDiscord() {
 		dd.users[user.ID] = user
 	}
@@ -96,6 +101,8 @@ func (d *DiscordDaemon) run() {
 
 	d.bot.AddHandler(d.commandHandler)
 
+	d.bot.AddHandler(d.actionHandler)
+
 	d.bot.Identify.Intents = dg.IntentsGuildMessages | dg.IntentsDirectMessages | dg.IntentsGuildMembers | dg.IntentsGuildInvites
 	if err := d.bot.Open(); err != nil {
 		d.app.err.Printf("Discord: Failed to start daemon: %v", err)
@@ -451,6 +458,9 @@ func (d *DiscordDaemon) UpdateCommands() {
 }
 
 func (d *DiscordDaemon) commandHandler(s *dg.Session, i *dg.InteractionCreate) {
+	if i.Type != dg.InteractionApplicationCommand {
+		return
+	}
 	if h, ok := d.commandHandlers[i.ApplicationCommandData().Name]; ok {
 		if i.GuildID != "" && d.channelName != "" {
 			if d.channelID == "" {
@@ -471,14 +481,218 @@ func (d *DiscordDaemon) commandHandler(s *dg.Session, i *dg.InteractionCreate) {
 		if i.Interaction.Member.User.ID == s.State.User.ID {
 			return
 		}
-		lang := d.app.storage.lang.chosenTelegramLang
-		if user, ok := d.users[i.Interaction.Member.User.ID]; ok {
-			if _, ok := d.app.storage.lang.Telegram[user.Lang]; ok {
-				lang = user.Lang
+		h(s, i, d.userLang(i.Interaction.Member.User.ID))
+	}
+}
+
+// userLang returns the language the Discord user has set with /lang, or the default.
+func (d *DiscordDaemon) userLang(userID string) string {
+	if user, ok := d.users[userID]; ok {
+		if _, ok := d.app.storage.lang.Telegram[user.Lang]; ok {
+			return user.Lang
+		}
+	}
+	return d.app.storage.lang.chosenTelegramLang
+}
+
+// discordActionPrefix marks the custom IDs of buttons and modals for message actions, followed by the action.
+const discordActionPrefix = "jfa-go:"
+
+// interactionUser returns the user who triggered the interaction. Member is only set in servers, User only in DMs.
+func interactionUser(i *dg.InteractionCreate) *dg.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// actionHandler dispatches clicks on message action buttons, and submissions of the modals they open.
+func (d *DiscordDaemon) actionHandler(s *dg.Session, i *dg.InteractionCreate) {
+	var customID string
+	switch i.Type {
+	case dg.InteractionMessageComponent:
+		customID = i.MessageComponentData().CustomID
+	case dg.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	default:
+		return
+	}
+	action, ok := strings.CutPrefix(customID, discordActionPrefix)
+	if !ok {
+		return
+	}
+	user := interactionUser(i)
+	if user == nil || user.ID == s.State.User.ID {
+		return
+	}
+	if h, ok := d.actionHandlers[action]; ok {
+		h(s, i, d.userLang(user.ID))
+	}
+}
+
+// respondEphemeral replies to an interaction with a message only the user can see.
+func (d *DiscordDaemon) respondEphemeral(s *dg.Session, i *dg.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &dg.InteractionResponse{
+		Type: dg.InteractionResponseChannelMessageWithSource,
+		Data: &dg.InteractionResponseData{
+			Content: content,
+			Flags:   64, // Ephemeral
+		},
+	})
+	if err != nil {
+		d.app.err.Printf("Discord: Failed to send reply: %v", err)
+	}
+}
+
+// linkedJellyfinID returns the ID of the Jellyfin user the Discord user is linked to.
+func (d *DiscordDaemon) linkedJellyfinID(userID string) (string, bool) {
+	var dcUser DiscordUser
+	err := d.app.storage.db.FindOne(&dcUser, badgerhold.Where("ID").Eq(userID))
+	return dcUser.JellyfinID, err == nil && dcUser.JellyfinID != ""
+}
+
+// actionLimited returns whether the Discord user is locked out of the action by the login limiter, replying to say so if they are.
+// Actions are limited per Discord user, as there's no IP to go by.
+func (d *DiscordDaemon) actionLimited(s *dg.Session, i *dg.InteractionCreate, lang, key string) bool {
+	if _, locked := d.app.loginLimiter.Locked(key); !locked {
+		return false
+	}
+	d.app.info.Printf("Discord: Denied action for \"%s\": Locked out", key)
+	d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("tooManyAttempts"))
+	return true
+}
+
+// recordActionAttempt counts an attempt at an action against the key, logging any resulting lockout.
+func (d *DiscordDaemon) recordActionAttempt(key string) {
+	for _, ban := range d.app.loginLimiter.Fail(key) {
+		d.app.info.Printf("Locked out \"%s\" until %s after too many attempts", ban.Key, ban.Until.Format(time.RFC3339))
+		d.app.storage.SetActivityKey(shortuuid.New(), Activity{
+			Type:       ActivityLockout,
+			SourceType: ActivityAnon,
+			Value:      ban.Key,
+			Time:       time.Now(),
+		}, nil, false)
+	}
+}
+
+// action* methods handle message action buttons.
+
+// actionResetPassword replies with a password reset PIN/link for the linked user.
+// Buttons stay on old messages, so whether resets are enabled is checked again here.
+func (d *DiscordDaemon) actionResetPassword(s *dg.Session, i *dg.InteractionCreate, lang string) {
+	if !d.app.config.Section("password_resets").Key("enabled").MustBool(false) {
+		d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("actionUnavailable"))
+		return
+	}
+	key := BAN_RESET_PREFIX + "discord:" + interactionUser(i).ID
+	if d.actionLimited(s, i, lang, key) {
+		return
+	}
+	// Every click counts, like reset requests on the user page.
+	d.recordActionAttempt(key)
+	jfID, ok := d.linkedJellyfinID(interactionUser(i).ID)
+	if !ok {
+		d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("accountNotLinked"))
+		return
+	}
+	pwr, err := d.app.GenInternalReset(jfID)
+	if err != nil {
+		d.app.err.Printf("Discord: Failed to generate password reset for \"%s\": %v", jfID, err)
+		d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("actionFailed"))
+		return
+	}
+	if d.app.internalPWRs == nil {
+		d.app.internalPWRs = map[string]InternalPWR{}
+	}
+	d.app.internalPWRs[pwr.PIN] = pwr
+	msg, err := d.app.emailFor(jfID).constructReset(
+		PasswordReset{
+			Pin:      pwr.PIN,
+			Username: pwr.Username,
+			Expiry:   pwr.Expiry,
+			Internal: true,
+		}, d.app, false,
+	)
+	if err != nil {
+		d.app.err.Printf("Discord: Failed to construct password reset message for \"%s\": %v", pwr.Username, err)
+		d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("actionFailed"))
+		return
+	}
+	embeds, components := d.richMessage(msg)
+	err = s.InteractionRespond(i.Interaction, &dg.InteractionResponse{
+		Type: dg.InteractionResponseChannelMessageWithSource,
+		Data: &dg.InteractionResponseData{
+			Embeds:     embeds,
+			Components: components,
+			Flags:      64, // Ephemeral
+		},
+	})
+	if err != nil {
+		d.app.err.Printf("Discord: Failed to send password reset to \"%s\": %v", pwr.Username, err)
+		return
+	}
+	d.app.info.Printf("Discord: Sent password reset to \"%s\"", pwr.Username)
+}
+
+// actionExtend opens a modal for an extension code, and redeems it once submitted.
+func (d *DiscordDaemon) actionExtend(s *dg.Session, i *dg.InteractionCreate, lang string) {
+	jfID, ok := d.linkedJellyfinID(interactionUser(i).ID)
+	if !ok {
+		d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.get("accountNotLinked"))
+		return
+	}
+	if i.Type == dg.InteractionMessageComponent {
+		err := s.InteractionRespond(i.Interaction, &dg.InteractionResponse{
+			Type: dg.InteractionResponseModal,
+			Data: &dg.InteractionResponseData{
+				CustomID: discordActionPrefix + MessageActionExtend,
+				Title:    d.app.storage.lang.Telegram[lang].Strings.get("extensionCode"),
+				Components: []dg.MessageComponent{
+					dg.ActionsRow{Components: []dg.MessageComponent{
+						dg.TextInput{
+							CustomID:  "code",
+							Label:     d.app.storage.lang.Telegram[lang].Strings.get("extensionCode"),
+							Style:     dg.TextInputShort,
+							Required:  true,
+							MaxLength: 100,
+						},
+					}},
+				},
+			},
+		})
+		if err != nil {
+			d.app.err.Printf("Discord: Failed to open extension code modal: %v", err)
+		}
+		return
+	}
+	code := ""
+	for _, row := range i.ModalSubmitData().Components {
+		if row, ok := row.(*dg.ActionsRow); ok {
+			for _, c := range row.Components {
+				if input, ok := c.(*dg.TextInput); ok && input.CustomID == "code" {
+					code = strings.TrimSpace(input.Value)
+				}
 			}
 		}
-		h(s, i, lang)
 	}
+	key := "discord:" + interactionUser(i).ID
+	if d.actionLimited(s, i, lang, key) {
+		return
+	}
+	_, expiry, _, errKey := d.app.redeemExtensionCode(nil, jfID, code)
+	if errKey == "errorInvalidExtensionCode" {
+		d.app.info.Printf("Discord: Extension code redemption failed for \"%s\": invalid code", jfID)
+		d.recordActionAttempt(key)
+	}
+	if errKey != "" {
+		userLang := lang
+		if _, ok := d.app.storage.lang.User[userLang]; !ok {
+			userLang = d.app.storage.lang.chosenUserLang
+		}
+		d.respondEphemeral(s, i, d.app.storage.lang.User[userLang].Notifications.get(errKey))
+		return
+	}
+	d.respondEphemeral(s, i, d.app.storage.lang.Telegram[lang].Strings.template("extensionCodeRedeemed", tmpl{"date": d.app.formatDatetime(expiry.Expiry)}))
 }
 
 // cmd* methods handle slash-commands, msg* methods handle ! commands.
@@ -836,7 +1050,112 @@ func (d *DiscordDaemon) SendDM(message *Message, userID ...string) error {
 	return d.Send(message, channels...)
 }
 
+// Embed colours by message kind.
+var discordEmbedColours = map[string]int{
+	"UserEnabled":       0x3ba55c,
+	"WelcomeEmail":      0x3ba55c,
+	"ExpiryExtended":    0x3ba55c,
+	"UserCreated":       0x3ba55c,
+	"PasswordReset":     0x5865f2,
+	"EmailConfirmation": 0x5865f2,
+	"InviteEmail":       0x5865f2,
+	"UsernameChanged":   0x5865f2,
+	"Announcement":      0x5865f2,
+	"UserDisabled":      0xfaa61a,
+	"InviteExpiry":      0xfaa61a,
+	"StreamLimit":       0xfaa61a,
+	"UserDeleted":       0xed4245,
+	"UserExpired":       0xed4245,
+}
+
+// Discord's limits on embeds and components.
+const (
+	discordMaxDescription  = 4096
+	discordMaxEmbeds       = 10
+	discordMaxFields       = 25
+	discordMaxButtonsInRow = 5
+	discordMaxRows         = 5
+	discordMaxButtonLabel  = 80
+)
+
+// richMessage builds an embed for the message, coloured by its kind, with its fields, and the first image in it.
+// Links and message actions become buttons.
+func (d *DiscordDaemon) richMessage(message *Message) ([]*dg.MessageEmbed, []dg.MessageComponent) {
+	description := message.Text
+	var linkEmbeds []*dg.MessageEmbed
+	if message.Markdown != "" {
+		description, linkEmbeds = StripAltText(message.Markdown, true)
+	}
+	embed := &dg.MessageEmbed{
+		Title:       message.Subject,
+		Description: discordTruncate(strings.TrimSpace(description), discordMaxDescription),
+		Color:       discordEmbedColours[message.Kind],
+	}
+	for _, field := range message.Fields {
+		if len(embed.Fields) == discordMaxFields {
+			break
+		}
+		embed.Fields = append(embed.Fields, &dg.MessageEmbedField{Name: field.Name, Value: field.Value, Inline: true})
+	}
+	embeds := []*dg.MessageEmbed{embed}
+	var buttons []dg.MessageComponent
+	for _, link := range linkEmbeds {
+		if link.Image != nil {
+			if embed.Image == nil {
+				embed.Image = link.Image
+			} else if len(embeds) < discordMaxEmbeds {
+				embeds = append(embeds, &dg.MessageEmbed{Image: link.Image})
+			}
+			continue
+		}
+		// Link buttons only accept web URLs.
+		if !strings.HasPrefix(link.URL, "https://") && !strings.HasPrefix(link.URL, "http://") {
+			continue
+		}
+		buttons = append(buttons, dg.Button{Label: discordButtonLabel(link.Title, link.URL), Style: dg.LinkButton, URL: link.URL})
+	}
+	for _, action := range message.Actions {
+		buttons = append(buttons, dg.Button{Label: discordButtonLabel(action.Label, action.Action), Style: dg.PrimaryButton, CustomID: discordActionPrefix + action.Action})
+	}
+	var rows []dg.MessageComponent
+	for i := 0; i < len(buttons) && len(rows) < discordMaxRows; i += discordMaxButtonsInRow {
+		end := i + discordMaxButtonsInRow
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+		rows = append(rows, dg.ActionsRow{Components: buttons[i:end]})
+	}
+	return embeds, rows
+}
+
+// discordButtonLabel returns the label, or the fallback if it's empty, cut to fit on a button.
+func discordButtonLabel(label, fallback string) string {
+	if label == "" {
+		label = fallback
+	}
+	return discordTruncate(label, discordMaxButtonLabel)
+}
+
+// discordTruncate cuts the text to at most max characters, as Discord counts them.
+func discordTruncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
 func (d *DiscordDaemon) Send(message *Message, channelID ...string) error {
+	if d.app.config.Section("discord").Key("rich_messages").MustBool(true) {
+		embeds, components := d.richMessage(message)
+		for _, id := range channelID {
+			_, err := d.bot.ChannelMessageSendComplex(id, &dg.MessageSend{Embeds: embeds, Components: components})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	msg := ""
 	var embeds []*dg.MessageEmbed
 	if message.Markdown != "" {
@@ -10,6 +10,7 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
@@ -22,14 +23,16 @@ import (
 	"github.com/hrfee/jfa-go/easyproxy"
 	"github.com/hrfee/mediabrowser"
 	"github.com/itchyny/timefmt-go"
+	"github.com/lithammer/shortuuid/v3"
 	"github.com/mailgun/mailgun-go/v4"
 	"github.com/timshannon/badgerhold/v4"
+	"github.com/toorop/go-dkim"
 	sMail "github.com/xhit/go-simple-mail/v2"
 )
 
 var markdownRenderer = html.NewRenderer(html.RendererOptions{Flags: html.Smartypants})
 
-// EmailClient implements email sending, right now via smtp, mailgun or a dummy client.
+// EmailClient implements email sending, right now via smtp, mailgun, sendgrid, postmark, ses, sendmail or a dummy client.
 type EmailClient interface {
 	Send(fromName, fromAddr string, message *Message, address ...string) error
 }
@@ -47,6 +50,33 @@ type Message struct {
 	HTML     string `json:"html"`
 	Text     string `json:"text"`
 	Markdown string `json:"markdown"`
+	// Bulk marks announcement-class messages, which users can unsubscribe from while still receiving others like password resets.
+	Bulk bool `json:"-"`
+	// Extra email headers, e.g. List-Unsubscribe. Set per-recipient by the email contact method.
+	Headers map[string]string `json:"-"`
+	// Kind is the type of message, e.g. "PasswordReset", for clients that style messages by type, like Discord.
+	Kind string `json:"-"`
+	// Fields are labelled values (e.g. expiry dates) shown separately by clients that support them.
+	Fields []MessageField `json:"-"`
+	// Actions are shown as buttons by clients that support them.
+	Actions []MessageAction `json:"-"`
+}
+
+// MessageField is a labelled value shown alongside a message, e.g. as a Discord embed field.
+type MessageField struct {
+	Name, Value string
+}
+
+// Actions users can take from a message, handled by the Discord bot.
+const (
+	MessageActionResetPassword = "resetPassword"
+	MessageActionExtend        = "extend"
+)
+
+// MessageAction is a button shown with a message which calls back into jfa-go.
+type MessageAction struct {
+	Label  string
+	Action string // One of MessageAction*.
 }
 
 func (emailer *Emailer) formatExpiry(expiry time.Time, tzaware bool, datePattern, timePattern string) (d, t, expiresIn string) {
@@ -77,7 +107,43 @@ func NewEmailer(app *appContext) *Emailer {
 		fromName: app.config.Section("email").Key("from").String(),
 		lang:     app.storage.lang.Email[app.storage.lang.chosenEmailLang],
 	}
-	method := app.config.Section("email").Key("method").String()
+	var proxy *http.Transport = nil
+	if app.proxyEnabled {
+		proxy = app.proxyTransport
+	}
+	// The main method is tried first, then any fallbacks in order.
+	methods := []string{app.config.Section("email").Key("method").String()}
+	for _, method := range strings.Split(app.config.Section("email").Key("fallback_methods").String(), ",") {
+		if method = strings.TrimSpace(method); method != "" && method != methods[0] {
+			methods = append(methods, method)
+		}
+	}
+	failover := &FailoverClient{
+		threshold: app.config.Section("email").Key("circuit_breaker_failures").MustInt(3),
+		cooldown:  time.Duration(app.config.Section("email").Key("circuit_breaker_cooldown").MustInt(5)) * time.Minute,
+		app:       app,
+	}
+	for _, method := range methods {
+		if method == "" {
+			continue
+		}
+		emailer.sender = nil
+		if err := emailer.setSender(app, method, proxy); err != nil {
+			app.err.Printf("Error while initiating %s mailer: %v", method, err)
+		}
+		if emailer.sender != nil {
+			failover.transports = append(failover.transports, &emailTransport{name: method, client: emailer.sender})
+		}
+	}
+	emailer.sender = nil
+	if len(failover.transports) != 0 {
+		emailer.sender = failover
+	}
+	return emailer
+}
+
+// setSender sets up the emailClient for the given method (the value of [email]/method).
+func (emailer *Emailer) setSender(app *appContext, method string, proxy *http.Transport) error {
 	if method == "smtp" {
 		sslTLS := false
 		if app.config.Section("smtp").Key("encryption").String() == "ssl_tls" {
@@ -94,15 +160,30 @@ func NewEmailer(app *appContext) *Emailer {
 		}
 		authType := sMail.AuthType(app.config.Section("smtp").Key("auth_type").MustInt(4))
 		err := emailer.NewSMTP(app.config.Section("smtp").Key("server").String(), app.config.Section("smtp").Key("port").MustInt(465), username, password, sslTLS, app.config.Section("smtp").Key("ssl_cert").MustString(""), app.config.Section("smtp").Key("hello_hostname").String(), app.config.Section("smtp").Key("cert_validation").MustBool(true), authType, proxyConf)
-		if err != nil {
-			app.err.Printf("Error while initiating SMTP mailer: %v", err)
+		if keyPath := app.config.Section("smtp").Key("dkim_private_key").String(); err == nil && keyPath != "" {
+			domain := app.config.Section("smtp").Key("dkim_domain").MustString(emailer.fromAddr[strings.LastIndex(emailer.fromAddr, "@")+1:])
+			if err = emailer.sender.(*SMTP).LoadDKIM(keyPath, domain, app.config.Section("smtp").Key("dkim_selector").MustString("default")); err != nil {
+				err = fmt.Errorf("failed to load DKIM key: %v", err)
+			}
 		}
+		return err
 	} else if method == "mailgun" {
 		emailer.NewMailgun(app.config.Section("mailgun").Key("api_url").String(), app.config.Section("mailgun").Key("api_key").String())
+	} else if method == "sendgrid" {
+		emailer.NewSendGrid(app.config.Section("sendgrid").Key("api_url").MustString("https://api.sendgrid.com"), app.config.Section("sendgrid").Key("api_key").String(), proxy)
+	} else if method == "postmark" {
+		emailer.NewPostmark(app.config.Section("postmark").Key("api_url").MustString("https://api.postmarkapp.com"), app.config.Section("postmark").Key("server_token").String(), app.config.Section("postmark").Key("message_stream").MustString("outbound"), proxy)
+	} else if method == "ses" {
+		section := app.config.Section("ses")
+		return emailer.NewSES(section.Key("region").MustString("us-east-1"), section.Key("access_key_id").String(), section.Key("secret_access_key").String(), section.Key("configuration_set").String(), section.Key("api_url").String(), proxy)
+	} else if method == "sendmail" {
+		emailer.NewSendmail(app.config.Section("sendmail").Key("path").MustString("/usr/sbin/sendmail"), app.config.Section("sendmail").Key("args").MustString("-i"))
 	} else if method == "dummy" {
 		emailer.sender = &DummyClient{}
+	} else {
+		return fmt.Errorf("unknown method \"%s\"", method)
 	}
-	return emailer
+	return nil
 }
 
 // DummyClient just logs the email to the console for debugging purposes. It can be used by settings [email]/method to "dummy".
@@ -116,6 +197,29 @@ func (dc *DummyClient) Send(fromName, fromAddr string, email *Message, address .
 // SMTP supports SSL/TLS and STARTTLS; implements EmailClient.
 type SMTP struct {
 	Client *sMail.SMTPServer
+	dkim   *dkim.SigOptions
+}
+
+// LoadDKIM enables DKIM signing of outgoing mail with the PEM-encoded RSA private key at keyPath.
+func (sm *SMTP) LoadDKIM(keyPath, domain, selector string) error {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	options := dkim.NewSigOptions()
+	options.PrivateKey = key
+	options.Domain = domain
+	options.Selector = selector
+	options.Canonicalization = "relaxed/relaxed"
+	options.Headers = []string{"from", "to", "subject", "date", "message-id", "mime-version", "content-type", "list-unsubscribe", "list-unsubscribe-post"}
+	options.AddSignatureTimestamp = true
+	// Check the key works now, rather than on every send.
+	test := []byte("From: " + domain + "\r\n\r\ntest\r\n")
+	if err := dkim.Sign(&test, options); err != nil {
+		return err
+	}
+	sm.dkim = &options
+	return nil
 }
 
 // NewSMTP returns an SMTP emailClient.
@@ -190,6 +294,12 @@ func (sm *SMTP) Send(fromName, fromAddr string, email *Message, address ...strin
 	if email.HTML != "" {
 		e.AddAlternative(sMail.TextHTML, email.HTML)
 	}
+	for name, value := range email.Headers {
+		e.AddHeader(name, value)
+	}
+	if sm.dkim != nil {
+		e.SetDkim(*sm.dkim)
+	}
 	err = e.Send(cli)
 	return err
 }
@@ -226,6 +336,9 @@ func (mg *Mailgun) Send(fromName, fromAddr string, email *Message, address ...st
 		message.AddRecipientAndVariables(a, map[string]interface{}{"unique_id": a})
 	}
 	message.SetHtml(email.HTML)
+	for name, value := range email.Headers {
+		message.AddHeader(name, value)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 	_, _, err := mg.client.Send(ctx, message)
@@ -359,6 +472,7 @@ func (emailer *Emailer) constructConfirmation(code, username, key string, app *a
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "EmailConfirmation"
 	return email, nil
 }
 
@@ -443,6 +557,7 @@ func (emailer *Emailer) constructInvite(code string, invite Invite, app *appCont
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "InviteEmail"
 	return email, nil
 }
 
@@ -483,6 +598,7 @@ func (emailer *Emailer) constructExpiry(code string, invite Invite, app *appCont
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "InviteExpiry"
 	return email, nil
 }
 
@@ -538,6 +654,7 @@ func (emailer *Emailer) constructCreated(code, username, address string, invite
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "UserCreated"
 	return email, nil
 }
 
@@ -580,7 +697,7 @@ func (emailer *Emailer) resetValues(pwr PasswordReset, app *appContext, noSub bo
 				// Only used in html email.
 				template["pin_code"] = pwr.Pin
 			} else {
-				app.info.Println("Couldn't generate PWR link: %v", err)
+				app.info.Printf("Couldn't generate PWR link: %v", err)
 				template["pin"] = pwr.Pin
 			}
 		} else {
@@ -612,6 +729,11 @@ func (emailer *Emailer) constructReset(pwr PasswordReset, app *appContext, noSub
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "PasswordReset"
+	if !noSub {
+		d, t, _ := emailer.formatExpiry(pwr.Expiry, true, app.datePattern, app.timePattern)
+		email.Fields = []MessageField{{Name: emailer.lang.Strings.get("expires"), Value: d + " " + t}}
+	}
 	return email, nil
 }
 
@@ -654,6 +776,7 @@ func (emailer *Emailer) constructDeleted(reason string, app *appContext, noSub b
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "UserDeleted"
 	return email, nil
 }
 
@@ -696,6 +819,7 @@ func (emailer *Emailer) constructDisabled(reason string, app *appContext, noSub
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "UserDisabled"
 	return email, nil
 }
 
@@ -738,6 +862,10 @@ func (emailer *Emailer) constructEnabled(reason string, app *appContext, noSub b
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "UserEnabled"
+	if app.config.Section("password_resets").Key("enabled").MustBool(false) {
+		email.Actions = []MessageAction{{Label: emailer.lang.Strings.get("resetPassword"), Action: MessageActionResetPassword}}
+	}
 	return email, nil
 }
 
@@ -804,6 +932,10 @@ func (emailer *Emailer) constructWelcome(username string, expiry time.Time, app
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "WelcomeEmail"
+	if !noSub && !expiry.IsZero() {
+		email.Fields = []MessageField{{Name: emailer.lang.Strings.get("expires"), Value: app.formatDatetime(expiry)}}
+	}
 	return email, nil
 }
 
@@ -840,9 +972,207 @@ func (emailer *Emailer) constructUserExpired(app *appContext, noSub bool) (*Mess
 	if err != nil {
 		return nil, err
 	}
+	email.Kind = "UserExpired"
+	if len(app.storage.GetExtensionCodes()) != 0 {
+		email.Actions = []MessageAction{{Label: emailer.lang.Strings.get("redeemExtensionCode"), Action: MessageActionExtend}}
+	}
+	return email, nil
+}
+
+// constructExpiryExtended builds the confirmation sent when a user extends their own expiry with an extension code.
+func (emailer *Emailer) constructExpiryExtended(username string, days int, expiry time.Time, app *appContext) (*Message, error) {
+	d, t, _ := emailer.formatExpiry(expiry, false, app.datePattern, app.timePattern)
+	md := emailer.lang.Strings.template("helloUser", tmpl{"username": username}) + "\n\n"
+	md += emailer.lang.ExpiryExtended.template("yourAccountWasExtended", tmpl{"days": strconv.Itoa(days)}) + " "
+	md += emailer.lang.ExpiryExtended.template("yourAccountWillExpire", tmpl{"date": d, "time": t})
+	email, err := emailer.constructTemplate(emailer.lang.ExpiryExtended.get("title"), md, app)
+	if err != nil {
+		return nil, err
+	}
+	email.Kind = "ExpiryExtended"
+	email.Fields = []MessageField{{Name: emailer.lang.Strings.get("expires"), Value: d + " " + t}}
+	return email, nil
+}
+
+// constructUsernameChanged builds the notice sent when a user's username is changed, by them or an admin.
+func (emailer *Emailer) constructUsernameChanged(oldName, newName string, app *appContext) (*Message, error) {
+	md := emailer.lang.Strings.template("helloUser", tmpl{"username": newName}) + "\n\n"
+	md += emailer.lang.UsernameChanged.template("yourUsernameWasChanged", tmpl{"oldUsername": oldName, "newUsername": newName}) + " "
+	md += emailer.lang.UsernameChanged.get("useItToLogIn")
+	email, err := emailer.constructTemplate(emailer.lang.UsernameChanged.get("title"), md, app)
+	if err != nil {
+		return nil, err
+	}
+	email.Kind = "UsernameChanged"
+	return email, nil
+}
+
+// streamLimitText describes how the user is over their stream/device limit, and what happens next. Also shown on their Jellyfin clients.
+func (emailer *Emailer) streamLimitText(limit string, count, max, minutes int) string {
+	over, next := "tooManyStreams", "newestStreamsStopped"
+	if limit == LIMIT_DEVICES {
+		over, next = "tooManyDevices", "newestDevicesSignedOut"
+	}
+	text := emailer.lang.StreamLimit.template(over, tmpl{"n": strconv.Itoa(count), "max": strconv.Itoa(max)}) + " "
+	text += emailer.lang.StreamLimit.template(next, tmpl{"n": strconv.Itoa(minutes)})
+	return text
+}
+
+// constructStreamLimitWarning builds the warning sent when a user goes over the max streams/devices set on their profile.
+func (emailer *Emailer) constructStreamLimitWarning(username, limit string, count, max, minutes int, app *appContext) (*Message, error) {
+	md := emailer.lang.Strings.template("helloUser", tmpl{"username": username}) + "\n\n"
+	md += emailer.streamLimitText(limit, count, max, minutes)
+	email, err := emailer.constructTemplate(emailer.lang.StreamLimit.get("title"), md, app)
+	if err != nil {
+		return nil, err
+	}
+	email.Kind = "StreamLimit"
 	return email, nil
 }
 
+// constructWatchTimeReport builds the monthly report of who watched the most, listing the top n users.
+func (emailer *Emailer) constructWatchTimeReport(month time.Time, users []watchTimeUserDTO, n int, app *appContext) (*Message, error) {
+	var total int64
+	for _, user := range users {
+		total += user.Seconds
+	}
+	hours := func(seconds int64) string { return strconv.FormatFloat(float64(seconds)/3600, 'f', 1, 64) }
+	md := "## " + emailer.lang.WatchTimeReport.template("heading", tmpl{"month": month.Format("January 2006")}) + "\n\n"
+	if len(users) == 0 {
+		md += emailer.lang.WatchTimeReport.get("noActivity")
+		return emailer.constructTemplate(emailer.lang.WatchTimeReport.get("title"), md, app)
+	}
+	md += emailer.lang.WatchTimeReport.template("total", tmpl{"n": hours(total), "users": strconv.Itoa(len(users))}) + "\n\n"
+	md += emailer.lang.WatchTimeReport.get("topUsers") + "\n\n"
+	if n > 0 && len(users) > n {
+		users = users[:n]
+	}
+	for i, user := range users {
+		name := user.Name
+		if name == "" {
+			name = user.ID
+		}
+		md += strconv.Itoa(i+1) + ". " + emailer.lang.WatchTimeReport.template("userHours", tmpl{"user": name, "n": hours(user.Seconds)}) + "\n"
+	}
+	return emailer.constructTemplate(emailer.lang.WatchTimeReport.get("title"), md, app)
+}
+
+// constructAdminDigest builds the periodic summary sent to admins.
+func (emailer *Emailer) constructAdminDigest(digest adminDigest, app *appContext) (*Message, error) {
+	l := emailer.lang.AdminDigest
+	md := "## " + l.template("heading", tmpl{"date": app.formatDatetime(digest.Since)}) + "\n\n"
+	md += "- " + l.template("newUsers", tmpl{"n": strconv.Itoa(len(digest.Created))})
+	if len(digest.Created) != 0 {
+		md += ": " + strings.Join(digest.Created, ", ")
+	}
+	md += "\n"
+	md += "- " + l.template("deletedUsers", tmpl{"n": strconv.Itoa(digest.Deleted)}) + "\n"
+	md += "- " + l.template("disabledUsers", tmpl{"n": strconv.Itoa(digest.Disabled)}) + "\n"
+	md += "- " + l.template("failedMessages", tmpl{"n": strconv.Itoa(digest.FailedMessages)}) + "\n"
+	if digest.BackupsEnabled {
+		md += "- "
+		if digest.LastBackup.IsZero() {
+			md += l.get("noBackups")
+		} else {
+			md += l.template("lastBackup", tmpl{"date": app.formatDatetime(digest.LastBackup)})
+		}
+		if digest.BackupOverdue {
+			md += " " + l.get("backupOverdue")
+		}
+		md += "\n"
+	}
+	days := strconv.Itoa(app.config.Section("admin_digest").Key("expiring_days").MustInt(7))
+	lists := []struct {
+		items         []adminDigestItem
+		heading, none string
+	}{
+		{digest.ExpiringUsers, "expiringUsers", "noExpiringUsers"},
+		{digest.ExpiringInvites, "expiringInvites", "noExpiringInvites"},
+	}
+	for _, list := range lists {
+		if len(list.items) == 0 {
+			md += "\n" + l.template(list.none, tmpl{"n": days}) + "\n"
+			continue
+		}
+		md += "\n" + l.template(list.heading, tmpl{"n": days}) + "\n\n"
+		for _, item := range list.items {
+			md += "- " + item.Name + ": " + app.formatDatetime(item.Time) + "\n"
+		}
+	}
+	return emailer.constructTemplate(l.get("title"), md, app)
+}
+
+// adminNotificationKeys maps admin events without their own message to their strings in the adminNotifications section.
+var adminNotificationKeys = map[string]string{
+	EventUserDeleted:     "userDeleted",
+	EventUserDisabled:    "userDisabled",
+	EventPasswordReset:   "passwordReset",
+	EventBackupFailed:    "backupFailed",
+	EventUpdateAvailable: "updateAvailable",
+}
+
+// constructAdminNotification builds a short notification for a routed admin event, filling in the given variables.
+func (emailer *Emailer) constructAdminNotification(event string, vars tmpl, app *appContext) (*Message, error) {
+	key := adminNotificationKeys[event]
+	md := emailer.lang.AdminNotifications.template(key, vars)
+	return emailer.constructTemplate(emailer.lang.AdminNotifications.get(key+"Title"), md, app)
+}
+
+// withLang returns a copy of the emailer which renders messages in the given language, or the emailer itself if it isn't available.
+func (emailer *Emailer) withLang(app *appContext, lang string) *Emailer {
+	l, ok := app.storage.lang.Email[lang]
+	if !ok {
+		return emailer
+	}
+	langEmailer := *emailer
+	langEmailer.lang = l
+	return &langEmailer
+}
+
+// userLang returns the language messages to the user should be in: the one chosen on the user page,
+// otherwise the first set on one of their contact methods, otherwise the default.
+func (app *appContext) userLang(jfID string) string {
+	if ul, ok := app.storage.GetUserLanguageKey(jfID); ok {
+		if _, ok := app.storage.lang.Email[ul.Lang]; ok {
+			return ul.Lang
+		}
+	}
+	for _, cm := range app.contactMethods {
+		if !cm.Enabled() {
+			continue
+		}
+		if link, ok := cm.Linked(jfID); ok {
+			if _, ok := app.storage.lang.Email[link.Lang]; ok {
+				return link.Lang
+			}
+		}
+	}
+	return app.storage.lang.chosenEmailLang
+}
+
+// emailFor returns an Emailer which renders messages in the user's language.
+func (app *appContext) emailFor(jfID string) *Emailer {
+	return app.email.withLang(app, app.userLang(jfID))
+}
+
+// messageForUsers returns a function giving the message in a user's language, constructing it at most once per language.
+// For sending the same message to many users.
+func (app *appContext) messageForUsers(construct func(emailer *Emailer) (*Message, error)) func(jfID string) (*Message, error) {
+	msgs := map[string]*Message{}
+	return func(jfID string) (*Message, error) {
+		lang := app.userLang(jfID)
+		if msg, ok := msgs[lang]; ok {
+			return msg, nil
+		}
+		msg, err := construct(app.email.withLang(app, lang))
+		if err != nil {
+			return nil, err
+		}
+		msgs[lang] = msg
+		return msg, nil
+	}
+}
+
 // calls the send method in the underlying emailClient.
 func (emailer *Emailer) send(email *Message, address ...string) error {
 	return emailer.sender.Send(emailer.fromName, emailer.fromAddr, email, address...)
@@ -850,49 +1180,78 @@ func (emailer *Emailer) send(email *Message, address ...string) error {
 
 func (app *appContext) sendByID(email *Message, ID ...string) (err error) {
 	for _, id := range ID {
-		if tgChat, ok := app.storage.GetTelegramKey(id); ok && tgChat.Contact && telegramEnabled {
-			err = app.telegram.Send(email, tgChat.ChatID)
-			// if err != nil {
-			// 	return err
-			// }
-		}
-		if dcChat, ok := app.storage.GetDiscordKey(id); ok && dcChat.Contact && discordEnabled {
-			err = app.discord.Send(email, dcChat.ChannelID)
-			// if err != nil {
-			// 	return err
-			// }
-		}
-		if mxChat, ok := app.storage.GetMatrixKey(id); ok && mxChat.Contact && matrixEnabled {
-			err = app.matrix.Send(email, mxChat)
-			// if err != nil {
-			// 	return err
-			// }
-		}
-		if address, ok := app.storage.GetEmailsKey(id); ok && address.Contact && emailEnabled {
-			err = app.email.send(email, address.Addr)
-			// if err != nil {
-			// 	return err
-			// }
-		}
-		// if err != nil {
-		// 	return err
-		// }
+		for _, cm := range app.contactMethods {
+			if !cm.Enabled() {
+				continue
+			}
+			if link, ok := cm.Linked(id); ok && (link.Contact || (link.BulkOptOut && !email.Bulk)) {
+				err = cm.Send(email, id)
+				app.recordMessageFailure(cm.Name(), id, err)
+			}
+		}
 	}
 	return
 }
 
-func (app *appContext) getAddressOrName(jfID string) string {
-	if dcChat, ok := app.storage.GetDiscordKey(jfID); ok && dcChat.Contact && discordEnabled {
-		return RenderDiscordUsername(dcChat)
-	}
-	if tgChat, ok := app.storage.GetTelegramKey(jfID); ok && tgChat.Contact && telegramEnabled {
-		return "@" + tgChat.Username
+// sendEmail sends to an email address not necessarily belonging to a user, recording any failure for the admin digest.
+// Users should be messaged through sendByID instead, which uses whichever contact methods they've chosen.
+func (app *appContext) sendEmail(msg *Message, address string) error {
+	err := app.email.send(msg, address)
+	app.recordMessageFailure("email", address, err)
+	return err
+}
+
+// recordMessageFailure stores a failed delivery for the admin digest. no-op if err is nil.
+func (app *appContext) recordMessageFailure(method, recipient string, err error) {
+	if err == nil {
+		return
 	}
-	if addr, ok := app.storage.GetEmailsKey(jfID); ok {
-		return addr.Addr
+	id := shortuuid.New()
+	app.storage.db.Upsert(id, MessageFailure{
+		ID:        id,
+		Time:      time.Now(),
+		Method:    method,
+		Recipient: recipient,
+		Error:     err.Error(),
+	})
+}
+
+// sendToRecipients sends to a comma-separated list of email addresses and Jellyfin IDs, as used for admin notifications.
+// Messages to Jellyfin IDs are sent through the user's contact methods. what describes the message for the logs.
+func (app *appContext) sendToRecipients(email *Message, recipients, what string) {
+	for _, addr := range strings.Split(recipients, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		var err error
+		if strings.Contains(addr, "@") {
+			if !emailEnabled {
+				continue
+			}
+			err = app.sendEmail(email, addr)
+		} else {
+			err = app.sendByID(email, addr)
+		}
+		if err != nil {
+			app.err.Printf("Failed to send %s to %s: %v", what, addr, err)
+		} else {
+			app.info.Printf("Sent %s to %s", what, addr)
+		}
 	}
-	if mxChat, ok := app.storage.GetMatrixKey(jfID); ok && mxChat.Contact && matrixEnabled {
-		return mxChat.UserID
+}
+
+// getAddressOrName returns the address/username of the first account the user wants to be contacted through, or "" if none.
+// The email address is returned whether or not they want to be contacted by email.
+func (app *appContext) getAddressOrName(jfID string) string {
+	for _, cm := range app.contactMethods {
+		link, ok := cm.Linked(jfID)
+		if !ok {
+			continue
+		}
+		if cm.Name() == "email" || (cm.Enabled() && link.Contact) {
+			return link.Display
+		}
 	}
 	return ""
 }
@@ -910,55 +1269,23 @@ func (app *appContext) ReverseUserSearch(address string, matchUsername, matchEma
 			return
 		}
 	}
-
-	if matchEmail {
-		emailAddresses := []EmailAddress{}
-		err = app.storage.db.Find(&emailAddresses, badgerhold.Where("Addr").Eq(address))
-		if err == nil && len(emailAddresses) > 0 {
-			for _, emailUser := range emailAddresses {
-				user, status, err = app.jf.UserByID(emailUser.JellyfinID, false)
-				if status == 200 && err == nil {
-					ok = true
-					return
-				}
-			}
+	for _, cm := range app.contactMethods {
+		allowed := matchContactMethod
+		if cm.Name() == "email" {
+			// Email addresses have their own setting.
+			allowed = matchEmail
 		}
-	}
-
-	// Dont know how we'd use badgerhold when we need to render each username,
-	// Apart from storing the rendered name in the db.
-	if matchContactMethod {
-		for _, dcUser := range app.storage.GetDiscord() {
-			if RenderDiscordUsername(dcUser) == strings.ToLower(address) {
-				user, status, err = app.jf.UserByID(dcUser.JellyfinID, false)
-				if status == 200 && err == nil {
-					ok = true
-					return
-				}
-			}
+		if !allowed {
+			continue
 		}
-		tgUsername := strings.TrimPrefix(address, "@")
-		telegramUsers := []TelegramUser{}
-		err = app.storage.db.Find(&telegramUsers, badgerhold.Where("Username").Eq(tgUsername))
-		if err == nil && len(telegramUsers) > 0 {
-			for _, telegramUser := range telegramUsers {
-				user, status, err = app.jf.UserByID(telegramUser.JellyfinID, false)
-				if status == 200 && err == nil {
-					ok = true
-					return
-				}
-			}
+		jfID, found := cm.FindUser(address)
+		if !found {
+			continue
 		}
-		matrixUsers := []MatrixUser{}
-		err = app.storage.db.Find(&matrixUsers, badgerhold.Where("UserID").Eq(address))
-		if err == nil && len(matrixUsers) > 0 {
-			for _, matrixUser := range matrixUsers {
-				user, status, err = app.jf.UserByID(matrixUser.JellyfinID, false)
-				if status == 200 && err == nil {
-					ok = true
-					return
-				}
-			}
+		user, status, err = app.jf.UserByID(jfID, false)
+		if status == 200 && err == nil {
+			ok = true
+			return
 		}
 	}
 	return
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// newEmailHTTPClient returns a client for the HTTP API email clients, using the proxy if one is given.
+func newEmailHTTPClient(proxy *http.Transport) *http.Client {
+	client := &http.Client{Timeout: 15 * time.Second}
+	if proxy != nil {
+		client.Transport = proxy
+	}
+	return client
+}
+
+// postEmailJSON sends data to an email API, returning the response body if it was successful.
+func postEmailJSON(client *http.Client, url string, headers map[string]string, data interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		err = fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, err
+}
+
+// SendGrid client implements EmailClient through the v3 Mail Send API.
+type SendGrid struct {
+	url, key string
+	client   *http.Client
+}
+
+// NewSendGrid returns a SendGrid emailClient.
+func (emailer *Emailer) NewSendGrid(apiURL, key string, proxy *http.Transport) {
+	emailer.sender = &SendGrid{
+		url:    strings.TrimSuffix(apiURL, "/") + "/v3/mail/send",
+		key:    key,
+		client: newEmailHTTPClient(proxy),
+	}
+}
+
+func (sg *SendGrid) Send(fromName, fromAddr string, email *Message, address ...string) error {
+	type sgAddress struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	type sgContent struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	// A personalization per recipient, so users don't see other recipients.
+	personalizations := make([]map[string][]sgAddress, len(address))
+	for i, a := range address {
+		personalizations[i] = map[string][]sgAddress{"to": {{Email: a}}}
+	}
+	content := []sgContent{{Type: "text/plain", Value: email.Text}}
+	if email.HTML != "" {
+		content = append(content, sgContent{Type: "text/html", Value: email.HTML})
+	}
+	data := map[string]interface{}{
+		"personalizations": personalizations,
+		"from":             sgAddress{Email: fromAddr, Name: fromName},
+		"subject":          email.Subject,
+		"content":          content,
+	}
+	if len(email.Headers) != 0 {
+		data["headers"] = email.Headers
+	}
+	_, err := postEmailJSON(sg.client, sg.url, map[string]string{"Authorization": "Bearer " + sg.key}, data)
+	return err
+}
+
+// nameValueHeaders converts headers to the [{"Name": ..., "Value": ...}] form used by Postmark and SES.
+func nameValueHeaders(headers map[string]string) []map[string]string {
+	out := make([]map[string]string, 0, len(headers))
+	for name, value := range headers {
+		out = append(out, map[string]string{"Name": name, "Value": value})
+	}
+	return out
+}
+
+// Postmark client implements EmailClient through the batch email API.
+type Postmark struct {
+	url, token, stream string
+	client             *http.Client
+}
+
+// NewPostmark returns a Postmark emailClient. stream is the message stream to send through, usually "outbound".
+func (emailer *Emailer) NewPostmark(apiURL, token, stream string, proxy *http.Transport) {
+	emailer.sender = &Postmark{
+		url:    strings.TrimSuffix(apiURL, "/") + "/email/batch",
+		token:  token,
+		stream: stream,
+		client: newEmailHTTPClient(proxy),
+	}
+}
+
+func (pm *Postmark) Send(fromName, fromAddr string, email *Message, address ...string) error {
+	from := (&mailAddress{fromName, fromAddr}).String()
+	messages := make([]map[string]interface{}, len(address))
+	for i, a := range address {
+		messages[i] = map[string]interface{}{
+			"From":          from,
+			"To":            a,
+			"Subject":       email.Subject,
+			"TextBody":      email.Text,
+			"MessageStream": pm.stream,
+		}
+		if email.HTML != "" {
+			messages[i]["HtmlBody"] = email.HTML
+		}
+		if len(email.Headers) != 0 {
+			messages[i]["Headers"] = nameValueHeaders(email.Headers)
+		}
+	}
+	body, err := postEmailJSON(pm.client, pm.url, map[string]string{"X-Postmark-Server-Token": pm.token}, messages)
+	if err != nil {
+		return err
+	}
+	// The batch API returns 200 even if some messages failed.
+	results := []struct {
+		ErrorCode int
+		Message   string
+		To        string
+	}{}
+	if err := json.Unmarshal(body, &results); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.ErrorCode != 0 {
+			return fmt.Errorf("failed to send to \"%s\": %s (%d)", result.To, result.Message, result.ErrorCode)
+		}
+	}
+	return nil
+}
+
+// SES client implements EmailClient through the Amazon SES v2 API, signing requests with AWS Signature Version 4.
+type SES struct {
+	url, host, region string
+	keyID, secretKey  string
+	configurationSet  string
+	client            *http.Client
+}
+
+// NewSES returns an SES emailClient. apiURL can be left blank to use the default endpoint for the region.
+func (emailer *Emailer) NewSES(region, keyID, secretKey, configurationSet, apiURL string, proxy *http.Transport) error {
+	if apiURL == "" {
+		apiURL = "https://email." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/v2/email/outbound-emails")
+	if err != nil {
+		return err
+	}
+	emailer.sender = &SES{
+		url:              u.String(),
+		host:             u.Host,
+		region:           region,
+		keyID:            keyID,
+		secretKey:        secretKey,
+		configurationSet: configurationSet,
+		client:           newEmailHTTPClient(proxy),
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds AWS Signature Version 4 headers to a request with the given body.
+func (ses *SES) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type") + "\nhost:" + ses.host + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + ses.region + "/ses/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+ses.secretKey), date)
+	key = hmacSHA256(key, ses.region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", ses.keyID, scope, signedHeaders, signature))
+}
+
+func (ses *SES) Send(fromName, fromAddr string, email *Message, address ...string) error {
+	body := map[string]interface{}{
+		"Text": map[string]string{"Data": email.Text, "Charset": "UTF-8"},
+	}
+	if email.HTML != "" {
+		body["Html"] = map[string]string{"Data": email.HTML, "Charset": "UTF-8"}
+	}
+	simple := map[string]interface{}{
+		"Subject": map[string]string{"Data": email.Subject, "Charset": "UTF-8"},
+		"Body":    body,
+	}
+	if len(email.Headers) != 0 {
+		simple["Headers"] = nameValueHeaders(email.Headers)
+	}
+	// Sent separately, so users don't see other recipients.
+	for _, a := range address {
+		data := map[string]interface{}{
+			"FromEmailAddress": (&mailAddress{fromName, fromAddr}).String(),
+			"Destination":      map[string][]string{"ToAddresses": {a}},
+			"Content": map[string]interface{}{
+				"Simple": simple,
+			},
+		}
+		if ses.configurationSet != "" {
+			data["ConfigurationSetName"] = ses.configurationSet
+		}
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", ses.url, bytes.NewReader(encoded))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		ses.sign(req, encoded, time.Now())
+		resp, err := ses.client.Do(req)
+		if err != nil {
+			return err
+		}
+		out, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// mailAddress formats a name and address for a header, encoding the name if necessary.
+type mailAddress struct {
+	Name, Address string
+}
+
+func (a *mailAddress) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	return mime.QEncoding.Encode("utf-8", a.Name) + " <" + a.Address + ">"
+}
+
+// buildMIMEMessage builds a multipart/alternative message with text and (if given) HTML parts.
+func buildMIMEMessage(fromName, fromAddr, to string, email *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	domain := fromAddr[strings.LastIndex(fromAddr, "@")+1:]
+	headers := []string{
+		"From: " + (&mailAddress{fromName, fromAddr}).String(),
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + shortuuid.New() + "@" + domain + ">",
+		"MIME-Version: 1.0",
+	}
+	for name, value := range email.Headers {
+		headers = append(headers, name+": "+value)
+	}
+	mw := multipart.NewWriter(&buf)
+	headers = append(headers, "Content-Type: multipart/alternative; boundary=\""+mw.Boundary()+"\"")
+	header := strings.Join(headers, "\r\n") + "\r\n\r\n"
+
+	parts := []struct{ contentType, content string }{{"text/plain", email.Text}}
+	if email.HTML != "" {
+		parts = append(parts, struct{ contentType, content string }{"text/html", email.HTML})
+	}
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		qp.Close()
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return append([]byte(header), buf.Bytes()...), nil
+}
+
+// Sendmail client implements EmailClient by piping messages to a local sendmail-compatible program, like sendmail or msmtp.
+type Sendmail struct {
+	path string
+	args []string
+}
+
+// NewSendmail returns a Sendmail emailClient. args are passed before the sender and recipient.
+func (emailer *Emailer) NewSendmail(path, args string) {
+	emailer.sender = &Sendmail{
+		path: path,
+		args: strings.Fields(args),
+	}
+}
+
+func (sm *Sendmail) Send(fromName, fromAddr string, email *Message, address ...string) error {
+	// Sent separately, so users don't see other recipients.
+	for _, a := range address {
+		msg, err := buildMIMEMessage(fromName, fromAddr, a, email)
+		if err != nil {
+			return err
+		}
+		args := append(append([]string{}, sm.args...), "-f", fromAddr, "--", a)
+		cmd := exec.Command(sm.path, args...)
+		cmd.Stdin = bytes.NewReader(msg)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// emailTransport is an emailClient and its health, for failover.
+type emailTransport struct {
+	name         string
+	client       EmailClient
+	failures     int // Consecutive failures.
+	lastError    string
+	lastFailure  time.Time
+	lastSuccess  time.Time
+	sent, failed int
+}
+
+// FailoverClient implements EmailClient by trying a list of transports in order, moving on to the next if one fails.
+// A transport that fails threshold times in a row is skipped for cooldown (a circuit breaker).
+type FailoverClient struct {
+	transports []*emailTransport
+	threshold  int
+	cooldown   time.Duration
+	lock       sync.Mutex
+	app        *appContext
+}
+
+// tripped returns whether the transport's circuit breaker is open.
+func (fc *FailoverClient) tripped(t *emailTransport) bool {
+	return fc.threshold > 0 && t.failures >= fc.threshold && time.Since(t.lastFailure) < fc.cooldown
+}
+
+// Send fails over each recipient separately, as clients don't report which of a batch were delivered, and resending the whole batch would give duplicates to the rest.
+func (fc *FailoverClient) Send(fromName, fromAddr string, email *Message, address ...string) error {
+	failed := []string{}
+	for _, addr := range address {
+		if err := fc.send(fromName, fromAddr, email, addr); err != nil {
+			failed = append(failed, fmt.Sprintf("\"%s\": %v", addr, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to send to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// send tries a single address through each transport in order.
+func (fc *FailoverClient) send(fromName, fromAddr string, email *Message, address string) error {
+	fc.lock.Lock()
+	order := []*emailTransport{}
+	skipped := []*emailTransport{}
+	for _, t := range fc.transports {
+		if fc.tripped(t) {
+			skipped = append(skipped, t)
+		} else {
+			order = append(order, t)
+		}
+	}
+	fc.lock.Unlock()
+	// If every breaker is open, try them anyway rather than dropping the message.
+	if len(order) == 0 {
+		order = skipped
+	}
+	var err error
+	for i, t := range order {
+		err = t.client.Send(fromName, fromAddr, email, address)
+		fc.lock.Lock()
+		if err == nil {
+			t.failures = 0
+			t.lastSuccess = time.Now()
+			t.sent++
+			fc.lock.Unlock()
+			return nil
+		}
+		t.failures++
+		t.failed++
+		t.lastFailure = time.Now()
+		t.lastError = err.Error()
+		if fc.tripped(t) && t.failures == fc.threshold {
+			fc.app.err.Printf("Email: %s failed %d times in a row, skipping it for %s", t.name, t.failures, fc.cooldown)
+		}
+		fc.lock.Unlock()
+		if i < len(order)-1 {
+			fc.app.err.Printf("Email: Failed to send through %s, trying %s: %v", t.name, order[i+1].name, err)
+		} else {
+			err = fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return err
+}
+
+// Status returns the health of each transport, in the order they're tried.
+func (fc *FailoverClient) Status() []EmailTransportDTO {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	out := make([]EmailTransportDTO, len(fc.transports))
+	for i, t := range fc.transports {
+		out[i] = EmailTransportDTO{
+			Name:                t.name,
+			Healthy:             t.failures == 0,
+			CircuitOpen:         fc.tripped(t),
+			ConsecutiveFailures: t.failures,
+			Sent:                t.sent,
+			Failed:              t.failed,
+			LastError:           t.lastError,
+			LastFailure:         t.lastFailure.Unix(),
+			LastSuccess:         t.lastSuccess.Unix(),
+		}
+		if t.lastFailure.IsZero() {
+			out[i].LastFailure = 0
+		}
+		if t.lastSuccess.IsZero() {
+			out[i].LastSuccess = 0
+		}
+	}
+	return out
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAWSKeyID  = "AKIDEXAMPLE"
+	testAWSSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func TestSESSignature(t *testing.T) {
+	emailer := &Emailer{}
+	if err := emailer.NewSES("eu-west-1", testAWSKeyID, testAWSSecret, "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	ses := emailer.sender.(*SES)
+	body := []byte(`{"test":true}`)
+	req, _ := http.NewRequest("POST", ses.url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	ses.sign(req, body, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	if date := req.Header.Get("X-Amz-Date"); date != "20240101T120000Z" {
+		t.Errorf("unexpected X-Amz-Date %q", date)
+	}
+	// Computed separately, following the AWS Signature Version 4 documentation.
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240101/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=b973bbca1aba0e977e07f3f397af2367ebfc65ea64ee8dfecc533be9d21ac6da"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("unexpected Authorization header:\n%s\nexpected:\n%s", auth, expected)
+	}
+}
+
+func TestSESSend(t *testing.T) {
+	var lock sync.Mutex
+	recipients := []string{}
+	status := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path != "/v2/email/outbound-emails" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+testAWSKeyID+"/") {
+			t.Errorf("request not signed: %q", r.Header.Get("Authorization"))
+		}
+		var data struct {
+			Destination          struct{ ToAddresses []string }
+			ConfigurationSetName string
+		}
+		json.NewDecoder(r.Body).Decode(&data)
+		if data.ConfigurationSetName != "jfa-go" {
+			t.Errorf("configuration set not passed: %q", data.ConfigurationSetName)
+		}
+		recipients = append(recipients, data.Destination.ToAddresses...)
+		w.WriteHeader(status)
+		io.WriteString(w, `{"message":"rejected"}`)
+	}))
+	defer server.Close()
+	emailer := &Emailer{}
+	if err := emailer.NewSES("eu-west-1", testAWSKeyID, testAWSSecret, "jfa-go", server.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Subject: "Subject", Text: "Text"}
+	if err := emailer.sender.Send("jfa-go", "jfa-go@example.com", msg, "a@example.com", "b@example.com"); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	// Each recipient gets their own request, so they don't see each other.
+	if len(recipients) != 2 || recipients[0] != "a@example.com" || recipients[1] != "b@example.com" {
+		t.Errorf("unexpected recipients %v", recipients)
+	}
+	status = 400
+	if err := emailer.sender.Send("jfa-go", "jfa-go@example.com", msg, "a@example.com"); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("expected failure, got %v", err)
+	}
+}
+
+func TestPostmarkErrorCodes(t *testing.T) {
+	var response string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/email/batch" || r.Header.Get("X-Postmark-Server-Token") != "token" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		messages := []map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&messages)
+		if len(messages) != 2 || messages[0]["MessageStream"] != "outbound" {
+			t.Errorf("unexpected messages %v", messages)
+		}
+		// The batch API returns 200 regardless of each message's outcome.
+		io.WriteString(w, response)
+	}))
+	defer server.Close()
+	emailer := &Emailer{}
+	emailer.NewPostmark(server.URL, "token", "outbound", nil)
+	msg := &Message{Subject: "Subject", Text: "Text"}
+	for _, tc := range []struct {
+		response, err string
+	}{
+		{`[{"ErrorCode":0,"Message":"OK","To":"a@example.com"},{"ErrorCode":0,"Message":"OK","To":"b@example.com"}]`, ""},
+		{`[{"ErrorCode":0,"Message":"OK","To":"a@example.com"},{"ErrorCode":406,"Message":"Inactive recipient","To":"b@example.com"}]`, "b@example.com"},
+	} {
+		response = tc.response
+		err := emailer.sender.Send("jfa-go", "jfa-go@example.com", msg, "a@example.com", "b@example.com")
+		if tc.err == "" && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("expected error mentioning %q, got %v", tc.err, err)
+		}
+	}
+}
+
+// fakeEmailClient records who it sent to, failing for addresses in fail.
+type fakeEmailClient struct {
+	sent []string
+	fail map[string]bool
+}
+
+func (fc *fakeEmailClient) Send(fromName, fromAddr string, message *Message, address ...string) error {
+	failed := []string{}
+	for _, addr := range address {
+		if fc.fail[addr] {
+			failed = append(failed, addr)
+		} else {
+			fc.sent = append(fc.sent, addr)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to send to %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func TestSendEmailRecordsFailures(t *testing.T) {
+	app, _ := newTestApp(t, "")
+	app.email = &Emailer{sender: &fakeEmailClient{fail: map[string]bool{"bad@example.com": true}}}
+	msg := &Message{Subject: "Subject", Text: "Text"}
+	if err := app.sendEmail(msg, "good@example.com"); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if err := app.sendEmail(msg, "bad@example.com"); err == nil {
+		t.Fatal("expected failure")
+	}
+	failures := []MessageFailure{}
+	app.storage.db.Find(&failures, nil)
+	if len(failures) != 1 || failures[0].Recipient != "bad@example.com" || failures[0].Method != "email" {
+		t.Errorf("expected one failure for bad@example.com, got %+v", failures)
+	}
+}
+
+func TestFailoverClientPerRecipient(t *testing.T) {
+	app, _ := newTestApp(t, "")
+	primary := &fakeEmailClient{fail: map[string]bool{"b@example.com": true, "c@example.com": true}}
+	fallback := &fakeEmailClient{fail: map[string]bool{"c@example.com": true}}
+	fc := &FailoverClient{
+		transports: []*emailTransport{{name: "primary", client: primary}, {name: "fallback", client: fallback}},
+		app:        app,
+	}
+	err := fc.Send("jfa-go", "jfa-go@example.com", &Message{Subject: "Subject", Text: "Text"}, "a@example.com", "b@example.com", "c@example.com")
+	if err == nil || !strings.Contains(err.Error(), "c@example.com") || strings.Contains(err.Error(), "b@example.com") {
+		t.Errorf("expected failure for c@example.com only, got %v", err)
+	}
+	if strings.Join(primary.sent, ",") != "a@example.com" {
+		t.Errorf("primary sent to %v", primary.sent)
+	}
+	// a@example.com was delivered by primary, so mustn't get it again.
+	if strings.Join(fallback.sent, ",") != "b@example.com" {
+		t.Errorf("fallback sent to %v", fallback.sent)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/jfa-go/logger"
+	"github.com/hrfee/mediabrowser"
+	"github.com/timshannon/badgerhold/v4"
+	"gopkg.in/ini.v1"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	if os.Getenv("JFA_SECRET") == "" {
+		os.Setenv("JFA_SECRET", "test-secret")
+	}
+}
+
+// fakeJellyfin is just enough of the Jellyfin API for handlers under test.
+type fakeJellyfin struct {
+	server    *httptest.Server
+	users     map[string]mediabrowser.User
+	passwords map[string]string // Usernames to passwords.
+	devices   []jfDevice
+	deleted   []string // IDs of devices deleted.
+	lock      sync.Mutex
+}
+
+func newFakeJellyfin(t *testing.T) *fakeJellyfin {
+	f := &fakeJellyfin{users: map[string]mediabrowser.User{}, passwords: map[string]string{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeJellyfin) addUser(user mediabrowser.User) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.users[user.ID] = user
+}
+
+func (f *fakeJellyfin) handle(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := strings.ToLower(r.URL.Path)
+	writeJSON := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case path == "/system/info/public":
+		writeJSON(map[string]string{"Version": "10.8.0", "ServerName": "fake", "Id": "fake"})
+	case path == "/users" && r.Method == "GET":
+		users := []mediabrowser.User{}
+		for _, u := range f.users {
+			users = append(users, u)
+		}
+		writeJSON(users)
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/policy"):
+		id := strings.Split(r.URL.Path, "/")[2]
+		user, ok := f.users[id]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&user.Policy)
+		f.users[id] = user
+		w.WriteHeader(204)
+	case path == "/users/authenticatebyname":
+		var req struct{ Username, Pw string }
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, user := range f.users {
+			if user.Name != req.Username {
+				continue
+			}
+			// Like Jellyfin, disabled users are refused whether or not the password's right.
+			if user.Policy.IsDisabled {
+				w.WriteHeader(403)
+				return
+			}
+			if f.passwords[user.Name] == req.Pw {
+				writeJSON(map[string]interface{}{"User": user, "AccessToken": "token"})
+				return
+			}
+		}
+		w.WriteHeader(401)
+	case path == "/users/new" && r.Method == "POST":
+		var req struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&req)
+		user := mediabrowser.User{ID: fmt.Sprintf("newuser%04d", len(f.users)), Name: req.Name}
+		f.users[user.ID] = user
+		writeJSON(user)
+	case strings.HasPrefix(path, "/users/"):
+		user, ok := f.users[strings.TrimPrefix(r.URL.Path[len("/users/"):], "/")]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		writeJSON(user)
+	case path == "/devices" && r.Method == "GET":
+		devices := []jfDevice{}
+		// Like Jellyfin, return every device the user can access, not just their own.
+		devices = append(devices, f.devices...)
+		writeJSON(map[string]interface{}{"Items": devices})
+	case path == "/devices" && r.Method == "DELETE":
+		f.deleted = append(f.deleted, r.URL.Query().Get("id"))
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// newTestApp returns an appContext with the given config, a fresh database and a fake Jellyfin.
+func newTestApp(t *testing.T, config string) (*appContext, *fakeJellyfin) {
+	t.Helper()
+	app := &appContext{}
+	app.info = logger.NewLogger(io.Discard, "[INFO] ", 0, color.FgWhite)
+	app.err = logger.NewLogger(io.Discard, "[ERROR] ", 0, color.FgRed)
+	app.debug = logger.NewEmptyLogger()
+	cfg, err := ini.Load([]byte(config))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	app.config = cfg
+	app.jellyfinLogin = true
+	opts := badgerhold.DefaultOptions
+	opts.Dir = t.TempDir()
+	opts.ValueDir = opts.Dir
+	opts.Logger = nil
+	app.storage.db, err = badgerhold.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	t.Cleanup(func() { app.storage.db.Close() })
+	app.storage.debug = app.debug
+	f := newFakeJellyfin(t)
+	app.jf, err = mediabrowser.NewServer(mediabrowser.JellyfinServer, f.server.URL, "jfa-go", "test", "test", "test", func() {}, 0)
+	if err != nil {
+		t.Fatalf("Failed to connect to fake Jellyfin: %v", err)
+	}
+	app.authJf, _ = mediabrowser.NewServer(mediabrowser.JellyfinServer, f.server.URL, "jfa-go", "test", "auth", "auth", func() {}, 0)
+	app.loadLoginLimiter()
+	app.loadContactMethods()
+	return app, f
+}
+
+// testContext returns a gin context for a request with the given JSON body.
+func testContext(method, path string, body interface{}) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	gc, _ := gin.CreateTestContext(w)
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		reader = bytes.NewReader(data)
+	}
+	gc.Request = httptest.NewRequest(method, path, reader)
+	gc.Request.Header.Set("Content-Type", "application/json")
+	gc.Request.RemoteAddr = "192.0.2.1:1234"
+	return gc, w
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+// jfRequest makes an authenticated request to a Jellyfin/Emby endpoint mediabrowser doesn't cover, returning the response body.
+// data, if not nil, is sent as JSON.
+func (app *appContext) jfRequest(method, path string, data interface{}) ([]byte, int, error) {
+	var body io.Reader
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, app.jf.Server+path, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Emby-Token", app.jf.AccessToken)
+	client := &http.Client{Timeout: 10 * time.Second}
+	if app.proxyEnabled {
+		client.Transport = app.proxyTransport
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		err = fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	return out, resp.StatusCode, err
+}
+
+// renameJellyfinUser changes the given user's name, by posting the user back to /Users/{id} like the Jellyfin/Emby dashboard does.
+func (app *appContext) renameJellyfinUser(user mediabrowser.User, newName string) (int, error) {
+	user.Name = newName
+	mediabrowser.DeNullConfiguration(&user.Configuration)
+	_, status, err := app.jfRequest("POST", "/Users/"+user.ID, user)
+	app.jf.CacheExpiry = time.Now()
+	return status, err
+}
+
+// jfTime is a mediabrowser.Time which tolerates null or empty values, left as the zero time.
+type jfTime struct {
+	mediabrowser.Time
+}
+
+func (t *jfTime) UnmarshalJSON(b []byte) error {
+	if s := string(b); s == "null" || s == `""` {
+		return nil
+	}
+	return t.Time.UnmarshalJSON(b)
+}
+
+// jfDevice is a device registered to a Jellyfin user, as returned by /Devices.
+type jfDevice struct {
+	ID               string `json:"Id"`
+	Name             string `json:"Name"`
+	AppName          string `json:"AppName"`
+	AppVersion       string `json:"AppVersion"`
+	LastUserID       string `json:"LastUserId"`
+	LastUserName     string `json:"LastUserName"`
+	DateLastActivity jfTime `json:"DateLastActivity"`
+}
+
+// jfSession is a client currently connected to Jellyfin, as returned by /Sessions.
+type jfSession struct {
+	ID               string       `json:"Id"`
+	UserID           string       `json:"UserId"`
+	UserName         string       `json:"UserName"`
+	Client           string       `json:"Client"`
+	DeviceName       string       `json:"DeviceName"`
+	DeviceID         string       `json:"DeviceId"`
+	RemoteEndPoint   string       `json:"RemoteEndPoint"`
+	LastActivityDate jfTime       `json:"LastActivityDate"`
+	NowPlayingItem   *jfItem      `json:"NowPlayingItem"` // nil if nothing is playing.
+	PlayState        *jfPlayState `json:"PlayState"`
+}
+
+// jfPlayState is the playback state of a session.
+type jfPlayState struct {
+	IsPaused bool `json:"IsPaused"`
+}
+
+// jfItem is a library item, as included in a session's NowPlayingItem.
+type jfItem struct {
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Type         string `json:"Type"`
+	RunTimeTicks int64  `json:"RunTimeTicks"`
+}
+
+// getJellyfinDevices returns the devices the given user last logged in on, or all devices if userID is blank.
+func (app *appContext) getJellyfinDevices(userID string) ([]jfDevice, error) {
+	path := "/Devices"
+	if userID != "" {
+		path += "?userId=" + url.QueryEscape(userID)
+	}
+	data, status, err := app.jfRequest("GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices (%d): %v", status, err)
+	}
+	var resp struct {
+		Items []jfDevice `json:"Items"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if userID == "" || err != nil {
+		return resp.Items, err
+	}
+	// userId returns all devices the user can access, which is every device if they have EnableAllDevices.
+	devices := make([]jfDevice, 0, len(resp.Items))
+	for _, d := range resp.Items {
+		if d.LastUserID == userID {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// getJellyfinSessions returns all sessions active on Jellyfin.
+func (app *appContext) getJellyfinSessions() ([]jfSession, error) {
+	data, status, err := app.jfRequest("GET", "/Sessions", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions (%d): %v", status, err)
+	}
+	sessions := []jfSession{}
+	err = json.Unmarshal(data, &sessions)
+	return sessions, err
+}
+
+// deleteJellyfinDevice removes a device, revoking its access token and so signing it out.
+func (app *appContext) deleteJellyfinDevice(deviceID string) error {
+	_, status, err := app.jfRequest("DELETE", "/Devices?id="+url.QueryEscape(deviceID), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete device (%d): %v", status, err)
+	}
+	return nil
+}
+
+// stopJellyfinSession stops playback in the given session.
+func (app *appContext) stopJellyfinSession(sessionID string) error {
+	_, status, err := app.jfRequest("POST", "/Sessions/"+url.PathEscape(sessionID)+"/Playing/Stop", nil)
+	if err != nil {
+		return fmt.Errorf("failed to stop session (%d): %v", status, err)
+	}
+	return nil
+}
+
+// messageJellyfinSession shows a message on the given session's client, for the given duration.
+func (app *appContext) messageJellyfinSession(sessionID, header, text string, timeout time.Duration) error {
+	_, status, err := app.jfRequest("POST", "/Sessions/"+url.PathEscape(sessionID)+"/Message", map[string]interface{}{
+		"Header":    header,
+		"Text":      text,
+		"TimeoutMs": timeout.Milliseconds(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message (%d): %v", status, err)
+	}
+	return nil
+}
@@ -93,18 +93,24 @@ func (ls *emailLangs) getOptions() [][2]string {
 }
 
 type emailLang struct {
-	Meta              langMeta    `json:"meta"`
-	Strings           langSection `json:"strings"`
-	UserCreated       langSection `json:"userCreated"`
-	InviteExpiry      langSection `json:"inviteExpiry"`
-	PasswordReset     langSection `json:"passwordReset"`
-	UserDeleted       langSection `json:"userDeleted"`
-	UserDisabled      langSection `json:"userDisabled"`
-	UserEnabled       langSection `json:"userEnabled"`
-	InviteEmail       langSection `json:"inviteEmail"`
-	WelcomeEmail      langSection `json:"welcomeEmail"`
-	EmailConfirmation langSection `json:"emailConfirmation"`
-	UserExpired       langSection `json:"userExpired"`
+	Meta               langMeta    `json:"meta"`
+	Strings            langSection `json:"strings"`
+	UserCreated        langSection `json:"userCreated"`
+	InviteExpiry       langSection `json:"inviteExpiry"`
+	PasswordReset      langSection `json:"passwordReset"`
+	UserDeleted        langSection `json:"userDeleted"`
+	UserDisabled       langSection `json:"userDisabled"`
+	UserEnabled        langSection `json:"userEnabled"`
+	InviteEmail        langSection `json:"inviteEmail"`
+	WelcomeEmail       langSection `json:"welcomeEmail"`
+	EmailConfirmation  langSection `json:"emailConfirmation"`
+	UserExpired        langSection `json:"userExpired"`
+	ExpiryExtended     langSection `json:"expiryExtended"`
+	UsernameChanged    langSection `json:"usernameChanged"`
+	StreamLimit        langSection `json:"streamLimit"`
+	WatchTimeReport    langSection `json:"watchTimeReport"`
+	AdminDigest        langSection `json:"adminDigest"`
+	AdminNotifications langSection `json:"adminNotifications"`
 }
 
 type setupLangs map[string]setupLang
@@ -22,11 +22,11 @@ import (
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/hrfee/jfa-go/common"
 	_ "github.com/hrfee/jfa-go/docs"
 	"github.com/hrfee/jfa-go/easyproxy"
 	"github.com/hrfee/jfa-go/logger"
-	"github.com/hrfee/jfa-go/ombi"
 	"github.com/hrfee/mediabrowser"
 	"github.com/lithammer/shortuuid/v3"
 	"gopkg.in/ini.v1"
@@ -96,11 +96,10 @@ type appContext struct {
 	cssClass       string // Default theme, "light"|"dark".
 	jellyfinLogin  bool
 	adminUsers     []User
-	invalidTokens  []string
 	// Keeping jf name because I can't think of a better one
 	jf                   *mediabrowser.MediaBrowser
 	authJf               *mediabrowser.MediaBrowser
-	ombi                 *ombi.Ombi
+	requestManagers      []common.RequestManager
 	datePattern          string
 	timePattern          string
 	storage              Storage
@@ -109,6 +108,9 @@ type appContext struct {
 	telegram             *TelegramDaemon
 	discord              *DiscordDaemon
 	matrix               *MatrixDaemon
+	signal               *SignalDaemon
+	sms                  *SMSGateway
+	push                 *PushNotifier
 	info, debug, err     *logger.Logger
 	host                 string
 	port                 int
@@ -125,6 +127,13 @@ type appContext struct {
 	pwrCaptchas          map[string]Captcha
 	ConfirmationKeys     map[string]map[string]newUserDTO // Map of invite code to jwt to request
 	confirmationKeysLock sync.Mutex
+	webauthn             *webauthn.WebAuthn
+	passkeySessions      PasskeySessions
+	loginLimiter         *LoginLimiter
+	payments             PaymentProvider
+	streamLimiter        *StreamLimiter
+	watchTime            *WatchTimeCollector
+	contactMethods       []ContactMethod
 }
 
 func generateSecret(length int) (string, error) {
@@ -348,16 +357,7 @@ func start(asDaemon, firstCall bool) {
 
 		app.debug.Printf("Loaded config file \"%s\"", app.configPath)
 
-		if app.config.Section("ombi").Key("enabled").MustBool(false) {
-			app.debug.Printf("Connecting to Ombi")
-			ombiServer := app.config.Section("ombi").Key("server").String()
-			app.ombi = ombi.NewOmbi(
-				ombiServer,
-				app.config.Section("ombi").Key("api_key").String(),
-				common.NewTimeoutHandler("Ombi", ombiServer, true),
-			)
-
-		}
+		app.loadRequestManagers()
 
 		app.storage.db_path = filepath.Join(app.dataPath, "db")
 		app.loadPendingBackup()
@@ -441,6 +441,13 @@ func start(asDaemon, firstCall bool) {
 			}
 		}
 
+		app.loadContactMethods()
+		app.loadWebAuthn()
+		app.loadPaymentProvider()
+		app.loadStreamLimiter()
+		app.loadWatchTimeCollector()
+		app.loadLoginLimiter()
+
 		// Since email depends on language, the email reload in loadConfig won't work first time.
 		app.email = NewEmailer(app)
 		app.loadStrftime()
@@ -519,6 +526,30 @@ func start(asDaemon, firstCall bool) {
 				defer app.matrix.Shutdown()
 			}
 		}
+		if signalEnabled {
+			app.signal, err = newSignalDaemon(app)
+			if err != nil {
+				app.err.Printf("Failed to connect to signal-cli-rest-api: %v", err)
+				signalEnabled = false
+			} else {
+				go app.signal.run()
+				defer app.signal.Shutdown()
+			}
+		}
+		if smsEnabled {
+			app.sms, err = newSMSGateway(app)
+			if err != nil {
+				app.err.Printf("Failed to initialize SMS gateway: %v", err)
+				smsEnabled = false
+			}
+		}
+		if pushEnabled {
+			app.push, err = newPushNotifier(app)
+			if err != nil {
+				app.err.Printf("Failed to initialize push notifications: %v", err)
+				pushEnabled = false
+			}
+		}
 	} else {
 		debugMode = false
 		if *PORT != app.port && *PORT > 0 {
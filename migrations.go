@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/timshannon/badgerhold/v4"
 	"gopkg.in/ini.v1"
 )
 
@@ -14,9 +15,11 @@ func runMigrations(app *appContext) {
 	migrateBootstrap(app)
 	migrateEmailStorage(app)
 	migrateNotificationMethods(app)
-	linkExistingOmbiDiscordTelegram(app)
+	linkExistingRequestManagerDiscordTelegram(app)
 	// migrateHyphens(app)
 	migrateToBadger(app)
+	migrateOmbiProfiles(app)
+	migrateReferrals(app)
 }
 
 // Migrate pre-0.2.0 user templates to profiles
@@ -162,11 +165,11 @@ func migrateNotificationMethods(app *appContext) error {
 }
 
 // Pre-0.4.0, Ombi users were created without linking their Discord & Telegram accounts. This will add them.
-func linkExistingOmbiDiscordTelegram(app *appContext) error {
+func linkExistingRequestManagerDiscordTelegram(app *appContext) error {
 	if !discordEnabled && !telegramEnabled {
 		return nil
 	}
-	if !app.config.Section("ombi").Key("enabled").MustBool(false) {
+	if len(app.requestManagers) == 0 {
 		return nil
 	}
 	idList := map[string][2]string{}
@@ -181,16 +184,18 @@ func linkExistingOmbiDiscordTelegram(app *appContext) error {
 		vals[1] = user.Username
 		idList[user.JellyfinID] = vals
 	}
-	for jfID, ids := range idList {
-		ombiUser, status, err := app.getOmbiUser(jfID)
-		if status != 200 || err != nil {
-			app.debug.Printf("Failed to get Ombi user with Discord/Telegram \"%s\"/\"%s\" (%d): %v", ids[0], ids[1], status, err)
-			continue
-		}
-		_, status, err = app.ombi.SetNotificationPrefs(ombiUser, ids[0], ids[1])
-		if status != 200 || err != nil {
-			app.debug.Printf("Failed to set prefs for Ombi user \"%s\" (%d): %v", ombiUser["userName"].(string), status, err)
-			continue
+	for _, rm := range app.requestManagers {
+		for jfID, ids := range idList {
+			rmUser, status, err := app.getRequestManagerUser(rm, jfID)
+			if status != 200 || err != nil {
+				app.debug.Printf("Failed to get %s user with Discord/Telegram \"%s\"/\"%s\" (%d): %v", rm.Name(), ids[0], ids[1], status, err)
+				continue
+			}
+			_, status, err = rm.SetNotificationPrefs(rmUser, ids[0], ids[1])
+			if status != 200 || err != nil {
+				app.debug.Printf("Failed to set prefs for %s user \"%s\" (%d): %v", rm.Name(), rmUser.Name, status, err)
+				continue
+			}
 		}
 	}
 	return nil
@@ -336,6 +341,52 @@ func migrateToBadger(app *appContext) {
 	app.info.Println("All data migrated to database. JSON files in the config folder can be deleted if you are sure all data is correct in the app. Create an issue if you have problems.")
 }
 
+// Pre-0.5.0, profiles stored a single Ombi template. Templates are now stored per request manager, keyed by name.
+func migrateOmbiProfiles(app *appContext) {
+	for _, profile := range app.storage.GetProfiles() {
+		if profile.Ombi == nil {
+			continue
+		}
+		if profile.RequestManagers == nil {
+			profile.RequestManagers = map[string]map[string]interface{}{}
+		}
+		if _, ok := profile.RequestManagers["ombi"]; !ok {
+			profile.RequestManagers["ombi"] = profile.Ombi
+		}
+		profile.Ombi = nil
+		app.storage.SetProfileKey(profile.Name, profile)
+		app.info.Printf("Migrated Ombi template in profile \"%s\"", profile.Name)
+	}
+}
+
+// Referrals used to only be recorded in the activity log. This copies them into their own store, so they aren't lost when activities are cleared.
+func migrateReferrals(app *appContext) {
+	migrated := MigrationStatus{}
+	app.storage.db.Get("migrated_referrals", &migrated)
+	if migrated.Done {
+		return
+	}
+	activities := []Activity{}
+	app.storage.db.Find(&activities, badgerhold.Where("Type").Eq(ActivityCreation).And("SourceType").Eq(ActivityUser))
+	for _, act := range activities {
+		if act.Source == "" {
+			continue
+		}
+		if _, ok := app.storage.GetReferralKey(act.UserID); ok {
+			continue
+		}
+		app.storage.SetReferralKey(act.UserID, Referral{
+			ReferrerJellyfinID: act.Source,
+			InviteCode:         act.InviteCode,
+			Created:            act.Time,
+		})
+	}
+	if len(activities) != 0 {
+		app.info.Printf("Migrated %d referral(s) from the activity log", len(activities))
+	}
+	app.storage.db.Upsert("migrated_referrals", MigrationStatus{true})
+}
+
 // Migrate between hyphenated & non-hyphenated user IDs. Doesn't seem to happen anymore, so disabled.
 // func migrateHyphens(app *appContext) {
 // 	checkVersion := func(version string) int {
@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 type stringResponse struct {
 	Response string `json:"response" example:"message"`
@@ -13,19 +17,64 @@ type boolResponse struct {
 }
 
 type newUserDTO struct {
-	Username        string `json:"username" example:"jeff" binding:"required"`  // User's username
-	Password        string `json:"password" example:"guest" binding:"required"` // User's password
-	Email           string `json:"email" example:"jeff@jellyf.in"`              // User's email address
-	Code            string `json:"code" example:"abc0933jncjkcjj"`              // Invite code (required on /newUser)
-	TelegramPIN     string `json:"telegram_pin" example:"A1-B2-3C"`             // Telegram verification PIN (if used)
-	TelegramContact bool   `json:"telegram_contact"`                            // Whether or not to use telegram for notifications/pwrs
-	DiscordPIN      string `json:"discord_pin" example:"A1-B2-3C"`              // Discord verification PIN (if used)
-	DiscordContact  bool   `json:"discord_contact"`                             // Whether or not to use discord for notifications/pwrs
-	MatrixPIN       string `json:"matrix_pin" example:"A1-B2-3C"`               // Matrix verification PIN (if used)
-	MatrixContact   bool   `json:"matrix_contact"`                              // Whether or not to use matrix for notifications/pwrs
-	CaptchaID       string `json:"captcha_id"`                                  // Captcha ID (if enabled)
-	CaptchaText     string `json:"captcha_text"`                                // Captcha text (if enabled)
-	Profile         string `json:"profile"`                                     // Profile (for admins only)
+	Username    string `json:"username" example:"jeff" binding:"required"`  // User's username
+	Password    string `json:"password" example:"guest" binding:"required"` // User's password
+	Email       string `json:"email" example:"jeff@jellyf.in"`              // User's email address
+	Code        string `json:"code" example:"abc0933jncjkcjj"`              // Invite code (required on /newUser)
+	CaptchaID   string `json:"captcha_id"`                                  // Captcha ID (if enabled)
+	CaptchaText string `json:"captcha_text"`                                // Captcha text (if enabled)
+	Profile     string `json:"profile"`                                     // Profile (for admins only)
+	// Contact method verification PINs (if used) and whether to use each for notifications/pwrs, by method name.
+	// Sent as "<method>_pin" and "<method>_contact", e.g. "discord_pin": "A1-B2-3C", "discord_contact": true.
+	ContactPINs map[string]string `json:"-"`
+	Contact     map[string]bool   `json:"-"`
+}
+
+func (req *newUserDTO) UnmarshalJSON(data []byte) error {
+	type plain newUserDTO
+	if err := json.Unmarshal(data, (*plain)(req)); err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	req.ContactPINs = map[string]string{}
+	req.Contact = map[string]bool{}
+	for key, value := range fields {
+		var err error
+		if method, ok := strings.CutSuffix(key, "_pin"); ok {
+			var pin string
+			err = json.Unmarshal(value, &pin)
+			req.ContactPINs[method] = pin
+		} else if method, ok := strings.CutSuffix(key, "_contact"); ok {
+			var contact bool
+			err = json.Unmarshal(value, &contact)
+			req.Contact[method] = contact
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalWithFields marshals v, a struct, with the given fields added to the resulting object.
+func marshalWithFields(v interface{}, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if fields[key], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
 }
 
 type newUserResponse struct {
@@ -72,11 +121,13 @@ type inviteProfileDTO struct {
 }
 
 type profileDTO struct {
-	Admin            bool   `json:"admin" example:"false"`            // Whether profile has admin rights or not
-	LibraryAccess    string `json:"libraries" example:"all"`          // Number of libraries profile has access to
-	FromUser         string `json:"fromUser" example:"jeff"`          // The user the profile is based on
-	Ombi             bool   `json:"ombi"`                             // Whether or not Ombi settings are stored in this profile.
-	ReferralsEnabled bool   `json:"referrals_enabled" example:"true"` // Whether or not the profile has referrals enabled, and has a template invite stored.
+	Admin            bool             `json:"admin" example:"false"`            // Whether profile has admin rights or not
+	LibraryAccess    string           `json:"libraries" example:"all"`          // Number of libraries profile has access to
+	FromUser         string           `json:"fromUser" example:"jeff"`          // The user the profile is based on
+	Ombi             bool             `json:"ombi"`                             // Whether or not Ombi settings are stored in this profile.
+	ReferralsEnabled bool             `json:"referrals_enabled" example:"true"` // Whether or not the profile has referrals enabled, and has a template invite stored.
+	ReferralQuota    referralQuotaDTO `json:"referral_quota"`
+	Limits           profileLimitsDTO `json:"limits"`
 }
 
 type getProfilesDTO struct {
@@ -136,24 +187,31 @@ type deleteInviteDTO struct {
 }
 
 type respUser struct {
-	ID                    string `json:"id" example:"fdgsdfg45534fa"`              // userID of user
-	Name                  string `json:"name" example:"jeff"`                      // Username of user
-	Email                 string `json:"email,omitempty" example:"jeff@jellyf.in"` // Email address of user (if available)
-	NotifyThroughEmail    bool   `json:"notify_email"`
-	LastActive            int64  `json:"last_active" example:"1617737207510"` // Time of last activity on Jellyfin
-	Admin                 bool   `json:"admin" example:"false"`               // Whether or not the user is Administrator
-	Expiry                int64  `json:"expiry" example:"1617737207510"`      // Expiry time of user as Epoch/Unix time.
-	Disabled              bool   `json:"disabled"`                            // Whether or not the user is disabled.
-	Telegram              string `json:"telegram"`                            // Telegram username (if known)
-	NotifyThroughTelegram bool   `json:"notify_telegram"`
-	Discord               string `json:"discord"`    // Discord username (if known)
-	DiscordID             string `json:"discord_id"` // Discord user ID for creating links.
-	NotifyThroughDiscord  bool   `json:"notify_discord"`
-	Matrix                string `json:"matrix"` // Matrix ID (if known)
-	NotifyThroughMatrix   bool   `json:"notify_matrix"`
-	Label                 string `json:"label"`          // Label of user, shown next to their name.
-	AccountsAdmin         bool   `json:"accounts_admin"` // Whether or not the user is a jfa-go admin.
-	ReferralsEnabled      bool   `json:"referrals_enabled"`
+	ID               string `json:"id" example:"fdgsdfg45534fa"`         // userID of user
+	Name             string `json:"name" example:"jeff"`                 // Username of user
+	LastActive       int64  `json:"last_active" example:"1617737207510"` // Time of last activity on Jellyfin
+	Admin            bool   `json:"admin" example:"false"`               // Whether or not the user is Administrator
+	Expiry           int64  `json:"expiry" example:"1617737207510"`      // Expiry time of user as Epoch/Unix time.
+	Disabled         bool   `json:"disabled"`                            // Whether or not the user is disabled.
+	Label            string `json:"label"`                               // Label of user, shown next to their name.
+	AccountsAdmin    bool   `json:"accounts_admin"`                      // Whether or not the user is a jfa-go admin.
+	ReferralsEnabled bool   `json:"referrals_enabled"`
+	ReferrerInactive bool   `json:"referrer_inactive"`          // Whether the user was referred by someone since deleted or disabled.
+	PendingUsername  string `json:"pending_username,omitempty"` // Username change requested by the user, awaiting approval.
+	// Account linked on each contact method (blank if none), by method name.
+	// Sent as "<method>": display name, "<method>_id": account ID (e.g. Discord user ID for creating links), and "notify_<method>".
+	ContactMethods map[string]ContactLink `json:"-"`
+}
+
+func (u respUser) MarshalJSON() ([]byte, error) {
+	type plain respUser
+	fields := map[string]interface{}{}
+	for method, link := range u.ContactMethods {
+		fields[method] = link.Display
+		fields[method+"_id"] = link.ID
+		fields["notify_"+method] = link.Contact
+	}
+	return marshalWithFields(plain(u), fields)
 }
 
 type getUsersDTO struct {
@@ -260,6 +318,33 @@ type customEmailDTO struct {
 	Plaintext    string                 `json:"plaintext"`
 }
 
+type customMessagePreviewReqDTO struct {
+	Content string                 `json:"content"` // Markdown to render in place of the stored content. Optional.
+	Subject string                 `json:"subject"` // Optional.
+	Values  map[string]interface{} `json:"values"`  // Variable values to override the sample ones with.
+	Lang    string                 `json:"lang"`    // Language to render in. Defaults to the recipient's when testing, otherwise the server's.
+}
+
+type customMessagePreviewDTO struct {
+	Subject  string `json:"subject"`
+	HTML     string `json:"html"`
+	Text     string `json:"text"`
+	Markdown string `json:"markdown"` // As sent to Discord/Telegram/Matrix.
+}
+
+type customMessageTestReqDTO struct {
+	customMessagePreviewReqDTO
+	User    string `json:"user"`    // Jellyfin ID of a user to send to.
+	Address string `json:"address"` // Or an email address.
+	Method  string `json:"method"`  // Contact method to send to the user through, e.g. "discord". If blank, the user's chosen ones are used.
+}
+
+type customMessageTestDTO struct {
+	customMessagePreviewDTO
+	Sent  bool   `json:"sent"`
+	Error string `json:"error"`
+}
+
 type extendExpiryDTO struct {
 	Users     []string `json:"users"`               // List of user IDs to apply to.
 	Months    int      `json:"months" example:"1"`  // Number of months to add.
@@ -285,11 +370,31 @@ type telegramSetDTO struct {
 }
 
 type SetContactMethodsDTO struct {
-	ID       string `json:"id"`
-	Email    bool   `json:"email"`
-	Discord  bool   `json:"discord"`
-	Telegram bool   `json:"telegram"`
-	Matrix   bool   `json:"matrix"`
+	ID string `json:"id"`
+	// Whether to contact the user through each method, by method name. Sent as "<method>": true/false, e.g. "discord": true.
+	Contact map[string]bool `json:"-"`
+}
+
+func (req *SetContactMethodsDTO) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	req.Contact = map[string]bool{}
+	for key, value := range fields {
+		var err error
+		if key == "id" {
+			err = json.Unmarshal(value, &req.ID)
+		} else {
+			var contact bool
+			err = json.Unmarshal(value, &contact)
+			req.Contact[key] = contact
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type DiscordUserDTO struct {
@@ -316,6 +421,18 @@ type MatrixSendPINDTO struct {
 	UserID string `json:"user_id"`
 }
 
+type SMSSendPINDTO struct {
+	Number string `json:"number" example:"+441234567890"` // Phone number, in international format.
+	Lang   string `json:"lang" example:"en-us"`           // Language of the text, if available.
+}
+
+type PushSendPINDTO struct {
+	Service string `json:"service" example:"ntfy"`   // ntfy, gotify or apprise.
+	Target  string `json:"target" example:"mytopic"` // ntfy topic, Gotify application token or Apprise URL.
+	Token   string `json:"token"`                    // ntfy access token, for protected topics.
+	Lang    string `json:"lang" example:"en-us"`     // Language of the PIN message, if available.
+}
+
 type MatrixCheckPINDTO struct {
 	PIN string `json:"pin"`
 }
@@ -354,6 +471,22 @@ type InternalPWR struct {
 	Expiry   time.Time `json:"expiry"`
 }
 
+type EmailTransportDTO struct {
+	Name                string `json:"name"`                 // Email method, e.g. smtp or mailgun.
+	Healthy             bool   `json:"healthy"`              // False if the last attempt failed.
+	CircuitOpen         bool   `json:"circuit_open"`         // Whether the transport is being skipped after too many failures.
+	ConsecutiveFailures int    `json:"consecutive_failures"` // Failures since the last success.
+	Sent                int    `json:"sent"`                 // Since jfa-go started.
+	Failed              int    `json:"failed"`               // Since jfa-go started.
+	LastError           string `json:"last_error"`
+	LastFailure         int64  `json:"last_failure"` // Unix timestamp, 0 if never.
+	LastSuccess         int64  `json:"last_success"` // Unix timestamp, 0 if never.
+}
+
+type EmailTransportsDTO struct {
+	Transports []EmailTransportDTO `json:"transports"` // In the order they're tried.
+}
+
 type LogDTO struct {
 	Log string `json:"log"`
 }
@@ -384,17 +517,23 @@ type ReCaptchaResponseDTO struct {
 
 // MyDetailsDTO is sent to the user page to personalize it for the user.
 type MyDetailsDTO struct {
-	Id            string                      `json:"id"`
-	Username      string                      `json:"username"`
-	Expiry        int64                       `json:"expiry"`
-	Admin         bool                        `json:"admin"`
-	AccountsAdmin bool                        `json:"accounts_admin"`
-	Disabled      bool                        `json:"disabled"`
-	Email         *MyDetailsContactMethodsDTO `json:"email,omitempty"`
-	Discord       *MyDetailsContactMethodsDTO `json:"discord,omitempty"`
-	Telegram      *MyDetailsContactMethodsDTO `json:"telegram,omitempty"`
-	Matrix        *MyDetailsContactMethodsDTO `json:"matrix,omitempty"`
-	HasReferrals  bool                        `json:"has_referrals,omitempty"`
+	Id            string `json:"id"`
+	Username      string `json:"username"`
+	Expiry        int64  `json:"expiry"`
+	Admin         bool   `json:"admin"`
+	AccountsAdmin bool   `json:"accounts_admin"`
+	Disabled      bool   `json:"disabled"`
+	HasReferrals  bool   `json:"has_referrals,omitempty"`
+	// Username change awaiting admin approval, if any.
+	PendingUsername string `json:"pending_username,omitempty"`
+	// Language chosen for messages, or "" to use that of the user's contact methods.
+	Lang string `json:"lang"`
+	// Details of each enabled contact method, by method name. Sent as "<method>": {"value": ..., "enabled": ...}.
+	ContactMethods map[string]*MyDetailsContactMethodsDTO `json:"-"`
+}
+
+type MyLangDTO struct {
+	Lang string `json:"lang"` // Language code, e.g. en-us, or "" for the default.
 }
 
 type MyDetailsContactMethodsDTO struct {
@@ -402,6 +541,39 @@ type MyDetailsContactMethodsDTO struct {
 	Enabled bool   `json:"enabled"`
 }
 
+func (d MyDetailsDTO) MarshalJSON() ([]byte, error) {
+	type plain MyDetailsDTO
+	fields := map[string]interface{}{}
+	for method, details := range d.ContactMethods {
+		fields[method] = details
+	}
+	return marshalWithFields(plain(d), fields)
+}
+
+type ChangeMyUsernameDTO struct {
+	Username string `json:"username"`
+}
+
+type ChangeMyUsernameRespDTO struct {
+	Pending bool `json:"pending"` // True if the change must be approved by an admin first.
+}
+
+type renameUsersDTO struct {
+	Users  map[string]string `json:"users"`  // Map of user IDs to new usernames.
+	Notify bool              `json:"notify"` // Whether to notify users of the change.
+}
+
+type usernameRequestDTO struct {
+	ID      string `json:"id"` // Jellyfin ID of the user.
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
+	Time    int64  `json:"time"`
+}
+
+type usernameRequestsDTO struct {
+	Requests []usernameRequestDTO `json:"requests"`
+}
+
 type ModifyMyEmailDTO struct {
 	Email string `json:"email"`
 }
@@ -428,10 +600,61 @@ type GetMyReferralRespDTO struct {
 	NoLimit       bool   `json:"no_limit"`
 	Expiry        int64  `json:"expiry"` // Come back after this time to get a new referral (if UseExpiry, a new one can't be made).
 	UseExpiry     bool   `json:"use_expiry"`
+	QuotaReached  bool   `json:"quota_reached"` // The user can't refer anyone else until the quota period rolls over.
 }
 
 type EnableDisableReferralDTO struct {
-	Users []string `json:"users"`
+	Users           []string `json:"users"`
+	DisableReferred bool     `json:"disable_referred"` // When disabling, also disable the users they referred.
+	Recursive       bool     `json:"recursive"`        // With DisableReferred, disable everyone below them in the referral tree too.
+	Notify          bool     `json:"notify"`           // Whether to notify disabled users.
+	Reason          string   `json:"reason"`           // Reason for disabling (for notification).
+}
+
+type referralQuotaDTO struct {
+	MonthlyQuota   int `json:"monthly_quota"`   // Max referral signups per user per 30 days, 0 for no limit.
+	MaxOutstanding int `json:"max_outstanding"` // Max uses a referral invite can have at once, 0 to use the invite's.
+}
+
+type profileLimitsDTO struct {
+	MaxStreams int `json:"max_streams"` // Max concurrent streams for users of the profile, 0 for no limit.
+	MaxDevices int `json:"max_devices"` // Max signed in devices for users of the profile, 0 for no limit.
+}
+
+type watchTimeDayDTO struct {
+	Date    string `json:"date" example:"2023-06-01"`
+	Seconds int64  `json:"seconds"`
+}
+
+type watchTimeClientDTO struct {
+	Client  string `json:"client" example:"Jellyfin Web"`
+	Seconds int64  `json:"seconds"`
+}
+
+type watchTimeDTO struct {
+	Total   int64                `json:"total"`   // Total seconds watched over the period.
+	Days    []watchTimeDayDTO    `json:"days"`    // Seconds watched each day, oldest first.
+	Clients []watchTimeClientDTO `json:"clients"` // Clients used, most used first.
+}
+
+type watchTimeUserDTO struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Seconds int64  `json:"seconds"`
+}
+
+type topWatchersDTO struct {
+	Users []watchTimeUserDTO `json:"users"` // Most watched first.
+}
+
+type notificationRoutesDTO struct {
+	Events []string                             `json:"events"` // Events which can be routed.
+	Types  []string                             `json:"types"`  // Destination types usable with the enabled messaging methods.
+	Routes map[string][]NotificationDestination `json:"routes"` // Events to their destinations.
+}
+
+type setNotificationRouteDTO struct {
+	Destinations []NotificationDestination `json:"destinations"`
 }
 
 type ActivityDTO struct {
@@ -474,3 +697,142 @@ type CreateBackupDTO struct {
 type GetBackupsDTO struct {
 	Backups []CreateBackupDTO `json:"backups"`
 }
+
+type totpLoginDTO struct {
+	Challenge string `json:"challenge" binding:"required"` // TOTP challenge returned by /token/login.
+	Code      string `json:"code" binding:"required"`      // TOTP code, or a recovery code.
+}
+
+type totpCodeDTO struct {
+	Code string `json:"code" example:"123456"` // TOTP code.
+}
+
+type totpStatusDTO struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type totpEnrolDTO struct {
+	Secret string `json:"secret"` // Base32 encoded secret, for manual entry.
+	URL    string `json:"url"`    // otpauth:// URL, for QR codes.
+}
+
+type totpRecoveryCodesDTO struct {
+	Codes []string `json:"codes"` // Single-use recovery codes. These are not stored, so must be saved by the user.
+}
+
+type passkeyDTO struct {
+	ID       string `json:"id"` // Base64url encoded credential ID.
+	Name     string `json:"name"`
+	Added    int64  `json:"added"`
+	LastUsed int64  `json:"last_used"` // 0 if never used.
+}
+
+type passkeysDTO struct {
+	Passkeys []passkeyDTO `json:"passkeys"`
+}
+
+type newPasskeyDTO struct {
+	Name string `json:"name" example:"Phone"` // Name to identify the passkey by.
+}
+
+type passkeyBeginDTO struct {
+	Session string      `json:"session"` // ID to pass to the corresponding finish endpoint.
+	Options interface{} `json:"options"` // Options for navigator.credentials.create()/get().
+}
+
+type loginBanDTO struct {
+	Key     string `json:"key" example:"ip:1.2.3.4"` // "ip:<address>" or "user:<username>".
+	Until   int64  `json:"until"`                    // Unix timestamp of when the lockout ends.
+	Strikes int    `json:"strikes"`                  // Number of lockouts in a row, which determines the lockout duration.
+}
+
+type loginBansDTO struct {
+	Bans []loginBanDTO `json:"bans"`
+}
+
+type sessionDTO struct {
+	ID          string `json:"id"`
+	JellyfinID  string `json:"jellyfin_id"`
+	Username    string `json:"username"`
+	Admin       bool   `json:"admin"` // Whether this is an admin page session, rather than a user page one.
+	UserAgent   string `json:"user_agent"`
+	IP          string `json:"ip"` // Blank if IP logging is disabled.
+	Created     int64  `json:"created"`
+	LastRefresh int64  `json:"last_refresh"`
+	Current     bool   `json:"current"` // Whether this is the requester's session.
+}
+
+type sessionsDTO struct {
+	Sessions []sessionDTO `json:"sessions"`
+}
+
+type myDeviceDTO struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Client        string `json:"client"`
+	ClientVersion string `json:"client_version"`
+	LastSeen      int64  `json:"last_seen"`
+	IP            string `json:"ip"`     // Only known for devices currently connected.
+	Active        bool   `json:"active"` // Whether the device currently has a session open on Jellyfin.
+}
+
+type myDevicesDTO struct {
+	Devices []myDeviceDTO `json:"devices"`
+}
+
+type referralNodeDTO struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`                  // Blank if the user has been deleted.
+	Status          string            `json:"status"`                // "active", "disabled" (including expired) or "deleted".
+	Referred        int64             `json:"referred,omitempty"`    // When the user was referred, if they were.
+	RewardDays      int               `json:"reward_days,omitempty"` // Days given to the referrer for this user.
+	DirectReferrals int               `json:"direct_referrals"`
+	TotalReferrals  int               `json:"total_referrals"`  // All descendants, including those below the requested depth.
+	ActiveReferrals int               `json:"active_referrals"` // Active descendants, including those below the requested depth.
+	Referrals       []referralNodeDTO `json:"referrals"`        // Children, up to the requested depth.
+}
+
+type referralTreeDTO struct {
+	Referrer *referralNodeDTO `json:"referrer"` // User who referred this one, if any.
+	User     referralNodeDTO  `json:"user"`
+}
+
+type extensionCodeDTO struct {
+	Code          string `json:"code"`
+	Created       int64  `json:"created"`
+	Label         string `json:"label,omitempty"`
+	Days          int    `json:"days"`
+	RemainingUses int    `json:"remaining_uses"`
+	NoLimit       bool   `json:"no_limit"`
+	Profile       string `json:"profile,omitempty"` // Only users with this profile can redeem the code.
+	Redeemed      int    `json:"redeemed"`          // Number of times the code has been redeemed.
+}
+
+type extensionCodesDTO struct {
+	Codes []extensionCodeDTO `json:"codes"`
+}
+
+type generateExtensionCodesDTO struct {
+	Days    int    `json:"days" example:"30"` // Days added to the user's expiry.
+	Uses    int    `json:"uses" example:"1"`  // Times each code can be used, ignored if NoLimit.
+	NoLimit bool   `json:"no_limit"`
+	Profile string `json:"profile"` // Restrict codes to users with this profile.
+	Label   string `json:"label"`
+	Count   int    `json:"count" example:"1"` // Number of codes to generate, max 100.
+}
+
+type redeemCodeDTO struct {
+	Code string `json:"code"`
+}
+
+type redeemCodeLoginDTO struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type redeemCodeRespDTO struct {
+	Days   int   `json:"days"`
+	Expiry int64 `json:"expiry"` // New expiry as Unix time.
+}
@@ -132,13 +132,64 @@ func (ombi *Ombi) put(url string, data map[string]interface{}, response bool) (s
 	return ombi.send("PUT", url, data, response, nil)
 }
 
+// Name returns the name of the backend, "ombi".
+func (ombi *Ombi) Name() string { return "ombi" }
+
+// Ombi user types, as found in the "userType" field.
+const (
+	userTypeLocal       = 1
+	userTypePlex        = 2
+	userTypeEmby        = 3
+	userTypeEmbyConnect = 4
+	userTypeJellyfin    = 5
+)
+
+// converts Ombi's representation of a user to a common.RequestManagerUser.
+func toUser(data map[string]interface{}) (user common.RequestManagerUser) {
+	user.Raw = data
+	if id, ok := data["id"].(string); ok {
+		user.ID = id
+	}
+	if name, ok := data["userName"].(string); ok {
+		user.Name = name
+	}
+	if email, ok := data["emailAddress"].(string); ok {
+		user.Email = email
+	}
+	// JSON numbers are decoded as float64.
+	if uType, ok := data["userType"].(float64); ok {
+		switch int(uType) {
+		case userTypeJellyfin:
+			user.Source = "jellyfin"
+		case userTypeEmby, userTypeEmbyConnect:
+			user.Source = "emby"
+		}
+	}
+	return
+}
+
+// converts a common.RequestManagerUser back to Ombi's representation, applying any changes.
+func fromUser(user common.RequestManagerUser) map[string]interface{} {
+	data := map[string]interface{}{}
+	for k, v := range user.Raw {
+		data[k] = v
+	}
+	data["id"] = user.ID
+	data["userName"] = user.Name
+	data["emailAddress"] = user.Email
+	if user.Password != "" {
+		data["password"] = user.Password
+	}
+	return data
+}
+
 // ModifyUser applies the given modified user object to the corresponding user.
-func (ombi *Ombi) ModifyUser(user map[string]interface{}) (status int, err error) {
-	if _, ok := user["id"]; !ok {
+func (ombi *Ombi) ModifyUser(user common.RequestManagerUser) (status int, err error) {
+	if user.ID == "" {
 		err = fmt.Errorf("No ID provided")
 		return
 	}
-	_, status, err = ombi.put(ombi.server+"/api/v1/Identity/", user, false)
+	_, status, err = ombi.put(ombi.server+"/api/v1/Identity/", fromUser(user), false)
 	return
 }
 
@@ -155,26 +206,38 @@ func (ombi *Ombi) DeleteUser(id string) (code int, err error) {
 	return resp.StatusCode, err
 }
 
-// UserByID returns the user corresponding to the provided ID.
-func (ombi *Ombi) UserByID(id string) (result map[string]interface{}, code int, err error) {
+// returns Ombi's representation of the user corresponding to the provided ID.
+func (ombi *Ombi) userByID(id string) (result map[string]interface{}, code int, err error) {
 	resp, code, err := ombi.getJSON(fmt.Sprintf("%s/api/v1/Identity/User/%s", ombi.server, id), nil)
 	json.Unmarshal([]byte(resp), &result)
 	return
 }
 
+// UserByID returns the user corresponding to the provided ID.
+func (ombi *Ombi) UserByID(id string) (common.RequestManagerUser, int, error) {
+	result, code, err := ombi.userByID(id)
+	return toUser(result), code, err
+}
+
 // GetUsers returns all users on the Ombi instance.
-func (ombi *Ombi) GetUsers() ([]map[string]interface{}, int, error) {
+func (ombi *Ombi) GetUsers() ([]common.RequestManagerUser, int, error) {
+	code := 200
+	var err error
 	if time.Now().After(ombi.cacheExpiry) {
-		resp, code, err := ombi.getJSON(fmt.Sprintf("%s/api/v1/Identity/Users", ombi.server), nil)
+		var resp string
+		resp, code, err = ombi.getJSON(fmt.Sprintf("%s/api/v1/Identity/Users", ombi.server), nil)
 		var result []map[string]interface{}
 		json.Unmarshal([]byte(resp), &result)
 		ombi.userCache = result
 		if (code == 200 || code == 204) && err == nil {
 			ombi.cacheExpiry = time.Now().Add(time.Minute * time.Duration(ombi.cacheLength))
 		}
-		return result, code, err
 	}
-	return ombi.userCache, 200, nil
+	users := make([]common.RequestManagerUser, len(ombi.userCache))
+	for i, data := range ombi.userCache {
+		users[i] = toUser(data)
+	}
+	return users, code, err
 }
 
 // Strip these from a user when saving as a template.
@@ -191,7 +254,7 @@ var stripFromOmbi = []string{
 
 // TemplateByID returns a template based on the user corresponding to the provided ID's settings.
 func (ombi *Ombi) TemplateByID(id string) (result map[string]interface{}, code int, err error) {
-	result, code, err = ombi.UserByID(id)
+	result, code, err = ombi.userByID(id)
 	if err != nil || code != 200 {
 		return
 	}
@@ -236,8 +299,26 @@ type NotificationPref struct {
 	Enabled bool   `json:"enabled"`
 }
 
-func (ombi *Ombi) SetNotificationPrefs(user map[string]interface{}, discordID, telegramUser string) (result string, code int, err error) {
-	id := user["id"].(string)
+// ApplyTemplate applies the settings in the given template to an existing user.
+func (ombi *Ombi) ApplyTemplate(user common.RequestManagerUser, template map[string]interface{}) (status int, err error) {
+	data := fromUser(user)
+	for k, v := range template {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			data[k] = v
+		default:
+			if v != data[k] {
+				data[k] = v
+			}
+		}
+	}
+	_, status, err = ombi.put(ombi.server+"/api/v1/Identity/", data, false)
+	return
+}
+
+// SetNotificationPrefs links the given Discord ID and Telegram username to the user.
+func (ombi *Ombi) SetNotificationPrefs(user common.RequestManagerUser, discordID, telegramUser string) (result string, code int, err error) {
+	id := user.ID
 	url := fmt.Sprintf("%s/api/v1/Identity/NotificationPreferences", ombi.server)
 	data := []NotificationPref{}
 	if discordID != "" {
@@ -246,6 +327,6 @@ func (ombi *Ombi) SetNotificationPrefs(user map[string]interface{}, discordID, t
 	if telegramUser != "" {
 		data = append(data, NotificationPref{NotifAgentTelegram, id, telegramUser, true})
 	}
-	result, code, err = ombi.send("POST", url, data, true, map[string]string{"UserName": user["userName"].(string)})
+	result, code, err = ombi.send("POST", url, data, true, map[string]string{"UserName": user.Name})
 	return
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// PasskeyCredential is a WebAuthn credential registered by a user.
+type PasskeyCredential struct {
+	Name       string
+	Added      time.Time
+	LastUsed   time.Time
+	Credential webauthn.Credential
+}
+
+// Passkeys stores the WebAuthn credentials registered by a user, keyed by their Jellyfin ID.
+type Passkeys struct {
+	JellyfinID  string `badgerhold:"key"`
+	Credentials []PasskeyCredential
+}
+
+// passkeyUser implements webauthn.User. The Jellyfin ID is used as the user handle, so discoverable credentials can be looked up directly.
+type passkeyUser struct {
+	name     string
+	passkeys Passkeys
+}
+
+func (u passkeyUser) WebAuthnID() []byte          { return []byte(u.passkeys.JellyfinID) }
+func (u passkeyUser) WebAuthnName() string        { return u.name }
+func (u passkeyUser) WebAuthnDisplayName() string { return u.name }
+func (u passkeyUser) WebAuthnIcon() string        { return "" }
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.passkeys.Credentials))
+	for i, c := range u.passkeys.Credentials {
+		creds[i] = c.Credential
+	}
+	return creds
+}
+
+// passkeySession is an in-progress registration or login ceremony.
+type passkeySession struct {
+	data webauthn.SessionData
+	jfID string // User registering a credential. Blank for logins.
+	name string // Name for the credential being registered.
+}
+
+// Most ceremonies that can be in progress at once, as logins can be started by anyone.
+const PASSKEY_MAX_SESSIONS = 1000
+
+// PasskeySessions holds in-progress ceremonies, keyed by a random ID given to the client.
+type PasskeySessions struct {
+	sessions map[string]passkeySession
+	lock     sync.Mutex
+}
+
+// Add stores the session and returns its ID. Expired sessions are only cleared out once PASSKEY_MAX_SESSIONS is reached,
+// and ok is false if there are still too many pending.
+func (ps *PasskeySessions) Add(s passkeySession) (id string, ok bool) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if ps.sessions == nil {
+		ps.sessions = map[string]passkeySession{}
+	}
+	if len(ps.sessions) >= PASSKEY_MAX_SESSIONS {
+		now := time.Now()
+		for id, session := range ps.sessions {
+			if now.After(session.data.Expires) {
+				delete(ps.sessions, id)
+			}
+		}
+		if len(ps.sessions) >= PASSKEY_MAX_SESSIONS {
+			return "", false
+		}
+	}
+	id = shortuuid.New()
+	ps.sessions[id] = s
+	return id, true
+}
+
+// Pop returns and removes the session with the given ID. Sessions can only be used once.
+func (ps *PasskeySessions) Pop(id string) (passkeySession, bool) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	s, ok := ps.sessions[id]
+	delete(ps.sessions, id)
+	if ok && time.Now().After(s.data.Expires) {
+		ok = false
+	}
+	return s, ok
+}
+
+// loadWebAuthn sets up WebAuthn if enabled. Requires Jellyfin login, as credentials are stored by Jellyfin ID.
+func (app *appContext) loadWebAuthn() {
+	app.webauthn = nil
+	if !app.config.Section("webauthn").Key("enabled").MustBool(false) {
+		return
+	}
+	if !app.jellyfinLogin {
+		app.err.Println("Passkeys require Jellyfin Login to be enabled.")
+		return
+	}
+	origins := []string{}
+	for _, origin := range strings.Split(app.config.Section("webauthn").Key("origins").String(), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          app.config.Section("webauthn").Key("rp_id").String(),
+		RPDisplayName: "jfa-go",
+		RPOrigins:     origins,
+		// Sessions need an expiry for PasskeySessions to clean them up.
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true},
+			Registration: webauthn.TimeoutConfig{Enforce: true},
+		},
+	})
+	if err != nil {
+		app.err.Printf("Failed to initialize passkeys: %v", err)
+		return
+	}
+	app.webauthn = w
+}
+
+// getPasskeyUser returns a webauthn.User for the given Jellyfin user.
+func (app *appContext) getPasskeyUser(jfID string) (passkeyUser, error) {
+	user, status, err := app.jf.UserByID(jfID, false)
+	if status != 200 || err != nil {
+		return passkeyUser{}, fmt.Errorf("failed to get user (%d): %v", status, err)
+	}
+	passkeys, ok := app.storage.GetPasskeysKey(jfID)
+	if !ok {
+		passkeys = Passkeys{JellyfinID: jfID}
+	}
+	return passkeyUser{name: user.Name, passkeys: passkeys}, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/hrfee/mediabrowser"
+)
+
+const (
+	testRPID   = "jfa.test"
+	testOrigin = "https://jfa.test"
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding a single P-256 credential.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id}
+}
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func clientData(typ string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create responds to the options from navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, options protocol.CredentialCreation) []byte {
+	// User IDs are sent to the browser base64url-encoded.
+	a.userHandle, _ = base64.RawURLEncoding.DecodeString(options.Response.User.ID.(string))
+	key, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // Zero AAGUID.
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(append(attested, a.id...), key...)
+	// User present, user verified, attested credential data included.
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData("webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return resp
+}
+
+// get responds to the options from navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, options protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authData(0x05, nil)
+	cd := clientData("webauthn.get", options.Response.Challenge)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(cd),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return resp
+}
+
+func newPasskeyTestApp(t *testing.T) *appContext {
+	app, jf := newTestApp(t, "[webauthn]\nenabled = true\nrp_id = "+testRPID+"\norigins = "+testOrigin+"\n")
+	app.loadWebAuthn()
+	if app.webauthn == nil {
+		t.Fatal("WebAuthn not loaded")
+	}
+	jf.addUser(mediabrowser.User{ID: "admin", Name: "admin", Policy: mediabrowser.Policy{IsAdministrator: true}})
+	jf.addUser(mediabrowser.User{ID: "user", Name: "user"})
+	return app
+}
+
+// registerPasskey runs a registration ceremony for the given user through the handlers.
+func registerPasskey(t *testing.T, app *appContext, jfID string) *softAuthenticator {
+	gc, w := testContext("POST", "/my/passkeys/begin", newPasskeyDTO{Name: "Test"})
+	gc.Set("jfId", jfID)
+	app.BeginMyPasskeyRegistration(gc)
+	if w.Code != 200 {
+		t.Fatalf("begin registration: %d %s", w.Code, w.Body)
+	}
+	var begin struct {
+		Session string                      `json:"session"`
+		Options protocol.CredentialCreation `json:"options"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &begin)
+
+	auth := newSoftAuthenticator(t)
+	gc, w = testContext("POST", "/my/passkeys/finish/"+begin.Session, auth.create(t, begin.Options))
+	gc.Set("jfId", jfID)
+	gc.AddParam("session", begin.Session)
+	app.FinishMyPasskeyRegistration(gc)
+	if w.Code != 200 {
+		t.Fatalf("finish registration: %d %s", w.Code, w.Body)
+	}
+	return auth
+}
+
+// loginPasskey runs a login ceremony through the handlers, returning the final response.
+func loginPasskey(t *testing.T, app *appContext, auth *softAuthenticator, userpage bool) (int, getTokenDTO) {
+	gc, w := testContext("POST", "/token/passkey/begin", nil)
+	app.BeginPasskeyLogin(gc)
+	if w.Code != 200 {
+		t.Fatalf("begin login: %d %s", w.Code, w.Body)
+	}
+	var begin struct {
+		Session string                       `json:"session"`
+		Options protocol.CredentialAssertion `json:"options"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &begin)
+
+	gc, w = testContext("POST", "/token/passkey/finish/"+begin.Session, auth.get(t, begin.Options))
+	gc.AddParam("session", begin.Session)
+	if userpage {
+		app.FinishUserPasskeyLogin(gc)
+	} else {
+		app.FinishPasskeyLogin(gc)
+	}
+	var resp getTokenDTO
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	auth := registerPasskey(t, app, "user")
+
+	passkeys, ok := app.storage.GetPasskeysKey("user")
+	if !ok || len(passkeys.Credentials) != 1 || passkeys.Credentials[0].Name != "Test" {
+		t.Fatalf("passkey not stored: %+v", passkeys)
+	}
+
+	status, resp := loginPasskey(t, app, auth, true)
+	if status != 200 || resp.Token == "" {
+		t.Fatalf("user login: %d %+v", status, resp)
+	}
+	passkeys, _ = app.storage.GetPasskeysKey("user")
+	if passkeys.Credentials[0].LastUsed.IsZero() || passkeys.Credentials[0].Credential.Authenticator.SignCount != 1 {
+		t.Errorf("passkey use not recorded: %+v", passkeys.Credentials[0])
+	}
+
+	// Non-admins can't use their passkey for the admin page.
+	if status, _ := loginPasskey(t, app, auth, false); status != 401 {
+		t.Errorf("non-admin got %d for admin login", status)
+	}
+}
+
+func TestPasskeyLoginRejectsUnknownCredential(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	registerPasskey(t, app, "user")
+	stranger := newSoftAuthenticator(t)
+	stranger.userHandle = []byte("user")
+	if status, _ := loginPasskey(t, app, stranger, true); status != 401 {
+		t.Errorf("unknown credential got %d", status)
+	}
+}
+
+func TestPasskeyAdminLoginRequiresTOTP(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	auth := registerPasskey(t, app, "admin")
+
+	status, resp := loginPasskey(t, app, auth, false)
+	if status != 200 || resp.Token == "" {
+		t.Fatalf("admin login without TOTP: %d %+v", status, resp)
+	}
+
+	app.storage.SetAdminTOTPKey(adminTOTPKey("admin"), AdminTOTP{Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+	status, resp = loginPasskey(t, app, auth, false)
+	if status != 200 || resp.Token != "" || !resp.TOTPRequired || resp.TOTPChallenge == "" {
+		t.Errorf("admin login with TOTP enabled didn't give a challenge: %d %+v", status, resp)
+	}
+}
+
+func TestPasskeyLoginBeginLimits(t *testing.T) {
+	app := newPasskeyTestApp(t)
+	for i := 0; i < PASSKEY_MAX_SESSIONS; i++ {
+		if _, ok := app.passkeySessions.Add(passkeySession{data: webauthn.SessionData{Expires: time.Now().Add(time.Minute)}}); !ok {
+			t.Fatalf("session %d refused", i)
+		}
+	}
+	gc, w := testContext("POST", "/token/passkey/begin", nil)
+	app.BeginPasskeyLogin(gc)
+	if w.Code != 429 {
+		t.Errorf("expected 429 with too many pending sessions, got %d", w.Code)
+	}
+	// Expired sessions make room.
+	for id, session := range app.passkeySessions.sessions {
+		session.data.Expires = time.Now().Add(-time.Minute)
+		app.passkeySessions.sessions[id] = session
+		break
+	}
+	gc, w = testContext("POST", "/token/passkey/begin", nil)
+	app.BeginPasskeyLogin(gc)
+	if w.Code != 200 {
+		t.Errorf("expected 200 once a session expired, got %d", w.Code)
+	}
+
+	// Locked out IPs can't begin logins.
+	for i := 0; i < 100; i++ {
+		app.loginLimiter.Fail(BAN_IP_PREFIX + "192.0.2.1")
+	}
+	gc, w = testContext("POST", "/my/token/passkey/begin", nil)
+	app.BeginUserPasskeyLogin(gc)
+	if w.Code != 429 {
+		t.Errorf("expected 429 when locked out, got %d", w.Code)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// PaymentEventKind is what a payment provider's webhook means for the user's access.
+type PaymentEventKind int
+
+const (
+	PaymentIgnored      PaymentEventKind = iota // Irrelevant event type.
+	PaymentCheckout                             // First payment, e.g. a completed checkout.
+	PaymentRenewal                              // Subsequent payment, e.g. a paid subscription invoice.
+	PaymentCancellation                         // Subscription ended.
+)
+
+func (k PaymentEventKind) String() string {
+	switch k {
+	case PaymentCheckout:
+		return "checkout"
+	case PaymentRenewal:
+		return "renewal"
+	case PaymentCancellation:
+		return "cancellation"
+	}
+	return "ignored"
+}
+
+// PaymentEvent is a provider's webhook, normalized.
+type PaymentEvent struct {
+	ID         string // Identifies the payment, so one reported by several webhooks is only applied once.
+	Kind       PaymentEventKind
+	CustomerID string
+	Email      string
+	Metadata   map[string]string
+	PeriodEnd  time.Time // End of the paid period, if given.
+}
+
+// PaymentProvider verifies and parses webhooks from a payment provider.
+type PaymentProvider interface {
+	Name() string
+	// Verify checks the webhook was signed by the provider.
+	Verify(header http.Header, body []byte) error
+	// Parse converts the webhook body into a PaymentEvent.
+	Parse(body []byte) (PaymentEvent, error)
+}
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// PaymentCustomer links a provider's customer to a Jellyfin user, once they've been matched.
+type PaymentCustomer struct {
+	CustomerID string `badgerhold:"key"` // Prefixed with the provider name.
+	JellyfinID string `badgerhold:"index"`
+	Created    time.Time
+	ByEmail    bool // Matched by the email the customer gave the provider, which anyone could have used.
+}
+
+// PaymentEventRecord stores the IDs of handled payments, so retries and duplicate webhooks aren't applied twice.
+type PaymentEventRecord struct {
+	ID   string `badgerhold:"key"`
+	Time time.Time
+}
+
+// Handled webhook IDs are kept for this long, longer than providers retry for.
+const PAYMENT_EVENT_RETENTION_DAYS = 30
+
+// loadPaymentProvider sets up the provider in the "payments" section, if enabled.
+func (app *appContext) loadPaymentProvider() {
+	section := app.config.Section("payments")
+	if !section.Key("enabled").MustBool(false) {
+		return
+	}
+	secret := section.Key("webhook_secret").String()
+	if secret == "" {
+		app.err.Println("Payments: Webhook secret not set, disabling")
+		return
+	}
+	switch section.Key("provider").MustString("stripe") {
+	case "stripe":
+		app.payments = NewStripeProvider(secret, 5*time.Minute)
+	default:
+		app.err.Printf("Payments: Unknown provider \"%s\"", section.Key("provider").String())
+	}
+}
+
+// StripeProvider handles Stripe webhooks. Other providers which sign webhooks the same way can use it too.
+type StripeProvider struct {
+	secret    []byte
+	tolerance time.Duration
+}
+
+func NewStripeProvider(secret string, tolerance time.Duration) *StripeProvider {
+	return &StripeProvider{secret: []byte(secret), tolerance: tolerance}
+}
+
+func (sp *StripeProvider) Name() string { return "stripe" }
+
+// Verify checks the "Stripe-Signature" header, in the form "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">[,v1=...]".
+func (sp *StripeProvider) Verify(header http.Header, body []byte) error {
+	var timestamp string
+	signatures := [][]byte{}
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if key == "t" {
+			timestamp = value
+		} else if key == "v1" {
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(t, 0)); age > sp.tolerance || age < -sp.tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	mac := hmac.New(sha256.New, sp.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object stripeObject `json:"object"`
+	} `json:"data"`
+}
+
+// stripeObject holds the fields we use from checkout sessions, invoices and subscriptions.
+type stripeObject struct {
+	ID              string `json:"id"`
+	Customer        string `json:"customer"`
+	CustomerEmail   string `json:"customer_email"`
+	CustomerDetails struct {
+		Email string `json:"email"`
+	} `json:"customer_details"`
+	Metadata            map[string]string `json:"metadata"`
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+	CurrentPeriodEnd int64 `json:"current_period_end"`
+	Lines            struct {
+		Data []struct {
+			Period struct {
+				End int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+func (sp *StripeProvider) Parse(body []byte) (PaymentEvent, error) {
+	var ev stripeEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return PaymentEvent{}, err
+	}
+	obj := ev.Data.Object
+	event := PaymentEvent{
+		ID:         ev.ID,
+		CustomerID: obj.Customer,
+		Email:      obj.CustomerEmail,
+		Metadata:   obj.Metadata,
+	}
+	if obj.CustomerDetails.Email != "" {
+		event.Email = obj.CustomerDetails.Email
+	}
+	if len(event.Metadata) == 0 {
+		event.Metadata = obj.SubscriptionDetails.Metadata
+	}
+	switch ev.Type {
+	case "checkout.session.completed":
+		event.Kind = PaymentCheckout
+	case "invoice.paid", "invoice.payment_succeeded":
+		// Both are sent for the same invoice, so key on it instead of the event.
+		event.Kind = PaymentRenewal
+		if obj.ID != "" {
+			event.ID = "invoice:" + obj.ID
+		}
+		for _, line := range obj.Lines.Data {
+			if end := time.Unix(line.Period.End, 0); line.Period.End != 0 && end.After(event.PeriodEnd) {
+				event.PeriodEnd = end
+			}
+		}
+	case "customer.subscription.deleted":
+		event.Kind = PaymentCancellation
+		if obj.CurrentPeriodEnd != 0 {
+			event.PeriodEnd = time.Unix(obj.CurrentPeriodEnd, 0)
+		}
+	}
+	return event, nil
+}
+
+// matchPaymentCustomer finds the Jellyfin user a payment event belongs to,
+// first through previously matched customers, then by email or metadata depending on the "match_by" setting.
+// trusted is false if the user was matched by email, as providers don't verify the email a customer gives.
+func (app *appContext) matchPaymentCustomer(event PaymentEvent) (user mediabrowser.User, trusted bool, found bool) {
+	customerKey := app.payments.Name() + ":" + event.CustomerID
+	if event.CustomerID != "" {
+		customer := PaymentCustomer{}
+		if err := app.storage.db.Get(customerKey, &customer); err == nil {
+			if user, status, err := app.jf.UserByID(customer.JellyfinID, false); status == 200 && err == nil {
+				return user, !customer.ByEmail, true
+			}
+		}
+	}
+	section := app.config.Section("payments")
+	byEmail := section.Key("match_by").MustString("email") != "metadata"
+	if !byEmail {
+		value := event.Metadata[section.Key("metadata_key").MustString("jellyfin_username")]
+		if value != "" {
+			var status int
+			var err error
+			user, status, err = app.jf.UserByID(value, false)
+			if status != 200 || err != nil {
+				user, status, err = app.jf.UserByName(value, false)
+			}
+			found = status == 200 && err == nil
+		}
+	} else if event.Email != "" {
+		for _, email := range app.storage.GetEmails() {
+			if strings.EqualFold(email.Addr, event.Email) {
+				var status int
+				var err error
+				user, status, err = app.jf.UserByID(email.JellyfinID, false)
+				found = status == 200 && err == nil
+				break
+			}
+		}
+	}
+	if found && event.CustomerID != "" {
+		app.storage.db.Upsert(customerKey, PaymentCustomer{CustomerID: customerKey, JellyfinID: user.ID, Created: time.Now(), ByEmail: byEmail})
+	}
+	return user, !byEmail, found
+}
+
+// applyPaymentEvent extends, re-enables or disables the user according to the event.
+// Cancellations are ignored for untrusted matches, as anyone could otherwise cancel a subscription made with another user's email to disable them.
+// Their access still runs out at the end of the last paid period.
+func (app *appContext) applyPaymentEvent(gc *gin.Context, event PaymentEvent, user mediabrowser.User, trusted bool) error {
+	section := app.config.Section("payments")
+	grace := time.Duration(section.Key("grace_days").MustInt(1)) * 24 * time.Hour
+	now := time.Now()
+	current, hasExpiry := app.storage.GetUserExpiryKey(user.ID)
+	switch event.Kind {
+	case PaymentCheckout, PaymentRenewal:
+		expiry := now
+		if hasExpiry && current.Expiry.After(expiry) {
+			expiry = current.Expiry
+		}
+		if !event.PeriodEnd.IsZero() {
+			if end := event.PeriodEnd.Add(grace); end.After(expiry) {
+				expiry = end
+			}
+		} else {
+			expiry = expiry.AddDate(0, 0, section.Key("extend_days").MustInt(31))
+		}
+		app.storage.SetUserExpiryKey(user.ID, UserExpiry{Expiry: expiry})
+		app.info.Printf("Payments: Extended expiry of \"%s\" to %s", user.Name, expiry.Format(time.RFC3339))
+		if user.Policy.IsDisabled && app.disabledByDaemon(user.ID) {
+			errs := app.enableDisableUsers(gc, enableDisableUserDTO{Users: []string{user.ID}, Enabled: true}, ActivityDaemon)
+			if len(errs["GetUser"]) != 0 || len(errs["SetPolicy"]) != 0 {
+				return fmt.Errorf("failed to re-enable user: %v", errs)
+			}
+		}
+	case PaymentCancellation:
+		if !trusted {
+			app.info.Printf("Payments: Ignoring cancellation for \"%s\", as they were matched by email", user.Name)
+			return nil
+		}
+		if section.Key("on_cancel").MustString("expire") == "disable" {
+			app.storage.DeleteUserExpiryKey(user.ID)
+			errs := app.enableDisableUsers(gc, enableDisableUserDTO{Users: []string{user.ID}, Enabled: false}, ActivityDaemon)
+			if len(errs["GetUser"]) != 0 || len(errs["SetPolicy"]) != 0 {
+				return fmt.Errorf("failed to disable user: %v", errs)
+			}
+			app.info.Printf("Payments: Disabled \"%s\" after cancellation", user.Name)
+		} else {
+			// Let them keep what they've paid for, but no more.
+			expiry := now
+			if !event.PeriodEnd.IsZero() {
+				expiry = event.PeriodEnd
+			}
+			if hasExpiry && current.Expiry.Before(expiry) {
+				expiry = current.Expiry
+			}
+			app.storage.SetUserExpiryKey(user.ID, UserExpiry{Expiry: expiry})
+			app.info.Printf("Payments: \"%s\" will expire at %s after cancellation", user.Name, expiry.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// @Summary Receive a payment provider webhook, extending or disabling the customer's account.
+// @Produce json
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} stringResponse
+// @Failure 500 {object} stringResponse
+// @Router /payments/webhook [post]
+// @tags Other
+func (app *appContext) PaymentWebhook(gc *gin.Context) {
+	body, err := gc.GetRawData()
+	if err != nil {
+		respond(400, "Couldn't read body", gc)
+		return
+	}
+	if err := app.payments.Verify(gc.Request.Header, body); err != nil {
+		app.info.Printf("Payments: Rejected webhook from %s: %v", gc.ClientIP(), err)
+		respond(400, "Invalid signature", gc)
+		return
+	}
+	event, err := app.payments.Parse(body)
+	if err != nil || event.ID == "" {
+		app.err.Printf("Payments: Failed to parse webhook: %v", err)
+		respond(400, "Invalid payload", gc)
+		return
+	}
+	if event.Kind == PaymentIgnored {
+		respondBool(200, true, gc)
+		return
+	}
+	// Claim the event before applying it, so a duplicate delivered at the same time is ignored too.
+	if err := app.storage.db.Insert(event.ID, PaymentEventRecord{ID: event.ID, Time: time.Now()}); err == badgerhold.ErrKeyExists {
+		app.debug.Printf("Payments: Ignoring already handled event \"%s\"", event.ID)
+		respondBool(200, true, gc)
+		return
+	} else if err != nil {
+		app.err.Printf("Payments: Failed to store event \"%s\": %v", event.ID, err)
+		respond(500, "Failed to store event", gc)
+		return
+	}
+	user, trusted, ok := app.matchPaymentCustomer(event)
+	if !ok {
+		// Retrying won't help, so don't tell the provider to.
+		app.err.Printf("Payments: Couldn't match %s event \"%s\" to a user (customer \"%s\", email \"%s\")", event.Kind, event.ID, event.CustomerID, event.Email)
+		respondBool(200, true, gc)
+		return
+	}
+	if err := app.applyPaymentEvent(gc, event, user, trusted); err != nil {
+		app.err.Printf("Payments: Failed to apply %s event \"%s\" to \"%s\": %v", event.Kind, event.ID, user.Name, err)
+		// Let the provider's retry try again.
+		app.storage.db.Delete(event.ID, PaymentEventRecord{})
+		respond(500, "Failed to apply event", gc)
+		return
+	}
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityPayment,
+		UserID:     user.ID,
+		SourceType: ActivityDaemon,
+		Source:     app.payments.Name(),
+		Value:      event.Kind.String(),
+		Time:       time.Now(),
+	}, nil, false)
+	respondBool(200, true, gc)
+}
+
+// clearPaymentEvents forgets handled webhooks old enough not to be retried.
+func (app *appContext) clearPaymentEvents() {
+	app.debug.Println("Housekeeping: Clearing old payment events")
+	err := app.storage.db.DeleteMatching(&PaymentEventRecord{}, badgerhold.Where("Time").Lt(time.Now().AddDate(0, 0, -PAYMENT_EVENT_RETENTION_DAYS)))
+	if err != nil {
+		app.err.Printf("Failed to clear old payment events: %v", err)
+	}
+}
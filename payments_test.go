@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// newPaymentTestApp returns an app with Stripe payments set up, and a user "user" with the email in the recorded payloads.
+func newPaymentTestApp(t *testing.T, config string) (*appContext, *fakeJellyfin) {
+	app, jf := newTestApp(t, "[payments]\nenabled = true\nwebhook_secret = "+testWebhookSecret+"\n"+config)
+	app.loadPaymentProvider()
+	jf.addUser(mediabrowser.User{ID: "user", Name: "user"})
+	app.storage.SetEmailsKey("user", EmailAddress{Addr: "user@example.com", Contact: true})
+	return app, jf
+}
+
+// sendWebhook posts a recorded Stripe payload from testdata/stripe, signed as Stripe would.
+func sendWebhook(t *testing.T, app *appContext, name string) int {
+	body, err := os.ReadFile(filepath.Join("testdata", "stripe", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := fmt.Sprint(time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	gc, w := testContext("POST", "/payments/webhook", body)
+	gc.Request.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	app.PaymentWebhook(gc)
+	return w.Code
+}
+
+func TestStripeRejectsBadSignature(t *testing.T) {
+	app, _ := newPaymentTestApp(t, "")
+	gc, w := testContext("POST", "/payments/webhook", []byte(`{"id":"evt_1","type":"invoice.paid"}`))
+	gc.Request.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=00", time.Now().Unix()))
+	app.PaymentWebhook(gc)
+	if w.Code != 400 {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestStripeInvoiceAppliedOnce(t *testing.T) {
+	app, _ := newPaymentTestApp(t, "extend_days = 31\n")
+	for _, name := range []string{"invoice.paid", "invoice.payment_succeeded", "invoice.paid"} {
+		if status := sendWebhook(t, app, name); status != 200 {
+			t.Fatalf("%s: got %d", name, status)
+		}
+	}
+	expiry, ok := app.storage.GetUserExpiryKey("user")
+	if !ok {
+		t.Fatal("expiry not set")
+	}
+	if days := time.Until(expiry.Expiry).Hours() / 24; days < 30 || days > 32 {
+		t.Errorf("expected expiry in 31 days, got %.1f", days)
+	}
+}
+
+func TestStripeConcurrentDuplicatesAppliedOnce(t *testing.T) {
+	app, _ := newPaymentTestApp(t, "extend_days = 31\n")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status := sendWebhook(t, app, "invoice.paid"); status != 200 {
+				t.Errorf("got %d", status)
+			}
+		}()
+	}
+	wg.Wait()
+	expiry, ok := app.storage.GetUserExpiryKey("user")
+	if !ok {
+		t.Fatal("expiry not set")
+	}
+	if days := time.Until(expiry.Expiry).Hours() / 24; days < 30 || days > 32 {
+		t.Errorf("expected expiry in 31 days, got %.1f", days)
+	}
+}
+
+func TestStripeEmailMatchCannotCancel(t *testing.T) {
+	app, jf := newPaymentTestApp(t, "on_cancel = disable\n")
+	sendWebhook(t, app, "invoice.paid")
+	if status := sendWebhook(t, app, "customer.subscription.deleted"); status != 200 {
+		t.Fatalf("got %d", status)
+	}
+	if jf.users["user"].Policy.IsDisabled {
+		t.Error("user disabled by a customer matched only by email")
+	}
+	if _, ok := app.storage.GetUserExpiryKey("user"); !ok {
+		t.Error("expiry removed by a customer matched only by email")
+	}
+}
+
+func TestStripeMetadataMatchCancels(t *testing.T) {
+	app, jf := newPaymentTestApp(t, "match_by = metadata\non_cancel = disable\n")
+	if status := sendWebhook(t, app, "checkout.session.completed"); status != 200 {
+		t.Fatalf("checkout: got %d", status)
+	}
+	if _, ok := app.storage.GetUserExpiryKey("user"); !ok {
+		t.Fatal("checkout didn't set expiry")
+	}
+	if status := sendWebhook(t, app, "customer.subscription.deleted"); status != 200 {
+		t.Fatalf("cancellation: got %d", status)
+	}
+	if !jf.users["user"].Policy.IsDisabled {
+		t.Error("user not disabled after cancellation")
+	}
+	if _, ok := app.storage.GetUserExpiryKey("user"); ok {
+		t.Error("expiry not removed after cancellation")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Push services. Servers are set by the admin, users only give a topic, token or Apprise URL.
+const (
+	PushNtfy    = "ntfy"
+	PushGotify  = "gotify"
+	PushApprise = "apprise"
+)
+
+// Minimum time between PINs pushed to the same target.
+const PUSH_RESEND_INTERVAL = time.Minute
+
+// Key all pushed PINs are counted against in PushNotifier.globalLimiter.
+const PUSH_GLOBAL_LIMIT_KEY = "push:all"
+
+// ErrPINResentTooSoon is returned by PushNotifier.SendPIN if a PIN was pushed to the target within PUSH_RESEND_INTERVAL.
+var ErrPINResentTooSoon = errors.New("PIN already sent to this target recently")
+
+// Apprise URL schemes allowed if apprise_schemes isn't set. These services all have fixed hosts, so can't be used to reach the local network.
+const DEFAULT_APPRISE_SCHEMES = "discord,tgram,pover,pbul,slack,msteams"
+
+// PushTarget is where a user receives push notifications.
+type PushTarget struct {
+	JellyfinID string `badgerhold:"key"`
+	Service    string
+	Target     string // ntfy topic, Gotify application token or Apprise URL.
+	Token      string // ntfy access token, for protected topics.
+	Address    string `badgerhold:"index"` // "<Service>:<Target>", for require_unique.
+	Lang       string
+	Contact    bool // Whether to contact through push or not
+}
+
+// Display returns a name for the target which doesn't reveal tokens.
+func (pt PushTarget) Display() string {
+	switch pt.Service {
+	case PushNtfy:
+		return "ntfy: " + pt.Target
+	case PushApprise:
+		if scheme, _, ok := strings.Cut(pt.Target, "://"); ok {
+			return "Apprise: " + scheme
+		}
+		return "Apprise"
+	}
+	return "Gotify"
+}
+
+// ntfy topics are up to 64 characters, from the same set as URL-safe base64.
+var ntfyTopicRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+func pushAddress(service, target string) string {
+	return service + ":" + target
+}
+
+// UnverifiedPush is a target a verification PIN has been pushed to.
+type UnverifiedPush struct {
+	Target     PushTarget
+	Sent       time.Time
+	JellyfinID string
+}
+
+// PushNotifier sends messages through ntfy, Gotify or an Apprise API server.
+type PushNotifier struct {
+	ntfyServer    string
+	gotifyServer  string
+	appriseAPI    string
+	appriseURLs   map[string]bool // Apprise URL schemes users may give.
+	client        *http.Client
+	tokens        map[string]UnverifiedPush // Map of PINs to targets.
+	limiter       *LoginLimiter             // Counts PINs pushed per IP and user.
+	globalLimiter *LoginLimiter             // Counts all PINs pushed.
+	app           *appContext
+}
+
+func newPushNotifier(app *appContext) (*PushNotifier, error) {
+	section := app.config.Section("push")
+	pn := &PushNotifier{
+		ntfyServer:    strings.TrimSuffix(section.Key("ntfy_server").String(), "/"),
+		gotifyServer:  strings.TrimSuffix(section.Key("gotify_server").String(), "/"),
+		appriseAPI:    strings.TrimSuffix(section.Key("apprise_api").String(), "/"),
+		appriseURLs:   map[string]bool{},
+		client:        &http.Client{Timeout: 20 * time.Second},
+		tokens:        map[string]UnverifiedPush{},
+		limiter:       NewLoginLimiter(true, section.Key("pin_limit").MustInt(5), time.Hour, time.Hour, time.Hour),
+		globalLimiter: NewLoginLimiter(true, section.Key("pin_limit_global").MustInt(50), time.Hour, time.Hour, time.Hour),
+		app:           app,
+	}
+	for _, scheme := range strings.Split(section.Key("apprise_schemes").MustString(DEFAULT_APPRISE_SCHEMES), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			pn.appriseURLs[scheme] = true
+		}
+	}
+	if pn.appriseAPI != "" && len(pn.appriseURLs) == 0 {
+		app.err.Println("Push: No Apprise URL schemes are allowed, so Apprise won't be available.")
+	}
+	if app.proxyEnabled {
+		pn.client.Transport = app.proxyTransport
+	}
+	if len(pn.Services()) == 0 {
+		return nil, fmt.Errorf("no ntfy server, Gotify server or Apprise API URL was given")
+	}
+	return pn, nil
+}
+
+// Services returns the services a server has been set for.
+func (pn *PushNotifier) Services() []string {
+	services := []string{}
+	if pn.ntfyServer != "" {
+		services = append(services, PushNtfy)
+	}
+	if pn.gotifyServer != "" {
+		services = append(services, PushGotify)
+	}
+	if pn.appriseAPI != "" && len(pn.appriseURLs) != 0 {
+		services = append(services, PushApprise)
+	}
+	return services
+}
+
+func (pn *PushNotifier) serviceAvailable(service string) bool {
+	for _, s := range pn.Services() {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// validTarget returns whether the target is one users are allowed to give.
+// Apprise URLs are limited to the allowed schemes, as the Apprise server would otherwise make requests to any host the user chose.
+func (pn *PushNotifier) validTarget(target PushTarget) bool {
+	if !pn.serviceAvailable(target.Service) || target.Target == "" {
+		return false
+	}
+	switch target.Service {
+	case PushNtfy:
+		return ntfyTopicRegex.MatchString(target.Target)
+	case PushApprise:
+		scheme, _, ok := strings.Cut(target.Target, "://")
+		return ok && pn.appriseURLs[strings.ToLower(scheme)]
+	}
+	return true
+}
+
+func (pn *PushNotifier) post(url string, data interface{}, headers map[string]string) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := pn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		out, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Send pushes a message to a target. message.Markdown is used where available, otherwise message.Text.
+func (pn *PushNotifier) Send(message *Message, target PushTarget) error {
+	// Targets linked before a scheme was disallowed are skipped.
+	if !pn.validTarget(target) {
+		return fmt.Errorf("push target not allowed for %s", target.Service)
+	}
+	body, markdown := message.Text, false
+	if message.Markdown != "" {
+		body, markdown = message.Markdown, true
+	}
+	switch target.Service {
+	case PushNtfy:
+		headers := map[string]string{}
+		if target.Token != "" {
+			headers["Authorization"] = "Bearer " + target.Token
+		}
+		return pn.post(pn.ntfyServer, map[string]interface{}{
+			"topic":    target.Target,
+			"title":    message.Subject,
+			"message":  body,
+			"markdown": markdown,
+		}, headers)
+	case PushGotify:
+		contentType := "text/plain"
+		if markdown {
+			contentType = "text/markdown"
+		}
+		return pn.post(pn.gotifyServer+"/message", map[string]interface{}{
+			"title":    message.Subject,
+			"message":  body,
+			"priority": 5,
+			"extras": map[string]interface{}{
+				"client::display": map[string]string{"contentType": contentType},
+			},
+		}, map[string]string{"X-Gotify-Key": target.Target})
+	case PushApprise:
+		format := "text"
+		if markdown {
+			format = "markdown"
+		}
+		return pn.post(pn.appriseAPI+"/notify/", map[string]interface{}{
+			"urls":   target.Target,
+			"title":  message.Subject,
+			"body":   body,
+			"format": format,
+		}, nil)
+	}
+	return fmt.Errorf("unknown push service \"%s\"", target.Service)
+}
+
+// SendPIN pushes a new verification PIN to the target, assigned to the given Jellyfin ID.
+// Returns ErrPINResentTooSoon without sending if a PIN was pushed to the target within PUSH_RESEND_INTERVAL.
+func (pn *PushNotifier) SendPIN(target PushTarget, jfID, lang string) error {
+	for pin, token := range pn.tokens {
+		if time.Since(token.Sent) > VERIF_TOKEN_EXPIRY_SEC*time.Second {
+			delete(pn.tokens, pin)
+		} else if token.Target.Service == target.Service && token.Target.Target == target.Target && time.Since(token.Sent) < PUSH_RESEND_INTERVAL {
+			return ErrPINResentTooSoon
+		}
+	}
+	if _, ok := pn.app.storage.lang.Telegram[lang]; !ok {
+		lang = pn.app.storage.lang.chosenTelegramLang
+	}
+	pin := genAuthToken()
+	text := pn.app.storage.lang.Telegram[lang].Strings.template("pushPIN", tmpl{"pin": pin})
+	if err := pn.Send(&Message{Subject: "jfa-go", Text: text}, target); err != nil {
+		return err
+	}
+	pn.tokens[pin] = UnverifiedPush{Target: target, Sent: time.Now(), JellyfinID: jfID}
+	return nil
+}
+
+// AssignedTokenVerified returns the target the PIN was pushed to, if it was for the given Jellyfin ID and hasn't expired.
+func (pn *PushNotifier) AssignedTokenVerified(pin, jfID string) (PushTarget, bool) {
+	token, ok := pn.tokens[pin]
+	if !ok || token.JellyfinID != jfID || time.Since(token.Sent) > VERIF_TOKEN_EXPIRY_SEC*time.Second {
+		return PushTarget{}, false
+	}
+	return token.Target, true
+}
+
+// DeleteToken removes the token with the given PIN.
+func (pn *PushNotifier) DeleteToken(pin string) {
+	delete(pn.tokens, pin)
+}
+
+// UserExists returns whether or not a user with the given address ("<service>:<target>") exists.
+func (pn *PushNotifier) UserExists(address string) bool {
+	c, err := pn.app.storage.db.Count(&PushTarget{}, badgerhold.Where("Address").Eq(address))
+	return err != nil || c > 0
+}
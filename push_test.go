@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPushTestApp(t *testing.T, schemes string) (*appContext, *int32) {
+	var requests int32
+	apprise := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	t.Cleanup(apprise.Close)
+	config := "[push]\napprise_api = " + apprise.URL + "\n"
+	if schemes != "" {
+		config += "apprise_schemes = " + schemes + "\n"
+	}
+	app, _ := newTestApp(t, config)
+	pn, err := newPushNotifier(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.push = pn
+	return app, &requests
+}
+
+func TestPushAppriseSchemes(t *testing.T) {
+	app, requests := newPushTestApp(t, "")
+	for target, valid := range map[string]bool{
+		"pover://user@token":         true,
+		"Discord://webhook_id/token": true,
+		"json://10.0.0.5/hook":       false,
+		"form://169.254.169.254/":    false,
+		"ntfys://internal.lan/topic": false,
+		"not a url":                  false,
+		"":                           false,
+	} {
+		if got := app.push.validTarget(PushTarget{Service: PushApprise, Target: target}); got != valid {
+			t.Errorf("%q: expected %t, got %t", target, valid, got)
+		}
+	}
+
+	gc, w := testContext("POST", "/my/push/target", PushSendPINDTO{Service: PushApprise, Target: "json://10.0.0.5/hook"})
+	gc.Set("jfId", "user")
+	app.PushSendMyPIN(gc)
+	if w.Code != 400 {
+		t.Errorf("disallowed URL got %d", w.Code)
+	}
+
+	// Targets stored before their scheme was disallowed aren't sent to.
+	if err := app.push.Send(&Message{Text: "hi"}, PushTarget{Service: PushApprise, Target: "json://10.0.0.5/hook"}); err == nil {
+		t.Error("sent to disallowed URL")
+	}
+	if err := app.push.Send(&Message{Text: "hi"}, PushTarget{Service: PushApprise, Target: "pover://user@token"}); err != nil {
+		t.Errorf("failed to send to allowed URL: %v", err)
+	}
+	if *requests != 1 {
+		t.Errorf("expected 1 request to Apprise, got %d", *requests)
+	}
+}
+
+func TestPushAppriseCustomSchemes(t *testing.T) {
+	app, _ := newPushTestApp(t, " TGRAM , json")
+	if !app.push.validTarget(PushTarget{Service: PushApprise, Target: "json://example.com"}) {
+		t.Error("explicitly allowed scheme rejected")
+	}
+	if app.push.validTarget(PushTarget{Service: PushApprise, Target: "pover://user@token"}) {
+		t.Error("scheme not in custom list accepted")
+	}
+}
+
+func TestPushPINLimits(t *testing.T) {
+	app, requests := newPushTestApp(t, "")
+	app.push.limiter = NewLoginLimiter(true, 2, time.Hour, time.Hour, time.Hour)
+	app.push.globalLimiter = NewLoginLimiter(true, 3, time.Hour, time.Hour, time.Hour)
+	for i, tc := range []struct {
+		user, ip, target string
+		status           int
+	}{
+		{"a", "192.0.2.1", "pover://user@1", 200},
+		// Not again to the same target so soon.
+		{"b", "192.0.2.2", "pover://user@1", 429},
+		{"a", "192.0.2.1", "pover://user@2", 200},
+		// Per-IP and per-user limit.
+		{"a", "192.0.2.2", "pover://user@3", 429},
+		{"b", "192.0.2.1", "pover://user@3", 429},
+		{"b", "192.0.2.2", "pover://user@3", 200},
+		// Global limit, from a fresh IP and user.
+		{"c", "192.0.2.3", "pover://user@4", 429},
+	} {
+		gc, w := testContext("POST", "/my/push/target", PushSendPINDTO{Service: PushApprise, Target: tc.target})
+		gc.Request.RemoteAddr = tc.ip + ":1234"
+		gc.Set("jfId", tc.user)
+		app.PushSendMyPIN(gc)
+		if w.Code != tc.status {
+			t.Errorf("%d (user %s, IP %s): expected %d, got %d", i, tc.user, tc.ip, tc.status, w.Code)
+		}
+	}
+	if *requests != 3 {
+		t.Errorf("expected 3 PINs pushed, got %d", *requests)
+	}
+}
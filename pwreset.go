@@ -93,6 +93,9 @@ func pwrMonitor(app *appContext, watcher *fsnotify.Watcher) {
 					continue
 				}
 				app.info.Printf("New password reset for user \"%s\"", pwr.Username)
+				app.notifyAdmins(EventPasswordReset, func() (*Message, error) {
+					return app.email.constructAdminNotification(EventPasswordReset, tmpl{"username": pwr.Username}, app)
+				})
 				if currentTime := time.Now(); pwr.Expiry.After(currentTime) {
 					user, status, err := app.jf.UserByName(pwr.Username, false)
 					if !(status == 200 || status == 204) || err != nil {
@@ -107,7 +110,7 @@ func pwrMonitor(app *appContext, watcher *fsnotify.Watcher) {
 					}
 					name := app.getAddressOrName(uid)
 					if name != "" {
-						msg, err := app.email.constructReset(pwr, app, false)
+						msg, err := app.emailFor(uid).constructReset(pwr, app, false)
 
 						if err != nil {
 							app.err.Printf("Failed to construct password reset message for \"%s\"", pwr.Username)
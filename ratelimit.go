@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// Ban keys are prefixed with their type. Keys for actions other than logging in are additionally prefixed with the action, e.g. "reset:ip:1.2.3.4".
+const (
+	BAN_IP_PREFIX    = "ip:"
+	BAN_USER_PREFIX  = "user:"
+	BAN_RESET_PREFIX = "reset:"
+)
+
+// LoginLimiter counts failed attempts per key (IP or username) over a sliding window,
+// and locks keys out once too many have been made. Each successive lockout of a key is twice as long as the last, up to maxLockout.
+type LoginLimiter struct {
+	enabled     bool
+	maxAttempts int
+	window      time.Duration
+	lockout     time.Duration
+	maxLockout  time.Duration
+	entries     map[string]*loginLimitEntry
+	lock        sync.Mutex
+}
+
+type loginLimitEntry struct {
+	failures    []time.Time
+	strikes     int // Number of lockouts so far, used for backoff.
+	until       time.Time
+	lastLockout time.Time
+}
+
+// LoginBan describes a key currently locked out.
+type LoginBan struct {
+	Key     string
+	Until   time.Time
+	Strikes int
+}
+
+func NewLoginLimiter(enabled bool, maxAttempts int, window, lockout, maxLockout time.Duration) *LoginLimiter {
+	if maxLockout < lockout {
+		maxLockout = lockout
+	}
+	return &LoginLimiter{
+		enabled:     enabled,
+		maxAttempts: maxAttempts,
+		window:      window,
+		lockout:     lockout,
+		maxLockout:  maxLockout,
+		entries:     map[string]*loginLimitEntry{},
+	}
+}
+
+// Locked returns whether any of the given keys are locked out, and until when.
+func (l *LoginLimiter) Locked(keys ...string) (until time.Time, locked bool) {
+	if !l.enabled {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		if e, ok := l.entries[key]; ok && now.Before(e.until) && e.until.After(until) {
+			until = e.until
+			locked = true
+		}
+	}
+	return
+}
+
+// Fail records a failed attempt against each key, returning those which have just been locked out.
+func (l *LoginLimiter) Fail(keys ...string) (banned []LoginBan) {
+	if !l.enabled {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		e, ok := l.entries[key]
+		if !ok {
+			e = &loginLimitEntry{}
+			l.entries[key] = e
+		}
+		// Forget past lockouts once the key has behaved for the maximum lockout period.
+		if e.strikes != 0 && now.Sub(e.lastLockout) > l.maxLockout+e.until.Sub(e.lastLockout) {
+			e.strikes = 0
+		}
+		e.failures = append(pruneAttempts(e.failures, now.Add(-l.window)), now)
+		if len(e.failures) < l.maxAttempts || now.Before(e.until) {
+			continue
+		}
+		duration := l.lockout << e.strikes
+		if duration > l.maxLockout || duration <= 0 {
+			duration = l.maxLockout
+		}
+		e.strikes++
+		e.lastLockout = now
+		e.until = now.Add(duration)
+		e.failures = nil
+		banned = append(banned, LoginBan{Key: key, Until: e.until, Strikes: e.strikes})
+	}
+	l.clean(now)
+	return
+}
+
+// Succeed clears the failed attempts recorded against each key.
+func (l *LoginLimiter) Succeed(keys ...string) {
+	if !l.enabled {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, key := range keys {
+		if e, ok := l.entries[key]; ok {
+			e.failures = nil
+		}
+	}
+}
+
+// Bans returns the keys currently locked out, soonest to expire first.
+func (l *LoginLimiter) Bans() []LoginBan {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	bans := []LoginBan{}
+	for key, e := range l.entries {
+		if now.Before(e.until) {
+			bans = append(bans, LoginBan{Key: key, Until: e.until, Strikes: e.strikes})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// Unban lifts the lockout on a key and clears its history, returning false if it wasn't locked out.
+func (l *LoginLimiter) Unban(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.entries[key]
+	if !ok || !time.Now().Before(e.until) {
+		return false
+	}
+	delete(l.entries, key)
+	return true
+}
+
+// clean removes entries with nothing left worth remembering. Caller should hold the lock.
+func (l *LoginLimiter) clean(now time.Time) {
+	for key, e := range l.entries {
+		e.failures = pruneAttempts(e.failures, now.Add(-l.window))
+		if len(e.failures) == 0 && now.After(e.until) && now.Sub(e.lastLockout) > l.maxLockout+e.until.Sub(e.lastLockout) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func pruneAttempts(attempts []time.Time, after time.Time) []time.Time {
+	i := 0
+	for i < len(attempts) && !attempts[i].After(after) {
+		i++
+	}
+	return attempts[i:]
+}
+
+// loadLoginLimiter sets up rate limiting from the "rate_limiting" section.
+func (app *appContext) loadLoginLimiter() {
+	section := app.config.Section("rate_limiting")
+	app.loginLimiter = NewLoginLimiter(
+		section.Key("enabled").MustBool(true),
+		section.Key("max_attempts").MustInt(5),
+		time.Duration(section.Key("window_sec").MustInt(300))*time.Second,
+		time.Duration(section.Key("lockout_sec").MustInt(300))*time.Second,
+		time.Duration(section.Key("max_lockout_sec").MustInt(86400))*time.Second,
+	)
+}
+
+func rateLimitKeys(gc *gin.Context, username string) []string {
+	keys := []string{BAN_IP_PREFIX + gc.ClientIP()}
+	if username != "" {
+		keys = append(keys, BAN_USER_PREFIX+strings.ToLower(username))
+	}
+	return keys
+}
+
+// checkRateLimit responds with 429 if the client's IP or the given username (if any) are locked out.
+// Caller should return if ok is false.
+func (app *appContext) checkRateLimit(gc *gin.Context, userpage bool, username string) (ok bool) {
+	return app.checkLimit(gc, app.loginLimiter, userpage, rateLimitKeys(gc, username)...)
+}
+
+// checkLimit responds with 429 if any of the given keys are locked out by the limiter.
+// Caller should return if ok is false.
+func (app *appContext) checkLimit(gc *gin.Context, limiter *LoginLimiter, userpage bool, keys ...string) (ok bool) {
+	until, locked := limiter.Locked(keys...)
+	if !locked {
+		return true
+	}
+	app.logIpInfo(gc, userpage, fmt.Sprintf("Request denied: Locked out until %s", until.Format(time.RFC3339)))
+	gc.Header("Retry-After", fmt.Sprint(int(time.Until(until).Seconds())+1))
+	respond(429, "errorTooManyAttempts", gc)
+	return false
+}
+
+// recordFailedAttempt counts a failed attempt against the client's IP and the given username (if any), logging any resulting lockouts.
+func (app *appContext) recordFailedAttempt(gc *gin.Context, userpage bool, username string) {
+	app.recordAttempt(gc, app.loginLimiter, userpage, rateLimitKeys(gc, username)...)
+}
+
+// recordAttempt counts an attempt against each of the given keys, logging any resulting lockouts.
+func (app *appContext) recordAttempt(gc *gin.Context, limiter *LoginLimiter, userpage bool, keys ...string) {
+	for _, ban := range limiter.Fail(keys...) {
+		app.logIpInfo(gc, userpage, fmt.Sprintf("Locked out \"%s\" until %s after too many attempts", ban.Key, ban.Until.Format(time.RFC3339)))
+		value := ban.Key
+		if i := strings.Index(value, BAN_IP_PREFIX); i != -1 {
+			// IPs are only stored in the activity log if IP logging is enabled.
+			value = value[:i+len(BAN_IP_PREFIX)]
+		}
+		app.storage.SetActivityKey(shortuuid.New(), Activity{
+			Type:       ActivityLockout,
+			SourceType: ActivityAnon,
+			Value:      value,
+			Time:       time.Now(),
+		}, gc, userpage)
+	}
+}
+
+// recordSuccessfulAttempt clears failed attempts against the client's IP and the given username (if any).
+func (app *appContext) recordSuccessfulAttempt(gc *gin.Context, username string) {
+	app.loginLimiter.Succeed(rateLimitKeys(gc, username)...)
+}
+
+// @Summary Get the IPs & usernames currently locked out due to too many failed login attempts.
+// @Produce json
+// @Success 200 {object} loginBansDTO
+// @Router /bans [get]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) GetLoginBans(gc *gin.Context) {
+	bans := app.loginLimiter.Bans()
+	resp := loginBansDTO{Bans: make([]loginBanDTO, len(bans))}
+	for i, ban := range bans {
+		resp.Bans[i] = loginBanDTO{Key: ban.Key, Until: ban.Until.Unix(), Strikes: ban.Strikes}
+	}
+	gc.JSON(200, resp)
+}
+
+// @Summary Lift a lockout on an IP or username.
+// @Produce json
+// @Param key path string true "Ban key, e.g. ip:1.2.3.4 or user:username"
+// @Success 200 {object} boolResponse
+// @Failure 400 {object} boolResponse
+// @Router /bans/{key} [delete]
+// @Security Bearer
+// @tags Auth
+func (app *appContext) DeleteLoginBan(gc *gin.Context) {
+	key := gc.Param("key")
+	if !app.loginLimiter.Unban(key) {
+		respondBool(400, false, gc)
+		return
+	}
+	app.info.Printf("Lockout on \"%s\" lifted", key)
+	respondBool(200, true, gc)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	l := NewLoginLimiter(true, 3, time.Minute, time.Minute, 3*time.Minute)
+	for i := 0; i < 2; i++ {
+		if banned := l.Fail("ip:a"); len(banned) != 0 {
+			t.Fatalf("locked out after %d attempts", i+1)
+		}
+	}
+	banned := l.Fail("ip:a")
+	if len(banned) != 1 || banned[0].Strikes != 1 {
+		t.Fatalf("expected lockout on third attempt, got %+v", banned)
+	}
+	if until, locked := l.Locked("ip:a", "user:b"); !locked || time.Until(until) > time.Minute {
+		t.Errorf("expected a one minute lockout, got %t until %s", locked, until)
+	}
+	if _, locked := l.Locked("ip:c"); locked {
+		t.Error("unrelated key locked out")
+	}
+	if !l.Unban("ip:a") {
+		t.Error("couldn't lift lockout")
+	}
+	if _, locked := l.Locked("ip:a"); locked {
+		t.Error("still locked out after unban")
+	}
+}
+
+func TestResetsDontLockOutLogins(t *testing.T) {
+	app, _ := newTestApp(t, "[rate_limiting]\nmax_attempts = 2\n")
+	gc, _ := testContext("POST", "/my/password/reset/someone", nil)
+	resetKey := BAN_RESET_PREFIX + BAN_IP_PREFIX + gc.ClientIP()
+	for i := 0; i < 2; i++ {
+		app.recordAttempt(gc, app.loginLimiter, true, resetKey)
+	}
+	if app.checkLimit(gc, app.loginLimiter, true, resetKey) {
+		t.Error("resets not limited")
+	}
+	gc, w := testContext("GET", "/my/token/login", nil)
+	if !app.checkRateLimit(gc, true, "someone") {
+		t.Errorf("login locked out by resets: %d", w.Code)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	for _, tc := range []struct {
+		config, remote, expected string
+	}{
+		// Loopback is trusted by default, for a proxy on the same host.
+		{"", "127.0.0.1:1234", "203.0.113.5"},
+		// Anyone else could be spoofing the header.
+		{"", "198.51.100.7:1234", "198.51.100.7"},
+		{"[advanced]\ntrusted_proxies = 172.16.0.0/12\n", "172.18.0.2:1234", "203.0.113.5"},
+		{"[advanced]\ntrusted_proxies = 172.16.0.0/12\n", "127.0.0.1:1234", "127.0.0.1"},
+	} {
+		app, _ := newTestApp(t, tc.config)
+		router := gin.New()
+		app.setTrustedProxies(router)
+		router.GET("/", func(gc *gin.Context) { gc.String(200, gc.ClientIP()) })
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-For", "203.0.113.5")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Body.String() != tc.expected {
+			t.Errorf("%q from %s: expected %s, got %s", tc.config, tc.remote, tc.expected, w.Body.String())
+		}
+	}
+}
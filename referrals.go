@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Referral records that a user was created through another user's referral invite.
+// Unlike activities, these aren't cleared out over time, so the referral tree is always complete.
+type Referral struct {
+	JellyfinID         string `badgerhold:"key"`
+	ReferrerJellyfinID string `badgerhold:"index"`
+	InviteCode         string
+	Created            time.Time
+	RewardDays         int // Days added to the referrer's expiry for this referral, if any.
+}
+
+// Referral tree node statuses.
+const (
+	REFERRAL_ACTIVE   = "active"
+	REFERRAL_DISABLED = "disabled" // Includes users disabled on expiry.
+	REFERRAL_DELETED  = "deleted"
+)
+
+// recordReferral stores the referral of newID by referrerID, and rewards the referrer if enabled.
+func (app *appContext) recordReferral(gc *gin.Context, referrerID, newID, inviteCode string) {
+	referral := Referral{
+		ReferrerJellyfinID: referrerID,
+		InviteCode:         inviteCode,
+		Created:            time.Now(),
+	}
+	referral.RewardDays = app.rewardReferrer(gc, referrerID, newID)
+	app.storage.SetReferralKey(newID, referral)
+}
+
+// rewardReferrer extends the referrer's expiry by the configured number of days, if they have one and haven't hit the cap for the current period.
+// Returns the number of days given.
+func (app *appContext) rewardReferrer(gc *gin.Context, referrerID, newID string) int {
+	days := app.config.Section("user_page").Key("referral_reward_days").MustInt(0)
+	if days <= 0 {
+		return 0
+	}
+	expiry, ok := app.storage.GetUserExpiryKey(referrerID)
+	if !ok || expiry.Expiry.Before(time.Now()) {
+		// Nothing to extend, or they've expired and are about to be dealt with by the daemon.
+		return 0
+	}
+	if maxRewards := app.config.Section("user_page").Key("referral_reward_max").MustInt(0); maxRewards > 0 {
+		periodDays := app.config.Section("user_page").Key("referral_reward_period_days").MustInt(30)
+		since := time.Now().AddDate(0, 0, -periodDays)
+		rewarded := 0
+		for _, r := range app.storage.GetReferrals(badgerhold.Where("ReferrerJellyfinID").Eq(referrerID)) {
+			if r.RewardDays > 0 && r.Created.After(since) {
+				rewarded++
+			}
+		}
+		if rewarded >= maxRewards {
+			app.debug.Printf("Not rewarding referrer \"%s\", already rewarded %d time(s) in the last %d days", referrerID, rewarded, periodDays)
+			return 0
+		}
+	}
+	expiry.Expiry = expiry.Expiry.AddDate(0, 0, days)
+	app.storage.SetUserExpiryKey(referrerID, expiry)
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityReferralReward,
+		UserID:     referrerID,
+		SourceType: ActivityUser,
+		Source:     newID,
+		Value:      fmt.Sprint(days),
+		Time:       time.Now(),
+	}, gc, true)
+	app.info.Printf("Extended expiry of referrer \"%s\" by %d day(s)", referrerID, days)
+	return days
+}
+
+// referralNode builds the referral tree below the given user, up to depth levels deep (0 for just the user).
+// users maps Jellyfin IDs to users, and is used to find statuses.
+func (app *appContext) referralNode(id string, referral Referral, users map[string]mediabrowser.User, depth int) referralNodeDTO {
+	node := referralNodeDTO{ID: id, Status: REFERRAL_DELETED, Referrals: []referralNodeDTO{}}
+	if !referral.Created.IsZero() {
+		node.Referred = referral.Created.Unix()
+	}
+	node.RewardDays = referral.RewardDays
+	if user, ok := users[id]; ok {
+		node.Name = user.Name
+		node.Status = REFERRAL_ACTIVE
+		if user.Policy.IsDisabled {
+			node.Status = REFERRAL_DISABLED
+		}
+	}
+	children := app.storage.GetReferrals(badgerhold.Where("ReferrerJellyfinID").Eq(id))
+	node.DirectReferrals = len(children)
+	for _, child := range children {
+		// Descendants past the depth limit are still counted, just not included.
+		childDepth := depth - 1
+		if childDepth < 0 {
+			childDepth = 0
+		}
+		childNode := app.referralNode(child.JellyfinID, child, users, childDepth)
+		if depth > 0 {
+			node.Referrals = append(node.Referrals, childNode)
+		}
+		node.TotalReferrals += 1 + childNode.TotalReferrals
+		node.ActiveReferrals += childNode.ActiveReferrals
+		if childNode.Status == REFERRAL_ACTIVE {
+			node.ActiveReferrals++
+		}
+	}
+	return node
+}
+
+// Referral quotas are counted over a rolling period of this many days.
+const REFERRAL_QUOTA_PERIOD_DAYS = 30
+
+// referralSignupsThisPeriod returns the number of users referred by the given user within the quota period.
+func (app *appContext) referralSignupsThisPeriod(referrerID string) int {
+	since := time.Now().AddDate(0, 0, -REFERRAL_QUOTA_PERIOD_DAYS)
+	return len(app.storage.GetReferrals(badgerhold.Where("ReferrerJellyfinID").Eq(referrerID).And("Created").Gt(since)))
+}
+
+// applyReferralQuota caps the uses of a referral invite to the quotas of the profile it was sourced from.
+// Returns false if the referrer has no signups left this period.
+func (app *appContext) applyReferralQuota(inv *Invite) bool {
+	if inv.ReferrerProfile == "" || inv.ReferrerJellyfinID == "" {
+		return true
+	}
+	profile, ok := app.storage.GetProfileKey(inv.ReferrerProfile)
+	if !ok {
+		return true
+	}
+	limit := profile.ReferralMaxOutstanding
+	if profile.ReferralMonthlyQuota > 0 {
+		remaining := profile.ReferralMonthlyQuota - app.referralSignupsThisPeriod(inv.ReferrerJellyfinID)
+		if remaining <= 0 {
+			return false
+		}
+		if limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	if limit > 0 && (inv.NoLimit || inv.RemainingUses > limit) {
+		inv.NoLimit = false
+		inv.RemainingUses = limit
+	}
+	return true
+}
+
+// revokeReferrals removes the user's referral invite and template, so they can't make any more referrals.
+func (app *appContext) revokeReferrals(jfID string) {
+	// 1. Delete directly bound template
+	app.storage.db.DeleteMatching(Invite{}, badgerhold.Where("ReferrerJellyfinID").Eq(jfID))
+	// 2. Check for and delete profile-attached template
+	user, ok := app.storage.GetEmailsKey(jfID)
+	if !ok {
+		return
+	}
+	user.ReferralTemplateKey = ""
+	app.storage.SetEmailsKey(jfID, user)
+}
+
+// referredUsers returns the IDs of the users referred by the given user, and if recursive, everyone they referred too.
+func (app *appContext) referredUsers(jfID string, recursive bool) []string {
+	ids := []string{}
+	seen := map[string]bool{jfID: true}
+	queue := []string{jfID}
+	for len(queue) != 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, r := range app.storage.GetReferrals(badgerhold.Where("ReferrerJellyfinID").Eq(id)) {
+			if seen[r.JellyfinID] {
+				continue
+			}
+			seen[r.JellyfinID] = true
+			ids = append(ids, r.JellyfinID)
+			if recursive {
+				queue = append(queue, r.JellyfinID)
+			}
+		}
+	}
+	return ids
+}
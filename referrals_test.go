@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+func TestDisableReferredSkipsDeletedUsers(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	for _, id := range []string{"referrer1", "referred1", "referred3"} {
+		jf.addUser(mediabrowser.User{ID: id, Name: id})
+	}
+	// referred2 has since been deleted from Jellyfin, but referred someone else.
+	app.storage.SetReferralKey("referred1", Referral{JellyfinID: "referred1", ReferrerJellyfinID: "referrer1"})
+	app.storage.SetReferralKey("referred2", Referral{JellyfinID: "referred2", ReferrerJellyfinID: "referrer1"})
+	app.storage.SetReferralKey("referred3", Referral{JellyfinID: "referred3", ReferrerJellyfinID: "referred2"})
+	gc, w := testContext("DELETE", "/users/referral", EnableDisableReferralDTO{
+		Users:           []string{"referrer1"},
+		DisableReferred: true,
+		Recursive:       true,
+	})
+	app.DisableReferralForUsers(gc)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, id := range []string{"referred1", "referred3"} {
+		if !jf.users[id].Policy.IsDisabled {
+			t.Errorf("%s not disabled", id)
+		}
+	}
+	if jf.users["referrer1"].Policy.IsDisabled {
+		t.Error("referrer disabled")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hrfee/jfa-go/common"
+	"github.com/hrfee/jfa-go/ombi"
+	"github.com/hrfee/mediabrowser"
+)
+
+// loads any enabled request managers into app.requestManagers.
+func (app *appContext) loadRequestManagers() {
+	app.requestManagers = []common.RequestManager{}
+	if app.config.Section("ombi").Key("enabled").MustBool(false) {
+		app.debug.Printf("Connecting to Ombi")
+		ombiServer := app.config.Section("ombi").Key("server").String()
+		app.requestManagers = append(app.requestManagers, ombi.NewOmbi(
+			ombiServer,
+			app.config.Section("ombi").Key("api_key").String(),
+			common.NewTimeoutHandler("Ombi", ombiServer, true),
+		))
+	}
+}
+
+// requestManager returns the enabled request manager with the given name.
+func (app *appContext) requestManager(name string) (common.RequestManager, bool) {
+	for _, rm := range app.requestManagers {
+		if rm.Name() == name {
+			return rm, true
+		}
+	}
+	return nil, false
+}
+
+// getRequestManagerUser returns the request manager user matching the given Jellyfin user's name or email address.
+func (app *appContext) getRequestManagerUser(rm common.RequestManager, jfID string) (common.RequestManagerUser, int, error) {
+	rmUsers, code, err := rm.GetUsers()
+	if err != nil || code != 200 {
+		return common.RequestManagerUser{}, code, err
+	}
+	jfUser, code, err := app.jf.UserByID(jfID, false)
+	if err != nil || code != 200 {
+		return common.RequestManagerUser{}, code, err
+	}
+	username := jfUser.Name
+	email := ""
+	if e, ok := app.storage.GetEmailsKey(jfID); ok {
+		email = e.Addr
+	}
+	for _, rmUser := range rmUsers {
+		if rmUser.Name == username || (rmUser.Email == email && email != "") {
+			return rmUser, code, err
+		}
+	}
+	return common.RequestManagerUser{}, 400, fmt.Errorf("couldn't find user")
+}
+
+// Returns a user with the given name who has been imported from Jellyfin/Emby by the request manager.
+func (app *appContext) getRequestManagerImportedUser(rm common.RequestManager, name string) (common.RequestManagerUser, int, error) {
+	source := "emby"
+	if serverType == mediabrowser.JellyfinServer {
+		source = "jellyfin"
+	}
+	rmUsers, code, err := rm.GetUsers()
+	if err != nil || code != 200 {
+		return common.RequestManagerUser{}, code, err
+	}
+	for _, rmUser := range rmUsers {
+		if rmUser.Name == name && rmUser.Source == source {
+			return rmUser, code, err
+		}
+	}
+	return common.RequestManagerUser{}, 400, fmt.Errorf("couldn't find user")
+}
+
+// setRequestManagerPasswords changes the password of the given Jellyfin user on all request managers. Failures are only logged.
+func (app *appContext) setRequestManagerPasswords(jfID, password string) {
+	for _, rm := range app.requestManagers {
+		rmUser, status, err := app.getRequestManagerUser(rm, jfID)
+		if status != 200 || err != nil {
+			app.err.Printf("Failed to get user \"%s\" from %s (%d): %v", jfID, rm.Name(), status, err)
+			continue
+		}
+		rmUser.Password = password
+		status, err = rm.ModifyUser(rmUser)
+		if status != 200 || err != nil {
+			app.err.Printf("Failed to set password for %s user \"%s\" (%d): %v", rm.Name(), rmUser.Name, status, err)
+			continue
+		}
+		app.debug.Printf("Reset password for %s user \"%s\"", rm.Name(), rmUser.Name)
+	}
+}
+
+// setRequestManagerEmails changes the email address of the given Jellyfin user on all request managers. Failures are only logged.
+func (app *appContext) setRequestManagerEmails(jfID, address string) {
+	for _, rm := range app.requestManagers {
+		rmUser, code, err := app.getRequestManagerUser(rm, jfID)
+		if code != 200 || err != nil {
+			continue
+		}
+		rmUser.Email = address
+		code, err = rm.ModifyUser(rmUser)
+		if code != 200 || err != nil {
+			app.err.Printf("%s: Failed to change %s email address (%d): %v", rmUser.Name, rm.Name(), code, err)
+		}
+	}
+}
+
+// setRequestManagerUsernames renames the given Jellyfin user on all request managers.
+// Meant to be called after the Jellyfin user is renamed, so the old name is needed to find them. Failures are only logged.
+func (app *appContext) setRequestManagerUsernames(jfID, oldName, newName string) {
+	email := ""
+	if e, ok := app.storage.GetEmailsKey(jfID); ok {
+		email = e.Addr
+	}
+	for _, rm := range app.requestManagers {
+		rmUsers, code, err := rm.GetUsers()
+		if code != 200 || err != nil {
+			app.err.Printf("Failed to get %s users (%d): %v", rm.Name(), code, err)
+			continue
+		}
+		for _, rmUser := range rmUsers {
+			if !(rmUser.Name == oldName || (rmUser.Email == email && email != "")) {
+				continue
+			}
+			rmUser.Name = newName
+			code, err = rm.ModifyUser(rmUser)
+			if code != 200 || err != nil {
+				app.err.Printf("%s: Failed to change %s username (%d): %v", oldName, rm.Name(), code, err)
+			}
+			break
+		}
+	}
+}
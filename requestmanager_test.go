@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hrfee/jfa-go/common"
+	"github.com/hrfee/mediabrowser"
+)
+
+// fakeRequestManager is an in-memory common.RequestManager.
+type fakeRequestManager struct {
+	users     map[string]common.RequestManagerUser
+	templates map[string]map[string]interface{} // Templates applied to each user ID.
+}
+
+func newFakeRequestManager(users ...common.RequestManagerUser) *fakeRequestManager {
+	rm := &fakeRequestManager{users: map[string]common.RequestManagerUser{}, templates: map[string]map[string]interface{}{}}
+	for _, u := range users {
+		rm.users[u.ID] = u
+	}
+	return rm
+}
+
+func (rm *fakeRequestManager) Name() string { return "fake" }
+
+func (rm *fakeRequestManager) GetUsers() ([]common.RequestManagerUser, int, error) {
+	users := []common.RequestManagerUser{}
+	for _, u := range rm.users {
+		users = append(users, u)
+	}
+	return users, 200, nil
+}
+
+func (rm *fakeRequestManager) UserByID(id string) (common.RequestManagerUser, int, error) {
+	u, ok := rm.users[id]
+	if !ok {
+		return u, 404, fmt.Errorf("not found")
+	}
+	return u, 200, nil
+}
+
+func (rm *fakeRequestManager) NewUser(username, password, email string, template map[string]interface{}) ([]string, int, error) {
+	id := fmt.Sprint(len(rm.users) + 1)
+	rm.users[id] = common.RequestManagerUser{ID: id, Name: username, Email: email, Password: password}
+	rm.templates[id] = template
+	return nil, 200, nil
+}
+
+func (rm *fakeRequestManager) ModifyUser(user common.RequestManagerUser) (int, error) {
+	if _, ok := rm.users[user.ID]; !ok {
+		return 404, fmt.Errorf("not found")
+	}
+	rm.users[user.ID] = user
+	return 200, nil
+}
+
+func (rm *fakeRequestManager) DeleteUser(id string) (int, error) {
+	delete(rm.users, id)
+	return 200, nil
+}
+
+func (rm *fakeRequestManager) TemplateByID(id string) (map[string]interface{}, int, error) {
+	if _, ok := rm.users[id]; !ok {
+		return nil, 404, fmt.Errorf("not found")
+	}
+	return map[string]interface{}{"from": id}, 200, nil
+}
+
+func (rm *fakeRequestManager) ApplyTemplate(user common.RequestManagerUser, template map[string]interface{}) (int, error) {
+	rm.templates[user.ID] = template
+	return 200, nil
+}
+
+func (rm *fakeRequestManager) SetNotificationPrefs(user common.RequestManagerUser, discordID, telegramUser string) (string, int, error) {
+	return "", 200, nil
+}
+
+func TestRequestManagerUserMatching(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	jf.addUser(mediabrowser.User{ID: "jellyfin1", Name: "alice"})
+	jf.addUser(mediabrowser.User{ID: "jellyfin2", Name: "bob"})
+	app.storage.SetEmailsKey("jellyfin2", EmailAddress{Addr: "bob@example.com"})
+	rm := newFakeRequestManager(
+		common.RequestManagerUser{ID: "1", Name: "alice", Source: "jellyfin"},
+		common.RequestManagerUser{ID: "2", Name: "robert", Email: "bob@example.com"},
+	)
+	app.requestManagers = []common.RequestManager{rm}
+
+	if found, ok := app.requestManager("fake"); !ok || found != rm {
+		t.Error("couldn't find request manager by name")
+	}
+	// By name, then by email address.
+	for jfID, rmID := range map[string]string{"jellyfin1": "1", "jellyfin2": "2"} {
+		if user, status, err := app.getRequestManagerUser(rm, jfID); status != 200 || err != nil || user.ID != rmID {
+			t.Errorf("%s: expected user %s, got %q (%d): %v", jfID, rmID, user.ID, status, err)
+		}
+	}
+	// Only imported users count.
+	if _, _, err := app.getRequestManagerImportedUser(rm, "robert"); err == nil {
+		t.Error("matched a local user as imported")
+	}
+}
+
+func TestRequestManagerPropagation(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	jf.addUser(mediabrowser.User{ID: "jellyfin1", Name: "newname"})
+	rm := newFakeRequestManager(common.RequestManagerUser{ID: "1", Name: "oldname"})
+	app.requestManagers = []common.RequestManager{rm}
+
+	app.setRequestManagerUsernames("jellyfin1", "oldname", "newname")
+	app.setRequestManagerPasswords("jellyfin1", "password")
+	app.setRequestManagerEmails("jellyfin1", "new@example.com")
+	expected := common.RequestManagerUser{ID: "1", Name: "newname", Email: "new@example.com", Password: "password"}
+	if user := rm.users["1"]; user.Name != expected.Name || user.Email != expected.Email || user.Password != expected.Password {
+		t.Errorf("expected %+v, got %+v", expected, user)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/gin-contrib/pprof"
@@ -78,6 +79,7 @@ func (app *appContext) loadRouter(address string, debug bool) *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.New()
+	app.setTrustedProxies(router)
 
 	setGinLogger(router, debug)
 
@@ -96,6 +98,21 @@ func (app *appContext) loadRouter(address string, debug bool) *gin.Engine {
 	return router
 }
 
+// setTrustedProxies sets which proxies' X-Forwarded-For headers are believed, for rate limiting and IP logging.
+// Otherwise, gin trusts any client's headers, so IPs could be spoofed.
+func (app *appContext) setTrustedProxies(router *gin.Engine) {
+	proxies := []string{}
+	for _, proxy := range strings.Split(app.config.Section("advanced").Key("trusted_proxies").MustString("127.0.0.1,::1"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		app.err.Printf("Invalid trusted proxies, trusting none: %v", err)
+		router.SetTrustedProxies(nil)
+	}
+}
+
 func (app *appContext) loadRoutes(router *gin.Engine) {
 	routePrefixes := []string{app.URLBase}
 	if app.URLBase != "" {
@@ -124,6 +141,11 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 		router.GET(p+"/lang/:page/:file", app.ServeLang)
 		router.GET(p+"/token/login", app.getTokenLogin)
 		router.GET(p+"/token/refresh", app.getTokenRefresh)
+		router.POST(p+"/token/totp", app.getTokenTOTP)
+		if app.webauthn != nil {
+			router.POST(p+"/token/passkey/begin", app.BeginPasskeyLogin)
+			router.POST(p+"/token/passkey/finish/:session", app.FinishPasskeyLogin)
+		}
 		router.POST(p+"/newUser", app.NewUser)
 		router.Use(static.Serve(p+"/invite/", app.webFS))
 		router.GET(p+"/invite/:invCode", app.InviteProxy)
@@ -146,12 +168,29 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 			router.POST(p+"/invite/:invCode/matrix/user", app.MatrixSendPIN)
 			router.POST(p+"/users/matrix", app.MatrixConnect)
 		}
+		if signalEnabled {
+			router.GET(p+"/invite/:invCode/signal/verified/:pin", app.SignalVerifiedInvite)
+		}
+		if smsEnabled {
+			router.POST(p+"/invite/:invCode/sms/number", app.SMSSendPIN)
+			router.GET(p+"/invite/:invCode/sms/verified/:number/:pin", app.SMSCheckPIN)
+		}
+		router.GET(p+"/unsubscribe/:jwt", app.UnsubscribePage)
+		router.POST(p+"/unsubscribe/:jwt", app.Unsubscribe)
 		if userPageEnabled {
 			router.GET(p+"/my/account", app.MyUserPage)
 			router.GET(p+"/my/token/login", app.getUserTokenLogin)
 			router.GET(p+"/my/token/refresh", app.getUserTokenRefresh)
 			router.GET(p+"/my/confirm/:jwt", app.ConfirmMyAction)
 			router.POST(p+"/my/password/reset/:address", app.ResetMyPassword)
+			router.POST(p+"/my/redeem/login", app.RedeemExtensionCodeLogin)
+			if app.webauthn != nil {
+				router.POST(p+"/my/token/passkey/begin", app.BeginUserPasskeyLogin)
+				router.POST(p+"/my/token/passkey/finish/:session", app.FinishUserPasskeyLogin)
+			}
+		}
+		if app.payments != nil {
+			router.POST(p+"/payments/webhook", app.PaymentWebhook)
 		}
 	}
 	if *SWAGGER {
@@ -175,6 +214,9 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 		api.POST(p+"/users/extend", app.ExtendExpiry)
 		api.DELETE(p+"/users/:id/expiry", app.RemoveExpiry)
 		api.POST(p+"/users/enable", app.EnableDisableUsers)
+		api.POST(p+"/users/rename", app.RenameUsers)
+		api.GET(p+"/users/username-requests", app.GetUsernameRequests)
+		api.DELETE(p+"/users/username-requests/:id", app.DenyUsernameRequest)
 		api.POST(p+"/invites", app.GenerateInvite)
 		api.GET(p+"/invites", app.GetInvites)
 		api.DELETE(p+"/invites", app.DeleteInvite)
@@ -198,28 +240,46 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 
 		api.POST(p+"/users/password-reset", app.AdminPasswordReset)
 
+		api.GET(p+"/2fa", app.GetMyTOTP)
+		api.POST(p+"/2fa/enrol", app.EnrolTOTP)
+		api.POST(p+"/2fa/confirm", app.ConfirmTOTP)
+		api.POST(p+"/2fa/recovery", app.RegenerateTOTPRecoveryCodes)
+		api.DELETE(p+"/2fa", app.DisableMyTOTP)
+		api.DELETE(p+"/users/:id/2fa", app.ResetTOTP)
+		api.GET(p+"/bans", app.GetLoginBans)
+		api.GET(p+"/sessions", app.GetSessions)
+		api.DELETE(p+"/sessions/:id", app.DeleteSession)
+		api.DELETE(p+"/bans/:key", app.DeleteLoginBan)
+		api.GET(p+"/extension-codes", app.GetExtensionCodes)
+		api.POST(p+"/extension-codes", app.GenerateExtensionCodes)
+		api.DELETE(p+"/extension-codes/:code", app.DeleteExtensionCode)
+
 		api.GET(p+"/config/update", app.CheckUpdate)
 		api.POST(p+"/config/update", app.ApplyUpdate)
 		api.GET(p+"/config/emails", app.GetCustomContent)
 		api.GET(p+"/config/emails/:id", app.GetCustomMessageTemplate)
 		api.POST(p+"/config/emails/:id", app.SetCustomMessage)
 		api.POST(p+"/config/emails/:id/state/:state", app.SetCustomMessageState)
+		api.POST(p+"/config/emails/:id/preview", app.PreviewCustomMessage)
+		api.POST(p+"/config/emails/:id/test", app.TestCustomMessage)
 		api.GET(p+"/config", app.GetConfig)
 		api.POST(p+"/config", app.ModifyConfig)
 		api.POST(p+"/restart", app.restart)
 		api.GET(p+"/logs", app.GetLog)
+		api.GET(p+"/email/transports", app.GetEmailTransports)
 		api.POST(p+"/backups", app.CreateBackup)
 		api.GET(p+"/backups/:fname", app.GetBackup)
 		api.GET(p+"/backups", app.GetBackups)
 		api.POST(p+"/backups/restore/:fname", app.RestoreLocalBackup)
 		api.POST(p+"/backups/restore", app.RestoreBackup)
-		if telegramEnabled || discordEnabled || matrixEnabled {
+		if telegramEnabled || discordEnabled || matrixEnabled || signalEnabled || smsEnabled || pushEnabled {
 			api.GET(p+"/telegram/pin", app.TelegramGetPin)
 			api.GET(p+"/telegram/verified/:pin", app.TelegramVerified)
 			api.POST(p+"/users/telegram", app.TelegramAddUser)
 			api.DELETE(p+"/users/telegram", app.UnlinkTelegram)
 			api.DELETE(p+"/users/discord", app.UnlinkDiscord)
 			api.DELETE(p+"/users/matrix", app.UnlinkMatrix)
+			api.DELETE(p+"/users/contact/:method", app.UnlinkContact)
 		}
 		if emailEnabled {
 			api.POST(p+"/users/contact", app.SetContactMethods)
@@ -239,7 +299,18 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 			api.DELETE(p+"/users/referral", app.DisableReferralForUsers)
 			api.POST(p+"/profiles/referral/:profile/:invite/:useExpiry", app.EnableReferralForProfile)
 			api.DELETE(p+"/profiles/referral/:profile", app.DisableReferralForProfile)
+			api.GET(p+"/users/:id/referrals", app.GetUserReferrals)
+		}
+		if app.streamLimiter != nil {
+			api.POST(p+"/profiles/limits/:profile", app.SetProfileLimits)
 		}
+		if app.watchTime != nil {
+			api.GET(p+"/users/:id/watch-time", app.GetUserWatchTime)
+			api.GET(p+"/watch-time/top", app.GetTopWatchers)
+		}
+
+		api.GET(p+"/notifications/routes", app.GetNotificationRoutes)
+		api.POST(p+"/notifications/routes/:event", app.SetNotificationRoute)
 
 		api.POST(p+"/activity", app.GetActivities)
 		api.DELETE(p+"/activity/:id", app.DeleteActivity)
@@ -248,21 +319,46 @@ func (app *appContext) loadRoutes(router *gin.Engine) {
 		if userPageEnabled {
 			user.GET("/details", app.MyDetails)
 			user.POST("/contact", app.SetMyContactMethods)
+			user.POST("/language", app.SetMyLang)
 			user.POST("/logout", app.LogoutUser)
 			user.POST("/email", app.ModifyMyEmail)
 			user.GET("/discord/invite", app.MyDiscordServerInvite)
 			user.GET("/pin/:service", app.GetMyPIN)
 			user.GET("/discord/verified/:pin", app.MyDiscordVerifiedInvite)
 			user.GET("/telegram/verified/:pin", app.MyTelegramVerifiedInvite)
+			user.GET("/signal/verified/:pin", app.MySignalVerifiedInvite)
+			if smsEnabled {
+				user.POST("/sms/number", app.SMSSendMyPIN)
+				user.GET("/sms/verified/:number/:pin", app.SMSCheckMyPIN)
+			}
+			if pushEnabled {
+				user.POST("/push/target", app.PushSendMyPIN)
+				user.GET("/push/verified/:pin", app.PushCheckMyPIN)
+			}
 			user.POST("/matrix/user", app.MatrixSendMyPIN)
 			user.GET("/matrix/verified/:userID/:pin", app.MatrixCheckMyPIN)
 			user.DELETE("/discord", app.UnlinkMyDiscord)
 			user.DELETE("/telegram", app.UnlinkMyTelegram)
 			user.DELETE("/matrix", app.UnlinkMyMatrix)
+			user.DELETE("/contact/:method", app.UnlinkMyContact)
 			user.POST("/password", app.ChangeMyPassword)
+			user.GET("/sessions", app.GetMySessions)
+			user.DELETE("/sessions/:id", app.DeleteMySession)
+			user.GET("/devices", app.GetMyDevices)
+			user.DELETE("/devices/:id", app.DeleteMyDevice)
+			user.POST("/redeem", app.RedeemExtensionCode)
+			if app.config.Section("user_page").Key("allow_username_change").MustBool(false) {
+				user.POST("/username", app.ChangeMyUsername)
+			}
 			if app.config.Section("user_page").Key("referrals").MustBool(false) {
 				user.GET("/referral", app.GetMyReferral)
 			}
+			if app.webauthn != nil {
+				user.GET("/passkeys", app.GetMyPasskeys)
+				user.POST("/passkeys/begin", app.BeginMyPasskeyRegistration)
+				user.POST("/passkeys/finish/:session", app.FinishMyPasskeyRegistration)
+				user.DELETE("/passkeys/:id", app.DeleteMyPasskey)
+			}
 		}
 	}
 }
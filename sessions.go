@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Session represents a login on the admin or user page, identified by the "sid" claim of its tokens.
+// Refresh tokens are only accepted while their session exists, so deleting it logs the client out.
+type Session struct {
+	ID          string `badgerhold:"key"`
+	UserID      string // ID in app.adminUsers for admin sessions, Jellyfin ID for user sessions.
+	JellyfinID  string `badgerhold:"index"` // Blank for the admin set in the "ui" section.
+	Admin       bool
+	UserAgent   string
+	IP          string // Only stored if IP logging is enabled.
+	Created     time.Time
+	LastRefresh time.Time
+	Expiry      time.Time
+}
+
+// newSession creates and stores a session for the client.
+func (app *appContext) newSession(gc *gin.Context, userID, jfID string, admin bool) Session {
+	now := time.Now()
+	session := Session{
+		ID:          shortuuid.New(),
+		UserID:      userID,
+		JellyfinID:  jfID,
+		Admin:       admin,
+		Created:     now,
+		LastRefresh: now,
+	}
+	app.touchSession(gc, &session)
+	app.storage.SetSessionsKey(session.ID, session)
+	return session
+}
+
+// touchSession updates the client info and expiry of the session, for when it's created or refreshed. The caller should store the result.
+func (app *appContext) touchSession(gc *gin.Context, session *Session) {
+	session.UserAgent = gc.Request.UserAgent()
+	session.IP = ""
+	if (session.Admin && LOGIP) || (!session.Admin && LOGIPU) {
+		session.IP = gc.ClientIP()
+	}
+	session.LastRefresh = time.Now()
+	session.Expiry = session.LastRefresh.Add(time.Second * REFRESH_TOKEN_VALIDITY_SEC)
+}
+
+// getSessionFromClaims returns the (unexpired) session a token belongs to.
+func (app *appContext) getSessionFromClaims(claims jwt.MapClaims) (Session, bool) {
+	sid, ok := claims["sid"].(string)
+	if !ok || sid == "" {
+		return Session{}, false
+	}
+	session, ok := app.storage.GetSessionsKey(sid)
+	if !ok || session.Expiry.Before(time.Now()) {
+		return Session{}, false
+	}
+	admin, _ := claims["admin"].(bool)
+	return session, session.Admin == admin
+}
+
+// revokeSessionFromCookie deletes the session belonging to the refresh token in the given cookie, if there is one.
+func (app *appContext) revokeSessionFromCookie(gc *gin.Context, cookieName string) error {
+	cookie, err := gc.Cookie(cookieName)
+	if err != nil {
+		return err
+	}
+	token, err := jwt.Parse(cookie, checkToken)
+	if err != nil {
+		return err
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if sid, ok := claims["sid"].(string); ok {
+			app.storage.DeleteSessionsKey(sid)
+		}
+	}
+	return nil
+}
+
+// revokeUserSessions deletes all of the given Jellyfin user's sessions of the given type.
+func (app *appContext) revokeUserSessions(jfID string, admin bool) {
+	for _, session := range app.storage.GetSessions(badgerhold.Where("JellyfinID").Eq(jfID).And("Admin").Eq(admin)) {
+		app.storage.DeleteSessionsKey(session.ID)
+	}
+}
+
+// clearSessions removes expired sessions.
+func (app *appContext) clearSessions() {
+	app.debug.Println("Housekeeping: Clearing expired sessions")
+	err := app.storage.db.DeleteMatching(&Session{}, badgerhold.Where("Expiry").Lt(time.Now()))
+	if err != nil {
+		app.err.Printf("Failed to clear expired sessions: %v", err)
+	}
+}
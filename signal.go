@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// How often signal-cli-rest-api is polled for new messages, when not in json-rpc mode.
+const SIGNAL_POLL_INTERVAL = 3 * time.Second
+
+type SignalUser struct {
+	JellyfinID string `badgerhold:"key"`
+	Number     string `badgerhold:"index"` // Phone number, or UUID if the user hides their number.
+	Name       string // Signal profile name, if known.
+	Lang       string
+	Contact    bool // Whether to contact through Signal or not
+}
+
+type SignalVerifiedToken struct {
+	Number     string
+	Name       string
+	JellyfinID string // optional, for ensuring a user-requested change is only accessed by them.
+}
+
+// signalEnvelope is a message received by signal-cli-rest-api. Only the fields we use are included.
+type signalEnvelope struct {
+	Envelope struct {
+		Source       string `json:"source"`
+		SourceNumber string `json:"sourceNumber"`
+		SourceUUID   string `json:"sourceUuid"`
+		SourceName   string `json:"sourceName"`
+		DataMessage  *struct {
+			Message string `json:"message"`
+		} `json:"dataMessage"`
+	} `json:"envelope"`
+}
+
+// SignalDaemon talks to a signal-cli-rest-api instance (https://github.com/bbernhard/signal-cli-rest-api).
+// Messages are polled for in the "normal" and "native" modes, and received over a websocket in "json-rpc" mode.
+type SignalDaemon struct {
+	Stopped         bool
+	ShutdownChannel chan string
+	url             string
+	number          string
+	jsonRPC         bool
+	client          *http.Client
+	tokens          map[string]VerifToken          // Map of pins to tokens.
+	verifiedTokens  map[string]SignalVerifiedToken // Map of token pins to the responsible number+name.
+	languages       map[string]string              // Store of languages for numbers. Added to on first interaction, and loaded from app.storage on start.
+	app             *appContext
+}
+
+func newSignalDaemon(app *appContext) (*SignalDaemon, error) {
+	section := app.config.Section("signal")
+	number := section.Key("number").String()
+	if number == "" {
+		return nil, fmt.Errorf("number was blank")
+	}
+	sd := &SignalDaemon{
+		ShutdownChannel: make(chan string),
+		url:             strings.TrimSuffix(section.Key("url").MustString("http://localhost:8080"), "/"),
+		number:          number,
+		client:          &http.Client{Timeout: 20 * time.Second},
+		tokens:          map[string]VerifToken{},
+		verifiedTokens:  map[string]SignalVerifiedToken{},
+		languages:       map[string]string{},
+		app:             app,
+	}
+	about := struct {
+		Mode string `json:"mode"`
+	}{}
+	body, err := sd.request("GET", "/v1/about", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &about); err != nil {
+		return nil, err
+	}
+	sd.jsonRPC = about.Mode == "json-rpc"
+	for _, user := range app.storage.GetSignal() {
+		if user.Lang != "" {
+			sd.languages[user.Number] = user.Lang
+		}
+	}
+	return sd, nil
+}
+
+func (s *SignalDaemon) request(method, path string, data interface{}) ([]byte, error) {
+	var body io.Reader
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, s.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		err = fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	return out, err
+}
+
+// NewAuthToken generates an 8-character pin in the form "A1-2B-CD".
+func (s *SignalDaemon) NewAuthToken() string {
+	pin := genAuthToken()
+	s.tokens[pin] = VerifToken{Expiry: time.Now().Add(VERIF_TOKEN_EXPIRY_SEC * time.Second), JellyfinID: ""}
+	return pin
+}
+
+// NewAssignedAuthToken generates an 8-character pin in the form "A1-2B-CD",
+// and assigns it for access only with the given Jellyfin ID.
+func (s *SignalDaemon) NewAssignedAuthToken(id string) string {
+	pin := genAuthToken()
+	s.tokens[pin] = VerifToken{Expiry: time.Now().Add(VERIF_TOKEN_EXPIRY_SEC * time.Second), JellyfinID: id}
+	return pin
+}
+
+func (s *SignalDaemon) run() {
+	s.app.info.Println("Starting Signal daemon")
+	envelopes := make(chan signalEnvelope)
+	stop := make(chan bool)
+	if s.jsonRPC {
+		go s.receiveWebsocket(envelopes, stop)
+	} else {
+		go s.receivePoll(envelopes, stop)
+	}
+	for {
+		select {
+		case env := <-envelopes:
+			s.handle(env)
+		case <-s.ShutdownChannel:
+			close(stop)
+			s.ShutdownChannel <- "Down"
+			return
+		}
+	}
+}
+
+// receivePoll fetches new messages every SIGNAL_POLL_INTERVAL.
+func (s *SignalDaemon) receivePoll(envelopes chan<- signalEnvelope, stop <-chan bool) {
+	ticker := time.NewTicker(SIGNAL_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		body, err := s.request("GET", "/v1/receive/"+url.PathEscape(s.number), nil)
+		if err != nil {
+			s.app.err.Printf("Signal: Failed to receive messages: %v", err)
+			continue
+		}
+		var received []signalEnvelope
+		if err := json.Unmarshal(body, &received); err != nil {
+			s.app.err.Printf("Signal: Failed to read messages: %v", err)
+			continue
+		}
+		for _, env := range received {
+			select {
+			case envelopes <- env:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// receiveWebsocket reads messages from the websocket signal-cli-rest-api provides in json-rpc mode, reconnecting if it drops.
+func (s *SignalDaemon) receiveWebsocket(envelopes chan<- signalEnvelope, stop <-chan bool) {
+	wsURL := "ws" + strings.TrimPrefix(s.url, "http") + "/v1/receive/" + url.PathEscape(s.number)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			s.app.err.Printf("Signal: Failed to connect to websocket: %v", err)
+		} else {
+			go func() {
+				<-stop
+				conn.Close()
+			}()
+			for {
+				var env signalEnvelope
+				if err = conn.ReadJSON(&env); err != nil {
+					break
+				}
+				select {
+				case envelopes <- env:
+				case <-stop:
+					return
+				}
+			}
+			conn.Close()
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func (s *SignalDaemon) handle(env signalEnvelope) {
+	if env.Envelope.DataMessage == nil {
+		return
+	}
+	text := strings.TrimSpace(env.Envelope.DataMessage.Message)
+	sender := env.Envelope.SourceNumber
+	if sender == "" {
+		sender = env.Envelope.SourceUUID
+	}
+	if sender == "" {
+		sender = env.Envelope.Source
+	}
+	sects := strings.Fields(text)
+	if sender == "" || len(sects) == 0 {
+		return
+	}
+	lang := s.app.storage.lang.chosenTelegramLang
+	if storedLang, ok := s.languages[sender]; ok {
+		lang = storedLang
+	}
+	switch sects[0] {
+	case "/start":
+		s.commandStart(sender, lang)
+	case "/lang":
+		s.commandLang(sender, sects, lang)
+	default:
+		s.commandPIN(sender, env.Envelope.SourceName, text, lang)
+	}
+}
+
+// Reply sends plain text to a single recipient.
+func (s *SignalDaemon) Reply(recipient, content string) error {
+	_, err := s.request("POST", "/v2/send", map[string]interface{}{
+		"message":    content,
+		"number":     s.number,
+		"recipients": []string{recipient},
+	})
+	return err
+}
+
+// Send will send a Signal message to a list of numbers/UUIDs. message.Text is used, since Signal doesn't render markdown.
+func (s *SignalDaemon) Send(message *Message, recipients ...string) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	_, err := s.request("POST", "/v2/send", map[string]interface{}{
+		"message":    message.Text,
+		"number":     s.number,
+		"recipients": recipients,
+	})
+	return err
+}
+
+func (s *SignalDaemon) Shutdown() {
+	s.Stopped = true
+	s.ShutdownChannel <- "Down"
+	<-s.ShutdownChannel
+	close(s.ShutdownChannel)
+}
+
+func (s *SignalDaemon) commandStart(sender, lang string) {
+	content := s.app.storage.lang.Telegram[lang].Strings.get("startMessage") + "\n"
+	content += s.app.storage.lang.Telegram[lang].Strings.template("languageMessage", tmpl{"command": "/lang"})
+	if err := s.Reply(sender, content); err != nil {
+		s.app.err.Printf("Signal: Failed to send message to \"%s\": %v", sender, err)
+	}
+}
+
+func (s *SignalDaemon) commandLang(sender string, sects []string, lang string) {
+	if len(sects) == 1 {
+		list := "/lang <lang>\n"
+		for code := range s.app.storage.lang.Telegram {
+			list += fmt.Sprintf("%s: %s\n", code, s.app.storage.lang.Telegram[code].Meta.Name)
+		}
+		if err := s.Reply(sender, list); err != nil {
+			s.app.err.Printf("Signal: Failed to send message to \"%s\": %v", sender, err)
+		}
+		return
+	}
+	if _, ok := s.app.storage.lang.Telegram[sects[1]]; !ok {
+		return
+	}
+	s.languages[sender] = sects[1]
+	for _, user := range s.app.storage.GetSignal() {
+		if user.Number == sender {
+			user.Lang = sects[1]
+			s.app.storage.SetSignalKey(user.JellyfinID, user)
+			break
+		}
+	}
+	content := s.app.storage.lang.Telegram[sects[1]].Strings.template("languageSet", tmpl{"language": s.app.storage.lang.Telegram[sects[1]].Meta.Name})
+	if err := s.Reply(sender, content); err != nil {
+		s.app.err.Printf("Signal: Failed to send message to \"%s\": %v", sender, err)
+	}
+}
+
+func (s *SignalDaemon) commandPIN(sender, name, pin, lang string) {
+	token, ok := s.tokens[pin]
+	if !ok || time.Now().After(token.Expiry) {
+		if err := s.Reply(sender, s.app.storage.lang.Telegram[lang].Strings.get("invalidPIN")); err != nil {
+			s.app.err.Printf("Signal: Failed to send message to \"%s\": %v", sender, err)
+		}
+		delete(s.tokens, pin)
+		return
+	}
+	if err := s.Reply(sender, s.app.storage.lang.Telegram[lang].Strings.get("pinSuccess")); err != nil {
+		s.app.err.Printf("Signal: Failed to send message to \"%s\": %v", sender, err)
+	}
+	s.verifiedTokens[pin] = SignalVerifiedToken{
+		Number:     sender,
+		Name:       name,
+		JellyfinID: token.JellyfinID,
+	}
+	delete(s.tokens, pin)
+}
+
+// TokenVerified returns whether or not a token with the given PIN has been verified, and the token itself.
+func (s *SignalDaemon) TokenVerified(pin string) (token SignalVerifiedToken, ok bool) {
+	token, ok = s.verifiedTokens[pin]
+	return
+}
+
+// AssignedTokenVerified returns whether or not a token with the given PIN has been verified, and the token itself.
+// Returns false if the given Jellyfin ID does not match the one in the token.
+func (s *SignalDaemon) AssignedTokenVerified(pin string, jfID string) (token SignalVerifiedToken, ok bool) {
+	token, ok = s.verifiedTokens[pin]
+	if ok && token.JellyfinID != jfID {
+		ok = false
+	}
+	return
+}
+
+// UserExists returns whether or not a user with the given number exists.
+func (s *SignalDaemon) UserExists(number string) bool {
+	c, err := s.app.storage.db.Count(&SignalUser{}, badgerhold.Where("Number").Eq(number))
+	return err != nil || c > 0
+}
+
+// DeleteVerifiedToken removes the token with the given PIN.
+func (s *SignalDaemon) DeleteVerifiedToken(pin string) {
+	delete(s.verifiedTokens, pin)
+}
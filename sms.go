@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// Minimum time between verification PINs sent to the same number, since each one costs money.
+const SMS_RESEND_INTERVAL = time.Minute
+
+// Key all sent PINs are counted against in SMSGateway.globalLimiter.
+const SMS_GLOBAL_LIMIT_KEY = "sms:all"
+
+// SMSNumber is a user's phone number, stored like EmailAddress.
+type SMSNumber struct {
+	JellyfinID string `badgerhold:"key"`
+	Number     string `badgerhold:"index"` // E.164 format, e.g. +441234567890.
+	Lang       string
+	Contact    bool // Whether to contact through SMS or not
+}
+
+// UnverifiedSMS is a number a verification PIN has been sent to.
+type UnverifiedSMS struct {
+	Number   string
+	Sent     time.Time
+	Verified bool
+}
+
+// SMSGateway sends texts through Twilio (or a Twilio-compatible API), or an arbitrary HTTP endpoint built from templates.
+type SMSGateway struct {
+	provider          string
+	from              string
+	twilioURL         string
+	accountSID        string
+	authToken         string
+	customURL         string
+	customMethod      string
+	customContentType string
+	customBody        string
+	customHeader      string
+	client            *http.Client
+	tokens            map[string]UnverifiedSMS // Map of PINs to numbers.
+	limiter           *LoginLimiter            // Counts PINs sent per IP, invite and user.
+	globalLimiter     *LoginLimiter            // Counts all PINs sent.
+	app               *appContext
+}
+
+func newSMSGateway(app *appContext) (*SMSGateway, error) {
+	section := app.config.Section("sms")
+	sg := &SMSGateway{
+		provider:          section.Key("provider").MustString("twilio"),
+		from:              section.Key("from").String(),
+		twilioURL:         strings.TrimSuffix(section.Key("twilio_url").MustString("https://api.twilio.com"), "/"),
+		accountSID:        section.Key("twilio_account_sid").String(),
+		authToken:         section.Key("twilio_auth_token").String(),
+		customURL:         section.Key("custom_url").String(),
+		customMethod:      strings.ToUpper(section.Key("custom_method").MustString("POST")),
+		customContentType: section.Key("custom_content_type").MustString("json"),
+		customBody:        section.Key("custom_body").String(),
+		customHeader:      section.Key("custom_header").String(),
+		client:            &http.Client{Timeout: 20 * time.Second},
+		tokens:            map[string]UnverifiedSMS{},
+		limiter:           NewLoginLimiter(true, section.Key("pin_limit").MustInt(3), time.Hour, time.Hour, time.Hour),
+		globalLimiter:     NewLoginLimiter(true, section.Key("pin_limit_global").MustInt(20), time.Hour, time.Hour, time.Hour),
+		app:               app,
+	}
+	if app.proxyEnabled {
+		sg.client.Transport = app.proxyTransport
+	}
+	switch sg.provider {
+	case "twilio":
+		if sg.accountSID == "" || sg.authToken == "" || sg.from == "" {
+			return nil, fmt.Errorf("account SID, auth token and from number are required for Twilio")
+		}
+	case "custom":
+		if sg.customURL == "" {
+			return nil, fmt.Errorf("URL was blank")
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider \"%s\"", sg.provider)
+	}
+	return sg, nil
+}
+
+var smsNumberStripper = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+var smsNumberRegex = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizeSMSNumber strips formatting from a phone number, returning false if it isn't in international (E.164) format.
+func normalizeSMSNumber(number string) (string, bool) {
+	number = smsNumberStripper.Replace(strings.TrimSpace(number))
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+	return number, smsNumberRegex.MatchString(number)
+}
+
+// fillSMSTemplate replaces {to}, {from} and {message} in a custom URL or body, escaping each value with escape.
+func fillSMSTemplate(template, to, from, message string, escape func(string) string) string {
+	return strings.NewReplacer(
+		"{to}", escape(to),
+		"{from}", escape(from),
+		"{message}", escape(message),
+	).Replace(template)
+}
+
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// sendText sends plain text to a single number.
+func (sg *SMSGateway) sendText(to, text string) error {
+	var req *http.Request
+	var err error
+	if sg.provider == "twilio" {
+		form := url.Values{}
+		form.Set("To", to)
+		form.Set("From", sg.from)
+		form.Set("Body", text)
+		req, err = http.NewRequest("POST", sg.twilioURL+"/2010-04-01/Accounts/"+url.PathEscape(sg.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(sg.accountSID, sg.authToken)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		target := fillSMSTemplate(sg.customURL, to, sg.from, text, url.QueryEscape)
+		var body io.Reader
+		contentType := "application/json"
+		escape := jsonEscape
+		if sg.customContentType == "form" {
+			contentType = "application/x-www-form-urlencoded"
+			escape = url.QueryEscape
+		}
+		if sg.customMethod != "GET" && sg.customBody != "" {
+			body = strings.NewReader(fillSMSTemplate(sg.customBody, to, sg.from, text, escape))
+		}
+		req, err = http.NewRequest(sg.customMethod, target, body)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if name, value, ok := strings.Cut(sg.customHeader, ":"); ok {
+			req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	resp, err := sg.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		out, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Send sends message.Text to a list of numbers.
+func (sg *SMSGateway) Send(message *Message, numbers ...string) error {
+	for _, number := range numbers {
+		if err := sg.sendText(number, message.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendPIN texts a new verification PIN to the given (normalized) number.
+// Returns false without sending if a PIN was sent to the number within SMS_RESEND_INTERVAL.
+func (sg *SMSGateway) SendPIN(number, lang string) (ok bool) {
+	for pin, token := range sg.tokens {
+		if time.Since(token.Sent) > VERIF_TOKEN_EXPIRY_SEC*time.Second {
+			delete(sg.tokens, pin)
+		} else if token.Number == number && time.Since(token.Sent) < SMS_RESEND_INTERVAL {
+			sg.app.debug.Printf("SMS: Not resending PIN to \"%s\" so soon", number)
+			return false
+		}
+	}
+	if _, ok := sg.app.storage.lang.Telegram[lang]; !ok {
+		lang = sg.app.storage.lang.chosenTelegramLang
+	}
+	pin := genAuthToken()
+	text := sg.app.storage.lang.Telegram[lang].Strings.template("smsPIN", tmpl{"pin": pin})
+	if err := sg.sendText(number, text); err != nil {
+		sg.app.err.Printf("SMS: Failed to send PIN to \"%s\": %v", number, err)
+		return false
+	}
+	sg.tokens[pin] = UnverifiedSMS{Number: number, Sent: time.Now()}
+	return true
+}
+
+// CheckPIN returns whether the PIN was sent to the given number and hasn't expired, and marks it as verified if so.
+func (sg *SMSGateway) CheckPIN(pin, number string) bool {
+	token, ok := sg.tokens[pin]
+	if !ok || token.Number != number || time.Since(token.Sent) > VERIF_TOKEN_EXPIRY_SEC*time.Second {
+		return false
+	}
+	token.Verified = true
+	sg.tokens[pin] = token
+	return true
+}
+
+// TokenVerified returns whether or not the PIN has been verified, and the number it was sent to.
+func (sg *SMSGateway) TokenVerified(pin string) (number string, ok bool) {
+	token, ok := sg.tokens[pin]
+	return token.Number, ok && token.Verified
+}
+
+// DeleteToken removes the token with the given PIN.
+func (sg *SMSGateway) DeleteToken(pin string) {
+	delete(sg.tokens, pin)
+}
+
+// UserExists returns whether or not a user with the given number exists.
+func (sg *SMSGateway) UserExists(number string) bool {
+	c, err := sg.app.storage.db.Count(&SMSNumber{}, badgerhold.Where("Number").Eq(number))
+	return err != nil || c > 0
+}
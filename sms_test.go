@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func newSMSTestApp(t *testing.T) (*appContext, *int32) {
+	var sent int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+	}))
+	t.Cleanup(gateway.Close)
+	app, _ := newTestApp(t, "[sms]\nenabled = true\nprovider = custom\ncustom_url = "+gateway.URL+"\npin_limit = 2\npin_limit_global = 3\n")
+	sg, err := newSMSGateway(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.sms = sg
+	for _, code := range []string{"a", "b", "c"} {
+		app.storage.SetInvitesKey(code, Invite{Code: code})
+	}
+	return app, &sent
+}
+
+func sendSMSPIN(app *appContext, invite, ip string, n int) int {
+	gc, w := testContext("POST", "/invite/"+invite+"/sms/number", SMSSendPINDTO{Number: fmt.Sprintf("+4412345678%02d", n)})
+	gc.Request.RemoteAddr = ip + ":1234"
+	gc.AddParam("invCode", invite)
+	app.SMSSendPIN(gc)
+	return w.Code
+}
+
+func TestSMSPINLimits(t *testing.T) {
+	app, sent := newSMSTestApp(t)
+	for i, tc := range []struct {
+		invite, ip string
+		status     int
+	}{
+		{"a", "192.0.2.1", 200},
+		{"a", "192.0.2.1", 200},
+		// Per-IP and per-invite limit, even with a new number each time.
+		{"a", "192.0.2.1", 429},
+		{"a", "192.0.2.2", 429},
+		{"b", "192.0.2.1", 429},
+		{"b", "192.0.2.2", 200},
+		// Global limit, from a fresh IP and invite.
+		{"c", "192.0.2.3", 429},
+	} {
+		if status := sendSMSPIN(app, tc.invite, tc.ip, i); status != tc.status {
+			t.Errorf("%d (invite %s, IP %s): expected %d, got %d", i, tc.invite, tc.ip, tc.status, status)
+		}
+	}
+	if *sent != 3 {
+		t.Errorf("expected 3 texts, got %d", *sent)
+	}
+}
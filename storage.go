@@ -35,6 +35,12 @@ const (
 	ActivityResetPassword
 	ActivityCreateInvite
 	ActivityDeleteInvite
+	ActivityLockout        // Value is "user:<username>", or "ip:" with the address stored in IP if IP logging is enabled.
+	ActivityReferralReward // UserID is the referrer, Source the referred user, Value the number of days added to the referrer's expiry.
+	ActivityRedeemCode     // Value is the number of days added, InviteCode holds the extension code.
+	ActivityPayment        // Value is the kind of payment event, Source is the provider.
+	ActivityUsernameChange // Value is the previous username.
+	ActivityStreamLimit    // Value is the limit enforced, "streams" or "devices".
 	ActivityUnknown
 )
 
@@ -64,6 +70,53 @@ type UserExpiry struct {
 	Expiry     time.Time
 }
 
+// UserProfile records the profile last applied to a user, on creation or through the accounts tab.
+type UserProfile struct {
+	JellyfinID string `badgerhold:"key"`
+	Profile    string `badgerhold:"index"`
+}
+
+// UserLanguage is the language a user has chosen for messages on the user page, overriding those of their contact methods.
+type UserLanguage struct {
+	JellyfinID string `badgerhold:"key"`
+	Lang       string
+}
+
+// SigningKey is a secret kept across restarts, for tokens which need to outlive the per-run JFA_SECRET.
+type SigningKey struct {
+	Name   string `badgerhold:"key"`
+	Secret []byte
+}
+
+// ExtensionCode can be redeemed by users on the user page to extend their expiry.
+type ExtensionCode struct {
+	Code          string `badgerhold:"key"`
+	Created       time.Time
+	Label         string
+	Days          int      // Days added to the user's expiry.
+	RemainingUses int      // Ignored if NoLimit.
+	NoLimit       bool     // Can be redeemed any number of times, but only once per user.
+	Profile       string   // If set, only users with this profile can redeem the code.
+	RedeemedBy    []string // Jellyfin IDs of users who've redeemed the code.
+}
+
+// UsernameRequest is a username change requested on the user page, awaiting approval by an admin.
+type UsernameRequest struct {
+	JellyfinID string `badgerhold:"key"`
+	OldName    string
+	NewName    string
+	Time       time.Time
+}
+
+// MessageFailure is a message that couldn't be delivered, kept for the admin digest.
+type MessageFailure struct {
+	ID        string `badgerhold:"key"`
+	Time      time.Time
+	Method    string // "email", "discord", "telegram" or "matrix".
+	Recipient string
+	Error     string
+}
+
 type DebugLogAction int
 
 const (
@@ -106,6 +159,9 @@ const (
 	StoredDiscord
 	StoredTelegram
 	StoredMatrix
+	StoredSignal
+	StoredSMS
+	StoredPush
 	StoredInvites
 	StoredAnnouncements
 	StoredExpiries
@@ -128,6 +184,12 @@ func (st *Storage) DebugWatch(storeType StoreType, key, mainData string) {
 		actionKey = "telegram"
 	case StoredMatrix:
 		actionKey = "matrix"
+	case StoredSignal:
+		actionKey = "signal"
+	case StoredSMS:
+		actionKey = "sms"
+	case StoredPush:
+		actionKey = "push"
 	case StoredInvites:
 		actionKey = "invites"
 	case StoredAnnouncements:
@@ -155,7 +217,7 @@ func (st *Storage) DebugWatch(storeType StoreType, key, mainData string) {
 
 func generateLogActions(c *ini.File) map[string]DebugLogAction {
 	m := map[string]DebugLogAction{}
-	for _, v := range []string{"emails", "discord", "telegram", "matrix", "invites", "announcements", "expirires", "profiles", "custom_content"} {
+	for _, v := range []string{"emails", "discord", "telegram", "matrix", "signal", "sms", "push", "invites", "announcements", "expirires", "profiles", "custom_content"} {
 		switch c.Section("advanced").Key("debug_log_" + v).MustString("none") {
 		case "none":
 			m[v] = NoLog
@@ -294,6 +356,121 @@ func (st *Storage) DeleteTelegramKey(k string) {
 	st.db.Delete(k, TelegramUser{})
 }
 
+// GetSignal returns a copy of the store.
+func (st *Storage) GetSignal() []SignalUser {
+	result := []SignalUser{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find users: %v\n", err)
+	}
+	return result
+}
+
+// GetSignalKey returns the value stored in the store's key.
+func (st *Storage) GetSignalKey(k string) (SignalUser, bool) {
+	result := SignalUser{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		// fmt.Printf("Failed to find user: %v\n", err)
+		ok = false
+	}
+	return result, ok
+}
+
+// SetSignalKey stores value v in key k.
+func (st *Storage) SetSignalKey(k string, v SignalUser) {
+	st.DebugWatch(StoredSignal, k, v.Number)
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set user: %v\n", err)
+	}
+}
+
+// DeleteSignalKey deletes value at key k.
+func (st *Storage) DeleteSignalKey(k string) {
+	st.DebugWatch(StoredSignal, k, "")
+	st.db.Delete(k, SignalUser{})
+}
+
+// GetSMS returns a copy of the store.
+func (st *Storage) GetSMS() []SMSNumber {
+	result := []SMSNumber{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find users: %v\n", err)
+	}
+	return result
+}
+
+// GetSMSKey returns the value stored in the store's key.
+func (st *Storage) GetSMSKey(k string) (SMSNumber, bool) {
+	result := SMSNumber{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		// fmt.Printf("Failed to find user: %v\n", err)
+		ok = false
+	}
+	return result, ok
+}
+
+// SetSMSKey stores value v in key k.
+func (st *Storage) SetSMSKey(k string, v SMSNumber) {
+	st.DebugWatch(StoredSMS, k, v.Number)
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set user: %v\n", err)
+	}
+}
+
+// DeleteSMSKey deletes value at key k.
+func (st *Storage) DeleteSMSKey(k string) {
+	st.DebugWatch(StoredSMS, k, "")
+	st.db.Delete(k, SMSNumber{})
+}
+
+// GetPush returns a copy of the store.
+func (st *Storage) GetPush() []PushTarget {
+	result := []PushTarget{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find users: %v\n", err)
+	}
+	return result
+}
+
+// GetPushKey returns the value stored in the store's key.
+func (st *Storage) GetPushKey(k string) (PushTarget, bool) {
+	result := PushTarget{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		// fmt.Printf("Failed to find user: %v\n", err)
+		ok = false
+	}
+	return result, ok
+}
+
+// SetPushKey stores value v in key k.
+func (st *Storage) SetPushKey(k string, v PushTarget) {
+	st.DebugWatch(StoredPush, k, v.Display())
+	v.JellyfinID = k
+	v.Address = pushAddress(v.Service, v.Target)
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set user: %v\n", err)
+	}
+}
+
+// DeletePushKey deletes value at key k.
+func (st *Storage) DeletePushKey(k string) {
+	st.DebugWatch(StoredPush, k, "")
+	st.db.Delete(k, PushTarget{})
+}
+
 // GetMatrix returns a copy of the store.
 func (st *Storage) GetMatrix() []MatrixUser {
 	result := []MatrixUser{}
@@ -445,6 +622,191 @@ func (st *Storage) DeleteUserExpiryKey(k string) {
 	st.db.Delete(k, UserExpiry{})
 }
 
+// GetUserProfiles returns a copy of the store.
+func (st *Storage) GetUserProfiles() []UserProfile {
+	result := []UserProfile{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find user profiles: %v\n", err)
+	}
+	return result
+}
+
+// GetUserProfileKey returns the value stored in the store's key.
+func (st *Storage) GetUserProfileKey(k string) (UserProfile, bool) {
+	result := UserProfile{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetUserProfileKey stores value v in key k.
+func (st *Storage) SetUserProfileKey(k string, v UserProfile) {
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set user profile: %v\n", err)
+	}
+}
+
+// DeleteUserProfileKey deletes value at key k.
+func (st *Storage) DeleteUserProfileKey(k string) {
+	st.db.Delete(k, UserProfile{})
+}
+
+// GetUserLanguageKey returns the value stored in the store's key.
+func (st *Storage) GetUserLanguageKey(k string) (UserLanguage, bool) {
+	result := UserLanguage{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetUserLanguageKey stores value v in key k.
+func (st *Storage) SetUserLanguageKey(k string, v UserLanguage) {
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set user language: %v\n", err)
+	}
+}
+
+// DeleteUserLanguageKey deletes value at key k.
+func (st *Storage) DeleteUserLanguageKey(k string) {
+	st.db.Delete(k, UserLanguage{})
+}
+
+// GetSigningKeyKey returns the value stored in the store's key.
+func (st *Storage) GetSigningKeyKey(k string) (SigningKey, bool) {
+	result := SigningKey{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetSigningKeyKey stores value v in key k.
+func (st *Storage) SetSigningKeyKey(k string, v SigningKey) {
+	v.Name = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set signing key: %v\n", err)
+	}
+}
+
+// GetExtensionCodes returns a copy of the store.
+func (st *Storage) GetExtensionCodes() []ExtensionCode {
+	result := []ExtensionCode{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find extension codes: %v\n", err)
+	}
+	return result
+}
+
+// GetExtensionCodeKey returns the value stored in the store's key.
+func (st *Storage) GetExtensionCodeKey(k string) (ExtensionCode, bool) {
+	result := ExtensionCode{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetExtensionCodeKey stores value v in key k.
+func (st *Storage) SetExtensionCodeKey(k string, v ExtensionCode) {
+	v.Code = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set extension code: %v\n", err)
+	}
+}
+
+// DeleteExtensionCodeKey deletes value at key k.
+func (st *Storage) DeleteExtensionCodeKey(k string) {
+	st.db.Delete(k, ExtensionCode{})
+}
+
+// GetUsernameRequests returns a copy of the store.
+func (st *Storage) GetUsernameRequests() []UsernameRequest {
+	result := []UsernameRequest{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find username requests: %v\n", err)
+	}
+	return result
+}
+
+// GetUsernameRequestKey returns the value stored in the store's key.
+func (st *Storage) GetUsernameRequestKey(k string) (UsernameRequest, bool) {
+	result := UsernameRequest{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetUsernameRequestKey stores value v in key k.
+func (st *Storage) SetUsernameRequestKey(k string, v UsernameRequest) {
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set username request: %v\n", err)
+	}
+}
+
+// DeleteUsernameRequestKey deletes value at key k.
+func (st *Storage) DeleteUsernameRequestKey(k string) {
+	st.db.Delete(k, UsernameRequest{})
+}
+
+// GetNotificationRoutes returns a copy of the store.
+func (st *Storage) GetNotificationRoutes() []NotificationRoute {
+	result := []NotificationRoute{}
+	err := st.db.Find(&result, &badgerhold.Query{})
+	if err != nil {
+		// fmt.Printf("Failed to find notification routes: %v\n", err)
+	}
+	return result
+}
+
+// GetNotificationRouteKey returns the value stored in the store's key.
+func (st *Storage) GetNotificationRouteKey(k string) (NotificationRoute, bool) {
+	result := NotificationRoute{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetNotificationRouteKey stores value v in key k.
+func (st *Storage) SetNotificationRouteKey(k string, v NotificationRoute) {
+	v.Event = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set notification route: %v\n", err)
+	}
+}
+
+// DeleteNotificationRouteKey deletes value at key k.
+func (st *Storage) DeleteNotificationRouteKey(k string) {
+	st.db.Delete(k, NotificationRoute{})
+}
+
 // GetProfiles returns a copy of the store.
 func (st *Storage) GetProfiles() []Profile {
 	result := []Profile{}
@@ -582,6 +944,132 @@ func (st *Storage) DeleteActivityKey(k string) {
 	st.db.Delete(k, Activity{})
 }
 
+// GetAdminTOTPKey returns the value stored in the store's key.
+func (st *Storage) GetAdminTOTPKey(k string) (AdminTOTP, bool) {
+	result := AdminTOTP{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetAdminTOTPKey stores value v in key k.
+func (st *Storage) SetAdminTOTPKey(k string, v AdminTOTP) {
+	v.ID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set TOTP: %v\n", err)
+	}
+}
+
+// DeleteAdminTOTPKey deletes value at key k.
+func (st *Storage) DeleteAdminTOTPKey(k string) {
+	st.db.Delete(k, AdminTOTP{})
+}
+
+// GetPasskeysKey returns the value stored in the store's key.
+func (st *Storage) GetPasskeysKey(k string) (Passkeys, bool) {
+	result := Passkeys{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetPasskeysKey stores value v in key k.
+func (st *Storage) SetPasskeysKey(k string, v Passkeys) {
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set passkeys: %v\n", err)
+	}
+}
+
+// DeletePasskeysKey deletes value at key k.
+func (st *Storage) DeletePasskeysKey(k string) {
+	st.db.Delete(k, Passkeys{})
+}
+
+// GetReferrals returns all referrals matching the given query, or all of them if nil.
+func (st *Storage) GetReferrals(query *badgerhold.Query) []Referral {
+	result := []Referral{}
+	if query == nil {
+		query = &badgerhold.Query{}
+	}
+	err := st.db.Find(&result, query)
+	if err != nil {
+		// fmt.Printf("Failed to find referrals: %v\n", err)
+	}
+	return result
+}
+
+// GetReferralKey returns the value stored in the store's key.
+func (st *Storage) GetReferralKey(k string) (Referral, bool) {
+	result := Referral{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetReferralKey stores value v in key k.
+func (st *Storage) SetReferralKey(k string, v Referral) {
+	v.JellyfinID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set referral: %v\n", err)
+	}
+}
+
+// DeleteReferralKey deletes value at key k.
+func (st *Storage) DeleteReferralKey(k string) {
+	st.db.Delete(k, Referral{})
+}
+
+// GetSessions returns all sessions matching the given query, or all of them if nil.
+func (st *Storage) GetSessions(query *badgerhold.Query) []Session {
+	result := []Session{}
+	if query == nil {
+		query = &badgerhold.Query{}
+	}
+	err := st.db.Find(&result, query)
+	if err != nil {
+		// fmt.Printf("Failed to find sessions: %v\n", err)
+	}
+	return result
+}
+
+// GetSessionsKey returns the value stored in the store's key.
+func (st *Storage) GetSessionsKey(k string) (Session, bool) {
+	result := Session{}
+	err := st.db.Get(k, &result)
+	ok := true
+	if err != nil {
+		ok = false
+	}
+	return result, ok
+}
+
+// SetSessionsKey stores value v in key k.
+func (st *Storage) SetSessionsKey(k string, v Session) {
+	v.ID = k
+	err := st.db.Upsert(k, v)
+	if err != nil {
+		// fmt.Printf("Failed to set session: %v\n", err)
+	}
+}
+
+// DeleteSessionsKey deletes value at key k.
+func (st *Storage) DeleteSessionsKey(k string) {
+	st.db.Delete(k, Session{})
+}
+
 type TelegramUser struct {
 	JellyfinID string `badgerhold:"key"`
 	ChatID     int64  `badgerhold:"index"`
@@ -607,6 +1095,8 @@ type EmailAddress struct {
 	Admin               bool   // Whether or not user is jfa-go admin.
 	JellyfinID          string `badgerhold:"key"`
 	ReferralTemplateKey string
+	Unsubscribed        bool   // Whether Contact was turned off through a List-Unsubscribe link, so only bulk messages are stopped.
+	Lang                string // Language chosen on the form when signing up, if any.
 }
 
 type customEmails struct {
@@ -639,17 +1129,22 @@ type userPageContent struct {
 // timePattern: %Y-%m-%dT%H:%M:%S.%f
 
 type Profile struct {
-	Name                string                     `badgerhold:"key"`
-	Admin               bool                       `json:"admin,omitempty" badgerhold:"index"`
-	LibraryAccess       string                     `json:"libraries,omitempty"`
-	FromUser            string                     `json:"fromUser,omitempty"`
-	Homescreen          bool                       `json:"homescreen"`
-	Policy              mediabrowser.Policy        `json:"policy,omitempty"`
-	Configuration       mediabrowser.Configuration `json:"configuration,omitempty"`
-	Displayprefs        map[string]interface{}     `json:"displayprefs,omitempty"`
-	Default             bool                       `json:"default,omitempty"`
-	Ombi                map[string]interface{}     `json:"ombi,omitempty"`
-	ReferralTemplateKey string
+	Name                   string                            `badgerhold:"key"`
+	Admin                  bool                              `json:"admin,omitempty" badgerhold:"index"`
+	LibraryAccess          string                            `json:"libraries,omitempty"`
+	FromUser               string                            `json:"fromUser,omitempty"`
+	Homescreen             bool                              `json:"homescreen"`
+	Policy                 mediabrowser.Policy               `json:"policy,omitempty"`
+	Configuration          mediabrowser.Configuration        `json:"configuration,omitempty"`
+	Displayprefs           map[string]interface{}            `json:"displayprefs,omitempty"`
+	Default                bool                              `json:"default,omitempty"`
+	Ombi                   map[string]interface{}            `json:"ombi,omitempty"`             // Deprecated: moved to RequestManagers["ombi"].
+	RequestManagers        map[string]map[string]interface{} `json:"request_managers,omitempty"` // Map of request manager names to user templates.
+	ReferralTemplateKey    string
+	ReferralMonthlyQuota   int `json:"referral_monthly_quota,omitempty"`   // Max referral signups per user in the last 30 days, 0 for no limit.
+	ReferralMaxOutstanding int `json:"referral_max_outstanding,omitempty"` // Max uses a user's referral invite can have at once, 0 to use the template's.
+	MaxStreams             int `json:"max_streams,omitempty"`              // Max simultaneous streams per user, enforced by the stream limiter. 0 for no limit.
+	MaxDevices             int `json:"max_devices,omitempty"`              // Max signed-in devices per user, enforced by the stream limiter. 0 for no limit.
 }
 
 type Invite struct {
@@ -674,6 +1169,7 @@ type Invite struct {
 	IsReferral         bool                       `json:"is_referral" badgerhold:"index"`
 	ReferrerJellyfinID string                     `json:"referrer_id"`
 	UseReferralExpiry  bool                       `json:"use_referral_expiry"`
+	ReferrerProfile    string                     `json:"referrer_profile,omitempty"` // Profile the referral was sourced from, whose quotas apply.
 }
 
 type Captcha struct {
@@ -1229,6 +1725,12 @@ func (st *Storage) loadLangEmail(filesystems ...fs.FS) error {
 					patchLang(&lang.WelcomeEmail, &fallback.WelcomeEmail, &english.WelcomeEmail)
 					patchLang(&lang.EmailConfirmation, &fallback.EmailConfirmation, &english.EmailConfirmation)
 					patchLang(&lang.UserExpired, &fallback.UserExpired, &english.UserExpired)
+					patchLang(&lang.ExpiryExtended, &fallback.ExpiryExtended, &english.ExpiryExtended)
+					patchLang(&lang.UsernameChanged, &fallback.UsernameChanged, &english.UsernameChanged)
+					patchLang(&lang.StreamLimit, &fallback.StreamLimit, &english.StreamLimit)
+					patchLang(&lang.WatchTimeReport, &fallback.WatchTimeReport, &english.WatchTimeReport)
+					patchLang(&lang.AdminDigest, &fallback.AdminDigest, &english.AdminDigest)
+					patchLang(&lang.AdminNotifications, &fallback.AdminNotifications, &english.AdminNotifications)
 					patchLang(&lang.Strings, &fallback.Strings, &english.Strings)
 				}
 			}
@@ -1243,6 +1745,12 @@ func (st *Storage) loadLangEmail(filesystems ...fs.FS) error {
 				patchLang(&lang.WelcomeEmail, &english.WelcomeEmail)
 				patchLang(&lang.EmailConfirmation, &english.EmailConfirmation)
 				patchLang(&lang.UserExpired, &english.UserExpired)
+				patchLang(&lang.ExpiryExtended, &english.ExpiryExtended)
+				patchLang(&lang.UsernameChanged, &english.UsernameChanged)
+				patchLang(&lang.StreamLimit, &english.StreamLimit)
+				patchLang(&lang.WatchTimeReport, &english.WatchTimeReport)
+				patchLang(&lang.AdminDigest, &english.AdminDigest)
+				patchLang(&lang.AdminNotifications, &english.AdminNotifications)
 				patchLang(&lang.Strings, &english.Strings)
 			}
 		}
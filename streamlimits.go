@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lithammer/shortuuid/v3"
+)
+
+const (
+	LIMIT_STREAMS = "streams"
+	LIMIT_DEVICES = "devices"
+	// Device ID app.authJf logs users in to jfa-go with, which shouldn't count towards their limit.
+	AUTH_DEVICE_ID = "auth"
+)
+
+// streamLimitBackend is what the stream limiter needs from Jellyfin, so it can be replaced with a stub.
+type streamLimitBackend interface {
+	Sessions() ([]jfSession, error)
+	Devices() ([]jfDevice, error)
+	StopSession(sessionID string) error
+	RemoveDevice(deviceID string) error
+	SendMessage(sessionID, header, text string) error
+}
+
+// jellyfinLimitBackend implements streamLimitBackend with the Jellyfin API.
+type jellyfinLimitBackend struct {
+	app *appContext
+}
+
+func (jb jellyfinLimitBackend) Sessions() ([]jfSession, error) { return jb.app.getJellyfinSessions() }
+func (jb jellyfinLimitBackend) Devices() ([]jfDevice, error)   { return jb.app.getJellyfinDevices("") }
+func (jb jellyfinLimitBackend) StopSession(sessionID string) error {
+	return jb.app.stopJellyfinSession(sessionID)
+}
+func (jb jellyfinLimitBackend) RemoveDevice(deviceID string) error {
+	return jb.app.deleteJellyfinDevice(deviceID)
+}
+func (jb jellyfinLimitBackend) SendMessage(sessionID, header, text string) error {
+	return jb.app.messageJellyfinSession(sessionID, header, text, 15*time.Second)
+}
+
+// StreamLimiter enforces the max streams & devices set on profiles. Users over a limit are warned,
+// and if they're still over it after the grace period, their newest streams/devices are stopped/signed out.
+type StreamLimiter struct {
+	app            *appContext
+	backend        streamLimitBackend
+	grace          time.Duration
+	notifySessions bool
+	overSince      map[string]time.Time // "<limit>:<user ID>" to when the user went over the limit.
+	firstPlaying   map[string]time.Time // Session IDs to when they were first seen playing something.
+}
+
+func newStreamLimiter(app *appContext, backend streamLimitBackend) *StreamLimiter {
+	section := app.config.Section("stream_limits")
+	return &StreamLimiter{
+		app:            app,
+		backend:        backend,
+		grace:          time.Duration(section.Key("grace_minutes").MustInt(5)) * time.Minute,
+		notifySessions: section.Key("notify_sessions").MustBool(true),
+		overSince:      map[string]time.Time{},
+		firstPlaying:   map[string]time.Time{},
+	}
+}
+
+// userLimits returns the profile of each user whose profile sets a limit.
+func (sl *StreamLimiter) userLimits() map[string]Profile {
+	profiles := map[string]Profile{}
+	for _, p := range sl.app.storage.GetProfiles() {
+		if p.MaxStreams > 0 || p.MaxDevices > 0 {
+			profiles[p.Name] = p
+		}
+	}
+	limits := map[string]Profile{}
+	if len(profiles) == 0 {
+		return limits
+	}
+	for _, up := range sl.app.storage.GetUserProfiles() {
+		if p, ok := profiles[up.Profile]; ok {
+			limits[up.JellyfinID] = p
+		}
+	}
+	return limits
+}
+
+// check is run by the housekeeping daemon.
+func (sl *StreamLimiter) check() {
+	limits := sl.userLimits()
+	if len(limits) == 0 {
+		return
+	}
+	now := time.Now()
+	checkDevices := false
+	for _, p := range limits {
+		if p.MaxDevices > 0 {
+			checkDevices = true
+			break
+		}
+	}
+
+	sessions, err := sl.backend.Sessions()
+	if err != nil {
+		sl.app.err.Printf("Stream limits: Failed to get sessions: %v", err)
+		return
+	}
+	playing := map[string][]jfSession{}
+	stillPlaying := map[string]bool{}
+	for _, s := range sessions {
+		if s.NowPlayingItem == nil {
+			continue
+		}
+		stillPlaying[s.ID] = true
+		if _, ok := sl.firstPlaying[s.ID]; !ok {
+			sl.firstPlaying[s.ID] = now
+		}
+		if _, ok := limits[s.UserID]; ok {
+			playing[s.UserID] = append(playing[s.UserID], s)
+		}
+	}
+	for id := range sl.firstPlaying {
+		if !stillPlaying[id] {
+			delete(sl.firstPlaying, id)
+		}
+	}
+
+	devices := map[string][]jfDevice{}
+	if checkDevices {
+		allDevices, err := sl.backend.Devices()
+		if err != nil {
+			sl.app.err.Printf("Stream limits: Failed to get devices: %v", err)
+			checkDevices = false
+		}
+		for _, d := range allDevices {
+			if d.ID == AUTH_DEVICE_ID {
+				continue
+			}
+			devices[d.LastUserID] = append(devices[d.LastUserID], d)
+		}
+	}
+
+	for userID, p := range limits {
+		if p.MaxStreams > 0 {
+			streams := playing[userID]
+			// Newest first.
+			sort.Slice(streams, func(i, j int) bool { return sl.firstPlaying[streams[i].ID].After(sl.firstPlaying[streams[j].ID]) })
+			username := ""
+			if len(streams) != 0 {
+				username = streams[0].UserName
+			}
+			sl.enforce(userID, username, LIMIT_STREAMS, len(streams), p.MaxStreams, now, streams, func(excess int) {
+				for _, s := range streams[:excess] {
+					if err := sl.backend.StopSession(s.ID); err != nil {
+						sl.app.err.Printf("Stream limits: Failed to stop session \"%s\" of \"%s\": %v", s.ID, s.UserName, err)
+					}
+				}
+			})
+		}
+		if p.MaxDevices > 0 && checkDevices {
+			userDevices := devices[userID]
+			sort.Slice(userDevices, func(i, j int) bool {
+				return userDevices[i].DateLastActivity.After(userDevices[j].DateLastActivity.Time.Time)
+			})
+			username := ""
+			if len(userDevices) != 0 {
+				username = userDevices[0].LastUserName
+			}
+			var userSessions []jfSession
+			for _, s := range sessions {
+				if s.UserID == userID {
+					userSessions = append(userSessions, s)
+				}
+			}
+			sl.enforce(userID, username, LIMIT_DEVICES, len(userDevices), p.MaxDevices, now, userSessions, func(excess int) {
+				for _, d := range userDevices[:excess] {
+					if err := sl.backend.RemoveDevice(d.ID); err != nil {
+						sl.app.err.Printf("Stream limits: Failed to sign out device \"%s\" of \"%s\": %v", d.Name, d.LastUserName, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// enforce warns the user the first time they're seen over the limit, and calls stop with the number to remove once the grace period is up.
+func (sl *StreamLimiter) enforce(userID, username, limit string, count, max int, now time.Time, sessions []jfSession, stop func(excess int)) {
+	key := limit + ":" + userID
+	if count <= max {
+		delete(sl.overSince, key)
+		return
+	}
+	since, ok := sl.overSince[key]
+	if !ok {
+		sl.overSince[key] = now
+		sl.warn(userID, username, limit, count, max, sessions)
+		return
+	}
+	if now.Sub(since) < sl.grace {
+		return
+	}
+	stop(count - max)
+	delete(sl.overSince, key)
+	sl.app.info.Printf("Stream limits: Removed %d of %d %s for \"%s\", over their limit of %d", count-max, count, limit, username, max)
+	sl.app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityStreamLimit,
+		UserID:     userID,
+		SourceType: ActivityDaemon,
+		Value:      limit,
+		Time:       now,
+	}, nil, false)
+}
+
+func (sl *StreamLimiter) warn(userID, username, limit string, count, max int, sessions []jfSession) {
+	sl.app.debug.Printf("Stream limits: \"%s\" is over their limit of %d %s (%d)", username, max, limit, count)
+	minutes := int(sl.grace.Minutes())
+	if messagesEnabled {
+		msg, err := sl.app.emailFor(userID).constructStreamLimitWarning(username, limit, count, max, minutes, sl.app)
+		if err != nil {
+			sl.app.err.Printf("Stream limits: Failed to construct warning for \"%s\": %v", username, err)
+		} else if err := sl.app.sendByID(msg, userID); err != nil {
+			sl.app.err.Printf("Stream limits: Failed to send warning to \"%s\": %v", username, err)
+		}
+	}
+	if !sl.notifySessions {
+		return
+	}
+	header := sl.app.email.lang.StreamLimit.get("header")
+	text := sl.app.email.streamLimitText(limit, count, max, minutes)
+	for _, s := range sessions {
+		if err := sl.backend.SendMessage(s.ID, header, text); err != nil {
+			sl.app.debug.Printf("Stream limits: Failed to show warning on session \"%s\": %v", s.ID, err)
+		}
+	}
+}
+
+// loadStreamLimiter sets up the stream limiter if enabled.
+func (app *appContext) loadStreamLimiter() {
+	if !app.config.Section("stream_limits").Key("enabled").MustBool(false) {
+		return
+	}
+	app.streamLimiter = newStreamLimiter(app, jellyfinLimitBackend{app: app})
+	app.debug.Printf("Stream limits: Enabled with a grace period of %s", app.streamLimiter.grace)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+// stubLimitBackend is a streamLimitBackend with fixed sessions and devices, recording what the limiter does.
+type stubLimitBackend struct {
+	sessions []jfSession
+	devices  []jfDevice
+	stopped  []string
+	removed  []string
+}
+
+func (sb *stubLimitBackend) Sessions() ([]jfSession, error) { return sb.sessions, nil }
+func (sb *stubLimitBackend) Devices() ([]jfDevice, error)   { return sb.devices, nil }
+func (sb *stubLimitBackend) StopSession(sessionID string) error {
+	sb.stopped = append(sb.stopped, sessionID)
+	return nil
+}
+func (sb *stubLimitBackend) RemoveDevice(deviceID string) error {
+	sb.removed = append(sb.removed, deviceID)
+	return nil
+}
+func (sb *stubLimitBackend) SendMessage(sessionID, header, text string) error { return nil }
+
+func newTestStreamLimiter(t *testing.T, backend streamLimitBackend) *StreamLimiter {
+	app, _ := newTestApp(t, "[stream_limits]\nenabled = true\ngrace_minutes = 0\nnotify_sessions = false\n")
+	app.storage.SetProfileKey("limited", Profile{MaxStreams: 1, MaxDevices: 2})
+	app.storage.SetUserProfileKey("limiteduser", UserProfile{Profile: "limited"})
+	return newStreamLimiter(app, backend)
+}
+
+func playingSession(id, userID string) jfSession {
+	return jfSession{ID: id, UserID: userID, UserName: userID, NowPlayingItem: &jfItem{ID: "item"}}
+}
+
+func deviceAt(id, userID string, t time.Time) jfDevice {
+	return jfDevice{ID: id, LastUserID: userID, LastUserName: userID, DateLastActivity: jfTime{mediabrowser.Time{Time: t}}}
+}
+
+func TestStreamLimits(t *testing.T) {
+	backend := &stubLimitBackend{
+		sessions: []jfSession{
+			playingSession("first", "limiteduser"),
+			playingSession("unlimited", "otheruser"),
+			{ID: "idle", UserID: "limiteduser"},
+		},
+	}
+	sl := newTestStreamLimiter(t, backend)
+	sl.check()
+	// A second stream starts after the first.
+	backend.sessions = append(backend.sessions, playingSession("second", "limiteduser"))
+	sl.firstPlaying["first"] = time.Now().Add(-time.Hour)
+	sl.check()
+	if len(backend.stopped) != 0 {
+		t.Fatalf("stopped %v without a warning first", backend.stopped)
+	}
+	sl.check()
+	if len(backend.stopped) != 1 || backend.stopped[0] != "second" {
+		t.Errorf("expected the newest stream to be stopped, got %v", backend.stopped)
+	}
+	if n, _ := sl.app.storage.db.Count(&Activity{}, nil); n != 1 {
+		t.Error("stopping streams wasn't logged")
+	}
+}
+
+func TestDeviceLimits(t *testing.T) {
+	now := time.Now()
+	backend := &stubLimitBackend{
+		devices: []jfDevice{
+			deviceAt("old", "limiteduser", now.Add(-2*time.Hour)),
+			deviceAt("newest", "limiteduser", now),
+			deviceAt("newer", "limiteduser", now.Add(-time.Hour)),
+			// jfa-go's own logins and other users' devices don't count.
+			deviceAt(AUTH_DEVICE_ID, "limiteduser", now),
+			deviceAt("other", "otheruser", now),
+		},
+	}
+	sl := newTestStreamLimiter(t, backend)
+	sl.check()
+	sl.check()
+	if len(backend.removed) != 1 || backend.removed[0] != "newest" {
+		t.Errorf("expected the newest device to be signed out, got %v", backend.removed)
+	}
+	// Back under the limit, so the next time over needs a new warning.
+	backend.devices = backend.devices[:1]
+	backend.removed = nil
+	sl.check()
+	backend.devices = append(backend.devices, deviceAt("a", "limiteduser", now), deviceAt("b", "limiteduser", now))
+	sl.check()
+	if len(backend.removed) != 0 {
+		t.Errorf("signed out %v without a warning", backend.removed)
+	}
+	sl.check()
+	if len(backend.removed) != 1 || backend.removed[0] == "old" {
+		t.Errorf("expected a new device to be signed out, got %v", backend.removed)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as described in RFC 6238, with the defaults most authenticator apps expect (SHA1, 6 digits, 30s period).
+const (
+	TOTP_PERIOD_SEC      = 30
+	TOTP_DIGITS          = 6
+	TOTP_SECRET_LENGTH   = 20
+	TOTP_SKEW            = 1 // Number of periods either side of the current one which are also accepted.
+	TOTP_RECOVERY_CODES  = 10
+	TOTP_CHALLENGE_VALID = 5 * 60
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// AdminTOTP stores an admin's TOTP secret and recovery codes.
+// Keyed by the admin's Jellyfin ID, or "local" for the admin set in the "ui" section.
+type AdminTOTP struct {
+	ID            string `badgerhold:"key"`
+	Secret        string
+	Enabled       bool     // False until the admin has confirmed enrolment with a valid code.
+	RecoveryCodes []string // SHA256 hashes of unused recovery codes.
+	LastCounter   int64    // Counter of the last accepted code, so it can't be reused.
+	Enrolled      time.Time
+}
+
+// adminTOTPKey returns the storage key for the admin with the given Jellyfin ID.
+func adminTOTPKey(jfID string) string {
+	if jfID == "" {
+		return "local"
+	}
+	return jfID
+}
+
+// newTOTPSecret generates a new, base32 encoded TOTP secret.
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_LENGTH)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURL returns an otpauth:// URL for the given secret, for use in a QR code.
+func totpURL(secret, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", "jfa-go")
+	v.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	v.Set("period", fmt.Sprint(TOTP_PERIOD_SEC))
+	return "otpauth://totp/" + url.PathEscape("jfa-go:"+account) + "?" + v.Encode()
+}
+
+// totpCode returns the code for the given secret and counter.
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod), nil
+}
+
+// validateTOTP checks the code against the secret at time t, returning the matching counter.
+// Codes for counters at or below lastCounter are rejected to prevent reuse.
+func validateTOTP(secret, code string, t time.Time, lastCounter int64) (counter int64, ok bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TOTP_DIGITS {
+		return
+	}
+	current := t.Unix() / TOTP_PERIOD_SEC
+	for c := current - TOTP_SKEW; c <= current+TOTP_SKEW; c++ {
+		if c <= lastCounter {
+			continue
+		}
+		expected, err := totpCode(secret, c)
+		if err != nil {
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns a set of recovery codes, and their hashes for storage.
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	codes = make([]string, TOTP_RECOVERY_CODES)
+	hashes = make([]string, TOTP_RECOVERY_CODES)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+		code := strings.ToLower(hex.EncodeToString(b))
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return
+}
+
+// useRecoveryCode removes the given code from the store if present, returning whether it was valid.
+func (t *AdminTOTP) useRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, h := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i], t.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// check validates a TOTP or recovery code, updating the store's state if successful. The caller should store the result.
+func (t *AdminTOTP) check(code string) bool {
+	if counter, ok := validateTOTP(t.Secret, code, time.Now(), t.LastCounter); ok {
+		t.LastCounter = counter
+		return true
+	}
+	return t.useRecoveryCode(code)
+}
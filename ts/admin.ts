@@ -7,6 +7,8 @@ import { accountsList } from "./modules/accounts.js";
 import { settingsList } from "./modules/settings.js";
 import { activityList } from "./modules/activity.js";
 import { ProfileEditor } from "./modules/profiles.js";
+import { ExtensionCodeEditor } from "./modules/extensioncodes.js";
+import { AdminNotificationEditor } from "./modules/adminnotifications.js";
 import { _get, _post, notificationBox, whichAnimationEvent, bindManualDropdowns } from "./modules/common.js";
 import { Updater } from "./modules/update.js";
 import { Login } from "./modules/login.js";
@@ -37,10 +39,14 @@ window.availableProfiles = window.availableProfiles || [];
     window.modals.addUser = new Modal(document.getElementById('modal-add-user'));
 
     window.modals.about = new Modal(document.getElementById('modal-about'));
+
+    window.modals.extensionCodes = new Modal(document.getElementById('modal-extension-codes'));
     (document.getElementById('setting-about') as HTMLSpanElement).onclick = window.modals.about.toggle;
 
     window.modals.modifyUser = new Modal(document.getElementById('modal-modify-user'));
 
+    window.modals.renameUsers = new Modal(document.getElementById('modal-rename-users'));
+
     window.modals.deleteUser = new Modal(document.getElementById('modal-delete-user'));
 
     window.modals.settingsRestart = new Modal(document.getElementById('modal-restart'));
@@ -72,6 +78,8 @@ window.availableProfiles = window.availableProfiles || [];
 
     window.modals.backups = new Modal(document.getElementById("modal-backups"));
 
+    window.modals.adminNotifications = new Modal(document.getElementById("modal-admin-notifications"));
+
     if (window.telegramEnabled) {
         window.modals.telegram = new Modal(document.getElementById("modal-telegram"));
     }
@@ -87,6 +95,7 @@ window.availableProfiles = window.availableProfiles || [];
     if (window.referralsEnabled) {
         window.modals.enableReferralsUser = new Modal(document.getElementById("modal-enable-referrals-user"));
         window.modals.enableReferralsProfile = new Modal(document.getElementById("modal-enable-referrals-profile"));
+        window.modals.disableReferralsUser = new Modal(document.getElementById("modal-disable-referrals-user"));
     }
 })();
 
@@ -102,6 +111,10 @@ var settings = new settingsList();
 
 var profiles = new ProfileEditor();
 
+var extensionCodes = new ExtensionCodeEditor();
+
+var adminNotifications = new AdminNotificationEditor();
+
 window.notifications = new notificationBox(document.getElementById('notification-box') as HTMLDivElement, 5);
 
 /*const modifySettingsSource = function () {
@@ -3,7 +3,7 @@ import { notificationBox, whichAnimationEvent } from "./modules/common.js";
 import { _get, _post, toggleLoader, addLoader, removeLoader, toDateString } from "./modules/common.js";
 import { loadLangSelector } from "./modules/lang.js";
 import { Validator, ValidatorConf, ValidatorRespDTO } from "./modules/validator.js";
-import { Discord, Telegram, Matrix, ServiceConfiguration, MatrixConfiguration } from "./modules/account-linking.js";
+import { Discord, Telegram, Matrix, Signal, SMS, ServiceConfiguration, MatrixConfiguration, SMSConfiguration } from "./modules/account-linking.js";
 import { Captcha, GreCAPTCHA } from "./modules/captcha.js";
 
 interface formWindow extends Window {
@@ -12,6 +12,8 @@ interface formWindow extends Window {
     telegramModal: Modal;
     discordModal: Modal;
     matrixModal: Modal;
+    signalModal: Modal;
+    smsModal: Modal;
     confirmationModal: Modal;
     redirectToJellyfin: boolean;
     code: string;
@@ -26,6 +28,9 @@ interface formWindow extends Window {
     discordServerName: string;
     matrixRequired: boolean;
     matrixUserID: string;
+    signalRequired: boolean;
+    signalPIN: string;
+    smsRequired: boolean;
     userExpiryEnabled: boolean;
     userExpiryMonths: number;
     userExpiryDays: number;
@@ -144,6 +149,71 @@ if (window.matrixEnabled) {
     matrixButton.onclick = () => { matrix.show(); };
 }
 
+var signalVerified = false;
+if (window.signalEnabled) {
+    window.signalModal = new Modal(document.getElementById("modal-signal"), window.signalRequired);
+    const signalButton = document.getElementById("link-signal") as HTMLSpanElement;
+
+    const signalConf: ServiceConfiguration = {
+        modal: window.signalModal as Modal,
+        pin: window.signalPIN,
+        pinURL: "",
+        verifiedURL: "/invite/" + window.code + "/signal/verified/",
+        invalidCodeError: window.messages["errorInvalidPIN"],
+        accountLinkedError: window.messages["errorAccountLinked"],
+        successError: window.messages["verified"],
+        successFunc: (modalClosed: boolean) => {
+            if (modalClosed) return;
+            signalVerified = true;
+            signalButton.classList.add("unfocused");
+            document.getElementById("contact-via").classList.remove("unfocused");
+            document.getElementById("contact-via-email").parentElement.classList.remove("unfocused");
+            const checkbox = document.getElementById("contact-via-signal") as HTMLInputElement;
+            checkbox.parentElement.classList.remove("unfocused");
+            checkbox.checked = true;
+            validator.validate();
+        }
+    };
+
+    const signal = new Signal(signalConf);
+
+    signalButton.onclick = () => { signal.onclick(); };
+}
+
+var smsVerified = false;
+var smsPIN = "";
+if (window.smsEnabled) {
+    window.smsModal = new Modal(document.getElementById("modal-sms"), window.smsRequired);
+    const smsButton = document.getElementById("link-sms") as HTMLSpanElement;
+
+    const smsConf: SMSConfiguration = {
+        modal: window.smsModal as Modal,
+        sendMessageURL: "/invite/" + window.code + "/sms/number",
+        verifiedURL: "/invite/" + window.code + "/sms/verified/",
+        invalidCodeError: window.messages["errorInvalidPIN"],
+        invalidNumberError: window.messages["errorInvalidNumber"],
+        tooManyPINsError: window.messages["errorTooManyPINs"],
+        accountLinkedError: window.messages["errorAccountLinked"],
+        unknownError: window.messages["errorUnknown"],
+        successError: window.messages["verified"],
+        successFunc: () => {
+            smsVerified = true;
+            smsPIN = sms.pin;
+            smsButton.classList.add("unfocused");
+            document.getElementById("contact-via").classList.remove("unfocused");
+            document.getElementById("contact-via-email").parentElement.classList.remove("unfocused");
+            const checkbox = document.getElementById("contact-via-sms") as HTMLInputElement;
+            checkbox.parentElement.classList.remove("unfocused");
+            checkbox.checked = true;
+            validator.validate();
+        }
+    };
+
+    const sms = new SMS(smsConf);
+
+    smsButton.onclick = () => { sms.show(); };
+}
+
 if (window.confirmation) {
     window.confirmationModal = new Modal(document.getElementById("modal-confirmation"), true);
 }
@@ -194,6 +264,14 @@ function _baseValidator(oncomplete: (valid: boolean) => void, captchaValid: bool
         oncomplete(false);
         return;
     }
+    if (window.signalEnabled && window.signalRequired && !signalVerified) {
+        oncomplete(false);
+        return;
+    }
+    if (window.smsEnabled && window.smsRequired && !smsVerified) {
+        oncomplete(false);
+        return;
+    }
     if (window.captcha && !window.reCAPTCHA && !captchaValid) {
         oncomplete(false);
         return;
@@ -231,6 +309,10 @@ interface sendDTO {
     discord_contact?: boolean;
     matrix_pin?: string;
     matrix_contact?: boolean;
+    signal_pin?: string;
+    signal_contact?: boolean;
+    sms_pin?: string;
+    sms_contact?: boolean;
     captcha_id?: string;
     captcha_text?: string;
 }
@@ -274,6 +356,20 @@ const create = (event: SubmitEvent) => {
             send.matrix_contact = true;
         }
     }
+    if (signalVerified) {
+        send.signal_pin = window.signalPIN;
+        const checkbox = document.getElementById("contact-via-signal") as HTMLInputElement;
+        if (checkbox.checked) {
+            send.signal_contact = true;
+        }
+    }
+    if (smsVerified) {
+        send.sms_pin = smsPIN;
+        const checkbox = document.getElementById("contact-via-sms") as HTMLInputElement;
+        if (checkbox.checked) {
+            send.sms_contact = true;
+        }
+    }
     if (window.captcha) {
         if (window.reCAPTCHA) {
             send.captcha_text = grecaptcha.getResponse();
@@ -178,6 +178,14 @@ export class Telegram extends ServiceLinker {
     }
 };
 
+export class Signal extends ServiceLinker {
+    constructor(conf: ServiceConfiguration) {
+        super(conf);
+        this._name = "signal";
+        this._waiting = document.getElementById("signal-waiting") as HTMLSpanElement;
+    }
+};
+
 export interface MatrixConfiguration {
     modal: Modal;
     sendMessageURL: string;
@@ -269,3 +277,230 @@ export class Matrix {
     });
 }
 
+
+export interface SMSConfiguration {
+    modal: Modal;
+    sendMessageURL: string;
+    verifiedURL: string;
+    invalidCodeError: string;
+    invalidNumberError: string;
+    tooManyPINsError: string;
+    accountLinkedError: string;
+    unknownError: string;
+    successError: string;
+    successFunc: () => void;
+}
+
+// SMS works like Matrix: the user enters their number, is texted a PIN, and enters it in the same field.
+export class SMS {
+    private _conf: SMSConfiguration;
+    private _verified = false;
+    private _number: string = "";
+    private _pin: string = "";
+    private _input: HTMLInputElement;
+    private _submit: HTMLSpanElement;
+
+    get verified(): boolean { return this._verified; }
+    get pin(): string { return this._pin; }
+
+    constructor(conf: SMSConfiguration) {
+        this._conf = conf;
+        this._input = document.getElementById("sms-number") as HTMLInputElement;
+        this._submit = document.getElementById("sms-send") as HTMLSpanElement;
+        this._submit.onclick = () => { this._onclick(); };
+    }
+
+    private _onclick = () => {
+        addLoader(this._submit);
+        if (this._number == "") {
+            this._sendMessage();
+        } else {
+            this._verifyCode();
+        }
+    };
+
+    show = () => {
+        this._number = "";
+        this._input.value = "";
+        this._input.type = "tel";
+        this._input.placeholder = "+441234567890";
+        this._conf.modal.show();
+    }
+
+    private _sendMessage = () => _post(this._conf.sendMessageURL, { "number": this._input.value, "lang": window.language }, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        removeLoader(this._submit);
+        if (req.status == 400 && req.response["error"] == "errorInvalidNumber") {
+            window.notifications.customError("invalidNumberError", this._conf.invalidNumberError);
+            return;
+        } else if (req.status == 429) {
+            window.notifications.customError("tooManyPINsError", this._conf.tooManyPINsError);
+            return;
+        } else if (req.status == 400 && req.response["error"] == "errorAccountLinked") {
+            this._conf.modal.close();
+            window.notifications.customError("accountLinkedError", this._conf.accountLinkedError);
+            return;
+        } else if (req.status != 200) {
+            this._conf.modal.close();
+            window.notifications.customError("unknownError", this._conf.unknownError);
+            return;
+        }
+        this._number = this._input.value;
+        this._submit.classList.add("~positive");
+        this._submit.classList.remove("~info");
+        setTimeout(() => {
+            this._submit.classList.add("~info");
+            this._submit.classList.remove("~positive");
+        }, 2000);
+        this._input.type = "text";
+        this._input.placeholder = "PIN";
+        this._input.value = "";
+    });
+
+    private _verifyCode = () => _get(this._conf.verifiedURL + encodeURIComponent(this._number) + "/" + this._input.value, null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        removeLoader(this._submit);
+        const valid = req.response["success"] as boolean;
+        if (valid) {
+            this._conf.modal.close();
+            window.notifications.customPositive("smsVerified", "", this._conf.successError);
+            this._verified = true;
+            this._pin = this._input.value;
+            if (this._conf.successFunc) {
+                this._conf.successFunc();
+            }
+        } else if (req.status == 400) {
+            this._conf.modal.close();
+            window.notifications.customError("accountLinkedError", this._conf.accountLinkedError);
+        } else {
+            window.notifications.customError("invalidCodeError", this._conf.invalidCodeError);
+            this._submit.classList.add("~critical");
+            this._submit.classList.remove("~info");
+            setTimeout(() => {
+                this._submit.classList.add("~info");
+                this._submit.classList.remove("~critical");
+            }, 800);
+        }
+    });
+}
+
+export interface PushConfiguration {
+    modal: Modal;
+    sendMessageURL: string;
+    verifiedURL: string;
+    services: string[];
+    invalidCodeError: string;
+    invalidTargetError: string;
+    accountLinkedError: string;
+    unknownError: string;
+    successError: string;
+    successFunc: () => void;
+}
+
+// Push works like SMS: the user picks a service and enters their topic/token/URL, is pushed a PIN, and enters it in the same field.
+export class Push {
+    private _conf: PushConfiguration;
+    private _sent = false;
+    private _service: HTMLSelectElement;
+    private _input: HTMLInputElement;
+    private _token: HTMLInputElement;
+    private _submit: HTMLSpanElement;
+
+    private static _placeholders: { [service: string]: string } = {
+        "ntfy": "mytopic",
+        "gotify": "AbCdEf.gH1jKlM",
+        "apprise": "pover://user@token"
+    };
+
+    constructor(conf: PushConfiguration) {
+        this._conf = conf;
+        this._service = document.getElementById("push-service") as HTMLSelectElement;
+        this._input = document.getElementById("push-target") as HTMLInputElement;
+        this._token = document.getElementById("push-token") as HTMLInputElement;
+        this._submit = document.getElementById("push-send") as HTMLSpanElement;
+        for (let i = this._service.options.length - 1; i >= 0; i--) {
+            if (!this._conf.services.includes(this._service.options[i].value)) this._service.remove(i);
+        }
+        this._service.onchange = this._onServiceChange;
+        this._submit.onclick = () => { this._onclick(); };
+    }
+
+    private _onServiceChange = () => {
+        this._input.placeholder = Push._placeholders[this._service.value];
+        this._token.classList.toggle("unfocused", this._service.value != "ntfy");
+    };
+
+    private _onclick = () => {
+        addLoader(this._submit);
+        if (!this._sent) {
+            this._sendMessage();
+        } else {
+            this._verifyCode();
+        }
+    };
+
+    show = () => {
+        this._sent = false;
+        this._input.value = "";
+        this._token.value = "";
+        this._service.parentElement.classList.remove("unfocused");
+        this._onServiceChange();
+        this._conf.modal.show();
+    }
+
+    private _sendMessage = () => _post(this._conf.sendMessageURL, {
+        "service": this._service.value,
+        "target": this._input.value,
+        "token": this._token.value,
+        "lang": window.language
+    }, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        removeLoader(this._submit);
+        if (req.status == 400 && req.response["error"] == "errorInvalidPushTarget") {
+            window.notifications.customError("invalidTargetError", this._conf.invalidTargetError);
+            return;
+        } else if (req.status == 400 && req.response["error"] == "errorAccountLinked") {
+            this._conf.modal.close();
+            window.notifications.customError("accountLinkedError", this._conf.accountLinkedError);
+            return;
+        } else if (req.status != 200) {
+            window.notifications.customError("unknownError", this._conf.unknownError);
+            return;
+        }
+        this._sent = true;
+        this._submit.classList.add("~positive");
+        this._submit.classList.remove("~info");
+        setTimeout(() => {
+            this._submit.classList.add("~info");
+            this._submit.classList.remove("~positive");
+        }, 2000);
+        this._service.parentElement.classList.add("unfocused");
+        this._token.classList.add("unfocused");
+        this._input.placeholder = "PIN";
+        this._input.value = "";
+    });
+
+    private _verifyCode = () => _get(this._conf.verifiedURL + this._input.value, null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        removeLoader(this._submit);
+        const valid = req.response["success"] as boolean;
+        if (valid) {
+            this._conf.modal.close();
+            window.notifications.customPositive("pushVerified", "", this._conf.successError);
+            if (this._conf.successFunc) {
+                this._conf.successFunc();
+            }
+        } else if (req.status == 400) {
+            this._conf.modal.close();
+            window.notifications.customError("accountLinkedError", this._conf.accountLinkedError);
+        } else {
+            window.notifications.customError("invalidCodeError", this._conf.invalidCodeError);
+            this._submit.classList.add("~critical");
+            this._submit.classList.remove("~info");
+            setTimeout(() => {
+                this._submit.classList.add("~info");
+                this._submit.classList.remove("~critical");
+            }, 800);
+        }
+    });
+}
@@ -25,6 +25,8 @@ interface User {
     label: string;
     accounts_admin: boolean;
     referrals_enabled: boolean;
+    referrer_inactive: boolean;
+    pending_username?: string;
 }
 
 interface getPinResponse {
@@ -73,6 +75,9 @@ class user implements User, SearchableItem {
     private _selected: boolean;
     private _referralsEnabled: boolean;
     private _referralsEnabledCheck: HTMLElement;
+    private _referrerInactive: boolean;
+    private _pendingUsername: string;
+    private _pendingUsernameChip: HTMLSpanElement;
 
     focus = () => this._row.scrollIntoView({ behavior: "smooth", block: "center" });
 
@@ -107,6 +112,21 @@ class user implements User, SearchableItem {
     get name(): string { return this._username.textContent; }
     set name(value: string) { this._username.textContent = value; }
 
+    get pending_username(): string { return this._pendingUsername; }
+    set pending_username(v: string) {
+        this._pendingUsername = v || "";
+        if (this._pendingUsername) {
+            this._pendingUsernameChip.classList.add("chip", "~info", "@low", "ml-4");
+            this._pendingUsernameChip.title = window.lang.strings("usernameRequested");
+            this._pendingUsernameChip.innerHTML = `<i class="ri-arrow-right-line mr-2"></i><span></span>`;
+            (this._pendingUsernameChip.querySelector("span") as HTMLSpanElement).textContent = this._pendingUsername;
+        } else {
+            this._pendingUsernameChip.classList.remove("chip", "~info", "@low", "ml-4");
+            this._pendingUsernameChip.textContent = "";
+        }
+    }
+    get username_requested(): boolean { return this._pendingUsername != ""; }
+
     get admin(): boolean { return this._admin.classList.contains("chip"); }
     set admin(state: boolean) {
         if (state) {
@@ -171,12 +191,25 @@ class user implements User, SearchableItem {
     get referrals_enabled(): boolean { return this._referralsEnabled; }
     set referrals_enabled(v: boolean) {
         this._referralsEnabled = v;
+        this._renderReferrals();
+    }
+
+    get referrer_inactive(): boolean { return this._referrerInactive; }
+    set referrer_inactive(v: boolean) {
+        this._referrerInactive = v;
+        this._renderReferrals();
+    }
+
+    private _renderReferrals = () => {
         if (!window.referralsEnabled) return;
-        if (!v) {
-            this._referralsEnabledCheck.textContent = ``;
-        } else {
-            this._referralsEnabledCheck.innerHTML = `<i class="ri-check-line" aria-label="${window.lang.strings("enabled")}"></i>`;
+        let innerHTML = ``;
+        if (this._referralsEnabled) {
+            innerHTML += `<i class="ri-check-line" aria-label="${window.lang.strings("enabled")}"></i>`;
         }
+        if (this._referrerInactive) {
+            innerHTML += `<span class="chip ~critical @low" title="${window.lang.strings("referrerInactive")}"><i class="ri-error-warning-line" aria-label="${window.lang.strings("referrerInactive")}"></i></span>`;
+        }
+        this._referralsEnabledCheck.innerHTML = innerHTML;
     }
 
     private _constructDropdown = (): HTMLDivElement => {
@@ -350,7 +383,7 @@ class user implements User, SearchableItem {
             this._notifyDropdown.querySelector(".accounts-unlink-telegram").classList.remove("unfocused");
             this._telegram.innerHTML = `
             <div class="table-inline">
-                <a href="https://t.me/${u}" target="_blank">@${u}</a>
+                <a href="https://t.me/${u.replace(/^@/, "")}" target="_blank">${u}</a>
             </div>
             `;
             if (lastNotifyMethod) {
@@ -498,7 +531,7 @@ class user implements User, SearchableItem {
         this._row = document.createElement("tr") as HTMLTableRowElement;
         let innerHTML = `
             <td><input type="checkbox" class="accounts-select-user" value=""></td>
-            <td><div class="table-inline"><span class="accounts-username py-2 mr-2"></span><span class="accounts-label-container ml-2"></span> <i class="icon ri-edit-line accounts-label-edit"></i> <span class="accounts-admin"></span> <span class="accounts-disabled"></span></span></div></td>
+            <td><div class="table-inline"><span class="accounts-username py-2 mr-2"></span><span class="accounts-label-container ml-2"></span> <i class="icon ri-edit-line accounts-label-edit"></i> <span class="accounts-admin"></span> <span class="accounts-disabled"></span> <span class="accounts-pending-username"></span></span></div></td>
         `;
         if (window.jellyfinLogin) {
             innerHTML += `
@@ -540,6 +573,7 @@ class user implements User, SearchableItem {
         this._username = this._row.querySelector(".accounts-username") as HTMLSpanElement;
         this._admin = this._row.querySelector(".accounts-admin") as HTMLSpanElement;
         this._disabled = this._row.querySelector(".accounts-disabled") as HTMLSpanElement;
+        this._pendingUsernameChip = this._row.querySelector(".accounts-pending-username") as HTMLSpanElement;
         this._email = this._row.querySelector(".accounts-email-container") as HTMLInputElement;
         this._emailEditButton = this._row.querySelector(".accounts-email-edit") as HTMLElement;
         this._telegram = this._row.querySelector(".accounts-telegram") as HTMLTableDataCellElement;
@@ -743,6 +777,8 @@ class user implements User, SearchableItem {
         this.label = user.label;
         this.accounts_admin = user.accounts_admin;
         this.referrals_enabled = user.referrals_enabled;
+        this.referrer_inactive = user.referrer_inactive;
+        this.pending_username = user.pending_username;
     }
 
     asElement = (): HTMLTableRowElement => { return this._row; }
@@ -781,6 +817,7 @@ export class accountsList {
     private _enableExpiryNotify = document.getElementById("expiry-extend-enable") as HTMLInputElement;
     private _enableExpiryReason = document.getElementById("textarea-extend-enable") as HTMLTextAreaElement;
     private _modifySettings = document.getElementById("accounts-modify-user") as HTMLSpanElement;
+    private _renameUsers = document.getElementById("accounts-rename-users") as HTMLSpanElement;
     private _modifySettingsProfile = document.getElementById("radio-use-profile") as HTMLInputElement;
     private _modifySettingsUser = document.getElementById("radio-use-user") as HTMLInputElement;
     private _enableReferrals = document.getElementById("accounts-enable-referrals") as HTMLSpanElement;
@@ -955,6 +992,21 @@ export class accountsList {
             string: false,
             date: false,
             dependsOnElement: ".accounts-header-referrals"
+        },
+        "referrer-inactive": {
+            name: window.lang.strings("referrerInactive"),
+            getter: "referrer_inactive",
+            bool: true,
+            string: false,
+            date: false,
+            dependsOnElement: ".accounts-header-referrals"
+        },
+        "username-requested": {
+            name: window.lang.strings("usernameRequested"),
+            getter: "username_requested",
+            bool: true,
+            string: false,
+            date: false
         }
     }
 
@@ -986,6 +1038,7 @@ export class accountsList {
             this._selectAll.indeterminate = false;
             this._selectAll.checked = false;
             this._modifySettings.classList.add("unfocused");
+            this._renameUsers.classList.add("unfocused");
             if (window.referralsEnabled) {
                 this._enableReferrals.classList.add("unfocused");
             }
@@ -1011,6 +1064,7 @@ export class accountsList {
                 this._selectAll.indeterminate = true;
             }
             this._modifySettings.classList.remove("unfocused");
+            this._renameUsers.classList.remove("unfocused");
             if (window.referralsEnabled) {
                 this._enableReferrals.classList.remove("unfocused");
             }
@@ -1530,11 +1584,7 @@ export class accountsList {
 
         // Check if we're disabling or enabling
         if (this._users[list[0]].referrals_enabled) {
-            _delete("/users/referral", {"users": list}, (req: XMLHttpRequest) => {
-                if (req.readyState != 4 || req.status != 200) return;
-                window.notifications.customSuccess("disabledReferralsSuccess", window.lang.quantity("appliedSettings", list.length));
-                this.reload();
-            });
+            this.disableReferrals(list);
             return;
         }
             
@@ -1608,6 +1658,131 @@ export class accountsList {
         window.modals.enableReferralsUser.show();
     }
 
+    disableReferrals = (list: string[]) => {
+        const modalHeader = document.getElementById("header-disable-referrals-user");
+        modalHeader.textContent = window.lang.quantity("disableReferralsFor", list.length);
+        const form = document.getElementById("form-disable-referrals-user") as HTMLFormElement;
+        const button = form.querySelector("span.submit") as HTMLSpanElement;
+        const referred = document.getElementById("disable-referrals-user-referred") as HTMLInputElement;
+        const recursive = document.getElementById("disable-referrals-user-recursive") as HTMLInputElement;
+        const notify = document.getElementById("disable-referrals-user-notify") as HTMLInputElement;
+        const reason = document.getElementById("textarea-disable-referrals-user") as HTMLTextAreaElement;
+        const checkOptions = () => {
+            for (let el of [recursive.parentElement, notify.parentElement]) {
+                if (referred.checked) el.classList.remove("unfocused");
+                else el.classList.add("unfocused");
+            }
+            if (referred.checked && notify.checked) reason.classList.remove("unfocused");
+            else reason.classList.add("unfocused");
+        };
+        referred.onchange = checkOptions;
+        notify.onchange = checkOptions;
+        referred.checked = false;
+        recursive.checked = false;
+        notify.checked = false;
+        reason.value = "";
+        checkOptions();
+        form.onsubmit = (event: Event) => {
+            event.preventDefault();
+            toggleLoader(button);
+            let send = {
+                "users": list,
+                "disable_referred": referred.checked,
+                "recursive": recursive.checked,
+                "notify": notify.checked,
+                "reason": notify.checked ? reason.value : ""
+            };
+            _delete("/users/referral", send, (req: XMLHttpRequest) => {
+                if (req.readyState != 4) return;
+                toggleLoader(button);
+                window.modals.disableReferralsUser.close();
+                if (req.status == 200) {
+                    window.notifications.customSuccess("disabledReferralsSuccess", window.lang.quantity("appliedSettings", list.length));
+                } else {
+                    window.notifications.customError("disabledReferralsError", window.lang.notif("errorFailureCheckLogs"));
+                }
+                this.reload();
+            });
+        };
+        window.modals.disableReferralsUser.show();
+    }
+
+    renameUsers = () => {
+        const list = this._collectUsers();
+        const modalHeader = document.getElementById("header-rename-users");
+        modalHeader.textContent = window.lang.quantity("renameUsersFor", list.length);
+        const form = document.getElementById("form-rename-users") as HTMLFormElement;
+        const button = form.querySelector("span.submit") as HTMLSpanElement;
+        const notify = document.getElementById("rename-users-notify") as HTMLInputElement;
+        const userList = document.getElementById("rename-users-list") as HTMLDivElement;
+        notify.checked = false;
+        userList.textContent = "";
+        const inputs: { [id: string]: HTMLInputElement } = {};
+        for (let id of list) {
+            const u = this._users[id];
+            const row = document.createElement("div") as HTMLDivElement;
+            row.classList.add("flex", "flex-row", "gap-2", "items-center");
+            row.innerHTML = `
+            <span class="w-1/3 truncate"></span>
+            <input type="text" class="input ~neutral @low grow">
+            `;
+            (row.querySelector("span") as HTMLSpanElement).textContent = u.name;
+            const input = row.querySelector("input") as HTMLInputElement;
+            input.value = u.pending_username || u.name;
+            inputs[id] = input;
+            if (u.pending_username) {
+                const deny = document.createElement("span") as HTMLSpanElement;
+                deny.classList.add("button", "~critical", "@low");
+                deny.title = window.lang.strings("denyUsernameRequest");
+                deny.innerHTML = `<i class="ri-close-line"></i>`;
+                deny.onclick = () => _delete("/users/username-requests/" + id, null, (req: XMLHttpRequest) => {
+                    if (req.readyState != 4) return;
+                    if (req.status != 200) {
+                        window.notifications.customError("denyUsernameRequest", window.lang.notif("errorFailureCheckLogs"));
+                        return;
+                    }
+                    u.pending_username = "";
+                    input.value = u.name;
+                    deny.remove();
+                });
+                row.appendChild(deny);
+            }
+            userList.appendChild(row);
+        }
+        form.onsubmit = (event: Event) => {
+            event.preventDefault();
+            let send = {
+                "users": {},
+                "notify": notify.checked
+            };
+            let count = 0;
+            for (let id in inputs) {
+                const name = inputs[id].value.trim();
+                if (name && name != this._users[id].name) {
+                    send["users"][id] = name;
+                    count++;
+                }
+            }
+            if (count == 0) {
+                window.modals.renameUsers.close();
+                return;
+            }
+            toggleLoader(button);
+            _post("/users/rename", send, (req: XMLHttpRequest) => {
+                if (req.readyState != 4) return;
+                toggleLoader(button);
+                window.modals.renameUsers.close();
+                if (req.status == 200) {
+                    window.notifications.customSuccess("renameUsers", window.lang.quantity("renamedUsers", count));
+                } else {
+                    window.notifications.customError("renameUsers", window.lang.notif("errorFailureCheckLogs"));
+                }
+                this.reload();
+            });
+        };
+        window.modals.renameUsers.show();
+    }
+
     removeExpiry = () => {
         const list = this._collectUsers();
 
@@ -1817,6 +1992,8 @@ export class accountsList {
         };
         this._modifySettings.onclick = this.modifyUsers;
         this._modifySettings.classList.add("unfocused");
+        this._renameUsers.onclick = this.renameUsers;
+        this._renameUsers.classList.add("unfocused");
         const checkSource = () => {
             const profileSpan = this._modifySettingsProfile.nextElementSibling as HTMLSpanElement;
             const userSpan = this._modifySettingsUser.nextElementSibling as HTMLSpanElement;
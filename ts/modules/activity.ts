@@ -27,7 +27,13 @@ var activityTypeMoods = {
     "changePassword": 0,
     "resetPassword": 0,
     "createInvite": 1,
-    "deleteInvite": -1
+    "deleteInvite": -1,
+    "lockout": -1,
+    "referralReward": 1,
+    "redeemCode": 1,
+    "payment": 0,
+    "usernameChange": 0,
+    "streamLimit": -1
 };
 
 // var moodColours = ["~warning", "~neutral", "~urge"];
@@ -88,6 +94,12 @@ export class Activity implements activity, SearchableItem {
     get passwordReset(): boolean { return this.type == "resetPassword"; }
     get inviteCreated(): boolean { return this.type == "createInvite"; }
     get inviteDeleted(): boolean { return this.type == "deleteInvite"; }
+    get lockout(): boolean { return this.type == "lockout"; }
+    get referralReward(): boolean { return this.type == "referralReward"; }
+    get codeRedeemed(): boolean { return this.type == "redeemCode"; }
+    get payment(): boolean { return this.type == "payment"; }
+    get usernameChange(): boolean { return this.type == "usernameChange"; }
+    get streamLimit(): boolean { return this.type == "streamLimit"; }
 
     get mentionedUsers(): string {
         return (this.username + " " + this.source_username).toLowerCase();
@@ -184,6 +196,34 @@ export class Activity implements activity, SearchableItem {
             }
 
             this._title.innerHTML = innerHTML.replace("{invite}", this._renderInvText());
+        } else if (this.type == "lockout") {
+            if (this.value.startsWith("user:")) {
+                this._title.innerHTML = window.lang.strings("userLockedOut").replace("{user}", `<span class="font-medium"></span>`);
+                (this._title.querySelector("span") as HTMLElement).textContent = this.value.substring(5);
+            } else {
+                this._title.innerHTML = window.lang.strings("ipLockedOut");
+            }
+        } else if (this.type == "redeemCode") {
+            this._title.innerHTML = window.lang.strings("extensionCodeRedeemed").replace("{user}", this._genUserLink()).replace("{n}", this.value);
+        } else if (this.type == "referralReward") {
+            this._title.innerHTML = window.lang.strings("referralRewarded").replace("{user}", this._genUserLink()).replace("{n}", this.value).replace("{referred}", this._genSrcUserLink());
+        } else if (this.type == "payment") {
+            if (this.value == "checkout") {
+                this._title.innerHTML = window.lang.strings("paymentCheckout").replace("{user}", this._genUserLink());
+            } else if (this.value == "renewal") {
+                this._title.innerHTML = window.lang.strings("paymentRenewal").replace("{user}", this._genUserLink());
+            } else {
+                this._title.innerHTML = window.lang.strings("paymentCancellation").replace("{user}", this._genUserLink());
+            }
+        } else if (this.type == "usernameChange") {
+            this._title.innerHTML = window.lang.strings("usernameChanged").replace("{user}", this._genUserLink()).replace("{n}", `<span class="font-mono"></span>`);
+            (this._title.querySelector("span.font-mono") as HTMLElement).textContent = this.value;
+        } else if (this.type == "streamLimit") {
+            if (this.value == "devices") {
+                this._title.innerHTML = window.lang.strings("streamLimitDevices").replace("{user}", this._genUserLink());
+            } else {
+                this._title.innerHTML = window.lang.strings("streamLimitStreams").replace("{user}", this._genUserLink());
+            }
         }
     }
 
@@ -663,6 +703,48 @@ export class activityList {
             bool: true,
             string: false,
             date: false
+        },
+        "lockout": {
+            name: window.lang.strings("lockoutFilter"),
+            getter: "lockout",
+            bool: true,
+            string: false,
+            date: false
+        },
+        "referral-reward": {
+            name: window.lang.strings("referralRewardFilter"),
+            getter: "referralReward",
+            bool: true,
+            string: false,
+            date: false
+        },
+        "code-redeemed": {
+            name: window.lang.strings("extensionCodeRedeemedFilter"),
+            getter: "codeRedeemed",
+            bool: true,
+            string: false,
+            date: false
+        },
+        "payment": {
+            name: window.lang.strings("paymentFilter"),
+            getter: "payment",
+            bool: true,
+            string: false,
+            date: false
+        },
+        "username-change": {
+            name: window.lang.strings("usernameChangeFilter"),
+            getter: "usernameChange",
+            bool: true,
+            string: false,
+            date: false
+        },
+        "stream-limit": {
+            name: window.lang.strings("streamLimitFilter"),
+            getter: "streamLimit",
+            bool: true,
+            string: false,
+            date: false
         }
     };
 
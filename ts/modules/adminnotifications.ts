@@ -0,0 +1,116 @@
+import { _get, _post } from "../modules/common.js";
+
+interface NotificationDestination {
+    type: string;
+    target: string;
+}
+
+interface NotificationRoutes {
+    events: string[];
+    types: string[];
+    routes: { [event: string]: NotificationDestination[] };
+}
+
+const eventStrings: { [event: string]: string } = {
+    "user_created": "eventUserCreated",
+    "user_deleted": "eventUserDeleted",
+    "user_disabled": "eventUserDisabled",
+    "password_reset": "eventPasswordReset",
+    "invite_expired": "eventInviteExpired",
+    "backup_failed": "eventBackupFailed",
+    "update_available": "eventUpdateAvailable"
+};
+
+const typeNames: { [type: string]: string } = {
+    "email": "Email",
+    "discord": "Discord",
+    "telegram": "Telegram",
+    "matrix": "Matrix",
+    "signal": "Signal",
+    "sms": "SMS"
+};
+
+export class AdminNotificationEditor {
+    private _table = document.getElementById("table-admin-notifications") as HTMLTableSectionElement;
+    private _types: string[] = [];
+    private _routes: { [event: string]: NotificationDestination[] } = {};
+
+    private _save = (event: string, destinations: NotificationDestination[]) => _post("/notifications/routes/" + event, { "destinations": destinations }, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        if (req.status != 200) {
+            window.notifications.customError("setNotificationRoute", req.response["error"] || window.lang.notif("errorUnknown"));
+        } else {
+            this._routes[event] = destinations;
+        }
+        this._render();
+    }, true);
+
+    private _row = (event: string): HTMLTableRowElement => {
+        const row = document.createElement("tr") as HTMLTableRowElement;
+        let options = "";
+        for (let type of this._types) {
+            options += `<option value="${type}">${typeNames[type]}</option>`;
+        }
+        row.innerHTML = `
+            <td>${window.lang.strings(eventStrings[event])}</td>
+            <td class="admin-notification-destinations"></td>
+            <td>
+                <div class="flex flex-row gap-2">
+                    <div class="select ~neutral @low">
+                        <select class="admin-notification-type">${options}</select>
+                    </div>
+                    <input type="text" class="input ~neutral @low admin-notification-target" placeholder="${window.lang.strings("notificationTarget")}">
+                    <span class="button ~neutral @low admin-notification-add">${window.lang.strings("add")}</span>
+                </div>
+            </td>
+        `;
+        const destinations = this._routes[event] || [];
+        const list = row.querySelector(".admin-notification-destinations") as HTMLTableDataCellElement;
+        if (destinations.length == 0) {
+            list.innerHTML = `<span class="support">${window.lang.strings("noDestinations")}</span>`;
+        }
+        for (let i = 0; i < destinations.length; i++) {
+            const chip = document.createElement("span") as HTMLSpanElement;
+            chip.classList.add("chip", "~neutral", "mr-2", "mb-1");
+            chip.innerHTML = `<span class="font-bold mr-1"></span><span class="font-mono"></span><i class="ri-close-line ml-2 cursor-pointer" title="${window.lang.strings("delete")}"></i>`;
+            (chip.children[0] as HTMLElement).textContent = typeNames[destinations[i].type] || destinations[i].type;
+            (chip.children[1] as HTMLElement).textContent = destinations[i].target;
+            (chip.querySelector("i") as HTMLElement).onclick = () => this._save(event, destinations.filter((_, j: number) => j != i));
+            list.appendChild(chip);
+        }
+        const type = row.querySelector("select.admin-notification-type") as HTMLSelectElement;
+        const target = row.querySelector("input.admin-notification-target") as HTMLInputElement;
+        (row.querySelector("span.admin-notification-add") as HTMLSpanElement).onclick = () => {
+            if (!target.value) return;
+            this._save(event, destinations.concat([{ type: type.value, target: target.value }]));
+        };
+        return row;
+    };
+
+    private _render = () => {
+        this._table.textContent = "";
+        for (let event in this._routes) {
+            this._table.appendChild(this._row(event));
+        }
+    };
+
+    load = () => _get("/notifications/routes", null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        if (req.status != 200) {
+            window.notifications.customError("loadNotificationRoutes", window.lang.notif("errorUnknown"));
+            return;
+        }
+        const resp = req.response as NotificationRoutes;
+        this._types = resp.types;
+        this._routes = {};
+        for (let event of resp.events) {
+            this._routes[event] = resp.routes[event] || [];
+        }
+        this._render();
+        window.modals.adminNotifications.show();
+    });
+
+    constructor() {
+        (document.getElementById("setting-admin-notifications") as HTMLSpanElement).onclick = this.load;
+    }
+}
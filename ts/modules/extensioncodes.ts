@@ -0,0 +1,110 @@
+import { _get, _post, _delete, toggleLoader, toDateString, toClipboard } from "../modules/common.js";
+
+interface ExtensionCode {
+    code: string;
+    created: number;
+    label: string;
+    days: number;
+    remaining_uses: number;
+    no_limit: boolean;
+    profile: string;
+    redeemed: number;
+}
+
+export class ExtensionCodeEditor {
+    private _table = document.getElementById("table-extension-codes") as HTMLTableSectionElement;
+    private _form = document.getElementById("form-extension-codes") as HTMLFormElement;
+    private _days = document.getElementById("extension-codes-days") as HTMLInputElement;
+    private _uses = document.getElementById("extension-codes-uses") as HTMLInputElement;
+    private _count = document.getElementById("extension-codes-count") as HTMLInputElement;
+    private _profile = document.getElementById("extension-codes-profile") as HTMLSelectElement;
+    private _label = document.getElementById("extension-codes-label") as HTMLInputElement;
+    private _noLimit = document.getElementById("extension-codes-no-limit") as HTMLInputElement;
+
+    private _row = (code: ExtensionCode): HTMLTableRowElement => {
+        const row = document.createElement("tr") as HTMLTableRowElement;
+        row.innerHTML = `
+            <td><span class="font-mono extension-code-code"></span> <span class="extension-code-label"></span> <i class="icon ri-file-copy-line cursor-pointer ml-2" title="${window.lang.strings("copy")}"></i></td>
+            <td>${code.days}</td>
+            <td>${code.no_limit ? "∞" : code.remaining_uses}</td>
+            <td class="extension-code-profile"></td>
+            <td>${toDateString(new Date(code.created * 1000))}</td>
+            <td><span class="button ~critical @low">${window.lang.strings("delete")}</span></td>
+        `;
+        (row.querySelector(".extension-code-code") as HTMLElement).textContent = code.code;
+        const label = row.querySelector(".extension-code-label") as HTMLElement;
+        if (code.label) {
+            label.classList.add("chip", "~gray");
+            label.textContent = code.label;
+        }
+        (row.querySelector(".extension-code-profile") as HTMLElement).textContent = code.profile || window.lang.strings("anyProfile");
+        (row.querySelector("i.icon") as HTMLElement).onclick = () => toClipboard(code.code);
+        (row.querySelector("span.button") as HTMLSpanElement).onclick = () => _delete("/extension-codes/" + code.code, null, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            if (req.status != 200) window.notifications.customError("deleteExtensionCode", window.lang.notif("errorUnknown"));
+            this.reload();
+        });
+        return row;
+    };
+
+    reload = () => _get("/extension-codes", null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        if (req.status != 200) {
+            window.notifications.customError("loadExtensionCodes", window.lang.notif("errorUnknown"));
+            return;
+        }
+        const codes = req.response["codes"] as ExtensionCode[];
+        this._table.textContent = "";
+        if (codes.length == 0) {
+            this._table.innerHTML = `<tr><td colspan="6" class="text-center">${window.lang.strings("noExtensionCodes")}</td></tr>`;
+            return;
+        }
+        for (let code of codes) {
+            this._table.appendChild(this._row(code));
+        }
+    });
+
+    load = () => {
+        let innerHTML = `<option value="">${window.lang.strings("anyProfile")}</option>`;
+        for (let profile of window.availableProfiles) {
+            innerHTML += `<option value="${profile}">${profile}</option>`;
+        }
+        this._profile.innerHTML = innerHTML;
+        this._noLimit.checked = false;
+        this._uses.disabled = false;
+        this.reload();
+        window.modals.extensionCodes.show();
+    };
+
+    private _submit = (event: SubmitEvent) => {
+        event.preventDefault();
+        const button = this._form.querySelector("span.submit") as HTMLSpanElement;
+        toggleLoader(button);
+        const send = {
+            "days": +this._days.value,
+            "uses": +this._uses.value,
+            "no_limit": this._noLimit.checked,
+            "count": +this._count.value,
+            "profile": this._profile.value,
+            "label": this._label.value
+        };
+        _post("/extension-codes", send, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            toggleLoader(button);
+            if (req.status != 200) {
+                window.notifications.customError("createExtensionCodes", window.lang.notif("errorUnknown"));
+                return;
+            }
+            const codes = req.response["codes"] as ExtensionCode[];
+            window.notifications.customSuccess("createExtensionCodes", window.lang.quantity("extensionCodesCreated", codes.length));
+            this._label.value = "";
+            this.reload();
+        }, true);
+    };
+
+    constructor() {
+        (document.getElementById("setting-extension-codes") as HTMLSpanElement).onclick = this.load;
+        this._form.onsubmit = this._submit;
+        this._noLimit.onchange = () => { this._uses.disabled = this._noLimit.checked; };
+    }
+}
@@ -1,5 +1,6 @@
 import { Modal } from "../modules/modal.js";
 import { toggleLoader, _post } from "../modules/common.js";
+import { getPasskey, passkeysSupported } from "../modules/webauthn.js";
 
 export class Login {
     private _modal: Modal;
@@ -7,9 +8,12 @@ export class Login {
     private _url: string;
     private _endpoint: string;
     private _onLogin: (username: string, password: string) => void;
+    private _onDisabled: (username: string, password: string) => void;
     private _logoutButton: HTMLElement = null;
     private _wall: HTMLElement;
     private _hasOpacityWall: boolean = false;
+    private _totpChallenge: string = "";
+    private _totpInput: HTMLInputElement;
 
     constructor(modal: Modal, endpoint: string, appearance: string) {
         this._endpoint = endpoint;
@@ -24,9 +28,20 @@ export class Login {
             this._modal.asElement().parentElement.appendChild(this._wall);
         }
         this._form = this._modal.asElement().querySelector(".form-login") as HTMLFormElement;
+        this._totpInput = document.getElementById("login-totp") as HTMLInputElement;
         this._form.onsubmit = (event: SubmitEvent) => {
             event.preventDefault();
             const button = (event.target as HTMLElement).querySelector(".submit") as HTMLSpanElement;
+            if (this._totpChallenge) {
+                const code = this._totpInput.value;
+                if (!code) {
+                    window.notifications.customError("loginError", window.lang.notif("errorLoginBlank"));
+                    return;
+                }
+                toggleLoader(button);
+                this.loginTOTP(code, () => toggleLoader(button));
+                return;
+            }
             const username = (document.getElementById("login-user") as HTMLInputElement).value;
             const password = (document.getElementById("login-password") as HTMLInputElement).value;
             if (!username || !password) {
@@ -36,6 +51,17 @@ export class Login {
             toggleLoader(button);
             this.login(username, password, () => toggleLoader(button));
         };
+        const passkeyButton = document.getElementById("login-passkey") as HTMLSpanElement;
+        if (passkeyButton) {
+            if (passkeysSupported()) {
+                passkeyButton.onclick = () => {
+                    toggleLoader(passkeyButton);
+                    this.loginPasskey(() => toggleLoader(passkeyButton));
+                };
+            } else {
+                passkeyButton.classList.add("unfocused");
+            }
+        }
     }
 
     bindLogout = (button: HTMLElement) => {
@@ -61,6 +87,10 @@ export class Login {
     get onLogin() { return this._onLogin; }
     set onLogin(f: (username: string, password: string) => void) { this._onLogin = f; }
 
+    // Called when the credentials are for a disabled account, which Jellyfin won't log in.
+    get onDisabled() { return this._onDisabled; }
+    set onDisabled(f: (username: string, password: string) => void) { this._onDisabled = f; }
+
     login = (username: string, password: string, run?: (state?: number) => void) => {
         const req = new XMLHttpRequest();
         req.responseType = 'json';
@@ -71,38 +101,104 @@ export class Login {
         }
         req.onreadystatechange = ((req: XMLHttpRequest, _: Event): any => {
             if (req.readyState == 4) {
-                if (req.status != 200) {
-                    let errorMsg = window.lang.notif("errorConnection");
-                    if (req.response) {
-                        errorMsg = req.response["error"];
-                        const langErrorMsg = window.lang.strings(errorMsg);
-                        if (langErrorMsg) {
-                            errorMsg = langErrorMsg;
-                        }
-                    }
-                    if (!errorMsg) {
-                        errorMsg = window.lang.notif("errorUnknown");
-                    }
-                    if (!refresh) {
-                        window.notifications.customError("loginError", errorMsg);
-                    } else {
-                        this._modal.show();
-                    }
-                } else {
-                    const data = req.response;
-                    window.token = data["token"];
-                    if (this._onLogin) {
-                        this._onLogin(username, password);
-                    }
-                    if (this._hasOpacityWall) this._wall.remove();
-                    this._modal.close();
-                    if (this._logoutButton != null)
-                        this._logoutButton.classList.remove("unfocused");
-                }
+                this._handleResponse(req, username, password, refresh);
                 if (run) { run(+req.status); }
             }
         }).bind(this, req);
         req.send();
     };
-}
 
+    // Submits a TOTP or recovery code along with the challenge given by the first login step.
+    loginTOTP = (code: string, run?: (state?: number) => void) => {
+        const req = new XMLHttpRequest();
+        req.responseType = 'json';
+        req.open("POST", this._url + "token/totp", true);
+        req.setRequestHeader('Content-Type', 'application/json; charset=UTF-8');
+        req.onreadystatechange = ((req: XMLHttpRequest, _: Event): any => {
+            if (req.readyState == 4) {
+                const username = (document.getElementById("login-user") as HTMLInputElement).value;
+                const password = (document.getElementById("login-password") as HTMLInputElement).value;
+                if (req.status == 400) {
+                    // Challenge expired or invalid, so start again.
+                    this._totpChallenge = "";
+                    this._totpInput.classList.add("unfocused");
+                }
+                this._handleResponse(req, username, password, false);
+                if (run) { run(+req.status); }
+            }
+        }).bind(this, req);
+        req.send(JSON.stringify({ "challenge": this._totpChallenge, "code": code }));
+    };
+
+    // Logs in with a discoverable passkey, so no username is needed.
+    loginPasskey = (run?: (state?: number) => void) => {
+        const send = (path: string, data: Object, onDone: (req: XMLHttpRequest) => void) => {
+            const req = new XMLHttpRequest();
+            req.responseType = 'json';
+            req.open("POST", this._url + path, true);
+            req.setRequestHeader('Content-Type', 'application/json; charset=UTF-8');
+            req.onreadystatechange = () => { if (req.readyState == 4) onDone(req); };
+            req.send(JSON.stringify(data));
+        };
+        send("token/passkey/begin", null, (req: XMLHttpRequest) => {
+            if (req.status != 200) {
+                this._handleResponse(req, "", "", false);
+                if (run) { run(+req.status); }
+                return;
+            }
+            const session = req.response["session"];
+            getPasskey(req.response["options"]).then((assertion: any) => {
+                send("token/passkey/finish/" + session, assertion, (req: XMLHttpRequest) => {
+                    this._handleResponse(req, "", "", false);
+                    if (run) { run(+req.status); }
+                });
+            }).catch(() => {
+                window.notifications.customError("loginError", window.lang.notif("errorPasskey"));
+                if (run) { run(); }
+            });
+        });
+    };
+
+    private _handleResponse = (req: XMLHttpRequest, username: string, password: string, refresh: boolean) => {
+        if (req.status != 200) {
+            let errorMsg = window.lang.notif("errorConnection");
+            if (req.response) {
+                errorMsg = req.response["error"];
+                const langErrorMsg = window.lang.strings(errorMsg);
+                if (langErrorMsg) {
+                    errorMsg = langErrorMsg;
+                }
+            }
+            if (!errorMsg) {
+                errorMsg = window.lang.notif("errorUnknown");
+            }
+            if (!refresh) {
+                window.notifications.customError("loginError", errorMsg);
+                if (req.status == 403 && this._onDisabled) this._onDisabled(username, password);
+            } else {
+                this._modal.show();
+            }
+            return;
+        }
+        const data = req.response;
+        if (data["totp_required"]) {
+            this._totpChallenge = data["totp_challenge"];
+            this._totpInput.classList.remove("unfocused");
+            this._totpInput.focus();
+            return;
+        }
+        this._totpChallenge = "";
+        if (this._totpInput) {
+            this._totpInput.value = "";
+            this._totpInput.classList.add("unfocused");
+        }
+        window.token = data["token"];
+        if (this._onLogin) {
+            this._onLogin(username, password);
+        }
+        if (this._hasOpacityWall) this._wall.remove();
+        this._modal.close();
+        if (this._logoutButton != null)
+            this._logoutButton.classList.remove("unfocused");
+    };
+}
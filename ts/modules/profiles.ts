@@ -6,6 +6,12 @@ interface Profile {
     fromUser: string;
     ombi: boolean;
     referrals_enabled: boolean;
+    limits: ProfileLimits;
+}
+
+interface ProfileLimits {
+    max_streams: number;
+    max_devices: number;
 }
 
 class profile implements Profile {
@@ -19,6 +25,8 @@ class profile implements Profile {
     private _ombi: boolean;
     private _referralsButton: HTMLSpanElement;
     private _referralsEnabled: boolean;
+    private _maxStreams: HTMLInputElement;
+    private _maxDevices: HTMLInputElement;
 
     get name(): string { return this._name.textContent; }
     set name(v: string) { this._name.textContent = v; }
@@ -70,6 +78,16 @@ class profile implements Profile {
         }
     }
 
+    get limits(): ProfileLimits {
+        if (!window.streamLimitsEnabled) return { max_streams: 0, max_devices: 0 };
+        return { max_streams: +this._maxStreams.value, max_devices: +this._maxDevices.value };
+    }
+    set limits(v: ProfileLimits) {
+        if (!window.streamLimitsEnabled) return;
+        this._maxStreams.value = "" + v.max_streams;
+        this._maxDevices.value = "" + v.max_devices;
+    }
+
     get default(): boolean { return this._defaultRadio.checked; }
     set default(v: boolean) { this._defaultRadio.checked = v; }
 
@@ -85,6 +103,12 @@ class profile implements Profile {
         if (window.referralsEnabled) innerHTML += `
             <td><span class="button @low profile-referrals"></span></td>
         `;
+        if (window.streamLimitsEnabled) innerHTML += `
+            <td class="flex flex-row gap-2">
+                <input type="number" min="0" class="input ~neutral @low profile-max-streams" title="${window.lang.strings("maxStreams")}" placeholder="${window.lang.strings("maxStreams")}">
+                <input type="number" min="0" class="input ~neutral @low profile-max-devices" title="${window.lang.strings("maxDevices")}" placeholder="${window.lang.strings("maxDevices")}">
+            </td>
+        `;
         innerHTML += `
             <td class="profile-from truncate"></td>
             <td class="profile-libraries"></td>
@@ -98,6 +122,12 @@ class profile implements Profile {
             this._ombiButton = this._row.querySelector("span.profile-ombi") as HTMLSpanElement;
         if (window.referralsEnabled)
             this._referralsButton = this._row.querySelector("span.profile-referrals") as HTMLSpanElement;
+        if (window.streamLimitsEnabled) {
+            this._maxStreams = this._row.querySelector("input.profile-max-streams") as HTMLInputElement;
+            this._maxDevices = this._row.querySelector("input.profile-max-devices") as HTMLInputElement;
+            this._maxStreams.onchange = this.setLimits;
+            this._maxDevices.onchange = this.setLimits;
+        }
         this._fromUser = this._row.querySelector("td.profile-from") as HTMLTableDataCellElement;
         this._defaultRadio = this._row.querySelector("input[type=radio]") as HTMLInputElement;
         this._defaultRadio.onclick = () => document.dispatchEvent(new CustomEvent("profiles-default", { detail: this.name }));
@@ -113,11 +143,22 @@ class profile implements Profile {
         this.libraries = p.libraries;
         this.ombi = p.ombi;
         this.referrals_enabled = p.referrals_enabled;
+        this.limits = p.limits;
     }
 
     setOmbiFunc = (ombiFunc: (ombi: boolean) => void) => { this._ombiButton.onclick = () => ombiFunc(this._ombi); }
     setReferralFunc = (referralFunc: (enabled: boolean) => void) => { this._referralsButton.onclick = () => referralFunc(this._referralsEnabled); }
 
+    setLimits = () => _post("/profiles/limits/" + encodeURIComponent(this.name), this.limits, (req: XMLHttpRequest) => {
+        if (req.readyState == 4) {
+            if (req.status == 200) {
+                window.notifications.customSuccess("profileLimits", window.lang.var("notifications", "profileLimitsSet", `"${this.name}"`));
+            } else {
+                window.notifications.customError("profileLimits", window.lang.notif("errorUnknown"));
+            }
+        }
+    })
+
     remove = () => { document.dispatchEvent(new CustomEvent("profiles-delete", { detail: this._name })); this._row.remove(); }
 
     delete = () => _delete("/profiles", { "name": this.name }, (req: XMLHttpRequest) => {
@@ -226,6 +267,8 @@ export class ProfileEditor {
     enableReferrals = (name: string) => {
         const referralsInviteSelect = document.getElementById("enable-referrals-profile-invites") as HTMLSelectElement;
         const referralsExpiry = document.getElementById("enable-referrals-profile-expiry") as HTMLInputElement;
+        const referralsMonthlyQuota = document.getElementById("enable-referrals-profile-monthly-quota") as HTMLInputElement;
+        const referralsMaxOutstanding = document.getElementById("enable-referrals-profile-max-outstanding") as HTMLInputElement;
         _get("/invites", null, (req: XMLHttpRequest) => {
             if (req.readyState != 4 || req.status != 200) return;
 
@@ -255,7 +298,9 @@ export class ProfileEditor {
 
             let send = {
                 "profile": name,
-                "invite": referralsInviteSelect.value
+                "invite": referralsInviteSelect.value,
+                "monthly_quota": +referralsMonthlyQuota.value,
+                "max_outstanding": +referralsMaxOutstanding.value
             };
             
             _post("/profiles/referral/" + send["profile"] + "/" + send["invite"] + "/" + (referralsExpiry.checked ? "with-expiry" : "none"), send, (req: XMLHttpRequest) => {
@@ -272,6 +317,8 @@ export class ProfileEditor {
             });
         };
         referralsExpiry.checked = false;
+        referralsMonthlyQuota.value = "0";
+        referralsMaxOutstanding.value = "0";
         window.modals.profiles.close();
         window.modals.enableReferralsProfile.show();
     };
@@ -0,0 +1,65 @@
+// Helpers for passing WebAuthn options and credentials between the server (base64url JSON) and the browser (ArrayBuffers).
+
+export const passkeysSupported = (): boolean => window.PublicKeyCredential !== undefined;
+
+const decode = (value: string): ArrayBuffer => {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "=".repeat((4 - base64.length % 4) % 4);
+    const raw = atob(padded);
+    const buffer = new Uint8Array(raw.length);
+    for (let i = 0; i < raw.length; i++) buffer[i] = raw.charCodeAt(i);
+    return buffer.buffer;
+};
+
+const encode = (value: ArrayBuffer): string => {
+    const bytes = new Uint8Array(value);
+    let raw = "";
+    for (let i = 0; i < bytes.length; i++) raw += String.fromCharCode(bytes[i]);
+    return btoa(raw).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+};
+
+const decodeDescriptors = (descriptors: any[]): any[] => {
+    if (!descriptors) return descriptors;
+    return descriptors.map((d: any) => ({ ...d, id: decode(d.id) }));
+};
+
+// Takes the options returned by /my/passkeys/begin, and returns the registration response to send to /my/passkeys/finish.
+export const createPasskey = (options: any): Promise<any> => {
+    const publicKey = options["publicKey"];
+    publicKey.challenge = decode(publicKey.challenge);
+    publicKey.user.id = decode(publicKey.user.id);
+    publicKey.excludeCredentials = decodeDescriptors(publicKey.excludeCredentials);
+    return navigator.credentials.create({ publicKey: publicKey }).then((cred: PublicKeyCredential) => {
+        const response = cred.response as AuthenticatorAttestationResponse;
+        return {
+            id: cred.id,
+            rawId: encode(cred.rawId),
+            type: cred.type,
+            response: {
+                attestationObject: encode(response.attestationObject),
+                clientDataJSON: encode(response.clientDataJSON),
+            },
+        };
+    });
+};
+
+// Takes the options returned by token/passkey/begin, and returns the assertion to send to token/passkey/finish.
+export const getPasskey = (options: any): Promise<any> => {
+    const publicKey = options["publicKey"];
+    publicKey.challenge = decode(publicKey.challenge);
+    publicKey.allowCredentials = decodeDescriptors(publicKey.allowCredentials);
+    return navigator.credentials.get({ publicKey: publicKey }).then((cred: PublicKeyCredential) => {
+        const response = cred.response as AuthenticatorAssertionResponse;
+        return {
+            id: cred.id,
+            rawId: encode(cred.rawId),
+            type: cred.type,
+            response: {
+                authenticatorData: encode(response.authenticatorData),
+                clientDataJSON: encode(response.clientDataJSON),
+                signature: encode(response.signature),
+                userHandle: response.userHandle ? encode(response.userHandle) : "",
+            },
+        };
+    });
+};
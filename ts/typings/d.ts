@@ -23,6 +23,10 @@ declare interface Window {
     telegramEnabled: boolean;
     discordEnabled: boolean;
     matrixEnabled: boolean;
+    signalEnabled: boolean;
+    smsEnabled: boolean;
+    pushEnabled: boolean;
+    pushServices: string;
     ombiEnabled: boolean;
     usernameEnabled: boolean;
     linkResetEnabled: boolean;
@@ -41,6 +45,7 @@ declare interface Window {
     jfAdminOnly: boolean;
     jfAllowAll: boolean;
     referralsEnabled: boolean;
+    streamLimitsEnabled: boolean;
     loginAppearance: string; 
 }
 
@@ -97,6 +102,7 @@ declare interface Modals {
     login: Modal;
     addUser: Modal;
     modifyUser: Modal;
+    renameUsers: Modal;
     deleteUser: Modal;
     settingsRestart: Modal;
     settingsRefresh: Modal;
@@ -111,12 +117,18 @@ declare interface Modals {
     telegram: Modal;
     discord: Modal;
     matrix: Modal;
+    signal?: Modal;
+    sms?: Modal;
+    push?: Modal;
     sendPWR?: Modal;
     pwr?: Modal;
     logs: Modal;
     email?: Modal;
     enableReferralsUser?: Modal;
     enableReferralsProfile?: Modal;
+    disableReferralsUser?: Modal;
+    extensionCodes: Modal;
+    adminNotifications: Modal;
     backedUp?: Modal;
     backups?: Modal;
 }
@@ -3,8 +3,9 @@ import { lang, LangFile, loadLangSelector } from "./modules/lang.js";
 import { Modal } from "./modules/modal.js";
 import { _get, _post, _delete, notificationBox, whichAnimationEvent, toDateString, toggleLoader, addLoader, removeLoader, toClipboard } from "./modules/common.js";
 import { Login } from "./modules/login.js";
-import { Discord, Telegram, Matrix, ServiceConfiguration, MatrixConfiguration } from "./modules/account-linking.js";
+import { Discord, Telegram, Matrix, Signal, SMS, Push, ServiceConfiguration, MatrixConfiguration, SMSConfiguration, PushConfiguration } from "./modules/account-linking.js";
 import { Validator, ValidatorConf, ValidatorRespDTO } from "./modules/validator.js";
+import { createPasskey, passkeysSupported } from "./modules/webauthn.js";
 
 interface userWindow extends Window {
     jellyfinID: string;
@@ -13,12 +14,15 @@ interface userWindow extends Window {
     discordRequired: boolean;
     telegramRequired: boolean;
     matrixRequired: boolean;
+    signalRequired: boolean;
+    smsRequired: boolean;
     discordServerName: string;
     discordInviteLink: boolean;
     matrixUserID: string;
     discordSendPINMessage: string;
     pwrEnabled: string;
     referralsEnabled: boolean;
+    passkeysEnabled: boolean;
 }
 
 declare var window: userWindow;
@@ -47,6 +51,15 @@ window.modals = {} as Modals;
     if (window.matrixEnabled) {
         window.modals.matrix = new Modal(document.getElementById("modal-matrix"), false);
     }
+    if (window.signalEnabled) {
+        window.modals.signal = new Modal(document.getElementById("modal-signal"), false);
+    }
+    if (window.smsEnabled) {
+        window.modals.sms = new Modal(document.getElementById("modal-sms"), false);
+    }
+    if (window.pushEnabled) {
+        window.modals.push = new Modal(document.getElementById("modal-push"), false);
+    }
     if (window.pwrEnabled) {
         window.modals.pwr = new Modal(document.getElementById("modal-pwr"), false);
         window.modals.pwr.onclose = () => {
@@ -108,7 +121,12 @@ interface MyDetails {
     discord?: MyDetailsContactMethod;
     telegram?: MyDetailsContactMethod;
     matrix?: MyDetailsContactMethod;
+    signal?: MyDetailsContactMethod;
+    sms?: MyDetailsContactMethod;
+    push?: MyDetailsContactMethod;
     has_referrals: boolean;
+    pending_username?: string;
+    lang: string;
 }
 
 interface MyReferral {
@@ -117,6 +135,7 @@ interface MyReferral {
     no_limit: boolean;
     expiry: number;
     use_expiry: boolean;
+    quota_reached: boolean;
 }
 
 interface ContactDTO {
@@ -124,6 +143,9 @@ interface ContactDTO {
     discord?: boolean;
     telegram?: boolean;
     matrix?: boolean;
+    signal?: boolean;
+    sms?: boolean;
+    push?: boolean;
 }
 
 class ContactMethods {
@@ -221,7 +243,7 @@ class ContactMethods {
         
         if (!required && details.value != "") {
             const deleteButton = row.querySelector(".user-contact-delete") as HTMLButtonElement;
-            deleteButton.onclick = () => _delete("/my/" + name, null, (req: XMLHttpRequest) => {
+            deleteButton.onclick = () => _delete("/my/contact/" + name, null, (req: XMLHttpRequest) => {
                 if (req.readyState != 4) return;
                 document.dispatchEvent(new CustomEvent("details-reload"));
             });
@@ -353,6 +375,15 @@ class ReferralCard {
     hide = () => this._card.classList.add("unfocused");
 
     update = (referral: MyReferral) => {
+        this._card.classList.remove("unfocused");
+        if (referral.quota_reached) {
+            this._descriptionEl.textContent = window.lang.strings("referralQuotaReached");
+            this._infoArea.classList.add("unfocused");
+            this._button.classList.add("unfocused");
+            return;
+        }
+        this._infoArea.classList.remove("unfocused");
+        this._button.classList.remove("unfocused");
         this.code = referral.code;
         this.remaining_uses = referral.remaining_uses;
         this.no_limit = referral.no_limit;
@@ -369,11 +400,16 @@ class ExpiryCard {
     private _countdown: HTMLElement;
     private _interval: number = null;
     private _expiryUnix: number = 0;
+    private _redeemCode: HTMLInputElement;
+    private _redeemButton: HTMLButtonElement;
 
     constructor(card: HTMLElement) {
         this._card = card;
         this._aside = this._card.querySelector(".user-expiry") as HTMLElement;
         this._countdown = this._card.querySelector(".user-expiry-countdown") as HTMLElement;
+        this._redeemCode = this._card.querySelector(".user-redeem-code") as HTMLInputElement;
+        this._redeemButton = this._card.querySelector(".user-redeem-button") as HTMLButtonElement;
+        this._redeemButton.onclick = this.redeem;
 
         document.addEventListener("timefmt-change", () => {
             this.expiry = this._expiryUnix;
@@ -439,13 +475,288 @@ class ExpiryCard {
         this._interval = window.setInterval(this._drawCountdown, 60*1000);
         this._drawCountdown();
     }
+
+    redeem = () => {
+        const code = this._redeemCode.value.trim();
+        if (!code) return;
+        toggleLoader(this._redeemButton);
+        _post("/my/redeem", { "code": code }, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            toggleLoader(this._redeemButton);
+            if (req.status == 200) {
+                this._redeemCode.value = "";
+                window.notifications.customSuccess("codeRedeemed", window.lang.notif("codeRedeemed").replace("{n}", window.lang.quantity("day", req.response["days"])));
+                document.dispatchEvent(new CustomEvent("details-reload"));
+                return;
+            }
+            const err = req.response ? req.response["error"] as string : "";
+            window.notifications.customError("codeRedeemed", window.lang.notif(err) || window.lang.notif("errorUnknown"));
+        }, true);
+    };
+}
+
+class UsernameCard {
+    private _card: HTMLElement;
+    private _pending: HTMLElement;
+    private _input: HTMLInputElement;
+    private _submit: HTMLSpanElement;
+
+    constructor(card: HTMLElement) {
+        this._card = card;
+        this._pending = this._card.querySelector(".user-username-pending") as HTMLElement;
+        this._input = document.getElementById("user-new-username") as HTMLInputElement;
+        this._submit = document.getElementById("user-username-submit") as HTMLSpanElement;
+        this._submit.onclick = this.submit;
+    }
+
+    set pending(name: string) {
+        if (name) {
+            this._pending.textContent = window.lang.strings("usernamePending").replace("{n}", name);
+            this._pending.classList.remove("unfocused");
+        } else {
+            this._pending.classList.add("unfocused");
+        }
+    }
+
+    submit = () => {
+        const username = this._input.value.trim();
+        if (!username || username == window.username) return;
+        toggleLoader(this._submit);
+        _post("/my/username", { "username": username }, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            toggleLoader(this._submit);
+            if (req.status == 200) {
+                this._input.value = "";
+                if (req.response["pending"]) {
+                    window.notifications.customSuccess("usernameChanged", window.lang.notif("usernameChangeRequested"));
+                } else {
+                    window.notifications.customSuccess("usernameChanged", window.lang.notif("usernameChanged"));
+                }
+                document.dispatchEvent(new CustomEvent("details-reload"));
+                return;
+            }
+            const err = req.response ? req.response["error"] as string : "";
+            window.notifications.customError("usernameChanged", window.lang.notif(err) || window.lang.notif("errorUnknown"));
+        }, true);
+    };
+}
+
+interface Passkey {
+    id: string;
+    name: string;
+    added: number;
+    last_used: number;
+}
+
+class PasskeysCard {
+    private _card: HTMLElement;
+    private _list: HTMLElement;
+    private _name: HTMLInputElement;
+    private _button: HTMLButtonElement;
+
+    constructor(card: HTMLElement) {
+        this._card = card;
+        this._list = this._card.querySelector(".user-passkeys-list") as HTMLElement;
+        this._name = this._card.querySelector(".user-passkeys-name") as HTMLInputElement;
+        this._button = this._card.querySelector(".user-passkeys-add") as HTMLButtonElement;
+        this._button.onclick = this.add;
+    }
+
+    reload = () => {
+        this._card.classList.remove("unfocused");
+        _get("/my/passkeys", null, (req: XMLHttpRequest) => {
+            if (req.readyState != 4 || req.status != 200) return;
+            const passkeys = req.response["passkeys"] as Passkey[];
+            this._list.textContent = "";
+            if (passkeys.length == 0) {
+                this._list.innerHTML = `<span class="text-gray-400">${window.lang.strings("noPasskeys")}</span>`;
+                return;
+            }
+            for (let passkey of passkeys) {
+                const row = document.createElement("div");
+                row.classList.add("flex", "flex-row", "justify-between", "items-center");
+                row.innerHTML = `
+                <div class="flex flex-col">
+                    <span class="passkey-name font-bold"></span>
+                    <span class="text-gray-400 text-sm">${passkey.last_used ? window.lang.strings("lastUsed") + ": " + toDateString(new Date(passkey.last_used * 1000)) : toDateString(new Date(passkey.added * 1000))}</span>
+                </div>
+                <button type="button" class="button ~critical @low" title="${window.lang.strings("delete")}"><i class="ri-delete-bin-line"></i></button>
+                `;
+                (row.querySelector(".passkey-name") as HTMLElement).textContent = passkey.name;
+                (row.querySelector("button") as HTMLButtonElement).onclick = () => _delete("/my/passkeys/" + passkey.id, null, (req: XMLHttpRequest) => {
+                    if (req.readyState != 4) return;
+                    this.reload();
+                });
+                this._list.appendChild(row);
+            }
+        });
+    };
+
+    add = () => {
+        toggleLoader(this._button);
+        const done = () => {
+            toggleLoader(this._button);
+            this.reload();
+        };
+        _post("/my/passkeys/begin", { "name": this._name.value }, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            if (req.status != 200) {
+                window.notifications.customError("passkeyError", window.lang.notif("errorPasskey"));
+                done();
+                return;
+            }
+            const session = req.response["session"];
+            createPasskey(req.response["options"]).then((cred: any) => {
+                _post("/my/passkeys/finish/" + session, cred, (req: XMLHttpRequest) => {
+                    if (req.readyState != 4) return;
+                    if (req.status != 200) {
+                        window.notifications.customError("passkeyError", window.lang.notif("errorPasskey"));
+                    } else {
+                        this._name.value = "";
+                    }
+                    done();
+                });
+            }).catch(() => {
+                window.notifications.customError("passkeyError", window.lang.notif("errorPasskey"));
+                done();
+            });
+        }, true);
+    };
 }
 
+interface Session {
+    id: string;
+    admin: boolean;
+    user_agent: string;
+    ip: string;
+    created: number;
+    last_refresh: number;
+    current: boolean;
+}
+
+class SessionsCard {
+    private _card: HTMLElement;
+    private _list: HTMLElement;
+
+    constructor(card: HTMLElement) {
+        this._card = card;
+        this._list = this._card.querySelector(".user-sessions-list") as HTMLElement;
+    }
+
+    reload = () => _get("/my/sessions", null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4 || req.status != 200) return;
+        const sessions = req.response["sessions"] as Session[];
+        this._list.textContent = "";
+        for (let session of sessions) {
+            const row = document.createElement("div");
+            row.classList.add("flex", "flex-row", "justify-between", "items-center", "gap-2");
+            row.innerHTML = `
+            <div class="flex flex-col min-w-0">
+                <span class="session-device font-bold truncate"></span>
+                <span class="text-gray-400 text-sm">${toDateString(new Date(session.last_refresh * 1000))}${session.ip ? " · " + session.ip : ""}</span>
+            </div>
+            `;
+            const device = row.querySelector(".session-device") as HTMLElement;
+            device.textContent = session.user_agent || window.lang.strings("unknownDevice");
+            device.title = device.textContent;
+            if (session.admin) {
+                device.insertAdjacentHTML("afterbegin", `<span class="chip ~info mr-2">${window.lang.strings("admin")}</span>`);
+            }
+            if (session.current) {
+                row.insertAdjacentHTML("beforeend", `<span class="chip ~positive">${window.lang.strings("currentSession")}</span>`);
+            } else {
+                const button = document.createElement("button");
+                button.type = "button";
+                button.classList.add("button", "~critical", "@low");
+                button.title = window.lang.strings("logout");
+                button.innerHTML = `<i class="ri-logout-box-r-line"></i>`;
+                button.onclick = () => _delete("/my/sessions/" + session.id, null, (req: XMLHttpRequest) => {
+                    if (req.readyState != 4) return;
+                    this.reload();
+                });
+                row.appendChild(button);
+            }
+            this._list.appendChild(row);
+        }
+    });
+}
+
+interface Device {
+    id: string;
+    name: string;
+    client: string;
+    client_version: string;
+    last_seen: number;
+    ip: string;
+    active: boolean;
+}
+
+class DevicesCard {
+    private _card: HTMLElement;
+    private _list: HTMLElement;
+
+    constructor(card: HTMLElement) {
+        this._card = card;
+        this._list = this._card.querySelector(".user-devices-list") as HTMLElement;
+    }
+
+    reload = () => _get("/my/devices", null, (req: XMLHttpRequest) => {
+        if (req.readyState != 4 || req.status != 200) return;
+        const devices = req.response["devices"] as Device[];
+        this._list.textContent = "";
+        for (let device of devices) {
+            const row = document.createElement("div");
+            row.classList.add("flex", "flex-row", "justify-between", "items-center", "gap-2");
+            row.innerHTML = `
+            <div class="flex flex-col min-w-0">
+                <span class="device-name font-bold truncate"></span>
+                <span class="device-details text-gray-400 text-sm"></span>
+            </div>
+            `;
+            const name = row.querySelector(".device-name") as HTMLElement;
+            name.textContent = device.name || window.lang.strings("unknownDevice");
+            name.title = name.textContent;
+            let details = [device.client + (device.client_version ? " " + device.client_version : "")];
+            if (device.last_seen) details.push(toDateString(new Date(device.last_seen * 1000)));
+            if (device.ip) details.push(device.ip);
+            (row.querySelector(".device-details") as HTMLElement).textContent = details.join(" · ");
+            if (device.active) {
+                name.insertAdjacentHTML("afterbegin", `<span class="chip ~positive mr-2">${window.lang.strings("deviceActive")}</span>`);
+            }
+            const button = document.createElement("button");
+            button.type = "button";
+            button.classList.add("button", "~critical", "@low");
+            button.title = window.lang.strings("signOutDevice");
+            button.innerHTML = `<i class="ri-logout-box-r-line"></i>`;
+            button.onclick = () => _delete("/my/devices/" + device.id, null, (req: XMLHttpRequest) => {
+                if (req.readyState != 4) return;
+                if (req.status == 200) {
+                    window.notifications.customSuccess("deviceSignedOut", window.lang.notif("deviceSignedOut"));
+                } else {
+                    window.notifications.customError("deviceSignedOut", window.lang.notif("errorUnknown"));
+                }
+                this.reload();
+            });
+            row.appendChild(button);
+            this._list.appendChild(row);
+        }
+    });
+}
+
+var sessionsCard = new SessionsCard(document.getElementById("card-sessions"));
+
+var devicesCard = new DevicesCard(document.getElementById("card-devices"));
+
 var expiryCard = new ExpiryCard(statusCard);
 
 var referralCard: ReferralCard;
 if (window.referralsEnabled) referralCard = new ReferralCard(document.getElementById("card-referrals"));
 
+var passkeysCard: PasskeysCard;
+if (window.passkeysEnabled && passkeysSupported()) passkeysCard = new PasskeysCard(document.getElementById("card-passkeys"));
+let usernameCard: UsernameCard;
+if (document.getElementById("card-username")) usernameCard = new UsernameCard(document.getElementById("card-username"));
+
 var contactMethodList = new ContactMethods(contactCard);
 
 const addEditEmail = (add: boolean): void => {
@@ -527,6 +838,58 @@ const matrixConf: MatrixConfiguration = {
 let matrix: Matrix;
 if (window.matrixEnabled) matrix = new Matrix(matrixConf);
 
+const signalConf: ServiceConfiguration = {
+    modal: window.modals.signal as Modal,
+    pin: "",
+    pinURL: "/my/pin/signal",
+    verifiedURL: "/my/signal/verified/",
+    invalidCodeError: window.lang.notif("errorInvalidPIN"),
+    accountLinkedError: window.lang.notif("errorAccountLinked"),
+    successError: window.lang.notif("verified"),
+    successFunc: (modalClosed: boolean) => {
+        if (modalClosed) document.dispatchEvent(new CustomEvent("details-reload"));
+    }
+};
+
+let signal: Signal;
+if (window.signalEnabled) signal = new Signal(signalConf);
+
+const smsConf: SMSConfiguration = {
+    modal: window.modals.sms as Modal,
+    sendMessageURL: "/my/sms/number",
+    verifiedURL: "/my/sms/verified/",
+    invalidCodeError: window.lang.notif("errorInvalidPIN"),
+    invalidNumberError: window.lang.notif("errorInvalidNumber"),
+    tooManyPINsError: window.lang.notif("errorTooManyPINs"),
+    accountLinkedError: window.lang.notif("errorAccountLinked"),
+    unknownError: window.lang.notif("errorUnknown"),
+    successError: window.lang.notif("verified"),
+    successFunc: () => {
+        setTimeout(() => document.dispatchEvent(new CustomEvent("details-reload")), 1200);
+    }
+};
+
+let sms: SMS;
+if (window.smsEnabled) sms = new SMS(smsConf);
+
+const pushConf: PushConfiguration = {
+    modal: window.modals.push as Modal,
+    sendMessageURL: "/my/push/target",
+    verifiedURL: "/my/push/verified/",
+    services: window.pushServices.split(","),
+    invalidCodeError: window.lang.notif("errorInvalidPIN"),
+    invalidTargetError: window.lang.notif("errorInvalidPushTarget"),
+    accountLinkedError: window.lang.notif("errorAccountLinked"),
+    unknownError: window.lang.notif("errorUnknown"),
+    successError: window.lang.notif("verified"),
+    successFunc: () => {
+        setTimeout(() => document.dispatchEvent(new CustomEvent("details-reload")), 1200);
+    }
+};
+
+let push: Push;
+if (window.pushEnabled) push = new Push(pushConf);
+
 
 const oldPasswordField = document.getElementById("user-old-password") as HTMLInputElement;
 const newPasswordField = document.getElementById("user-new-password") as HTMLInputElement;
@@ -572,6 +935,32 @@ changePasswordButton.addEventListener("click", () => {
     });
 });
 
+const messageLangSelect = document.getElementById("user-message-lang") as HTMLSelectElement;
+let messageLang = "";
+_get("/lang/email", null, (req: XMLHttpRequest) => {
+    if (req.readyState != 4 || req.status != 200) return;
+    const langs = req.response as { [code: string]: string };
+    for (let code of Object.keys(langs).sort((a, b) => langs[a].localeCompare(langs[b]))) {
+        const option = document.createElement("option") as HTMLOptionElement;
+        option.value = code;
+        option.textContent = langs[code];
+        messageLangSelect.appendChild(option);
+    }
+    messageLangSelect.value = messageLang;
+});
+messageLangSelect.onchange = () => {
+    _post("/my/language", { "lang": messageLangSelect.value }, (req: XMLHttpRequest) => {
+        if (req.readyState != 4) return;
+        if (req.status == 200) {
+            messageLang = messageLangSelect.value;
+            window.notifications.customSuccess("messageLanguageChanged", window.lang.notif("messageLanguageChanged"));
+        } else {
+            messageLangSelect.value = messageLang;
+            window.notifications.customError("messageLanguageChanged", window.lang.notif("errorUnknown"));
+        }
+    });
+};
+
 document.addEventListener("details-reload", () => {
     _get("/my/details", null, (req: XMLHttpRequest) => {
         if (req.readyState == 4) {
@@ -603,7 +992,10 @@ document.addEventListener("details-reload", () => {
                 {name: "email", icon: `<i class="ri-mail-fill ri-lg"></i>`, f: addEditEmail, required: true, enabled: true},
                 {name: "discord", icon: `<i class="ri-discord-fill ri-lg"></i>`, f: (add: boolean) => { discord.onclick(); }, required: window.discordRequired, enabled: window.discordEnabled},
                 {name: "telegram", icon: `<i class="ri-telegram-fill ri-lg"></i>`, f: (add: boolean) => { telegram.onclick() }, required: window.telegramRequired, enabled: window.telegramEnabled},
-                {name: "matrix", icon: `<span class="font-bold">[m]</span>`, f: (add: boolean) => { matrix.show(); }, required: window.matrixRequired, enabled: window.matrixEnabled}
+                {name: "matrix", icon: `<span class="font-bold">[m]</span>`, f: (add: boolean) => { matrix.show(); }, required: window.matrixRequired, enabled: window.matrixEnabled},
+                {name: "signal", icon: `<i class="ri-chat-private-fill ri-lg"></i>`, f: (add: boolean) => { signal.onclick(); }, required: window.signalRequired, enabled: window.signalEnabled},
+                {name: "sms", icon: `<i class="ri-message-2-fill ri-lg"></i>`, f: (add: boolean) => { sms.show(); }, required: window.smsRequired, enabled: window.smsEnabled},
+                {name: "push", icon: `<i class="ri-notification-3-fill ri-lg"></i>`, f: (add: boolean) => { push.show(); }, required: false, enabled: window.pushEnabled}
             ];
             
             for (let method of contactMethods) {
@@ -615,6 +1007,9 @@ document.addEventListener("details-reload", () => {
 
             expiryCard.expiry = details.expiry;
 
+            messageLang = details.lang || "";
+            messageLangSelect.value = messageLang;
+
             const adminBackButton = document.getElementById("admin-back-button") as HTMLAnchorElement;
             adminBackButton.href = window.location.href.replace("my/account", "");
 
@@ -643,6 +1038,11 @@ document.addEventListener("details-reload", () => {
                 // setBestRowSpan(passwordCard, true);
             }
 
+            sessionsCard.reload();
+            devicesCard.reload();
+            if (passkeysCard) passkeysCard.reload();
+            if (usernameCard) usernameCard.pending = details.pending_username;
+
             if (window.referralsEnabled) {
                 if (details.has_referrals) {
                     _get("/my/referral", null, (req: XMLHttpRequest) => {
@@ -719,6 +1119,38 @@ login.onLogin = () => {
     document.dispatchEvent(new CustomEvent("details-reload"));
 };
 
+// Accounts disabled on expiry can't log in, so offer to redeem an extension code with the same credentials.
+const loginRedeem = document.getElementById("login-redeem") as HTMLDivElement;
+if (loginRedeem) {
+    const codeInput = document.getElementById("login-redeem-code") as HTMLInputElement;
+    const redeemButton = document.getElementById("login-redeem-button") as HTMLSpanElement;
+    let username = "", password = "";
+    login.onDisabled = (u: string, p: string) => {
+        username = u;
+        password = p;
+        loginRedeem.classList.remove("unfocused");
+        codeInput.focus();
+    };
+    redeemButton.onclick = () => {
+        const code = codeInput.value.trim();
+        if (!code) return;
+        toggleLoader(redeemButton);
+        _post("/my/redeem/login", { "username": username, "password": password, "code": code }, (req: XMLHttpRequest) => {
+            if (req.readyState != 4) return;
+            toggleLoader(redeemButton);
+            if (req.status == 200) {
+                codeInput.value = "";
+                loginRedeem.classList.add("unfocused");
+                window.notifications.customSuccess("codeRedeemed", window.lang.notif("codeRedeemed").replace("{n}", window.lang.quantity("day", req.response["days"])));
+                login.login(username, password);
+                return;
+            }
+            const err = req.response ? req.response["error"] as string : "";
+            window.notifications.customError("codeRedeemed", window.lang.notif(err) || window.lang.notif("errorUnknown"));
+        }, true);
+    };
+}
+
 const setBestRowSpan = (el: HTMLElement, setOnParent: boolean) => {
     let largestNonMessageCardHeight = 0;
     const cards = grid.querySelectorAll(".card") as NodeListOf<HTMLElement>;
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// unsubscribeSecretLock stops two keys being generated at once, which would invalidate links signed with the first.
+var unsubscribeSecretLock sync.Mutex
+
+// unsubscribeSecret returns the key unsubscribe tokens are signed with, generating one if needed.
+// Unlike JFA_SECRET, it's stored, so links in old emails still work after a restart.
+func (app *appContext) unsubscribeSecret() ([]byte, error) {
+	unsubscribeSecretLock.Lock()
+	defer unsubscribeSecretLock.Unlock()
+	if key, ok := app.storage.GetSigningKeyKey("unsubscribe"); ok && len(key.Secret) != 0 {
+		return key.Secret, nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	app.storage.SetSigningKeyKey("unsubscribe", SigningKey{Secret: secret})
+	return secret, nil
+}
+
+// unsubscribeURL returns a link which turns off email contact for the user, for the List-Unsubscribe header.
+// Returns "" if List-Unsubscribe is disabled or no URL base is set.
+func (app *appContext) unsubscribeURL(jfID string) string {
+	if !app.config.Section("email").Key("list_unsubscribe").MustBool(true) {
+		return ""
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(app.config.Section("invite_emails").Key("url_base").String(), "/"), "/invite")
+	if base == "" {
+		return ""
+	}
+	// No expiry, as the link is in the user's inbox indefinitely.
+	claims := jwt.MapClaims{
+		"valid": true,
+		"id":    jfID,
+		"type":  "unsubscribe",
+	}
+	tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	secret, err := app.unsubscribeSecret()
+	var key string
+	if err == nil {
+		key, err = tk.SignedString(secret)
+	}
+	if err != nil {
+		app.err.Printf("Failed to generate unsubscribe token: %v", err)
+		return ""
+	}
+	return base + "/unsubscribe/" + url.PathEscape(key)
+}
+
+// withUnsubscribeHeaders returns a copy of the bulk message with List-Unsubscribe headers for the given user.
+func (app *appContext) withUnsubscribeHeaders(msg *Message, jfID string) *Message {
+	link := app.unsubscribeURL(jfID)
+	if link == "" {
+		return msg
+	}
+	withHeaders := *msg
+	withHeaders.Headers = map[string]string{}
+	for k, v := range msg.Headers {
+		withHeaders.Headers[k] = v
+	}
+	withHeaders.Headers["List-Unsubscribe"] = "<" + link + ">"
+	// RFC 8058 one-click unsubscribe: mail clients POST "List-Unsubscribe=One-Click" to the link.
+	withHeaders.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	return &withHeaders
+}
+
+// unsubscribeID returns the Jellyfin ID from an unsubscribe token, or false if it's invalid.
+func (app *appContext) unsubscribeID(key string) (string, bool) {
+	token, err := jwt.Parse(key, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method %v", token.Header["alg"])
+		}
+		return app.unsubscribeSecret()
+	})
+	if err != nil {
+		return "", false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["type"] != "unsubscribe" {
+		return "", false
+	}
+	id, ok := claims["id"].(string)
+	return id, ok && id != ""
+}
+
+func (app *appContext) unsubscribePage(gc *gin.Context, code int, done bool) {
+	lang := app.getLang(gc, UserPage, app.storage.lang.chosenUserLang)
+	gcHTML(gc, code, "unsubscribe.html", gin.H{
+		"urlBase":        app.getURLBase(gc),
+		"cssClass":       app.cssClass,
+		"cssVersion":     cssVersion,
+		"strings":        app.storage.lang.User[lang].Strings,
+		"contactMessage": app.config.Section("ui").Key("contact_message").String(),
+		"done":           done,
+	})
+}
+
+// UnsubscribePage shows a button to confirm unsubscribing, so link scanners visiting the List-Unsubscribe URL don't unsubscribe users.
+func (app *appContext) UnsubscribePage(gc *gin.Context) {
+	if _, ok := app.unsubscribeID(gc.Param("jwt")); !ok {
+		app.NoRouteHandler(gc)
+		return
+	}
+	app.unsubscribePage(gc, http.StatusOK, false)
+}
+
+// @Summary Stop announcements and other bulk messages being emailed to the user, through a link from the List-Unsubscribe header. Other messages, like password resets, are still sent.
+// @Produce html
+// @Param jwt path string true "Unsubscribe token"
+// @Success 200
+// @Failure 404
+// @Router /unsubscribe/{jwt} [post]
+// @tags Other
+func (app *appContext) Unsubscribe(gc *gin.Context) {
+	id, ok := app.unsubscribeID(gc.Param("jwt"))
+	if !ok {
+		app.NoRouteHandler(gc)
+		return
+	}
+	if email, ok := app.storage.GetEmailsKey(id); ok && email.Contact {
+		email.Contact = false
+		email.Unsubscribed = true
+		app.storage.SetEmailsKey(id, email)
+		app.info.Printf("Unsubscribed \"%s\" from bulk emails", email.Addr)
+	}
+	app.unsubscribePage(gc, http.StatusOK, true)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUnsubscribeLinkSurvivesRestart(t *testing.T) {
+	app, _ := newTestApp(t, "[invite_emails]\nurl_base = https://jfa.test/invite\n")
+	link := app.unsubscribeURL("user")
+	if link == "" {
+		t.Fatal("no link generated")
+	}
+	key := path.Base(link)
+
+	// A restart generates a new JFA_SECRET.
+	old := os.Getenv("JFA_SECRET")
+	os.Setenv("JFA_SECRET", "after-restart")
+	defer os.Setenv("JFA_SECRET", old)
+
+	if id, ok := app.unsubscribeID(key); !ok || id != "user" {
+		t.Errorf("link invalid after restart: %q %t", id, ok)
+	}
+	if _, ok := app.unsubscribeID(key[:len(key)-2] + "xx"); ok {
+		t.Error("tampered link accepted")
+	}
+}
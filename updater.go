@@ -574,6 +574,9 @@ func (app *appContext) checkForUpdates() {
 				app.tag = tag
 				app.update = update
 				app.newUpdate = true
+				app.notifyAdmins(EventUpdateAvailable, func() (*Message, error) {
+					return app.email.constructAdminNotification(EventUpdateAvailable, tmpl{"version": update.Version, "link": update.Link}, app)
+				})
 			}
 		}()
 		time.Sleep(30 * time.Minute)
@@ -26,6 +26,7 @@ func (app *appContext) userAuthenticate(gc *gin.Context) {
 	jfID := claims["jfid"].(string)
 
 	gc.Set("jfId", jfID)
+	gc.Set("sessionId", claims["sid"].(string))
 	gc.Set("userMode", true)
 	app.debug.Println("Auth succeeded")
 	gc.Next()
@@ -50,26 +51,35 @@ func (app *appContext) getUserTokenLogin(gc *gin.Context) {
 	if !ok {
 		return
 	}
+	if !app.checkRateLimit(gc, true, username) {
+		return
+	}
 
 	user, ok := app.validateJellyfinCredentials(username, password, gc, true)
 	if !ok {
 		return
 	}
+	app.recordSuccessfulAttempt(gc, username)
 
-	token, refresh, err := CreateToken(user.ID, user.ID, false)
+	app.debug.Printf("Token generated for non-admin user \"%s\"", username)
+	app.respondUserToken(user.ID, gc)
+}
+
+// respondUserToken responds with a new user-access token & refresh cookie for the given Jellyfin user.
+func (app *appContext) respondUserToken(jfID string, gc *gin.Context) {
+	session := app.newSession(gc, jfID, jfID, false)
+	token, refresh, err := CreateToken(jfID, jfID, false, session.ID)
 	if err != nil {
 		app.err.Printf("getUserToken failed: Couldn't generate user token (%s)", err)
 		respond(500, "Couldn't generate user token", gc)
 		return
 	}
-
-	app.debug.Printf("Token generated for non-admin user \"%s\"", username)
 	uri := "/my"
 	if strings.HasPrefix(gc.Request.RequestURI, app.URLBase) {
 		uri = "/accounts/my"
 	}
 	gc.SetCookie("user-refresh", refresh, REFRESH_TOKEN_VALIDITY_SEC, uri, gc.Request.URL.Hostname(), true, true)
-	gc.JSON(200, getTokenDTO{token})
+	gc.JSON(200, getTokenDTO{Token: token})
 }
 
 // @Summary Grabs an user-access token using a refresh token from cookies.
@@ -87,14 +97,14 @@ func (app *appContext) getUserTokenRefresh(gc *gin.Context) {
 	}
 
 	app.logIpInfo(gc, true, "UserToken request (refresh token)")
-	claims, ok := app.decodeValidateRefreshCookie(gc, "user-refresh")
+	claims, session, ok := app.decodeValidateRefreshCookie(gc, "user-refresh")
 	if !ok {
 		return
 	}
 
 	jfID := claims["jfid"].(string)
 
-	jwt, refresh, err := CreateToken(jfID, jfID, false)
+	jwt, refresh, err := CreateToken(jfID, jfID, false, session.ID)
 	if err != nil {
 		app.err.Printf("getUserToken failed: Couldn't generate user token (%s)", err)
 		respond(500, "Couldn't generate user token", gc)
@@ -102,5 +112,5 @@ func (app *appContext) getUserTokenRefresh(gc *gin.Context) {
 	}
 
 	gc.SetCookie("user-refresh", refresh, REFRESH_TOKEN_VALIDITY_SEC, "/my", gc.Request.URL.Hostname(), true, true)
-	gc.JSON(200, getTokenDTO{jwt})
+	gc.JSON(200, getTokenDTO{Token: jwt})
 }
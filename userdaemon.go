@@ -121,6 +121,13 @@ func (app *appContext) checkUsers() {
 			}
 
 			app.storage.SetActivityKey(shortuuid.New(), activity, nil, false)
+			event := EventUserDisabled
+			if mode == "delete" {
+				event = EventUserDeleted
+			}
+			app.notifyAdmins(event, func() (*Message, error) {
+				return app.email.constructAdminNotification(event, tmpl{"username": user.Name}, app)
+			})
 
 			app.storage.DeleteUserExpiryKey(expiry.JellyfinID)
 			app.jf.CacheExpiry = time.Now()
@@ -129,7 +136,7 @@ func (app *appContext) checkUsers() {
 					continue
 				}
 				name := app.getAddressOrName(user.ID)
-				msg, err := app.email.constructUserExpired(app, false)
+				msg, err := app.emailFor(user.ID).constructUserExpired(app, false)
 				if err != nil {
 					app.err.Printf("Failed to construct expiry message for \"%s\": %s", user.Name, err)
 				} else if err := app.sendByID(msg, user.ID); err != nil {
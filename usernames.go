@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hrfee/mediabrowser"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+// usernamePattern matches the characters Jellyfin allows in usernames.
+// Jellyfin's \w is Unicode-aware, unlike Go's, so letters, marks and digits from any script are allowed.
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{M}\p{N}_\-'.@+ ]+$`)
+
+// validateUsername checks the given name can be taken by the user with the given ID,
+// returning the lang key of the error if not.
+func (app *appContext) validateUsername(jfID, name string) string {
+	if name == "" || strings.TrimSpace(name) != name || !usernamePattern.MatchString(name) {
+		return "errorInvalidUsername"
+	}
+	users, status, err := app.jf.GetUsers(false)
+	if status != 200 || err != nil {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+		return "errorUnknown"
+	}
+	// Jellyfin usernames are case-insensitive.
+	for _, user := range users {
+		if user.ID != jfID && strings.EqualFold(user.Name, name) {
+			return "errorUserExists"
+		}
+	}
+	return ""
+}
+
+// renameUser renames the user on Jellyfin and any request managers, clears any pending request, and logs the change.
+func (app *appContext) renameUser(gc *gin.Context, user mediabrowser.User, newName string, sourceType ActivitySource, source string, notify bool) error {
+	oldName := user.Name
+	status, err := app.renameJellyfinUser(user, newName)
+	if err != nil {
+		return fmt.Errorf("failed to rename on Jellyfin (%d): %v", status, err)
+	}
+	app.setRequestManagerUsernames(user.ID, oldName, newName)
+	app.storage.DeleteUsernameRequestKey(user.ID)
+
+	app.storage.SetActivityKey(shortuuid.New(), Activity{
+		Type:       ActivityUsernameChange,
+		UserID:     user.ID,
+		SourceType: sourceType,
+		Source:     source,
+		Value:      oldName,
+		Time:       time.Now(),
+	}, gc, sourceType == ActivityUser)
+	app.info.Printf("Renamed user \"%s\" to \"%s\"", oldName, newName)
+
+	if notify && messagesEnabled {
+		msg, err := app.emailFor(user.ID).constructUsernameChanged(oldName, newName, app)
+		if err != nil {
+			app.err.Printf("%s: Failed to construct username change message: %v", newName, err)
+		} else if err := app.sendByID(msg, user.ID); err != nil {
+			app.err.Printf("%s: Failed to send username change message: %v", newName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hrfee/mediabrowser"
+)
+
+func TestValidateUsername(t *testing.T) {
+	app, jf := newTestApp(t, "")
+	jf.addUser(mediabrowser.User{ID: "existinguser", Name: "Taken"})
+	for _, tc := range []struct {
+		name, errKey string
+	}{
+		{"john.smith", ""},
+		{"o'brien+jf@example", ""},
+		{"José", ""},
+		{"Ñandú 2", ""},
+		{"ユーザー", ""},
+		{"Дмитрий", ""},
+		{"", "errorInvalidUsername"},
+		{" padded", "errorInvalidUsername"},
+		{"semi;colon", "errorInvalidUsername"},
+		{"slash/name", "errorInvalidUsername"},
+		{"taken", "errorUserExists"},
+	} {
+		if errKey := app.validateUsername("someoneelse", tc.name); errKey != tc.errKey {
+			t.Errorf("%q: expected %q, got %q", tc.name, tc.errKey, errKey)
+		}
+	}
+	if errKey := app.validateUsername("existinguser", "TAKEN"); errKey != "" {
+		t.Errorf("user couldn't change the case of their own name: %q", errKey)
+	}
+}
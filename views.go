@@ -86,6 +86,21 @@ const (
 	OtherPage
 )
 
+// messageLang returns the language chosen on the form or user page, if messages can be sent in it, otherwise "".
+func (app *appContext) messageLang(gc *gin.Context) string {
+	if gc == nil {
+		return ""
+	}
+	lang := gc.Query("lang")
+	if lang == "" {
+		lang, _ = gc.Cookie("lang")
+	}
+	if _, ok := app.storage.lang.Email[lang]; !ok {
+		return ""
+	}
+	return lang
+}
+
 func (app *appContext) getLang(gc *gin.Context, page Page, chosen string) string {
 	lang := gc.Query("lang")
 	cookie, err := gc.Cookie("lang")
@@ -155,34 +170,36 @@ func (app *appContext) AdminPage(gc *gin.Context) {
 	}
 
 	gcHTML(gc, http.StatusOK, "admin.html", gin.H{
-		"urlBase":          app.getURLBase(gc),
-		"cssClass":         app.cssClass,
-		"cssVersion":       cssVersion,
-		"contactMessage":   "",
-		"emailEnabled":     emailEnabled,
-		"telegramEnabled":  telegramEnabled,
-		"discordEnabled":   discordEnabled,
-		"matrixEnabled":    matrixEnabled,
-		"ombiEnabled":      ombiEnabled,
-		"linkResetEnabled": app.config.Section("password_resets").Key("link_reset").MustBool(false),
-		"notifications":    notificationsEnabled,
-		"version":          version,
-		"commit":           commit,
-		"buildTime":        buildTime,
-		"builtBy":          builtBy,
-		"username":         !app.config.Section("email").Key("no_username").MustBool(false),
-		"strings":          app.storage.lang.Admin[lang].Strings,
-		"quantityStrings":  app.storage.lang.Admin[lang].QuantityStrings,
-		"language":         app.storage.lang.Admin[lang].JSON,
-		"langName":         lang,
-		"license":          license,
-		"jellyfinLogin":    app.jellyfinLogin,
-		"jfAdminOnly":      jfAdminOnly,
-		"jfAllowAll":       jfAllowAll,
-		"userPageEnabled":  app.config.Section("user_page").Key("enabled").MustBool(false),
-		"showUserPageLink": app.config.Section("user_page").Key("show_link").MustBool(true),
-		"referralsEnabled": app.config.Section("user_page").Key("enabled").MustBool(false) && app.config.Section("user_page").Key("referrals").MustBool(false),
-		"loginAppearance":  app.config.Section("ui").Key("login_appearance").MustString("clear"),
+		"urlBase":             app.getURLBase(gc),
+		"cssClass":            app.cssClass,
+		"cssVersion":          cssVersion,
+		"contactMessage":      "",
+		"emailEnabled":        emailEnabled,
+		"telegramEnabled":     telegramEnabled,
+		"discordEnabled":      discordEnabled,
+		"matrixEnabled":       matrixEnabled,
+		"ombiEnabled":         ombiEnabled,
+		"linkResetEnabled":    app.config.Section("password_resets").Key("link_reset").MustBool(false),
+		"notifications":       notificationsEnabled,
+		"version":             version,
+		"commit":              commit,
+		"buildTime":           buildTime,
+		"builtBy":             builtBy,
+		"username":            !app.config.Section("email").Key("no_username").MustBool(false),
+		"strings":             app.storage.lang.Admin[lang].Strings,
+		"quantityStrings":     app.storage.lang.Admin[lang].QuantityStrings,
+		"language":            app.storage.lang.Admin[lang].JSON,
+		"langName":            lang,
+		"license":             license,
+		"jellyfinLogin":       app.jellyfinLogin,
+		"jfAdminOnly":         jfAdminOnly,
+		"jfAllowAll":          jfAllowAll,
+		"userPageEnabled":     app.config.Section("user_page").Key("enabled").MustBool(false),
+		"showUserPageLink":    app.config.Section("user_page").Key("show_link").MustBool(true),
+		"referralsEnabled":    app.config.Section("user_page").Key("enabled").MustBool(false) && app.config.Section("user_page").Key("referrals").MustBool(false),
+		"loginAppearance":     app.config.Section("ui").Key("login_appearance").MustString("clear"),
+		"passkeysEnabled":     app.webauthn != nil,
+		"streamLimitsEnabled": app.streamLimiter != nil,
 	})
 }
 
@@ -192,6 +209,10 @@ func (app *appContext) MyUserPage(gc *gin.Context) {
 	emailEnabled, _ := app.config.Section("invite_emails").Key("enabled").Bool()
 	notificationsEnabled, _ := app.config.Section("notifications").Key("enabled").Bool()
 	ombiEnabled := app.config.Section("ombi").Key("enabled").MustBool(false)
+	pushServices := ""
+	if pushEnabled {
+		pushServices = strings.Join(app.push.Services(), ",")
+	}
 	data := gin.H{
 		"urlBase":           app.getURLBase(gc),
 		"cssClass":          app.cssClass,
@@ -202,6 +223,11 @@ func (app *appContext) MyUserPage(gc *gin.Context) {
 		"telegramEnabled":   telegramEnabled,
 		"discordEnabled":    discordEnabled,
 		"matrixEnabled":     matrixEnabled,
+		"signalEnabled":     signalEnabled,
+		"smsEnabled":        smsEnabled,
+		"smsRequired":       app.config.Section("sms").Key("required").MustBool(false),
+		"pushEnabled":       pushEnabled,
+		"pushServices":      pushServices,
 		"ombiEnabled":       ombiEnabled,
 		"pwrEnabled":        app.config.Section("password_resets").Key("enabled").MustBool(false),
 		"linkResetEnabled":  app.config.Section("password_resets").Key("link_reset").MustBool(false),
@@ -214,7 +240,11 @@ func (app *appContext) MyUserPage(gc *gin.Context) {
 		"jfLink":            app.config.Section("ui").Key("redirect_url").String(),
 		"requirements":      app.validator.getCriteria(),
 		"referralsEnabled":  app.config.Section("user_page").Key("enabled").MustBool(false) && app.config.Section("user_page").Key("referrals").MustBool(false),
+		"passkeysEnabled":   app.webauthn != nil,
+		"usernameChange":    app.config.Section("user_page").Key("allow_username_change").MustBool(false),
 	}
+	// Users disabled on expiry can't log in, so they can redeem an extension code from the login form instead.
+	data["redeemWhenDisabled"] = true
 	if telegramEnabled {
 		data["telegramUsername"] = app.telegram.username
 		data["telegramURL"] = app.telegram.link
@@ -224,6 +254,10 @@ func (app *appContext) MyUserPage(gc *gin.Context) {
 		data["matrixRequired"] = app.config.Section("matrix").Key("required").MustBool(false)
 		data["matrixUser"] = app.matrix.userID
 	}
+	if signalEnabled {
+		data["signalNumber"] = app.signal.number
+		data["signalRequired"] = app.config.Section("signal").Key("required").MustBool(false)
+	}
 	if discordEnabled {
 		data["discordUsername"] = app.discord.username
 		data["discordRequired"] = app.config.Section("discord").Key("required").MustBool(false)
@@ -296,6 +330,9 @@ func (app *appContext) ResetPassword(gc *gin.Context) {
 		data["telegramEnabled"] = false
 		data["discordEnabled"] = false
 		data["matrixEnabled"] = false
+		data["signalEnabled"] = false
+		data["smsEnabled"] = false
+		data["pushEnabled"] = false
 		data["captcha"] = app.config.Section("captcha").Key("enabled").MustBool(false)
 		data["reCAPTCHA"] = app.config.Section("captcha").Key("recaptcha").MustBool(false)
 		data["reCAPTCHASiteKey"] = app.config.Section("captcha").Key("recaptcha_site_key").MustString("")
@@ -369,24 +406,13 @@ func (app *appContext) ResetPassword(gc *gin.Context) {
 		}
 	}
 
-	if app.config.Section("ombi").Key("enabled").MustBool(false) {
+	if len(app.requestManagers) != 0 {
 		jfUser, status, err := app.jf.UserByName(username, false)
 		if status != 200 || err != nil {
 			app.err.Printf("Failed to get user \"%s\" from jellyfin/emby (%d): %v", username, status, err)
 			return
 		}
-		ombiUser, status, err := app.getOmbiUser(jfUser.ID)
-		if status != 200 || err != nil {
-			app.err.Printf("Failed to get user \"%s\" from ombi (%d): %v", username, status, err)
-			return
-		}
-		ombiUser["password"] = pin
-		status, err = app.ombi.ModifyUser(ombiUser)
-		if status != 200 || err != nil {
-			app.err.Printf("Failed to set password for ombi user \"%s\" (%d): %v", ombiUser["userName"], status, err)
-			return
-		}
-		app.debug.Printf("Reset password for ombi user \"%s\"", ombiUser["userName"])
+		app.setRequestManagerPasswords(jfUser.ID, pin)
 	}
 }
 
@@ -529,7 +555,7 @@ func (app *appContext) verifyCaptcha(code, id, text string, isPWR bool) bool {
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil || resp.StatusCode != 200 {
-		app.err.Printf("Failed to read reCAPTCHA status (%d): %+v\n", resp.Status, err)
+		app.err.Printf("Failed to read reCAPTCHA status (%d): %+v\n", resp.StatusCode, err)
 		return false
 	}
 	defer resp.Body.Close()
@@ -608,7 +634,11 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 	/* Don't actually check if the invite is valid, just if it exists, just so the page loads quicker. Invite is actually checked on submit anyway. */
 	// if app.checkInvite(code, false, "") {
 	inv, ok := app.storage.GetInvitesKey(code)
-	if !ok {
+	// Locked out clients are shown the same page as for an invalid code, so codes can't be guessed.
+	if _, locked := app.loginLimiter.Locked(rateLimitKeys(gc, "")...); locked || !ok {
+		if !ok {
+			app.recordFailedAttempt(gc, true, "")
+		}
 		gcHTML(gc, 404, "invalidCode.html", gin.H{
 			"urlBase":        app.getURLBase(gc),
 			"cssClass":       app.cssClass,
@@ -693,6 +723,8 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 	telegram := telegramEnabled && app.config.Section("telegram").Key("show_on_reg").MustBool(true)
 	discord := discordEnabled && app.config.Section("discord").Key("show_on_reg").MustBool(true)
 	matrix := matrixEnabled && app.config.Section("matrix").Key("show_on_reg").MustBool(true)
+	signal := signalEnabled && app.config.Section("signal").Key("show_on_reg").MustBool(true)
+	sms := smsEnabled && app.config.Section("sms").Key("show_on_reg").MustBool(true)
 
 	userPageAddress := app.config.Section("invite_emails").Key("url_base").String()
 	if userPageAddress == "" {
@@ -737,6 +769,9 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 		"telegramEnabled":    telegram,
 		"discordEnabled":     discord,
 		"matrixEnabled":      matrix,
+		"signalEnabled":      signal,
+		"smsEnabled":         sms,
+		"smsRequired":        sms && app.config.Section("sms").Key("required").MustBool(false),
 		"emailRequired":      app.config.Section("email").Key("required").MustBool(false),
 		"captcha":            app.config.Section("captcha").Key("enabled").MustBool(false),
 		"reCAPTCHA":          app.config.Section("captcha").Key("recaptcha").MustBool(false),
@@ -751,6 +786,11 @@ func (app *appContext) InviteProxy(gc *gin.Context) {
 		data["telegramURL"] = app.telegram.link
 		data["telegramRequired"] = app.config.Section("telegram").Key("required").MustBool(false)
 	}
+	if signal {
+		data["signalPIN"] = app.signal.NewAuthToken()
+		data["signalNumber"] = app.signal.number
+		data["signalRequired"] = app.config.Section("signal").Key("required").MustBool(false)
+	}
 	if matrix {
 		data["matrixRequired"] = app.config.Section("matrix").Key("required").MustBool(false)
 		data["matrixUser"] = app.matrix.userID
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+const (
+	// Longest gap between samples that's counted as watch time, so downtime isn't attributed to whoever was watching before it.
+	WATCH_TIME_MAX_GAP      = 3 * time.Minute
+	WATCH_TIME_DATE_FORMAT  = "2006-01-02"
+	WATCH_TIME_DEFAULT_DAYS = 30
+	WATCH_TIME_MAX_DAYS     = 3660
+)
+
+// WatchTimeDay is a user's watch time on a single day, sampled from their Jellyfin sessions.
+type WatchTimeDay struct {
+	ID         string    `badgerhold:"key"` // "<Jellyfin ID>:<YYYY-MM-DD>"
+	JellyfinID string    `badgerhold:"index"`
+	Date       time.Time // Midnight (local time) of the day.
+	Seconds    int64
+	Clients    map[string]int64 // Client names to seconds watched with them.
+}
+
+// WatchTimeReportStatus stores the last month a watch time report was sent for, so restarts don't send it again.
+type WatchTimeReportStatus struct {
+	Month string
+}
+
+// WatchTimeCollector samples Jellyfin sessions on each housekeeping run, adding the time since the last run to the watch time of anyone playing something.
+type WatchTimeCollector struct {
+	app        *appContext
+	lastSample time.Time
+}
+
+// loadWatchTimeCollector sets up the watch time collector if enabled.
+func (app *appContext) loadWatchTimeCollector() {
+	if !app.config.Section("watch_time").Key("enabled").MustBool(false) {
+		return
+	}
+	app.watchTime = &WatchTimeCollector{app: app, lastSample: time.Now()}
+	app.debug.Println("Watch time: Collector enabled")
+}
+
+func watchTimeDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// collect is run by the housekeeping daemon.
+func (wc *WatchTimeCollector) collect() {
+	now := time.Now()
+	elapsed := now.Sub(wc.lastSample)
+	wc.lastSample = now
+	if elapsed > WATCH_TIME_MAX_GAP {
+		elapsed = WATCH_TIME_MAX_GAP
+	}
+	seconds := int64(elapsed.Seconds())
+
+	sessions, err := wc.app.getJellyfinSessions()
+	if err != nil {
+		wc.app.err.Printf("Watch time: Failed to get sessions: %v", err)
+		return
+	}
+	date := watchTimeDay(now)
+	days := map[string]WatchTimeDay{}
+	for _, s := range sessions {
+		if s.NowPlayingItem == nil || s.UserID == "" || (s.PlayState != nil && s.PlayState.IsPaused) {
+			continue
+		}
+		key := s.UserID + ":" + date.Format(WATCH_TIME_DATE_FORMAT)
+		day, ok := days[key]
+		if !ok {
+			err := wc.app.storage.db.Get(key, &day)
+			if err != nil {
+				day = WatchTimeDay{ID: key, JellyfinID: s.UserID, Date: date}
+			}
+			if day.Clients == nil {
+				day.Clients = map[string]int64{}
+			}
+		}
+		day.Seconds += seconds
+		day.Clients[s.Client] += seconds
+		days[key] = day
+	}
+	for key, day := range days {
+		if err := wc.app.storage.db.Upsert(key, day); err != nil {
+			wc.app.err.Printf("Watch time: Failed to store watch time for \"%s\": %v", day.JellyfinID, err)
+		}
+	}
+
+	wc.sendMonthlyReport(now)
+}
+
+// clearWatchTime deletes watch time older than watch_time/keep_days.
+func (app *appContext) clearWatchTime() {
+	keepDays := app.config.Section("watch_time").Key("keep_days").MustInt(365)
+	if keepDays == 0 {
+		return
+	}
+	err := app.storage.db.DeleteMatching(&WatchTimeDay{}, badgerhold.Where("Date").Lt(watchTimeDay(time.Now()).AddDate(0, 0, -keepDays)))
+	if err != nil {
+		app.err.Printf("Failed to clear old watch time: %v", err)
+	}
+}
+
+// watchTimeSince returns all watch time recorded on or after the given day, optionally only for the given user.
+func (app *appContext) watchTimeSince(start time.Time, jfID string) []WatchTimeDay {
+	result := []WatchTimeDay{}
+	query := badgerhold.Where("Date").Ge(start)
+	if jfID != "" {
+		query = badgerhold.Where("JellyfinID").Eq(jfID).Index("JellyfinID").And("Date").Ge(start)
+	}
+	err := app.storage.db.Find(&result, query)
+	if err != nil {
+		// fmt.Printf("Failed to find watch time: %v\n", err)
+	}
+	return result
+}
+
+// topWatchers sums the given watch time per user, returning the n users who watched the most (all if n is 0).
+func (app *appContext) topWatchers(days []WatchTimeDay, n int) []watchTimeUserDTO {
+	totals := map[string]int64{}
+	for _, day := range days {
+		totals[day.JellyfinID] += day.Seconds
+	}
+	names := map[string]string{}
+	users, status, err := app.jf.GetUsers(false)
+	if err != nil || status != 200 {
+		app.err.Printf("Failed to get users from Jellyfin (%d): %v", status, err)
+	}
+	for _, user := range users {
+		names[user.ID] = user.Name
+	}
+	out := make([]watchTimeUserDTO, 0, len(totals))
+	for id, seconds := range totals {
+		out = append(out, watchTimeUserDTO{ID: id, Name: names[id], Seconds: seconds})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Seconds > out[j].Seconds })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// watchTimeDays parses the "days" query parameter, the number of days (including today) to cover.
+func watchTimeDays(gc *gin.Context) (int, bool) {
+	days := WATCH_TIME_DEFAULT_DAYS
+	if d := gc.Query("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days < 1 || days > WATCH_TIME_MAX_DAYS {
+			respond(400, "Invalid days", gc)
+			return 0, false
+		}
+	}
+	return days, true
+}
+
+// @Summary Get a user's watch time per day, and the clients they used most, over the last given number of days.
+// @Produce json
+// @Param id path string true "Jellyfin ID of user"
+// @Param days query int false "Days to cover, including today. Default 30."
+// @Success 200 {object} watchTimeDTO
+// @Failure 400 {object} stringResponse
+// @Router /users/{id}/watch-time [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetUserWatchTime(gc *gin.Context) {
+	id := gc.Param("id")
+	days, ok := watchTimeDays(gc)
+	if !ok {
+		return
+	}
+	start := watchTimeDay(time.Now()).AddDate(0, 0, 1-days)
+	resp := watchTimeDTO{Days: make([]watchTimeDayDTO, days), Clients: []watchTimeClientDTO{}}
+	for i := range resp.Days {
+		resp.Days[i].Date = start.AddDate(0, 0, i).Format(WATCH_TIME_DATE_FORMAT)
+	}
+	clients := map[string]int64{}
+	for _, day := range app.watchTimeSince(start, id) {
+		for i := range resp.Days {
+			if resp.Days[i].Date == day.Date.Format(WATCH_TIME_DATE_FORMAT) {
+				resp.Days[i].Seconds += day.Seconds
+				break
+			}
+		}
+		resp.Total += day.Seconds
+		for client, seconds := range day.Clients {
+			clients[client] += seconds
+		}
+	}
+	for client, seconds := range clients {
+		resp.Clients = append(resp.Clients, watchTimeClientDTO{Client: client, Seconds: seconds})
+	}
+	sort.Slice(resp.Clients, func(i, j int) bool { return resp.Clients[i].Seconds > resp.Clients[j].Seconds })
+	gc.JSON(200, resp)
+}
+
+// @Summary Get the users who watched the most over the last given number of days.
+// @Produce json
+// @Param days query int false "Days to cover, including today. Default 30."
+// @Param n query int false "Number of users to return. Default 10, 0 for all."
+// @Success 200 {object} topWatchersDTO
+// @Failure 400 {object} stringResponse
+// @Router /watch-time/top [get]
+// @Security Bearer
+// @tags Users
+func (app *appContext) GetTopWatchers(gc *gin.Context) {
+	days, ok := watchTimeDays(gc)
+	if !ok {
+		return
+	}
+	n := 10
+	if v := gc.Query("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respond(400, "Invalid n", gc)
+			return
+		}
+	}
+	start := watchTimeDay(time.Now()).AddDate(0, 0, 1-days)
+	gc.JSON(200, topWatchersDTO{Users: app.topWatchers(app.watchTimeSince(start, ""), n)})
+}
+
+// sendMonthlyReport sends the previous month's top watchers to watch_time/report_recipients, once per month.
+func (wc *WatchTimeCollector) sendMonthlyReport(now time.Time) {
+	section := wc.app.config.Section("watch_time")
+	if !messagesEnabled || !section.Key("monthly_report").MustBool(false) {
+		return
+	}
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	status := WatchTimeReportStatus{}
+	wc.app.storage.db.Get("watch_time_report", &status)
+	if status.Month == lastMonth.Format("2006-01") {
+		return
+	}
+	// Mark as sent first, so a failed delivery isn't retried every minute.
+	wc.app.storage.db.Upsert("watch_time_report", WatchTimeReportStatus{Month: lastMonth.Format("2006-01")})
+
+	var days []WatchTimeDay
+	for _, day := range wc.app.watchTimeSince(lastMonth, "") {
+		if day.Date.Before(thisMonth) {
+			days = append(days, day)
+		}
+	}
+	users := wc.app.topWatchers(days, 0)
+	msg, err := wc.app.email.constructWatchTimeReport(lastMonth, users, section.Key("report_top_n").MustInt(10), wc.app)
+	if err != nil {
+		wc.app.err.Printf("Watch time: Failed to construct monthly report: %v", err)
+		return
+	}
+	wc.app.sendToRecipients(msg, section.Key("report_recipients").String(), "monthly watch time report")
+}